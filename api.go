@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// api implements the /thing routes.
+type api struct {
+	store Store
+
+	// authorize enforces the scopes required by each route, and scopes
+	// requests to the caller's tenant, named by tenantClaim (by default
+	// "tenant").
+	authorize   bool
+	tenantClaim string
+
+	// maxAge is how long caches may reuse read responses without
+	// revalidating them.
+	maxAge time.Duration
+
+	// attachments, if set, enables the attachment routes.
+	attachments *attachments
+}
+
+func (a *api) operations() []operation {
+	ops := []operation{
+		{
+			method:      "POST",
+			path:        "/thing",
+			id:          "createThing",
+			summary:     "Create a thing",
+			requestBody: "application/json",
+			status:      201,
+			response:    "Thing",
+			errors:      []int{400},
+			scope:       scopeWrite,
+			handler:     a.createThing,
+		},
+		{
+			method:   "GET",
+			path:     "/thing",
+			id:       "listThings",
+			summary:  "List things",
+			query:    []string{"limit", "cursor", "name", "createdAfter"},
+			status:   200,
+			response: "ThingPage",
+			errors:   []int{400},
+			scope:    scopeRead,
+			handler:  a.listThings,
+		},
+		{
+			method:   "GET",
+			path:     "/thing/search",
+			id:       "searchThings",
+			summary:  "Search things",
+			query:    []string{"q", "limit", "cursor"},
+			status:   200,
+			response: "ThingPage",
+			errors:   []int{400},
+			scope:    scopeRead,
+			handler:  a.searchThings,
+		},
+		{
+			method:  "GET",
+			path:    exportPath,
+			id:      "exportThings",
+			summary: "Stream every thing as NDJSON or CSV",
+			query:   []string{"format"},
+			status:  200,
+			errors:  []int{400, 406},
+			scope:   scopeRead,
+			handler: a.exportThings,
+		},
+		{
+			method:      "PUT",
+			path:        "/thing/{id}",
+			id:          "putThing",
+			summary:     "Replace a thing",
+			requestBody: "application/json",
+			status:      200,
+			response:    "Thing",
+			errors:      []int{400, 404, 409, 412},
+			scope:       scopeWrite,
+			handler:     a.putThing,
+		},
+		{
+			method:      "PATCH",
+			path:        "/thing/{id}",
+			id:          "patchThing",
+			summary:     "Update a thing with a merge-patch",
+			requestBody: mergePatchContentType,
+			status:      200,
+			response:    "Thing",
+			errors:      []int{400, 404, 409, 412, 415},
+			scope:       scopeWrite,
+			handler:     a.patchThing,
+		},
+		{
+			method:   "GET",
+			path:     "/thing/{id}",
+			id:       "getThing",
+			summary:  "Get a thing",
+			status:   200,
+			response: "Thing",
+			errors:   []int{404},
+			scope:    scopeRead,
+			handler:  a.getThing,
+		},
+		{
+			method:  "DELETE",
+			path:    "/thing/{id}",
+			id:      "deleteThing",
+			summary: "Delete a thing",
+			status:  204,
+			errors:  []int{404, 412},
+			scope:   scopeWrite,
+			handler: a.deleteThing,
+		},
+		{
+			method:      "POST",
+			path:        "/things:batch",
+			id:          "batchThings",
+			summary:     "Create and delete things in a batch",
+			requestBody: "application/json",
+			request:     "BatchRequest",
+			status:      207,
+			response:    "BatchResponse",
+			errors:      []int{400, 413},
+			scope:       scopeWrite,
+			handler:     a.batch,
+		},
+	}
+
+	if a.attachments != nil {
+		ops = append(ops,
+			operation{
+				method:   "POST",
+				path:     "/thing/{id}/attachment",
+				id:       "uploadAttachment",
+				summary:  "Get a URL to upload a thing's attachment to",
+				status:   200,
+				response: "AttachmentUpload",
+				errors:   []int{404},
+				scope:    scopeWrite,
+				handler:  a.uploadAttachment,
+			},
+			operation{
+				method:  "GET",
+				path:    "/thing/{id}/attachment",
+				id:      "getAttachment",
+				summary: "Redirect to a URL to download a thing's attachment from",
+				status:  302,
+				errors:  []int{404},
+				scope:   scopeRead,
+				handler: a.getAttachment,
+			},
+		)
+	}
+	return ops
+}
+
+func (a *api) register(mux *http.ServeMux) {
+	ops := a.operations()
+	for _, op := range ops {
+		h := op.handler
+		if a.authorize && op.scope != "" {
+			h = requireScope(op.scope, h)
+		}
+		if a.authorize {
+			claim := a.tenantClaim
+			if claim == "" {
+				claim = defaultTenantClaim
+			}
+			h = requireTenant(claim, h)
+		}
+		mux.HandleFunc(op.method+" "+op.path, h)
+	}
+
+	doc := openAPI(ops)
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, doc)
+	})
+
+	mux.HandleFunc("GET /healthz", a.healthz)
+	mux.HandleFunc("GET /readyz", a.readyz)
+	mux.HandleFunc("GET /version", a.version)
+}
+
+func (a *api) createThing(w http.ResponseWriter, r *http.Request) {
+	t, p := decodeThing(r)
+	if p != nil {
+		mlambda.WriteProblem(w, r, p)
+		return
+	}
+
+	if err := a.store.Create(r.Context(), t); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/thing/"+t.ID)
+	w.Header().Set("ETag", thingETag(t))
+	writeJSON(w, 201, t)
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// thingPage is a page of a thing-listing.
+type thingPage struct {
+	Items     []*Thing `json:"items"`
+	NextToken string   `json:"nextToken,omitempty"`
+}
+
+func (a *api) listThings(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := ListOptions{
+		Limit:  defaultPageSize,
+		Cursor: q.Get("cursor"),
+		Name:   q.Get("name"),
+	}
+	if !parseLimit(w, r, &opts) {
+		return
+	}
+	if v := q.Get("createdAfter"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeProblem(w, r, problemInvalidRequest, "createdAfter must be an RFC 3339 timestamp")
+			return
+		}
+		opts.CreatedAfter = t
+	}
+
+	things, next, err := a.store.List(r.Context(), opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if things == nil {
+		things = []*Thing{}
+	}
+	a.setCacheControl(w)
+	writeJSON(w, 200, &thingPage{Items: things, NextToken: next})
+}
+
+// searchThings lists the things matching the query in the "q"
+// parameter. See Query for the syntax.
+func (a *api) searchThings(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeProblem(w, r, problemInvalidRequest, err.Error())
+		return
+	}
+	opts := ListOptions{
+		Limit:  defaultPageSize,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+	if !parseLimit(w, r, &opts) {
+		return
+	}
+
+	things, next, err := a.store.Search(r.Context(), query, opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if things == nil {
+		things = []*Thing{}
+	}
+	a.setCacheControl(w)
+	writeJSON(w, 200, &thingPage{Items: things, NextToken: next})
+}
+
+// parseLimit sets the page-size from the "limit" parameter, if any.
+// If it is invalid it responds with an error and returns false.
+func parseLimit(w http.ResponseWriter, r *http.Request, opts *ListOptions) bool {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		writeProblem(w, r, problemInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		return false
+	}
+	opts.Limit = limit
+	return true
+}
+
+func (a *api) putThing(w http.ResponseWriter, r *http.Request) {
+	t, p := decodeThing(r)
+	if p != nil {
+		mlambda.WriteProblem(w, r, p)
+		return
+	}
+
+	// the id in the path wins
+	t.ID = r.PathValue("id")
+	version, ok := a.checkIfMatch(w, r, t.ID)
+	if !ok {
+		return
+	}
+	if version != 0 {
+		t.Version = version
+	}
+	if err := a.store.Update(r.Context(), t); err != nil {
+		writeConditionalError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", thingETag(t))
+	writeJSON(w, 200, t)
+}
+
+func (a *api) getThing(w http.ResponseWriter, r *http.Request) {
+	t, err := a.store.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	a.setCacheHeaders(w, t)
+	if notModified(w, r, t) {
+		return
+	}
+	writeJSON(w, 200, t)
+}
+
+func (a *api) deleteThing(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	version, ok := a.checkIfMatch(w, r, id)
+	if !ok {
+		return
+	}
+	if err := a.store.Delete(r.Context(), id, version); err != nil {
+		writeConditionalError(w, r, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// writeStoreError responds to a failed Store operation.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	mlambda.WriteError(w, r, storeProblem(err))
+}
+
+// storeProblem describes a failed Store operation. Unexpected errors
+// are returned unchanged, so they are not disclosed to clients.
+func storeProblem(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return problemNotFound.newProblem(err.Error())
+	case errors.Is(err, ErrConflict):
+		return problemConflict.newProblem(err.Error())
+	case errors.Is(err, ErrInvalidCursor):
+		return problemInvalidRequest.newProblem(err.Error())
+	}
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.MarshalWrite(w, v)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+var s3Service = awsapi.Service{
+	SigningName: "s3",
+}
+
+// attachmentURLExpiry is how long presigned attachment URLs are valid.
+const attachmentURLExpiry = 15 * time.Minute
+
+// attachments holds a single binary attachment for each thing in an S3
+// bucket. Clients transfer attachments directly with S3, using presigned
+// URLs, so they are not subject to lambda's payload limits.
+type attachments struct {
+	client *awsapi.Client
+	bucket string
+}
+
+// attachmentUpload is the response to a request to upload an
+// attachment.
+type attachmentUpload struct {
+	// UploadURL should be used with a PUT request holding the
+	// attachment.
+	UploadURL string    `json:"uploadUrl"`
+	Expires   time.Time `json:"expires"`
+}
+
+// presign returns a presigned URL for a request to the thing's
+// attachment.
+func (s *attachments) presign(ctx context.Context, method string, id string) (string, error) {
+	u, err := url.Parse(s.client.Endpoint(s3Service))
+	if err != nil {
+		return "", err
+	}
+	if _, ok := s.client.Endpoints[s3Service.SigningName]; ok {
+		// overridden endpoints (such as local emulators) use path-style
+		u.Path = "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path += "/things/" + scopedID(ctx, id) + "/attachment"
+
+	r, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	return s.client.Presign(ctx, s3Service, r, attachmentURLExpiry)
+}
+
+// uploadAttachment responds with a URL to which the thing's attachment
+// can be uploaded, replacing any existing attachment.
+func (a *api) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := a.store.Get(r.Context(), id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	expires := time.Now().UTC().Add(attachmentURLExpiry)
+	u, err := a.attachments.presign(r.Context(), http.MethodPut, id)
+	if err != nil {
+		mlambda.WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, 200, &attachmentUpload{UploadURL: u, Expires: expires})
+}
+
+// getAttachment redirects to a URL from which the thing's attachment
+// can be downloaded. S3 responds with a 404 if there is no attachment.
+func (a *api) getAttachment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := a.store.Get(r.Context(), id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	u, err := a.attachments.presign(r.Context(), http.MethodGet, id)
+	if err != nil {
+		mlambda.WriteError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, u, http.StatusFound)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// Scopes required by the thing routes.
+const (
+	scopeRead  = "thing:read"
+	scopeWrite = "thing:write"
+)
+
+// requireScope wraps h so that it is only called if the request was
+// authorized by an API Gateway JWT authorizer with the given scope.
+// Other requests receive a 403.
+func requireScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasScope(r, scope) {
+			writeProblem(w, r, problemForbidden, "the "+scope+" scope is required")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// hasScope reports whether the request's JWT has the scope. Scopes are
+// taken from the authorizer, or from the token's "scope" claim (which
+// API Gateway passes through for access-tokens).
+func hasScope(r *http.Request, scope string) bool {
+	gc, ok := mlambda.GatewayContextFromContext(r.Context())
+	if !ok || gc.Authorizer.JWT == nil {
+		return false
+	}
+	jwt := gc.Authorizer.JWT
+	if slices.Contains(jwt.Scopes, scope) {
+		return true
+	}
+	return slices.Contains(strings.Fields(jwt.Claims["scope"]), scope)
+}
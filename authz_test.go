@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// Events for each payload version and authorizer. The version 1.0
+// events are as sent by REST APIs.
+const (
+	v1CognitoEvent = `{"httpMethod":"GET","path":"/thing","resource":"/thing","requestContext":{
+		"identity":{"sourceIp":"192.0.2.1","userArn":null},
+		"authorizer":{"claims":{"sub":"user-1","tenant":"acme","scope":"thing:read openid"}}}}`
+	v1LambdaEvent = `{"httpMethod":"GET","path":"/thing","resource":"/thing","requestContext":{
+		"identity":{"sourceIp":"192.0.2.1"},
+		"authorizer":{"principalId":"user-1","tenant":"acme","integrationLatency":12}}}`
+	v1IAMEvent = `{"httpMethod":"GET","path":"/thing","resource":"/thing","requestContext":{
+		"identity":{"sourceIp":"192.0.2.1","accessKey":"AKIDEXAMPLE","userArn":"arn:aws:iam::123456789012:user/alice"}}}`
+	v1NoAuthorizerEvent = `{"httpMethod":"GET","path":"/thing","resource":"/thing","requestContext":{
+		"identity":{"sourceIp":"192.0.2.1","userArn":null},"authorizer":null}}`
+	v2JWTEvent = `{"version":"2.0","rawPath":"/thing","requestContext":{"http":{"method":"GET","path":"/thing"},
+		"authorizer":{"jwt":{"claims":{"sub":"user-1","tenant":"acme"},"scopes":["thing:read"]}}}}`
+	v2LambdaEvent = `{"version":"2.0","rawPath":"/thing","requestContext":{"http":{"method":"GET","path":"/thing"},
+		"authorizer":{"lambda":{"tenant":"acme"}}}}`
+)
+
+// invokeHTTP sends an event to h through the lambda HTTP adapter,
+// returning the response status and body.
+func invokeHTTP(t *testing.T, h http.Handler, event string) (int, string) {
+	t.Helper()
+
+	var out bytes.Buffer
+	err := mlambda.HttpHandler(h).Invoke(context.Background(), &out, &mlambda.Request{Body: strings.NewReader(event)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		StatusCode      int    `json:"statusCode"`
+		Body            string `json:"body"`
+		IsBase64Encoded bool   `json:"isBase64Encoded"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp, json.RejectUnknownMembers(false)); err != nil {
+		t.Fatalf("decoding response %s: %s", out.String(), err)
+	}
+	body := resp.Body
+	if resp.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(b)
+	}
+	return resp.StatusCode, body
+}
+
+func TestRequireScope(t *testing.T) {
+	h := requireScope(scopeRead, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	tests := []struct {
+		name  string
+		event string
+		want  int
+	}{
+		{"v1 cognito", v1CognitoEvent, 200},
+		{"v1 lambda", v1LambdaEvent, 403},
+		{"v1 iam", v1IAMEvent, 403},
+		{"v1 no authorizer", v1NoAuthorizerEvent, 403},
+		{"v2 jwt", v2JWTEvent, 200},
+		{"v2 lambda", v2LambdaEvent, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, body := invokeHTTP(t, h, tt.event); got != tt.want {
+				t.Errorf("got %d (%s), want %d", got, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireTenant(t *testing.T) {
+	h := requireTenant(defaultTenantClaim, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, tenantFromContext(r.Context()))
+	})
+	tests := []struct {
+		name       string
+		event      string
+		want       int
+		wantTenant string
+	}{
+		{"v1 cognito", v1CognitoEvent, 200, "acme"},
+		{"v1 lambda", v1LambdaEvent, 200, "acme"},
+		{"v1 iam", v1IAMEvent, 403, ""},
+		{"v1 no authorizer", v1NoAuthorizerEvent, 403, ""},
+		{"v2 jwt", v2JWTEvent, 200, "acme"},
+		{"v2 lambda", v2LambdaEvent, 200, "acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, body := invokeHTTP(t, h, tt.event)
+			if got != tt.want {
+				t.Fatalf("got %d (%s), want %d", got, body, tt.want)
+			}
+			if got == 200 && body != tt.wantTenant {
+				t.Errorf("got tenant %q, want %q", body, tt.wantTenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Package awsapi is a minimal client for AWS service APIs: just enough
+// SigV4 signing and JSON-protocol support for the integrations in this
+// module, without depending on the full AWS SDK.
+package awsapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// Service describes how to reach and sign requests for an AWS service.
+type Service struct {
+	// SigningName is the service-name used in signatures.
+	SigningName string
+
+	// EndpointPrefix is the host-prefix of the regional endpoint.
+	// If empty, SigningName is used.
+	EndpointPrefix string
+
+	// JSONVersion and TargetPrefix are used by services using the
+	// AWS JSON protocol (such as "1.1" and "Logs_20140328").
+	JSONVersion  string
+	TargetPrefix string
+}
+
+// Client sends signed requests to AWS services.
+type Client struct {
+	HTTPClient  *http.Client
+	Region      string
+	Credentials CredentialsProvider
+
+	// Endpoints overrides the endpoint (scheme and host) for services,
+	// keyed by endpoint-prefix. It is intended for testing.
+	Endpoints map[string]string
+}
+
+// NewFromEnv returns a client for the region and credentials in the
+// standard environment variables, as set by the lambda service.
+func NewFromEnv() (*Client, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, fmt.Errorf("AWS_REGION not set")
+	}
+	return &Client{
+		HTTPClient:  http.DefaultClient,
+		Region:      region,
+		Credentials: EnvCredentials,
+	}, nil
+}
+
+// WithCredentials returns a copy of c using the given credentials.
+func (c *Client) WithCredentials(creds CredentialsProvider) *Client {
+	c2 := *c
+	c2.Credentials = creds
+	return &c2
+}
+
+// Endpoint returns the base-URL for the service.
+func (c *Client) Endpoint(svc Service) string {
+	prefix := svc.EndpointPrefix
+	if prefix == "" {
+		prefix = svc.SigningName
+	}
+	if e, ok := c.Endpoints[prefix]; ok {
+		return e
+	}
+	return "https://" + prefix + "." + c.Region + ".amazonaws.com"
+}
+
+// APIError is an error-response from an AWS service.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// IsCode reports whether err is, or wraps, an *APIError with the given
+// code.
+func IsCode(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// Do signs and sends r. The body must be supplied separately so that
+// it can be hashed; r.Body is replaced.
+func (c *Client) Do(ctx context.Context, svc Service, r *http.Request, body []byte) (*http.Response, error) {
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving credentials: %s", err)
+	}
+
+	r = r.WithContext(ctx)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	Sign(r, HashPayload(body), creds, c.Region, svc.SigningName, time.Now())
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(r)
+}
+
+// DoUnsignedPayload signs and sends r with body, of size bytes, as an
+// unsigned payload. This allows large bodies (such as S3 objects) to be
+// streamed rather than hashed first; it is only allowed over HTTPS.
+func (c *Client) DoUnsignedPayload(ctx context.Context, svc Service, r *http.Request, body io.Reader, size int64) (*http.Response, error) {
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving credentials: %s", err)
+	}
+
+	r = r.WithContext(ctx)
+	r.Body = io.NopCloser(body)
+	if size == 0 {
+		// otherwise sent chunked, as of unknown length
+		r.Body = http.NoBody
+	}
+	r.ContentLength = size
+	Sign(r, UnsignedPayload, creds, c.Region, svc.SigningName, time.Now())
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(r)
+}
+
+// Presign returns a pre-signed URL for r.
+func (c *Client) Presign(ctx context.Context, svc Service, r *http.Request, expires time.Duration) (string, error) {
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("retrieving credentials: %s", err)
+	}
+	return Presign(r, creds, c.Region, svc.SigningName, time.Now(), expires), nil
+}
+
+// DoJSON calls an operation of a service using the AWS JSON protocol,
+// marshaling in as the request and unmarshaling the response into out
+// (which may be nil).
+func (c *Client) DoJSON(ctx context.Context, svc Service, op string, in any, out any) error {
+	body, err := jsonv2.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest("POST", c.Endpoint(svc)+"/", nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-amz-json-"+svc.JSONVersion)
+	r.Header.Set("X-Amz-Target", svc.TargetPrefix+"."+op)
+
+	resp, err := c.Do(ctx, svc, r, body)
+	if err != nil {
+		return err
+	}
+	return readJSONResponse(resp, out)
+}
+
+// DoRESTJSON calls an operation of a service using the REST-JSON
+// protocol.
+func (c *Client) DoRESTJSON(ctx context.Context, svc Service, method string, path string, in any, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = jsonv2.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := http.NewRequest(method, c.Endpoint(svc)+path, nil)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.Do(ctx, svc, r, body)
+	if err != nil {
+		return err
+	}
+	return readJSONResponse(resp, out)
+}
+
+// readJSONResponse decodes a JSON response, or the error it describes.
+func readJSONResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return parseError(resp, respBody)
+	}
+	if out == nil || len(bytes.TrimSpace(respBody)) == 0 {
+		return nil
+	}
+	return jsonv2.Unmarshal(respBody, out, jsonv2.RejectUnknownMembers(false))
+}
+
+// parseError builds an *APIError from a JSON error-response.
+func parseError(resp *http.Response, body []byte) error {
+	var e struct {
+		Type     string `json:"__type"`
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		Message2 string `json:"Message"`
+	}
+	_ = jsonv2.Unmarshal(body, &e)
+
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	apiErr.Code = e.Type
+	if apiErr.Code == "" {
+		apiErr.Code = e.Code
+	}
+	if apiErr.Code == "" {
+		apiErr.Code = resp.Header.Get("X-Amzn-ErrorType")
+	}
+	// codes may be qualified with a namespace ("ns#Code") or
+	// followed by extra data ("Code:http://...")
+	if i := strings.LastIndex(apiErr.Code, "#"); i >= 0 {
+		apiErr.Code = apiErr.Code[i+1:]
+	}
+	if i := strings.Index(apiErr.Code, ":"); i >= 0 {
+		apiErr.Code = apiErr.Code[:i]
+	}
+	if apiErr.Code == "" {
+		apiErr.Code = resp.Status
+	}
+
+	apiErr.Message = e.Message
+	if apiErr.Message == "" {
+		apiErr.Message = e.Message2
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = string(body)
+	}
+	return apiErr
+}
@@ -0,0 +1,54 @@
+package awsapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Credentials are AWS credentials used to sign requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Expires is when the credentials expire, or the zero time if
+	// they do not.
+	Expires time.Time
+}
+
+// Expired reports whether the credentials expire before t.
+func (c Credentials) Expired(t time.Time) bool {
+	return !c.Expires.IsZero() && !t.Before(c.Expires)
+}
+
+// CredentialsProvider supplies credentials for signing requests.
+// Implementations must be safe for concurrent use.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+// Retrieve implements CredentialsProvider.
+func (f CredentialsProviderFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+var _ CredentialsProvider = (CredentialsProviderFunc)(nil)
+
+// EnvCredentials reads credentials from the standard environment variables,
+// which the lambda service sets to the function's execution-role
+// credentials.
+var EnvCredentials CredentialsProvider = CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+	c := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY not set")
+	}
+	return c, nil
+})
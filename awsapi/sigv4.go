@@ -0,0 +1,203 @@
+package awsapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+//
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+
+	// UnsignedPayload may be used as the payload-hash when the body
+	// is not signed (as for pre-signed S3 URLs).
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// HashPayload returns the hex-encoded SHA-256 of body, for use as the
+// payload-hash when signing.
+func HashPayload(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// Sign adds a SigV4 signature to r. The request's Host must be set
+// (http.NewRequest does this).
+func Sign(r *http.Request, payloadHash string, creds Credentials, region string, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+
+	r.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	if service == "s3" {
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	scope := credentialScope(now, region, service)
+	signedHeaders, canonicalHeaders := canonicalHeaders(r)
+	canonical := strings.Join([]string{
+		r.Method,
+		canonicalPath(r.URL),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	signature := signature(creds, now, region, service, amzDate, scope, canonical)
+
+	r.Header.Set("Authorization", signingAlgorithm+
+		" Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+}
+
+// Presign returns a pre-signed URL for r, valid for expires. Only the host
+// header is signed, and the payload is unsigned.
+func Presign(r *http.Request, creds Credentials, region string, service string, now time.Time, expires time.Duration) string {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := credentialScope(now, region, service)
+
+	q := r.URL.Query()
+	q.Set("X-Amz-Algorithm", signingAlgorithm)
+	q.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		q.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		canonicalPath(r.URL),
+		canonicalQuery(q),
+		"host:" + hostHeader(r) + "\n",
+		"host",
+		UnsignedPayload,
+	}, "\n")
+
+	q.Set("X-Amz-Signature", signature(creds, now, region, service, amzDate, scope, canonical))
+
+	u := *r.URL
+	u.RawQuery = canonicalQuery(q)
+	return u.String()
+}
+
+func credentialScope(now time.Time, region string, service string) string {
+	return now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+}
+
+func signature(creds Credentials, now time.Time, region string, service string, amzDate string, scope string, canonical string) string {
+	hashedCanonical := sha256.Sum256([]byte(canonical))
+	stringToSign := signingAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedCanonical[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hostHeader(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+// canonicalHeaders returns the signed-headers list and the canonical
+// header block. All headers set on the request, plus host, are signed.
+func canonicalHeaders(r *http.Request) (string, string) {
+	headers := map[string]string{"host": hostHeader(r)}
+	for k, vs := range r.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" || k == "user-agent" {
+			continue
+		}
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[k] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k)
+		b.WriteString(":")
+		b.WriteString(headers[k])
+		b.WriteString("\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// canonicalPath encodes each segment of the path with uriEncode, which
+// (unlike url.URL.EscapedPath) escapes everything but the unreserved
+// characters.
+func canonicalPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		if s, err := url.PathUnescape(seg); err == nil {
+			seg = s
+		}
+		segments[i] = uriEncode(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes the query with sorted keys, using the strict
+// escaping SigV4 requires.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except the unreserved characters
+// (A-Z, a-z, 0-9, '-', '.', '_', and '~').
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// maxBatchSize limits the operations in a batch.
+const maxBatchSize = 100
+
+// Batch operations.
+const (
+	batchCreate = "create"
+	batchDelete = "delete"
+)
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation creates a thing, or deletes the thing with the id.
+type batchOperation struct {
+	Op    string `json:"op"`
+	ID    string `json:"id,omitempty"`
+	Thing *Thing `json:"thing,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// batchResult is the outcome of a single operation. Status is the
+// status-code the operation would have had as its own request.
+type batchResult struct {
+	Status int              `json:"status"`
+	Thing  *Thing           `json:"thing,omitempty"`
+	Error  *mlambda.Problem `json:"error,omitempty"`
+}
+
+// batch applies a list of create and delete operations. Operations are
+// independent: each succeeds or fails on its own, and the response is
+// a 207 (multi-status) holding a result for each, in order.
+func (a *api) batch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.UnmarshalRead(r.Body, &req, decodeOptions); err != nil {
+		pt, detail := requestError(err)
+		writeProblem(w, r, pt, detail)
+		return
+	}
+	switch n := len(req.Operations); {
+	case n == 0:
+		writeProblem(w, r, problemInvalidRequest, "no operations")
+		return
+	case n > maxBatchSize:
+		writeProblem(w, r, problemInvalidRequest, fmt.Sprintf("at most %d operations are allowed", maxBatchSize))
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchResult, len(req.Operations))}
+	for i, op := range req.Operations {
+		resp.Results[i] = a.batchOperation(r, &op)
+	}
+	writeJSON(w, http.StatusMultiStatus, &resp)
+}
+
+func (a *api) batchOperation(r *http.Request, op *batchOperation) batchResult {
+	switch op.Op {
+	case batchCreate:
+		if op.Thing == nil {
+			return batchError(problemInvalidRequest.newProblem("create requires a thing"))
+		}
+		t := op.Thing
+		if t.Status == "" {
+			t.Status = StatusActive
+		}
+		if p := validationProblem(t.validate()); p != nil {
+			return batchError(p)
+		}
+		if err := a.store.Create(r.Context(), t); err != nil {
+			return batchError(storeProblem(err))
+		}
+		return batchResult{Status: http.StatusCreated, Thing: t}
+
+	case batchDelete:
+		if op.ID == "" {
+			return batchError(problemInvalidRequest.newProblem("delete requires an id"))
+		}
+		if err := a.store.Delete(r.Context(), op.ID, 0); err != nil {
+			return batchError(storeProblem(err))
+		}
+		return batchResult{Status: http.StatusNoContent}
+	}
+
+	return batchError(problemInvalidRequest.newProblem(fmt.Sprintf("op must be %q or %q", batchCreate, batchDelete)))
+}
+
+// batchError is the result of a failed operation.
+func batchError(err error) batchResult {
+	var p *mlambda.Problem
+	if !errors.As(err, &p) {
+		p = mlambda.NewProblem(http.StatusInternalServerError, "")
+	}
+	return batchResult{Status: p.Status, Error: p}
+}
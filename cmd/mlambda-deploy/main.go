@@ -0,0 +1,181 @@
+// Command mlambda-deploy deploys new code to a lambda function: it
+// updates the function's code with a zip-file (such as one built by
+// mlambda-package) or a container-image, waits for the update to
+// complete, and optionally publishes a version and points an alias at
+// it.
+//
+// AWS is called through package awsapi, so the region and credentials
+// are read from the standard environment variables (AWS_REGION,
+// AWS_ACCESS_KEY_ID, and so on); shared config-files and profiles are
+// not supported.
+//
+// Usage:
+//
+//	mlambda-deploy -function name (-zip file | -image uri) [-publish] [-alias name]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var lambdaService = awsapi.Service{SigningName: "lambda"}
+
+// functionConfiguration holds the fields of a function's configuration
+// needed to follow an update.
+type functionConfiguration struct {
+	FunctionName           string `json:"FunctionName"`
+	Version                string `json:"Version"`
+	CodeSha256             string `json:"CodeSha256"`
+	State                  string `json:"State"`
+	LastUpdateStatus       string `json:"LastUpdateStatus"`
+	LastUpdateStatusReason string `json:"LastUpdateStatusReason"`
+}
+
+func main() {
+	err := mainErr()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func mainErr() error {
+	function := flag.String("function", "", "function name or ARN")
+	zipFile := flag.String("zip", "", "zip-file holding the new code")
+	image := flag.String("image", "", "container-image URI holding the new code")
+	publish := flag.Bool("publish", false, "publish a version of the new code")
+	alias := flag.String("alias", "", "alias to point at the published version (implies -publish)")
+	description := flag.String("description", "", "description of the published version")
+	timeout := flag.Duration("timeout", 5*time.Minute, "how long to wait for the update")
+	flag.Parse()
+
+	if *function == "" {
+		return fmt.Errorf("-function is required")
+	}
+	if (*zipFile == "") == (*image == "") {
+		return fmt.Errorf("exactly one of -zip and -image is required")
+	}
+	if *alias != "" {
+		*publish = true
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	client, err := awsapi.NewFromEnv()
+	if err != nil {
+		return err
+	}
+	d := deployer{client: client, function: *function}
+
+	update := map[string]any{}
+	if *zipFile != "" {
+		code, err := os.ReadFile(*zipFile)
+		if err != nil {
+			return err
+		}
+		update["ZipFile"] = code
+	} else {
+		update["ImageUri"] = *image
+	}
+
+	var cfg functionConfiguration
+	if err := client.DoRESTJSON(ctx, lambdaService, "PUT", d.path("code"), update, &cfg); err != nil {
+		return fmt.Errorf("updating function code: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "updating %s (code %s)\n", cfg.FunctionName, cfg.CodeSha256)
+
+	if err := d.wait(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "updated %s\n", cfg.FunctionName)
+
+	if !*publish {
+		return nil
+	}
+
+	// the code-hash guards against publishing someone else's
+	// concurrent update
+	var version functionConfiguration
+	err = client.DoRESTJSON(ctx, lambdaService, "POST", d.path("versions"), map[string]any{
+		"CodeSha256":  cfg.CodeSha256,
+		"Description": *description,
+	}, &version)
+	if err != nil {
+		return fmt.Errorf("publishing version: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "published version %s\n", version.Version)
+
+	if *alias != "" {
+		if err := d.setAlias(ctx, *alias, version.Version); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "pointed alias %s at version %s\n", *alias, version.Version)
+	}
+
+	// print the version, for scripts
+	fmt.Println(version.Version)
+	return nil
+}
+
+type deployer struct {
+	client   *awsapi.Client
+	function string
+}
+
+// path returns the path of a sub-resource of the function.
+func (d *deployer) path(sub string) string {
+	return "/2015-03-31/functions/" + url.PathEscape(d.function) + "/" + sub
+}
+
+// wait polls the function's configuration until its last update has
+// completed.
+func (d *deployer) wait(ctx context.Context) error {
+	t := time.NewTicker(2 * time.Second)
+	defer t.Stop()
+	for {
+		var cfg functionConfiguration
+		if err := d.client.DoRESTJSON(ctx, lambdaService, "GET", d.path("configuration"), nil, &cfg); err != nil {
+			return fmt.Errorf("getting function configuration: %s", err)
+		}
+		switch cfg.LastUpdateStatus {
+		case "Successful":
+			return nil
+		case "Failed":
+			return fmt.Errorf("update failed: %s", cfg.LastUpdateStatusReason)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for update: %s", ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// setAlias points an alias at a version, creating it if needed.
+func (d *deployer) setAlias(ctx context.Context, alias string, version string) error {
+	err := d.client.DoRESTJSON(ctx, lambdaService, "PUT", d.path("aliases/"+url.PathEscape(alias)), map[string]any{
+		"FunctionVersion": version,
+	}, nil)
+	if awsapi.IsCode(err, "ResourceNotFoundException") {
+		err = d.client.DoRESTJSON(ctx, lambdaService, "POST", d.path("aliases"), map[string]any{
+			"Name":            alias,
+			"FunctionVersion": version,
+		}, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("setting alias %s: %s", alias, err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Command mlambda-package builds a Go main package as a lambda function
+// for the "OS only" runtimes (provided.al2023), producing a zip which
+// can be uploaded as the function's code.
+//
+// The binary is cross-compiled for Linux with cgo disabled and paths
+// trimmed, and named "bootstrap" as the runtime requires. The zip's
+// timestamps are fixed so that unchanged code produces an identical
+// zip (and code-hash).
+//
+// Usage:
+//
+//	mlambda-package [-arch arm64|amd64] [-o bin/bootstrap.zip] [-tags tags] [package]
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// modTime is the timestamp of the packaged binary.
+var modTime = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func main() {
+	err := mainErr()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func mainErr() error {
+	arch := flag.String("arch", "arm64", "target architecture: arm64 or amd64")
+	out := flag.String("o", "bin/bootstrap.zip", "output zip-file")
+	tags := flag.String("tags", "", "comma-separated build tags")
+	flag.Parse()
+
+	if *arch != "arm64" && *arch != "amd64" {
+		return fmt.Errorf("unsupported architecture %q", *arch)
+	}
+	pkg := "."
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		pkg = flag.Arg(0)
+	default:
+		return fmt.Errorf("expected at most one package, got %d", flag.NArg())
+	}
+
+	dir, err := os.MkdirTemp("", "mlambda-package")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "bootstrap")
+
+	if err := build(pkg, bin, *arch, *tags); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(*out), 0o755); err != nil {
+		return err
+	}
+	if err := writeZip(*out, bin); err != nil {
+		return err
+	}
+
+	for _, f := range []string{bin, *out} {
+		sum, err := fileHash(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s:\t%s\n", filepath.Base(f), sum)
+	}
+	return nil
+}
+
+// build cross-compiles pkg to bin.
+func build(pkg, bin, arch, tags string) error {
+	args := []string{"build", "-trimpath", "-ldflags", "-s -w", "-o", bin}
+	if tags != "" {
+		args = append(args, "-tags", tags)
+	}
+	args = append(args, pkg)
+
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+arch, "CGO_ENABLED=0")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("building %s: %s", pkg, err)
+	}
+	return nil
+}
+
+// writeZip writes a zip-file holding the executable bin, named
+// "bootstrap".
+func writeZip(out, bin string) error {
+	src, err := os.Open(bin)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// write to a temporary file so a failed build doesn't leave a
+	// truncated zip behind
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".bootstrap-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := zip.NewWriter(tmp)
+	hdr := &zip.FileHeader{
+		Name:     "bootstrap",
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	hdr.SetMode(0o755)
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), out)
+}
+
+// fileHash returns the base64 SHA-256 hash of a file, the format lambda
+// uses for code-hashes.
+func fileHash(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
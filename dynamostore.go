@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var dynamoDBService = awsapi.Service{
+	SigningName:  "dynamodb",
+	JSONVersion:  "1.0",
+	TargetPrefix: "DynamoDB_20120810",
+}
+
+// maxSearchItems bounds the matching items a DynamoDB search reads
+// before sorting. Results past it are not returned.
+const maxSearchItems = 1000
+
+// dynamoStore is a Store backed by a DynamoDB table with a string
+// partition-key named "id", holding the scoped id of each thing. Things
+// belonging to a tenant also have a "tenant" attribute.
+type dynamoStore struct {
+	client *awsapi.Client
+	table  string
+
+	// statusIndex, if set, is a global secondary index with "status"
+	// as its partition-key. Searches for a single status query it
+	// rather than scanning the table.
+	statusIndex string
+}
+
+// attributeValue is a DynamoDB attribute-value. Only the types used by
+// things are supported.
+type attributeValue struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+}
+
+type item map[string]attributeValue
+
+func stringValue(s string) attributeValue {
+	return attributeValue{S: &s}
+}
+
+func numberValue(n int64) attributeValue {
+	s := strconv.FormatInt(n, 10)
+	return attributeValue{N: &s}
+}
+
+// toItem encodes t for the tenant of ctx. Timestamps are stored as
+// unix-nanoseconds so that they compare correctly in expressions.
+func toItem(ctx context.Context, t *Thing) item {
+	it := item{
+		"id":      stringValue(scopedID(ctx, t.ID)),
+		"name":    stringValue(t.Name),
+		"status":  stringValue(t.Status),
+		"created": numberValue(t.Created.UnixNano()),
+		"updated": numberValue(t.Updated.UnixNano()),
+		"version": numberValue(t.Version),
+	}
+	if t.Description != "" {
+		it["description"] = stringValue(t.Description)
+	}
+	if tenant := tenantFromContext(ctx); tenant != "" {
+		it["tenant"] = stringValue(tenant)
+	}
+	return it
+}
+
+func fromItem(ctx context.Context, it item) (*Thing, error) {
+	t := &Thing{
+		ID:          strings.TrimPrefix(it.str("id"), scopedID(ctx, "")),
+		Name:        it.str("name"),
+		Description: it.str("description"),
+		Status:      it.str("status"),
+	}
+	created, err := it.num("created")
+	if err != nil {
+		return nil, err
+	}
+	updated, err := it.num("updated")
+	if err != nil {
+		return nil, err
+	}
+	t.Version, err = it.num("version")
+	if err != nil {
+		return nil, err
+	}
+	t.Created = time.Unix(0, created).UTC()
+	t.Updated = time.Unix(0, updated).UTC()
+	return t, nil
+}
+
+func (it item) str(name string) string {
+	if v := it[name].S; v != nil {
+		return *v
+	}
+	return ""
+}
+
+func (it item) num(name string) (int64, error) {
+	v := it[name].N
+	if v == nil {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(*v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("attribute %s: %s", name, err)
+	}
+	return n, nil
+}
+
+// expression holds the attribute names and values referenced by the
+// expressions of a DynamoDB request.
+type expression struct {
+	names  map[string]string
+	values item
+}
+
+// name returns the placeholder for a field-name.
+func (e *expression) name(field string) string {
+	if e.names == nil {
+		e.names = map[string]string{}
+		e.values = item{}
+	}
+	e.names["#"+field] = field
+	return "#" + field
+}
+
+// bind returns placeholders for a field-name and a value.
+func (e *expression) bind(field string, value attributeValue) (string, string) {
+	name := e.name(field)
+	placeholder := ":v" + strconv.Itoa(len(e.values))
+	e.values[placeholder] = value
+	return name, placeholder
+}
+
+// condition builds a "field op value" condition.
+func (e *expression) condition(field string, op string, value attributeValue) string {
+	name, placeholder := e.bind(field, value)
+	return name + " " + op + " " + placeholder
+}
+
+// apply sets the conditions as the expression named key, along with
+// the names and values.
+func (e *expression) apply(in map[string]any, key string, conditions []string) {
+	if len(conditions) == 0 {
+		return
+	}
+	in[key] = strings.Join(conditions, " AND ")
+	if len(e.names) > 0 {
+		in["ExpressionAttributeNames"] = e.names
+	}
+	if len(e.values) > 0 {
+		in["ExpressionAttributeValues"] = e.values
+	}
+}
+
+// tenantCondition builds a condition matching the things of the tenant
+// of ctx.
+func (e *expression) tenantCondition(ctx context.Context) string {
+	tenant := tenantFromContext(ctx)
+	if tenant == "" {
+		return "attribute_not_exists(" + e.name("tenant") + ")"
+	}
+	return e.condition("tenant", opEq, stringValue(tenant))
+}
+
+// filterValue encodes a query-filter value as stored.
+func filterValue(v any) attributeValue {
+	switch v := v.(type) {
+	case time.Time:
+		return numberValue(v.UnixNano())
+	case int64:
+		return numberValue(v)
+	}
+	return stringValue(v.(string))
+}
+
+// Create implements Store.
+func (d *dynamoStore) Create(ctx context.Context, t *Thing) error {
+	t.ID = newID()
+	t.Created = time.Now().UTC()
+	t.Updated = t.Created
+	t.Version = 1
+
+	in := map[string]any{
+		"TableName":           d.table,
+		"Item":                toItem(ctx, t),
+		"ConditionExpression": "attribute_not_exists(id)",
+	}
+	return d.client.DoJSON(ctx, dynamoDBService, "PutItem", in, nil)
+}
+
+// Get implements Store.
+func (d *dynamoStore) Get(ctx context.Context, id string) (*Thing, error) {
+	in := map[string]any{
+		"TableName":      d.table,
+		"Key":            item{"id": stringValue(scopedID(ctx, id))},
+		"ConsistentRead": true,
+	}
+	var out struct {
+		Item item
+	}
+	if err := d.client.DoJSON(ctx, dynamoDBService, "GetItem", in, &out); err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, ErrNotFound
+	}
+	return fromItem(ctx, out.Item)
+}
+
+// List implements Store. Things are in table order, and the cursor is
+// the encoded last-evaluated key. Filters are applied after the limit,
+// so pages may be short.
+func (d *dynamoStore) List(ctx context.Context, opts ListOptions) ([]*Thing, string, error) {
+	in := map[string]any{
+		"TableName": d.table,
+	}
+	if opts.Limit > 0 {
+		in["Limit"] = opts.Limit
+	}
+	if opts.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		var key item
+		if err := json.Unmarshal(b, &key); err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		in["ExclusiveStartKey"] = key
+	}
+
+	var e expression
+	filter := []string{e.tenantCondition(ctx)}
+	if opts.Name != "" {
+		filter = append(filter, e.condition("name", opEq, stringValue(opts.Name)))
+	}
+	if !opts.CreatedAfter.IsZero() {
+		filter = append(filter, e.condition("created", opGt, numberValue(opts.CreatedAfter.UnixNano())))
+	}
+	e.apply(in, "FilterExpression", filter)
+
+	var out scanOutput
+	if err := d.client.DoJSON(ctx, dynamoDBService, "Scan", in, &out); err != nil {
+		return nil, "", err
+	}
+	things, err := out.things(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var cursor string
+	if out.LastEvaluatedKey != nil {
+		b, err := json.Marshal(out.LastEvaluatedKey)
+		if err != nil {
+			return nil, "", err
+		}
+		cursor = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return things, cursor, nil
+}
+
+type scanOutput struct {
+	Items            []item
+	LastEvaluatedKey item
+}
+
+func (o *scanOutput) things(ctx context.Context) ([]*Thing, error) {
+	things := make([]*Thing, 0, len(o.Items))
+	for _, it := range o.Items {
+		t, err := fromItem(ctx, it)
+		if err != nil {
+			return nil, err
+		}
+		things = append(things, t)
+	}
+	return things, nil
+}
+
+// Search implements Store. The query's filters are pushed down to
+// DynamoDB; a search for a single status queries the status-index, if
+// there is one, and other searches scan the table. Matches are then
+// sorted and paged in memory, so at most maxSearchItems are considered.
+func (d *dynamoStore) Search(ctx context.Context, q *Query, opts ListOptions) ([]*Thing, string, error) {
+	in := map[string]any{
+		"TableName": d.table,
+	}
+	op := "Scan"
+
+	var e expression
+	var key []string
+	filter := []string{e.tenantCondition(ctx)}
+	for _, f := range q.Filters {
+		if f.Field == "id" {
+			f.Value = scopedID(ctx, f.Value.(string))
+		}
+		cond := e.condition(f.Field, f.Op, filterValue(f.Value))
+		if f.Field == "status" && f.Op == opEq && d.statusIndex != "" && len(key) == 0 {
+			key = append(key, cond)
+			continue
+		}
+		filter = append(filter, cond)
+	}
+	if len(key) > 0 {
+		op = "Query"
+		in["IndexName"] = d.statusIndex
+		e.apply(in, "KeyConditionExpression", key)
+	}
+	e.apply(in, "FilterExpression", filter)
+
+	var things []*Thing
+	for len(things) < maxSearchItems {
+		var out scanOutput
+		if err := d.client.DoJSON(ctx, dynamoDBService, op, in, &out); err != nil {
+			return nil, "", err
+		}
+		page, err := out.things(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, t := range page {
+			// free-text terms are matched here, case-insensitively
+			if q.matches(t) {
+				things = append(things, t)
+			}
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		in["ExclusiveStartKey"] = out.LastEvaluatedKey
+	}
+	if len(things) > maxSearchItems {
+		things = things[:maxSearchItems]
+	}
+
+	return searchPage(things, q, opts)
+}
+
+// Update implements Store.
+func (d *dynamoStore) Update(ctx context.Context, t *Thing) error {
+	existing, err := d.Get(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+	if t.Version != 0 && t.Version != existing.Version {
+		return ErrConflict
+	}
+
+	updated := *t
+	updated.Created = existing.Created
+	updated.Updated = time.Now().UTC()
+	updated.Version = existing.Version + 1
+
+	// the stored version must not have changed since it was read
+	var e expression
+	in := map[string]any{
+		"TableName": d.table,
+		"Item":      toItem(ctx, &updated),
+	}
+	e.apply(in, "ConditionExpression", []string{
+		e.condition("version", opEq, numberValue(existing.Version)),
+	})
+	if err := d.client.DoJSON(ctx, dynamoDBService, "PutItem", in, nil); err != nil {
+		return d.conditionError(ctx, t.ID, err)
+	}
+	*t = updated
+	return nil
+}
+
+// Delete implements Store.
+func (d *dynamoStore) Delete(ctx context.Context, id string, version int64) error {
+	var e expression
+	cond := []string{"attribute_exists(id)"}
+	if version != 0 {
+		cond = append(cond, e.condition("version", opEq, numberValue(version)))
+	}
+	in := map[string]any{
+		"TableName": d.table,
+		"Key":       item{"id": stringValue(scopedID(ctx, id))},
+	}
+	e.apply(in, "ConditionExpression", cond)
+	if err := d.client.DoJSON(ctx, dynamoDBService, "DeleteItem", in, nil); err != nil {
+		return d.conditionError(ctx, id, err)
+	}
+	return nil
+}
+
+// conditionError translates a failed condition into ErrNotFound or
+// ErrConflict, depending on whether the thing still exists.
+func (d *dynamoStore) conditionError(ctx context.Context, id string, err error) error {
+	if !awsapi.IsCode(err, "ConditionalCheckFailedException") {
+		return err
+	}
+	if _, err := d.Get(ctx, id); errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
+
+// Ping implements pinger.
+func (d *dynamoStore) Ping(ctx context.Context) error {
+	in := map[string]any{"TableName": d.table}
+	return d.client.DoJSON(ctx, dynamoDBService, "DescribeTable", in, nil)
+}
+
+var _ Store = (*dynamoStore)(nil)
+var _ pinger = (*dynamoStore)(nil)
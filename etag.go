@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// thingETag returns a strong entity-tag for t. Every update increments
+// a thing's version, so the version identifies the representation.
+func thingETag(t *Thing) string {
+	return `"` + t.ID + "." + strconv.FormatInt(t.Version, 10) + `"`
+}
+
+// etagMatches reports whether the If-Match or If-None-Match header
+// value matches etag. Weak comparison ignores "W/" prefixes.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setCacheHeaders sets the validators for t and the Cache-Control
+// header for reads.
+func (a *api) setCacheHeaders(w http.ResponseWriter, t *Thing) {
+	w.Header().Set("ETag", thingETag(t))
+	w.Header().Set("Last-Modified", t.Updated.UTC().Format(http.TimeFormat))
+	a.setCacheControl(w)
+}
+
+// setCacheControl allows caches to reuse a response for maxAge, after
+// which they must revalidate it.
+func (a *api) setCacheControl(w http.ResponseWriter) {
+	if a.maxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(a.maxAge/time.Second)))
+}
+
+// notModified handles If-None-Match and If-Modified-Since for reads,
+// returning true if a 304 response was sent. The validators must
+// already be set.
+func notModified(w http.ResponseWriter, r *http.Request, t *Thing) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		// If-Modified-Since is ignored when If-None-Match is present
+		if !etagMatches(inm, thingETag(t), true) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || t.Updated.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkIfMatch handles If-Match for updates. If the header is present
+// it returns the version the update must apply to, or sends a 412
+// response and returns false if the thing has changed.
+func (a *api) checkIfMatch(w http.ResponseWriter, r *http.Request, id string) (int64, bool) {
+	im := r.Header.Get("If-Match")
+	if im == "" {
+		return 0, true
+	}
+
+	t, err := a.store.Get(r.Context(), id)
+	if err != nil {
+		// "If-Match: *" fails for a missing thing, as does any
+		// other tag.
+		if errors.Is(err, ErrNotFound) {
+			writePreconditionFailed(w, r)
+			return 0, false
+		}
+		writeStoreError(w, r, err)
+		return 0, false
+	}
+	if !etagMatches(im, thingETag(t), false) {
+		writePreconditionFailed(w, r)
+		return 0, false
+	}
+	return t.Version, true
+}
+
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problemPrecondition, "thing does not match If-Match")
+}
+
+// writeConditionalError responds to a failed Store operation which
+// was conditional on If-Match, reporting conflicts as 412s.
+func writeConditionalError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrConflict) && r.Header.Get("If-Match") != "" {
+		writePreconditionFailed(w, r)
+		return
+	}
+	writeStoreError(w, r, err)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// eventSource is the source of published events.
+const eventSource = "aws-go-lambda-demo.things"
+
+// Event detail-types.
+const (
+	eventThingCreated = "ThingCreated"
+	eventThingUpdated = "ThingUpdated"
+	eventThingDeleted = "ThingDeleted"
+)
+
+// thingEvent is the detail of a published event. Thing is the new
+// state of the thing, and is omitted for deletions.
+type thingEvent struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant,omitempty"`
+	Thing  *Thing `json:"thing,omitempty"`
+}
+
+// eventStore wraps a Store, publishing an event to an EventBridge bus
+// after each successful change.
+//
+// If outboxTable is set, each event is first recorded in that DynamoDB
+// table (with a string partition-key named "id") and removed once it
+// has been published. Events which fail to publish stay in the outbox
+// and are retried on later changes, from any execution-environment.
+// An event is lost only if the function fails between changing the
+// thing and recording the event.
+type eventStore struct {
+	Store
+
+	publisher *mlambda.EventPublisher
+}
+
+func newEventStore(s Store, client *awsapi.Client, bus string, outboxTable string) *eventStore {
+	return &eventStore{
+		Store: s,
+		publisher: &mlambda.EventPublisher{
+			Client:      client,
+			EventBus:    bus,
+			OutboxTable: outboxTable,
+		},
+	}
+}
+
+// Create implements Store.
+func (es *eventStore) Create(ctx context.Context, t *Thing) error {
+	if err := es.Store.Create(ctx, t); err != nil {
+		return err
+	}
+	es.publish(ctx, eventThingCreated, t.ID, t)
+	return nil
+}
+
+// Update implements Store.
+func (es *eventStore) Update(ctx context.Context, t *Thing) error {
+	if err := es.Store.Update(ctx, t); err != nil {
+		return err
+	}
+	es.publish(ctx, eventThingUpdated, t.ID, t)
+	return nil
+}
+
+// Delete implements Store.
+func (es *eventStore) Delete(ctx context.Context, id string, version int64) error {
+	if err := es.Store.Delete(ctx, id, version); err != nil {
+		return err
+	}
+	es.publish(ctx, eventThingDeleted, id, nil)
+	return nil
+}
+
+// Ping implements pinger.
+func (es *eventStore) Ping(ctx context.Context) error {
+	if p, ok := es.Store.(pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// publish sends an event. The change has already been made, so
+// failures are logged rather than returned.
+func (es *eventStore) publish(ctx context.Context, detailType string, id string, t *Thing) {
+	err := es.publisher.Publish(ctx, mlambda.Event{
+		Source:     eventSource,
+		DetailType: detailType,
+		Detail:     &thingEvent{ID: id, Tenant: tenantFromContext(ctx), Thing: t},
+		Time:       time.Now(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "publishing event", "error", err, "detailType", detailType, "id", id)
+	}
+}
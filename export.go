@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elnormous/contenttype"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// exportPath is the route of the export, which responds with its own
+// media-types.
+const exportPath = "/thing/export"
+
+const csvContentType = "text/csv"
+
+// exportMediaTypes are the media-types of the export, the first being
+// the default.
+var exportMediaTypes = []contenttype.MediaType{
+	contenttype.NewMediaType(mlambda.NDJSONContentType),
+	contenttype.NewMediaType(csvContentType),
+}
+
+// exportPageSize is the number of things read from the store at once.
+const exportPageSize = 100
+
+// exportThings streams every thing as NDJSON or CSV (chosen by the
+// Accept header or the "format" parameter). Things are written as
+// they are read from the store, a page at a time, so the export is
+// not limited by the size of a buffered response when served through
+// a streaming function URL.
+//
+// Once streaming has started the status can no longer change, so a
+// failure part-way through is logged and the response cut short.
+func (a *api) exportThings(w http.ResponseWriter, r *http.Request) {
+	format := mlambda.NDJSONContentType
+	switch r.URL.Query().Get("format") {
+	case "":
+		if mt, _, err := contenttype.GetAcceptableMediaType(r, exportMediaTypes); err == nil {
+			format = mt.MIME()
+		}
+	case "ndjson":
+	case "csv":
+		format = csvContentType
+	default:
+		writeProblem(w, r, problemInvalidRequest, `format must be "ndjson" or "csv"`)
+		return
+	}
+
+	var write func(t *Thing) error
+	var flush func() error
+	switch format {
+	case csvContentType:
+		w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "name", "description", "status", "created", "updated", "version"})
+		write = func(t *Thing) error {
+			return cw.Write([]string{
+				t.ID,
+				t.Name,
+				t.Description,
+				t.Status,
+				t.Created.Format(time.RFC3339Nano),
+				t.Updated.Format(time.RFC3339Nano),
+				strconv.FormatInt(t.Version, 10),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return mlambda.Flush(w)
+		}
+	default:
+		w.Header().Set("Content-Type", mlambda.NDJSONContentType)
+		nw := mlambda.NewNDJSONWriter(r.Context(), w)
+		write = func(t *Thing) error { return nw.Encode(t) }
+		flush = func() error { return nil }
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := a.streamThings(r.Context(), write, flush); err != nil {
+		slog.ErrorContext(r.Context(), "export failed", "error", err)
+	}
+}
+
+// streamThings calls write for every thing, and flush after each page.
+func (a *api) streamThings(ctx context.Context, write func(*Thing) error, flush func() error) error {
+	opts := ListOptions{Limit: exportPageSize}
+	for {
+		things, next, err := a.store.List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, t := range things {
+			if err := write(t); err != nil {
+				return err
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Cursor = next
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// pinger is implemented by stores which can check their backend is
+// reachable.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// readyTimeout bounds the readiness check.
+const readyTimeout = 2 * time.Second
+
+// started is when the process started, for reporting uptime.
+var started = time.Now()
+
+type healthStatus struct {
+	Status    string            `json:"status"`
+	Checks    map[string]string `json:"checks,omitempty"`
+	Uptime    string            `json:"uptime"`
+	GoVersion string            `json:"goVersion"`
+	Function  string            `json:"function,omitempty"`
+}
+
+func newHealthStatus(status string) *healthStatus {
+	return &healthStatus{
+		Status:    status,
+		Uptime:    time.Since(started).Round(time.Second).String(),
+		GoVersion: runtime.Version(),
+		Function:  os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+	}
+}
+
+// healthz reports the process is able to serve requests.
+func (a *api) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, 200, newHealthStatus("ok"))
+}
+
+// readyz reports whether the API's dependencies are available.
+func (a *api) readyz(w http.ResponseWriter, r *http.Request) {
+	status := newHealthStatus("ok")
+	code := 200
+
+	if p, ok := a.store.(pinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		status.Checks = map[string]string{"store": "ok"}
+		if err := p.Ping(ctx); err != nil {
+			status.Status = "unavailable"
+			status.Checks["store"] = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, status)
+}
@@ -3,17 +3,23 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"time"
 
 	"golang.org/x/sys/unix"
 
-	"github.com/elnormous/contenttype"
-	"github.com/go-json-experiment/json"
-	"github.com/go-json-experiment/json/jsontext"
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
 
-	"github.com/aslatter/aws-go-lambda-demo/internal/mlambda"
+// Default per-client rate limit, in requests per second, and burst.
+const (
+	defaultRateLimit = 10
+	defaultRateBurst = 20
 )
 
 func main() {
@@ -28,96 +34,141 @@ func mainErr() error {
 	ctx, close := signal.NotifyContext(context.Background(), unix.SIGINT, unix.SIGTERM)
 	defer close()
 
-	// fake rest-like API
+	// rest-like API
 	mux := &http.ServeMux{}
-	mux.HandleFunc("POST /thing", func(w http.ResponseWriter, r *http.Request) {
-		if err := checkRequestJSON(r); err != nil {
-			w.WriteHeader(400)
-			fmt.Fprintln(w, "error parsing request: ", err.Error())
-			return
-		}
+	local := os.Getenv("AWS_LAMBDA_RUNTIME_API") == ""
+	store, err := newStore()
+	if err != nil {
+		return err
+	}
+	a := &api{
+		store: store,
 
-		w.Header().Add("content-type", "application/json")
-		w.WriteHeader(201)
-		fmt.Fprintln(w, `{"id": "1234567"}`)
-	})
-	mux.HandleFunc("GET /thing", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("content-type", "application/json")
-		w.WriteHeader(200)
-		fmt.Fprintln(w, `[{"id":"1"},{"id":"2"},{"id":"3"}]`)
-	})
-	mux.HandleFunc("PUT /thing/{id}", func(w http.ResponseWriter, r *http.Request) {
-		if err := checkRequestJSON(r); err != nil {
-			w.WriteHeader(400)
-			fmt.Fprintln(w, "error parsing request: ", err.Error())
-			return
+		// there's no authorizer when running locally
+		authorize:   !local,
+		tenantClaim: os.Getenv("TENANT_CLAIM"),
+	}
+	if v := os.Getenv("CACHE_MAX_AGE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid CACHE_MAX_AGE %q", v)
+		}
+		a.maxAge = time.Duration(n) * time.Second
+	}
+	if bucket := os.Getenv("ATTACHMENTS_BUCKET"); bucket != "" {
+		client, err := awsapi.NewFromEnv()
+		if err != nil {
+			return err
 		}
+		a.attachments = &attachments{client: client, bucket: bucket}
+	}
+	a.register(mux)
+	if local {
+		mux.HandleFunc("GET /docs", serveSwaggerUI)
+	}
+	mux.HandleFunc("/", notFound)
+
+	// wrap the mux with some handling to prove we can work with http-headers
+	var handler http.Handler = negotiate(methods(mux))
 
-		id := r.PathValue("id")
-		if id == "" {
-			w.WriteHeader(400)
-			fmt.Fprintln(w, "Missing id-path-component")
-			return
+	maxBodyBytes := int64(defaultMaxBodyBytes)
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid MAX_BODY_BYTES %q", v)
 		}
-		w.Header().Add("content-type", "application/json")
-		w.WriteHeader(200)
-		fmt.Fprintf(w, "{\"id\":%s}\n", jsonQuote(id))
-	})
-	mux.HandleFunc("GET /thing/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		if id == "" {
-			w.WriteHeader(400)
-			fmt.Fprintln(w, "Missing id-path-component")
-			return
+		maxBodyBytes = n
+	}
+	handler = limitBody(handler, maxBodyBytes)
+
+	// throttle each client by its source IP. API Gateway propagates it
+	// as the request's RemoteAddr.
+	rate, burst := float64(defaultRateLimit), defaultRateBurst
+	if v := os.Getenv("RATE_LIMIT"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid RATE_LIMIT %q", v)
 		}
-		w.Header().Add("content-type", "application/json")
-		w.WriteHeader(200)
-		fmt.Fprintf(w, "{\"id\":%s}\n", jsonQuote(id))
-	})
-	mux.HandleFunc("DELETE /thing/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		if id == "" {
-			w.WriteHeader(400)
-			fmt.Fprintln(w, "Missing id-path-component")
-			return
+		rate = n
+	}
+	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid RATE_LIMIT_BURST %q", v)
 		}
+		burst = n
+	}
+	handler = mlambda.RateLimit(handler, mlambda.RateLimitOptions{
+		Rate:    rate,
+		Burst:   burst,
+		Problem: problemRateLimited.newProblem("too many requests from this address"),
 	})
-	mux.Handle("/", http.NotFoundHandler())
+	handler = mlambda.SetSecurityHeaders(handler, mlambda.DefaultSecurityHeaders)
 
-	// wrap the mux with some handling to prove we can work with http-headers
-	availableMediaTypes := []contenttype.MediaType{contenttype.NewMediaType("application/json")}
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost || r.Method == http.MethodPut {
-			if r.Header.Get("content-type") != "application/json" {
-				w.WriteHeader(400)
-				fmt.Fprintln(w, "content-type header must be application/json")
-				return
-			}
-		}
-		if r.Method == http.MethodGet {
-			_, _, err := contenttype.GetAcceptableMediaType(r, availableMediaTypes)
-			if err != nil {
-				w.WriteHeader(400)
-				fmt.Fprintln(w, "accept header must be application/json")
-				return
-			}
-		}
-		mux.ServeHTTP(w, r)
+	// log every request, including those rejected by the middleware.
+	// The log-handler adds the lambda request-id to every record.
+	logger := slog.New(mlambda.NewLogHandler(slog.NewJSONHandler(os.Stdout, nil)))
+	slog.SetDefault(logger)
+	handler = mlambda.AccessLog(handler, mlambda.AccessLogOptions{
+		Logger: logger,
+		Fields: []string{
+			mlambda.AccessLogFieldMethod,
+			mlambda.AccessLogFieldPath,
+			mlambda.AccessLogFieldRoute,
+			mlambda.AccessLogFieldStatus,
+			mlambda.AccessLogFieldBytes,
+			mlambda.AccessLogFieldDuration,
+			mlambda.AccessLogFieldSourceIP,
+		},
 	})
 
-	srv := mlambda.Server{
-		Handler: mlambda.HttpHandler(handler),
+	// runtime options are tuned with MLAMBDA_* environment variables
+	srv, err := mlambda.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	srv.Handler = mlambda.HttpHandler(handler)
+	srv.Logger = logger
+	// streaming requires a function URL with the RESPONSE_STREAM
+	// invoke-mode. STREAM_RESPONSES is the older name for
+	// MLAMBDA_STREAM_RESPONSES, and is only read if that is unset.
+	if v := os.Getenv("STREAM_RESPONSES"); v != "" && os.Getenv("MLAMBDA_STREAM_RESPONSES") == "" {
+		stream, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid STREAM_RESPONSES %q", v)
+		}
+		srv.StreamResponses = stream
 	}
 
 	return srv.Start(ctx)
 }
 
-func jsonQuote(s string) string {
-	b, _ := jsontext.AppendQuote(nil, s)
-	return string(b)
-}
+// newStore returns a DynamoDB store if THINGS_TABLE is set, otherwise
+// an in-memory store. If EVENT_BUS is set, changes are published to that
+// EventBridge bus, through the outbox in EVENT_OUTBOX_TABLE if it is
+// set.
+func newStore() (Store, error) {
+	var store Store = newMemStore()
+	table := os.Getenv("THINGS_TABLE")
+	bus := os.Getenv("EVENT_BUS")
+	if table == "" && bus == "" {
+		return store, nil
+	}
 
-func checkRequestJSON(r *http.Request) error {
-	var v any
-	return json.UnmarshalRead(r.Body, &v)
+	// the client (and its connections) is shared by every invocation
+	client, err := awsapi.NewFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if table != "" {
+		store = &dynamoStore{
+			client:      client,
+			table:       table,
+			statusIndex: os.Getenv("THINGS_STATUS_INDEX"),
+		}
+	}
+	if bus != "" {
+		store = newEventStore(store, client, bus, os.Getenv("EVENT_OUTBOX_TABLE"))
+	}
+	return store, nil
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/elnormous/contenttype"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+var problemMediaType = contenttype.NewMediaType(mlambda.ProblemContentType)
+
+// responseMediaTypes are the media-types the API responds with, other
+// than from the export (see exportMediaTypes).
+var responseMediaTypes = []contenttype.MediaType{
+	contenttype.NewMediaType("application/json"),
+	problemMediaType,
+}
+
+// negotiate checks the request's media-types: the response must be
+// acceptable to the client (otherwise a 406 is returned), and POST and
+// PUT bodies must be JSON (otherwise a 415 is returned). Routes with
+// other request media-types (such as PATCH) check them themselves.
+func negotiate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		available, desc := responseMediaTypes, "responses are application/json"
+		if r.URL.Path == exportPath {
+			available, desc = slices.Concat(exportMediaTypes, []contenttype.MediaType{problemMediaType}), "exports are application/x-ndjson or text/csv"
+		}
+		if _, _, err := contenttype.GetAcceptableMediaType(r, available); err != nil {
+			writeProblem(w, r, problemNotAcceptable, desc)
+			return
+		}
+
+		// requests without a body (such as POST /thing/{id}/attachment)
+		// need no content-type
+		hasBody := r.ContentLength != 0 || r.Header.Get("Content-Type") != ""
+		if hasBody && (r.Method == http.MethodPost || r.Method == http.MethodPut) {
+			if mt, ok := requestMediaType(r); !ok || !isJSON(mt) {
+				writeProblem(w, r, problemUnsupportedMedia, "content-type must be application/json")
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestMediaType parses the request's Content-Type, with the type
+// and subtype lower-cased. It returns false if the header is missing
+// or malformed, or if it has a charset other than UTF-8 (the only
+// encoding the API reads).
+func requestMediaType(r *http.Request) (contenttype.MediaType, bool) {
+	if r.Header.Get("Content-Type") == "" {
+		return contenttype.MediaType{}, false
+	}
+	mt, err := contenttype.GetMediaType(r)
+	if err != nil {
+		return contenttype.MediaType{}, false
+	}
+	mt.Type = strings.ToLower(mt.Type)
+	mt.Subtype = strings.ToLower(mt.Subtype)
+	for k, v := range mt.Parameters {
+		if strings.EqualFold(k, "charset") && !strings.EqualFold(v, "utf-8") {
+			return contenttype.MediaType{}, false
+		}
+	}
+	return mt, true
+}
+
+// isJSON reports whether mt is application/json or a structured-syntax
+// JSON type (application/*+json).
+func isJSON(mt contenttype.MediaType) bool {
+	return mt.Type == "application" && (mt.Subtype == "json" || strings.HasSuffix(mt.Subtype, "+json"))
+}
+
+// defaultMaxBodyBytes is the default request-body limit. API Gateway
+// accepts payloads up to 10MB, far more than a thing needs.
+const defaultMaxBodyBytes = 1 << 20
+
+// limitBody caps request-bodies at limit bytes. Handlers see an
+// *http.MaxBytesError when reading beyond it, which requestError
+// reports as a 413.
+func limitBody(h http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeProblem(w, r, problemTooLarge, tooLargeDetail(limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestError classifies an error reading or parsing the request-body.
+func requestError(err error) (problemType, string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return problemTooLarge, tooLargeDetail(tooLarge.Limit)
+	}
+	return problemInvalidRequest, "error parsing request: " + err.Error()
+}
+
+func tooLargeDetail(limit int64) string {
+	return fmt.Sprintf("request body must be at most %d bytes", limit)
+}
+
+// routeMethods are the methods routes may be registered with.
+var routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// allowedMethods returns the methods mux routes for the request's path.
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allowed []string
+	probe := *r
+	for _, m := range routeMethods {
+		probe.Method = m
+		if _, pattern := mux.Handler(&probe); pattern != "" && pattern != "/" {
+			allowed = append(allowed, m)
+			if m == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+	}
+	if len(allowed) > 0 {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	return allowed
+}
+
+// methods handles the methods which aren't routed explicitly: OPTIONS
+// requests (including CORS preflights) are answered with the allowed
+// methods, HEAD requests are served by the GET route without a body,
+// and requests with other methods receive a 405.
+func methods(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" && pattern != "/" {
+			mlambda.SetRoute(r, pattern)
+			if r.Method == http.MethodHead {
+				w = &headResponseWriter{ResponseWriter: w}
+			}
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := allowedMethods(mux, r)
+		if len(allowed) == 0 {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		allow := strings.Join(allowed, ", ")
+		w.Header().Set("Allow", allow)
+
+		if r.Method != http.MethodOptions {
+			writeProblem(w, r, problemMethodNotAllowed, r.Method+" is not allowed")
+			return
+		}
+		if r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+			if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+				w.Header().Set("Access-Control-Allow-Headers", h)
+			}
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// headResponseWriter discards the response-body, for HEAD requests.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write implements http.ResponseWriter.
+func (h *headResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Unwrap supports http.ResponseController.
+func (h *headResponseWriter) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
@@ -0,0 +1,238 @@
+package mlambda
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects the output format of AccessLog.
+type AccessLogFormat int
+
+const (
+	// AccessLogJSON logs a structured record through a *slog.Logger.
+	AccessLogJSON AccessLogFormat = iota
+
+	// AccessLogCommon writes lines in the Common Log Format.
+	AccessLogCommon
+
+	// AccessLogCombined writes lines in the Apache "combined" format,
+	// which adds the referer and user-agent to the common format.
+	AccessLogCombined
+)
+
+// Fields available in AccessLogJSON records.
+const (
+	AccessLogFieldMethod    = "method"
+	AccessLogFieldPath      = "path"
+	AccessLogFieldQuery     = "query"
+	AccessLogFieldRoute     = "route"
+	AccessLogFieldStatus    = "status"
+	AccessLogFieldBytes     = "bytes"
+	AccessLogFieldDuration  = "duration"
+	AccessLogFieldRequestID = "requestId"
+	AccessLogFieldSourceIP  = "sourceIp"
+	AccessLogFieldUserAgent = "userAgent"
+	AccessLogFieldReferer   = "referer"
+)
+
+var defaultAccessLogFields = []string{
+	AccessLogFieldMethod,
+	AccessLogFieldPath,
+	AccessLogFieldRoute,
+	AccessLogFieldStatus,
+	AccessLogFieldBytes,
+	AccessLogFieldDuration,
+	AccessLogFieldRequestID,
+	AccessLogFieldSourceIP,
+}
+
+// AccessLogOptions configures AccessLog.
+type AccessLogOptions struct {
+	Format AccessLogFormat
+
+	// Logger receives AccessLogJSON records. If nil, slog.Default()
+	// is used.
+	Logger *slog.Logger
+
+	// Writer receives AccessLogCommon and AccessLogCombined lines.
+	// If nil, os.Stdout is used.
+	Writer io.Writer
+
+	// Fields selects the fields of AccessLogJSON records. If nil,
+	// method, path, route, status, bytes, duration, requestId, and
+	// sourceIp are logged.
+	Fields []string
+
+	// Headers lists request-headers to add to AccessLogJSON records,
+	// after redaction.
+	Headers []string
+
+	// Redactor masks header-values. If nil, DefaultRedactor is used.
+	Redactor Redactor
+
+	// Sampling controls which requests are logged. Requests with a
+	// 5xx status are treated as failures. If nil, all requests are
+	// logged.
+	Sampling *LogSampling
+}
+
+// AccessLog returns middleware logging each request once it completes.
+func AccessLog(h http.Handler, opts AccessLogOptions) http.Handler {
+	a := &accessLogger{opts: opts}
+	if a.opts.Logger == nil {
+		a.opts.Logger = slog.Default()
+	}
+	if a.opts.Writer == nil {
+		a.opts.Writer = os.Stdout
+	}
+	if a.opts.Fields == nil {
+		a.opts.Fields = defaultAccessLogFields
+	}
+	if a.opts.Redactor == nil {
+		a.opts.Redactor = DefaultRedactor
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, label := withRouteLabel(r)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+
+		status := rec.statusCode()
+		failed := status >= 500
+		coldStart := false
+		if lc, ok := FromContext(r.Context()); ok {
+			coldStart = lc.ColdStart
+		}
+		if !a.opts.Sampling.shouldLog(failed, coldStart) {
+			return
+		}
+
+		e := accessLogEntry{
+			r:        r,
+			route:    routeOf(h, r, label),
+			status:   status,
+			bytes:    rec.bytes,
+			start:    start,
+			duration: time.Since(start),
+		}
+		switch a.opts.Format {
+		case AccessLogCommon, AccessLogCombined:
+			a.writeLine(&e)
+		default:
+			a.logJSON(&e)
+		}
+	})
+}
+
+type accessLogger struct {
+	opts AccessLogOptions
+
+	// mu serializes line-writes
+	mu sync.Mutex
+}
+
+type accessLogEntry struct {
+	r        *http.Request
+	route    string
+	status   int
+	bytes    int64
+	start    time.Time
+	duration time.Duration
+}
+
+func (a *accessLogger) logJSON(e *accessLogEntry) {
+	r := e.r
+	attrs := make([]slog.Attr, 0, len(a.opts.Fields)+len(a.opts.Headers))
+	for _, f := range a.opts.Fields {
+		switch f {
+		case AccessLogFieldMethod:
+			attrs = append(attrs, slog.String(f, r.Method))
+		case AccessLogFieldPath:
+			attrs = append(attrs, slog.String(f, r.URL.Path))
+		case AccessLogFieldQuery:
+			attrs = append(attrs, slog.String(f, r.URL.RawQuery))
+		case AccessLogFieldRoute:
+			attrs = append(attrs, slog.String(f, e.route))
+		case AccessLogFieldStatus:
+			attrs = append(attrs, slog.Int(f, e.status))
+		case AccessLogFieldBytes:
+			attrs = append(attrs, slog.Int64(f, e.bytes))
+		case AccessLogFieldDuration:
+			attrs = append(attrs, slog.Duration(f, e.duration))
+		case AccessLogFieldRequestID:
+			attrs = append(attrs, slog.String(f, RequestIDFromContext(r.Context())))
+		case AccessLogFieldSourceIP:
+			attrs = append(attrs, slog.String(f, r.RemoteAddr))
+		case AccessLogFieldUserAgent:
+			attrs = append(attrs, slog.String(f, r.UserAgent()))
+		case AccessLogFieldReferer:
+			attrs = append(attrs, slog.String(f, r.Referer()))
+		}
+	}
+	if len(a.opts.Headers) > 0 {
+		var headers []any
+		for _, name := range a.opts.Headers {
+			if v := r.Header.Get(name); v != "" {
+				headers = append(headers, slog.String(name, a.opts.Redactor.RedactHeader(name, v)))
+			}
+		}
+		attrs = append(attrs, slog.Group("headers", headers...))
+	}
+
+	a.opts.Logger.LogAttrs(context.WithoutCancel(r.Context()), slog.LevelInfo, "request", attrs...)
+}
+
+// writeLine writes a Common/Combined Log Format line:
+//
+//	host ident authuser [date] "request-line" status bytes "referer" "user-agent"
+func (a *accessLogger) writeLine(e *accessLogEntry) {
+	r := e.r
+
+	var b strings.Builder
+	b.WriteString(clfField(r.RemoteAddr))
+	b.WriteString(" - ")
+	user := ""
+	if gc, ok := GatewayContextFromContext(r.Context()); ok {
+		user = gc.Authorizer.Principal()
+	}
+	b.WriteString(clfField(user))
+	b.WriteString(" [")
+	b.WriteString(e.start.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString("] ")
+	b.WriteString(strconv.Quote(r.Method + " " + r.URL.RequestURI() + " " + r.Proto))
+	b.WriteString(" ")
+	b.WriteString(strconv.Itoa(e.status))
+	b.WriteString(" ")
+	if e.bytes == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString(strconv.FormatInt(e.bytes, 10))
+	}
+	if a.opts.Format == AccessLogCombined {
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(r.Referer()))
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(r.UserAgent()))
+	}
+	b.WriteString("\n")
+
+	a.mu.Lock()
+	_, _ = io.WriteString(a.opts.Writer, b.String())
+	a.mu.Unlock()
+}
+
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, " ", "_")
+}
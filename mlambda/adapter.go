@@ -0,0 +1,114 @@
+package mlambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// HTTPAdapter adapts a Handler to serve events over plain HTTP, the
+// inverse of HttpHandler: the request body is passed to h as the event,
+// and its output is returned as the response body. This allows the
+// same Handler to be deployed outside lambda, such as on ECS or EC2
+// behind a load balancer, as a fallback or alongside lambda
+// deployments.
+//
+// Each request is given a LambdaContext with a new request-id, and the
+// request's context (and so its cancellation) is passed to h. If h
+// fails before writing any output, the response is a 500 (or, for
+// ErrOverloaded, a 503); a failure after writing aborts the response.
+// The error is logged with the request-id rather than sent to the
+// client.
+//
+// The local server run by Server.Start outside lambda serves its
+// handler this way.
+func HTTPAdapter(h Handler) http.Handler {
+	return &httpAdapter{handler: h}
+}
+
+type httpAdapter struct {
+	handler Handler
+
+	// withContext, if set, adds to the context of each invocation,
+	// and done is called after each invocation.
+	withContext func(context.Context) context.Context
+	done        func(context.Context)
+
+	// warm is set after the first invocation.
+	warm atomic.Bool
+}
+
+// ServeHTTP implements http.Handler.
+func (a *httpAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wrapper := &writerWrapper{w: w}
+	requestId := newLocalRequestID()
+	ctx := ContextWithLambdaContext(r.Context(), &LambdaContext{
+		RequestID: requestId,
+		ColdStart: a.warm.CompareAndSwap(false, true),
+	})
+	if a.withContext != nil {
+		ctx = a.withContext(ctx)
+	}
+	err := a.handler.Invoke(ctx, wrapper, &Request{Body: r.Body})
+	if a.done != nil {
+		a.done(ctx)
+	}
+	if err == nil {
+		return
+	}
+
+	// the error may describe internals, so it is logged rather
+	// than returned to the client
+	overloaded := errors.Is(err, ErrOverloaded)
+	level := slog.LevelError
+	if overloaded {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "handler failed", "requestId", requestId, "error", err)
+
+	if overloaded && !wrapper.didWrite {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(503)
+		fmt.Fprintln(w, http.StatusText(503))
+		return
+	}
+
+	if !wrapper.didWrite {
+		// return 500 if the handler hasn't started writing the response yet
+		w.WriteHeader(500)
+		fmt.Fprintln(w, http.StatusText(500))
+		return
+	}
+
+	// otherwise signal to the http package to close the response
+	// uncleanly, so the caller at least knows something went wrong
+	panic(http.ErrAbortHandler)
+}
+
+var _ http.Handler = (*httpAdapter)(nil)
+
+type writerWrapper struct {
+	w        io.Writer
+	didWrite bool
+}
+
+// Write implements io.Writer.
+func (w *writerWrapper) Write(p []byte) (n int, err error) {
+	w.didWrite = true
+	return w.w.Write(p)
+}
+
+// Flush implements Flusher.
+func (w *writerWrapper) Flush() error {
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+var _ io.Writer = (*writerWrapper)(nil)
+var _ Flusher = (*writerWrapper)(nil)
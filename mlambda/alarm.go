@@ -0,0 +1,186 @@
+package mlambda
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// Alarm states.
+const (
+	AlarmStateOK               = "OK"
+	AlarmStateAlarm            = "ALARM"
+	AlarmStateInsufficientData = "INSUFFICIENT_DATA"
+)
+
+// AlarmEvent is a CloudWatch alarm state-change, as delivered to a
+// function which is the alarm's action, or through EventBridge (as a
+// "CloudWatch Alarm State Change" event).
+type AlarmEvent struct {
+	AlarmARN  string
+	AlarmName string
+	AccountID string
+	Region    string
+	Time      time.Time
+
+	State         AlarmState
+	PreviousState AlarmState
+	Configuration AlarmConfiguration
+}
+
+// AlarmState is the state of an alarm.
+type AlarmState struct {
+	// Value is AlarmStateOK, AlarmStateAlarm, or
+	// AlarmStateInsufficientData.
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+
+	// ReasonData is a JSON document with the data behind the state,
+	// such as the evaluated datapoints.
+	ReasonData string    `json:"reasonData"`
+	Timestamp  time.Time `json:"timestamp,format:'2006-01-02T15:04:05.000-0700'"`
+}
+
+// AlarmConfiguration describes what an alarm watches.
+type AlarmConfiguration struct {
+	Description string        `json:"description"`
+	Metrics     []AlarmMetric `json:"metrics"`
+}
+
+// AlarmMetric is a metric, or metric-math expression, of an alarm.
+type AlarmMetric struct {
+	ID         string `json:"id"`
+	Label      string `json:"label"`
+	Expression string `json:"expression"`
+	ReturnData bool   `json:"returnData"`
+	MetricStat *struct {
+		Metric struct {
+			Namespace  string            `json:"namespace"`
+			Name       string            `json:"name"`
+			Dimensions map[string]string `json:"dimensions"`
+		} `json:"metric"`
+		Period int    `json:"period"`
+		Stat   string `json:"stat"`
+		Unit   string `json:"unit"`
+	} `json:"metricStat"`
+}
+
+// alarmTimeFormat is the format of alarm-action timestamps.
+const alarmTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+// ParseAlarmEvent parses an alarm-action or EventBridge alarm event.
+func ParseAlarmEvent(b []byte) (*AlarmEvent, error) {
+	type alarmData struct {
+		AlarmName     string             `json:"alarmName"`
+		State         AlarmState         `json:"state"`
+		PreviousState AlarmState         `json:"previousState"`
+		Configuration AlarmConfiguration `json:"configuration"`
+	}
+	var raw struct {
+		Source string `json:"source"`
+		Region string `json:"region"`
+
+		// alarm action
+		AlarmARN  string    `json:"alarmArn"`
+		AccountID string    `json:"accountId"`
+		Time      string    `json:"time"`
+		AlarmData alarmData `json:"alarmData"`
+
+		// EventBridge
+		DetailType string     `json:"detail-type"`
+		Account    string     `json:"account"`
+		Resources  []string   `json:"resources"`
+		Detail     *alarmData `json:"detail"`
+	}
+	if err := jsonv2.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("decoding alarm event: %s", err)
+	}
+	if raw.Source != "aws.cloudwatch" {
+		return nil, fmt.Errorf("not an alarm event: source is %q", raw.Source)
+	}
+
+	ev := &AlarmEvent{
+		AlarmARN:  raw.AlarmARN,
+		AccountID: raw.AccountID,
+		Region:    raw.Region,
+	}
+	data := &raw.AlarmData
+	layout := alarmTimeFormat
+	if raw.Detail != nil {
+		data = raw.Detail
+		layout = time.RFC3339
+		ev.AccountID = raw.Account
+		if len(raw.Resources) > 0 {
+			ev.AlarmARN = raw.Resources[0]
+		}
+	}
+	if raw.Time != "" {
+		t, err := time.Parse(layout, raw.Time)
+		if err != nil {
+			return nil, fmt.Errorf("decoding alarm event: %s", err)
+		}
+		ev.Time = t
+	}
+	ev.AlarmName = data.AlarmName
+	ev.State = data.State
+	ev.PreviousState = data.PreviousState
+	ev.Configuration = data.Configuration
+	return ev, nil
+}
+
+// AlarmFunc handles an alarm event.
+type AlarmFunc func(ctx context.Context, ev *AlarmEvent) error
+
+// AlarmRouter is a Handler dispatching alarm events to functions by the
+// alarm's name and new state, such as to run a different remediation
+// for each alarm.
+type AlarmRouter struct {
+	// Default, if set, handles events matching no route. Otherwise
+	// they are ignored.
+	Default AlarmFunc
+
+	routes []alarmRoute
+}
+
+type alarmRoute struct {
+	pattern string
+	state   string
+	f       AlarmFunc
+}
+
+// Handle routes events for alarms whose names match pattern (see
+// path.Match), changing to state (or to any state, if it is empty), to
+// f. Routes are tried in the order they were added.
+func (ar *AlarmRouter) Handle(pattern string, state string, f AlarmFunc) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("mlambda: invalid alarm pattern %q", pattern))
+	}
+	ar.routes = append(ar.routes, alarmRoute{pattern: pattern, state: state, f: f})
+}
+
+// Invoke implements Handler.
+func (ar *AlarmRouter) Invoke(ctx context.Context, w io.Writer, r *Request) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("reading event: %s", err)
+	}
+	ev, err := ParseAlarmEvent(b)
+	if err != nil {
+		return err
+	}
+	for _, route := range ar.routes {
+		if ok, _ := path.Match(route.pattern, ev.AlarmName); ok && (route.state == "" || route.state == ev.State.Value) {
+			return route.f(ctx, ev)
+		}
+	}
+	if ar.Default != nil {
+		return ar.Default(ctx, ev)
+	}
+	return nil
+}
+
+var _ Handler = (*AlarmRouter)(nil)
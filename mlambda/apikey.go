@@ -0,0 +1,202 @@
+package mlambda
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+// APIKeySource provides the active API keys, mapping each key to a
+// label identifying its holder.
+type APIKeySource interface {
+	APIKeys(ctx context.Context) (map[string]string, error)
+}
+
+// SecretsManagerAPIKeys reads API keys from a Secrets Manager secret.
+// The secret is a JSON object mapping labels to keys, such as
+// {"partner-a": "k1", "partner-b": "k2"}. Rotate a key by adding the
+// new key under a new label, then removing the old one once clients
+// have moved to it.
+type SecretsManagerAPIKeys struct {
+	Client   *awsapi.Client
+	SecretID string
+}
+
+// APIKeys implements APIKeySource.
+func (s *SecretsManagerAPIKeys) APIKeys(ctx context.Context) (map[string]string, error) {
+	v, err := getSecretString(ctx, s.Client, s.SecretID)
+	if err != nil {
+		return nil, fmt.Errorf("getting secret %s: %s", s.SecretID, err)
+	}
+	return parseAPIKeys(v)
+}
+
+var _ APIKeySource = (*SecretsManagerAPIKeys)(nil)
+
+// SSMAPIKeys reads API keys from an SSM parameter (typically a
+// SecureString), in the same format as SecretsManagerAPIKeys.
+type SSMAPIKeys struct {
+	Client *awsapi.Client
+	Name   string
+}
+
+// APIKeys implements APIKeySource.
+func (s *SSMAPIKeys) APIKeys(ctx context.Context) (map[string]string, error) {
+	v, err := getParameter(ctx, s.Client, s.Name)
+	if err != nil {
+		return nil, fmt.Errorf("getting parameter %s: %s", s.Name, err)
+	}
+	return parseAPIKeys(v)
+}
+
+var _ APIKeySource = (*SSMAPIKeys)(nil)
+
+// parseAPIKeys parses a JSON object of labels to keys, returning keys
+// to labels.
+func parseAPIKeys(v string) (map[string]string, error) {
+	var labels map[string]string
+	if err := jsonv2.Unmarshal([]byte(v), &labels); err != nil {
+		return nil, fmt.Errorf("parsing API keys: %s", err)
+	}
+	keys := make(map[string]string, len(labels))
+	for label, key := range labels {
+		if key != "" {
+			keys[key] = label
+		}
+	}
+	return keys, nil
+}
+
+// APIKeyOptions configures RequireAPIKey.
+type APIKeyOptions struct {
+	Source APIKeySource
+
+	// Header is the request-header holding the key. The default is
+	// "X-Api-Key".
+	Header string
+
+	// TTL is how long keys are cached before being read again. The
+	// default is five minutes. If reading fails, the previous keys
+	// continue to be used.
+	TTL time.Duration
+
+	// Problem is the response to requests without a valid key. If
+	// nil, a generic 401 problem is used.
+	Problem *Problem
+}
+
+// RequireAPIKey returns middleware which rejects requests without one
+// of the active API keys. The label of the key used is available to h
+// from APIKeyLabelFromContext, for attribution.
+func RequireAPIKey(h http.Handler, opts APIKeyOptions) http.Handler {
+	if opts.Source == nil {
+		panic("mlambda: APIKeyOptions requires Source")
+	}
+	if opts.Header == "" {
+		opts.Header = "X-Api-Key"
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Minute
+	}
+	if opts.Problem == nil {
+		opts.Problem = NewProblem(http.StatusUnauthorized, "")
+	}
+	c := &apiKeyCache{source: opts.Source, ttl: opts.TTL}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(opts.Header)
+		if key == "" {
+			WriteProblem(w, r, opts.Problem)
+			return
+		}
+		keys, err := c.get(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "reading API keys", "error", err)
+			WriteProblem(w, r, NewProblem(http.StatusInternalServerError, ""))
+			return
+		}
+		label, ok := keys.match(key)
+		if !ok {
+			WriteProblem(w, r, opts.Problem)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyLabelKey{}, label)))
+	})
+}
+
+type apiKeyLabelKey struct{}
+
+// APIKeyLabelFromContext returns the label of the API key a request
+// was authenticated with by RequireAPIKey.
+func APIKeyLabelFromContext(ctx context.Context) (string, bool) {
+	label, ok := ctx.Value(apiKeyLabelKey{}).(string)
+	return label, ok
+}
+
+// apiKeys holds the hashes of the active keys, so they can be compared
+// in constant time regardless of length.
+type apiKeys []apiKey
+
+type apiKey struct {
+	hash  [sha256.Size]byte
+	label string
+}
+
+// match returns the label of key, if it is active. Every key is
+// compared, so the time taken doesn't reveal which matched.
+func (ks apiKeys) match(key string) (string, bool) {
+	h := sha256.Sum256([]byte(key))
+	var label string
+	var found int
+	for _, k := range ks {
+		eq := subtle.ConstantTimeCompare(h[:], k.hash[:])
+		if eq == 1 {
+			label = k.label
+		}
+		found |= eq
+	}
+	return label, found == 1
+}
+
+type apiKeyCache struct {
+	source APIKeySource
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	keys    apiKeys
+	fetched time.Time
+}
+
+func (c *apiKeyCache) get(ctx context.Context) (apiKeys, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys != nil && time.Since(c.fetched) < c.ttl {
+		return c.keys, nil
+	}
+	m, err := c.source.APIKeys(ctx)
+	if err != nil {
+		if c.keys == nil {
+			return nil, err
+		}
+		// keep using the old keys until the next refresh
+		slog.WarnContext(ctx, "refreshing API keys", "error", err)
+		c.fetched = time.Now()
+		return c.keys, nil
+	}
+	keys := make(apiKeys, 0, len(m))
+	for key, label := range m {
+		keys = append(keys, apiKey{hash: sha256.Sum256([]byte(key)), label: label})
+	}
+	c.keys, c.fetched = keys, time.Now()
+	return keys, nil
+}
@@ -0,0 +1,205 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var appConfigDataService = awsapi.Service{
+	SigningName:    "appconfig",
+	EndpointPrefix: "appconfigdata",
+}
+
+// FeatureFlags reads feature-flags from an AppConfig configuration
+// profile of the AWS.AppConfig.FeatureFlags type, either from the
+// AppConfig data plane or from the AppConfig agent extension.
+//
+// Flags are cached, and polled for changes at most every PollInterval
+// (default one minute), so they can be evaluated on every invocation.
+// If polling fails, the previous flags continue to be used; until
+// flags are first read, every flag is disabled.
+//
+// Using the data plane, the function needs
+// appconfig:StartConfigurationSession and
+// appconfig:GetLatestConfiguration.
+type FeatureFlags struct {
+	// Client reads flags from the data plane, unless AgentURL is set.
+	Client *awsapi.Client
+
+	// AgentURL is the base-URL of the AppConfig agent extension, such
+	// as "http://localhost:2772".
+	AgentURL string
+
+	Application string
+	Environment string
+	Profile     string
+
+	PollInterval time.Duration
+
+	// OnEvaluate, if set, is called with the result of each flag
+	// evaluation, such as to log which flags an invocation saw.
+	OnEvaluate func(ctx context.Context, flag string, enabled bool)
+
+	mu       sync.Mutex
+	flags    map[string]jsontext.Value
+	token    string
+	nextPoll time.Time
+}
+
+// Enabled reports if a flag is enabled. Unknown flags are disabled.
+func (f *FeatureFlags) Enabled(ctx context.Context, name string) bool {
+	enabled, _ := f.Flag(ctx, name, nil)
+	return enabled
+}
+
+// Flag reports if a flag is enabled, and decodes its attributes into
+// the value pointed to by into (unless it is nil), such as:
+//
+//	var checkout struct {
+//		Variant string `json:"variant"`
+//		Limit   int    `json:"limit"`
+//	}
+//	enabled, err := flags.Flag(ctx, "new-checkout", &checkout)
+func (f *FeatureFlags) Flag(ctx context.Context, name string, into any) (bool, error) {
+	raw := f.lookup(ctx, name)
+	var enabled bool
+	var err error
+	if raw != nil {
+		var flag struct {
+			Enabled bool `json:"enabled"`
+		}
+		err = jsonv2.Unmarshal(raw, &flag, jsonv2.RejectUnknownMembers(false))
+		enabled = flag.Enabled
+		if err == nil && into != nil {
+			err = jsonv2.Unmarshal(raw, into, jsonv2.RejectUnknownMembers(false))
+		}
+		if err != nil {
+			err = fmt.Errorf("decoding flag %s: %s", name, err)
+			enabled = false
+		}
+	}
+	if f.OnEvaluate != nil {
+		f.OnEvaluate(ctx, name, enabled)
+	}
+	return enabled, err
+}
+
+// lookup returns a flag, polling for changes if they are due.
+func (f *FeatureFlags) lookup(ctx context.Context, name string) jsontext.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now := time.Now(); !now.Before(f.nextPoll) {
+		interval := f.PollInterval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		f.nextPoll = now.Add(interval)
+		if err := f.poll(ctx, interval); err != nil {
+			slog.WarnContext(ctx, "polling feature-flags", "error", err)
+		}
+	}
+	return f.flags[name]
+}
+
+// poll reads the flags, if they have changed.
+func (f *FeatureFlags) poll(ctx context.Context, interval time.Duration) error {
+	var doc []byte
+	var err error
+	if f.AgentURL != "" {
+		doc, err = f.getFromAgent(ctx)
+	} else {
+		doc, err = f.getLatest(ctx, interval)
+	}
+	if err != nil || len(bytes.TrimSpace(doc)) == 0 {
+		// an empty configuration from the data plane is unchanged
+		return err
+	}
+	var flags map[string]jsontext.Value
+	if err := jsonv2.Unmarshal(doc, &flags); err != nil {
+		return fmt.Errorf("decoding flags: %s", err)
+	}
+	f.flags = flags
+	return nil
+}
+
+func (f *FeatureFlags) getFromAgent(ctx context.Context) ([]byte, error) {
+	u := f.AgentURL + "/applications/" + url.PathEscape(f.Application) +
+		"/environments/" + url.PathEscape(f.Environment) +
+		"/configurations/" + url.PathEscape(f.Profile)
+	r, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from AppConfig agent: %s", resp.StatusCode, bytes.TrimSpace(b))
+	}
+	return b, nil
+}
+
+// getLatest reads the configuration from the data plane, starting a
+// session if needed. The configuration is empty if it has not changed
+// since the last call.
+func (f *FeatureFlags) getLatest(ctx context.Context, interval time.Duration) ([]byte, error) {
+	if f.token == "" {
+		var out struct {
+			InitialConfigurationToken string `json:"InitialConfigurationToken"`
+		}
+		err := f.Client.DoRESTJSON(ctx, appConfigDataService, "POST", "/configurationsessions", map[string]any{
+			"ApplicationIdentifier":                f.Application,
+			"EnvironmentIdentifier":                f.Environment,
+			"ConfigurationProfileIdentifier":       f.Profile,
+			"RequiredMinimumPollIntervalInSeconds": max(15, int(interval/time.Second)),
+		}, &out)
+		if err != nil {
+			return nil, fmt.Errorf("starting configuration session: %s", err)
+		}
+		f.token = out.InitialConfigurationToken
+	}
+
+	r, err := http.NewRequest("GET", f.Client.Endpoint(appConfigDataService)+"/configuration?configuration_token="+url.QueryEscape(f.token), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.Client.Do(ctx, appConfigDataService, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		// tokens expire, so start a new session next time
+		f.token = ""
+		return nil, fmt.Errorf("getting configuration: %s", &awsapi.APIError{StatusCode: resp.StatusCode, Code: resp.Header.Get("X-Amzn-ErrorType"), Message: string(bytes.TrimSpace(b))})
+	}
+	f.token = resp.Header.Get("Next-Poll-Configuration-Token")
+	if secs, err := strconv.Atoi(resp.Header.Get("Next-Poll-Interval-In-Seconds")); err == nil {
+		f.nextPoll = time.Now().Add(max(interval, time.Duration(secs)*time.Second))
+	}
+	return b, nil
+}
@@ -0,0 +1,159 @@
+package mlambda
+
+import (
+	"encoding"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// MessageAttribute is an SQS or SNS message-attribute, as delivered in
+// lambda events. It accepts both the SQS shape
+// ({"dataType": ..., "stringValue": ..., "binaryValue": ...}) and the
+// SNS shape ({"Type": ..., "Value": ...}).
+type MessageAttribute struct {
+	// DataType is "String", "Number", or "Binary", optionally with a
+	// custom suffix (such as "Number.int"), or, from SNS,
+	// "String.Array".
+	DataType    string
+	StringValue string
+	BinaryValue []byte
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *MessageAttribute) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		DataType    string `json:"dataType"`
+		StringValue string `json:"stringValue"`
+		BinaryValue []byte `json:"binaryValue"`
+
+		// SNS
+		Type  string `json:"Type"`
+		Value string `json:"Value"`
+	}
+	if err := jsonv2.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if raw.Type == "" {
+		*a = MessageAttribute{DataType: raw.DataType, StringValue: raw.StringValue, BinaryValue: raw.BinaryValue}
+		return nil
+	}
+
+	*a = MessageAttribute{DataType: raw.Type}
+	if strings.HasPrefix(raw.Type, "Binary") {
+		v, err := base64.StdEncoding.DecodeString(raw.Value)
+		if err != nil {
+			return fmt.Errorf("invalid binary attribute: %s", err)
+		}
+		a.BinaryValue = v
+	} else {
+		a.StringValue = raw.Value
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// DecodeMessageAttributes sets the fields of the struct pointed to by
+// into from message-attributes. Fields are matched by their "attr" tag,
+// such as:
+//
+//	type Attributes struct {
+//		TenantID string    `attr:"tenantId,required"`
+//		Priority int       `attr:"priority"`
+//		SentAt   time.Time `attr:"sentAt"`
+//		Tags     []string  `attr:"tags"`
+//	}
+//
+// String fields take the attribute's string value, numeric and bool
+// fields parse it, []byte fields take a binary value (or the bytes of a
+// string), other slices unmarshal a JSON array (an SNS "String.Array"),
+// and types implementing encoding.TextUnmarshaler (such as time.Time)
+// unmarshal the string value. Fields may be pointers, which are only
+// set if the attribute is present. Absent attributes leave fields
+// unchanged, unless the tag has the "required" option.
+func DecodeMessageAttributes(attrs map[string]MessageAttribute, into any) error {
+	v := reflect.ValueOf(into)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decoding attributes into %T: not a pointer to a struct", into)
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		tag, ok := field.Tag.Lookup("attr")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		attr, ok := attrs[name]
+		if !ok {
+			if opts == "required" {
+				return fmt.Errorf("missing attribute %q", name)
+			}
+			continue
+		}
+		if err := setAttribute(v.Field(i), attr); err != nil {
+			return fmt.Errorf("attribute %q: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func setAttribute(f reflect.Value, attr MessageAttribute) error {
+	if f.Kind() == reflect.Pointer {
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		f = f.Elem()
+	}
+	if f.Addr().Type().Implements(textUnmarshalerType) {
+		return f.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(attr.StringValue))
+	}
+
+	s := attr.StringValue
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.Uint8 {
+			b := attr.BinaryValue
+			if !strings.HasPrefix(attr.DataType, "Binary") {
+				b = []byte(s)
+			}
+			f.SetBytes(b)
+			return nil
+		}
+		return jsonv2.Unmarshal([]byte(s), f.Addr().Interface())
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package mlambda
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// AuditRecord describes who did what, and how it turned out.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Actor     string    `json:"actor"`
+	SourceIP  string    `json:"sourceIp,omitempty"`
+	Action    string    `json:"action"`
+	Resource  string    `json:"resource"`
+	Status    int       `json:"status"`
+	Outcome   string    `json:"outcome"`
+
+	// PrevHash and Hash chain the records together: Hash covers the
+	// record (with Hash empty), including PrevHash. Removing or
+	// altering a record breaks the chain.
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditSink receives serialized audit-records. Sinks may write to the
+// function's log, to a separate CloudWatch log-group, to EventBridge,
+// to S3, etc.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, record []byte) error
+}
+
+type AuditSinkFunc func(ctx context.Context, record []byte) error
+
+// WriteAudit implements AuditSink.
+func (f AuditSinkFunc) WriteAudit(ctx context.Context, record []byte) error {
+	return f(ctx, record)
+}
+
+// WriterAuditSink returns a sink writing newline-terminated records
+// to w. Writing to os.Stdout sends records to the function's log.
+func WriterAuditSink(w io.Writer) AuditSink {
+	var mu sync.Mutex
+	return AuditSinkFunc(func(ctx context.Context, record []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := w.Write(append(record, '\n'))
+		return err
+	})
+}
+
+// AuditOptions configures the Audit middleware.
+type AuditOptions struct {
+	Sink AuditSink
+
+	// Key, if set, makes the hash-chain an HMAC-SHA256 chain, so
+	// records cannot be forged without the key.
+	Key []byte
+
+	// Actor identifies the caller. The default uses the API Gateway
+	// authorizer (see Authorizer.Principal), or "anonymous".
+	Actor func(r *http.Request) string
+
+	// Filter selects which requests are audited. The default audits
+	// requests with methods other than GET, HEAD, and OPTIONS.
+	Filter func(r *http.Request) bool
+
+	// OnError is called if the sink fails. Audit failures do not
+	// affect the response.
+	OnError func(ctx context.Context, err error)
+}
+
+// Audit returns middleware recording an AuditRecord for each audited
+// request once it completes.
+func Audit(h http.Handler, opts AuditOptions) http.Handler {
+	a := &auditor{opts: opts}
+	if opts.Key != nil {
+		a.newHash = func() hash.Hash { return hmac.New(sha256.New, opts.Key) }
+	} else {
+		a.newHash = sha256.New
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.filter(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+
+		status := rec.statusCode()
+		outcome := "success"
+		if status >= 400 {
+			outcome = "failure"
+		}
+		a.write(r.Context(), &AuditRecord{
+			Time:      time.Now().UTC(),
+			RequestID: RequestIDFromContext(r.Context()),
+			Actor:     a.actor(r),
+			SourceIP:  r.RemoteAddr,
+			Action:    r.Method,
+			Resource:  r.URL.Path,
+			Status:    status,
+			Outcome:   outcome,
+		})
+	})
+}
+
+type auditor struct {
+	opts    AuditOptions
+	newHash func() hash.Hash
+
+	// mu serializes writes, so that the chain is in the order
+	// records are written.
+	mu       sync.Mutex
+	prevHash string
+}
+
+func (a *auditor) filter(r *http.Request) bool {
+	if a.opts.Filter != nil {
+		return a.opts.Filter(r)
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+func (a *auditor) actor(r *http.Request) string {
+	if a.opts.Actor != nil {
+		return a.opts.Actor(r)
+	}
+	if gc, ok := GatewayContextFromContext(r.Context()); ok {
+		if p := gc.Authorizer.Principal(); p != "" {
+			return p
+		}
+	}
+	return "anonymous"
+}
+
+func (a *auditor) write(ctx context.Context, record *AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	record.PrevHash = a.prevHash
+	record.Hash = ""
+	unsigned, err := jsonv2.Marshal(record)
+	if err == nil {
+		h := a.newHash()
+		h.Write(unsigned)
+		record.Hash = hex.EncodeToString(h.Sum(nil))
+
+		var signed []byte
+		signed, err = jsonv2.Marshal(record)
+		if err == nil {
+			err = a.opts.Sink.WriteAudit(ctx, signed)
+		}
+	}
+	if err != nil {
+		if a.opts.OnError != nil {
+			a.opts.OnError(ctx, err)
+		}
+		return
+	}
+	a.prevHash = record.Hash
+}
@@ -0,0 +1,122 @@
+package mlambda
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+	"sync"
+)
+
+// base64Body returns the encoding of a base64 request-body, and the
+// body to decode with it.
+//
+// API Gateway sends padded standard base64, but bodies from other
+// sources (and hand-written test events) may be unpadded or use the
+// URL-safe alphabet, so unless strict is set any of these are accepted.
+func base64Body(body string, strict bool) (*base64.Encoding, string) {
+	if strict {
+		return base64.StdEncoding, body
+	}
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(body, "-_") {
+		enc = base64.RawURLEncoding
+	}
+	return enc, strings.TrimRight(body, "=")
+}
+
+// base64Writer is a streaming base64-encoder, similar to the one
+// returned by base64.NewEncoder, except that it may be re-used
+// for multiple streams by calling Reset.
+type base64Writer struct {
+	w   io.Writer
+	err error
+
+	// partial input-block carried over between writes
+	buf  [3]byte
+	nbuf int
+
+	out [1024]byte
+}
+
+var base64WriterPool = sync.Pool{
+	New: func() any { return &base64Writer{} },
+}
+
+// getBase64Writer returns a pooled encoder writing to w. Callers
+// should return it with putBase64Writer after calling Close.
+func getBase64Writer(w io.Writer) *base64Writer {
+	e := base64WriterPool.Get().(*base64Writer)
+	e.Reset(w)
+	return e
+}
+
+func putBase64Writer(e *base64Writer) {
+	e.Reset(nil)
+	base64WriterPool.Put(e)
+}
+
+// Reset discards any state and begins encoding to w.
+func (e *base64Writer) Reset(w io.Writer) {
+	e.w = w
+	e.err = nil
+	e.nbuf = 0
+}
+
+// Write implements io.Writer.
+func (e *base64Writer) Write(p []byte) (n int, err error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	// finish a leftover block
+	if e.nbuf > 0 {
+		var i int
+		for i = 0; i < len(p) && e.nbuf < 3; i++ {
+			e.buf[e.nbuf] = p[i]
+			e.nbuf++
+		}
+		n += i
+		p = p[i:]
+		if e.nbuf < 3 {
+			return n, nil
+		}
+		base64.StdEncoding.Encode(e.out[:], e.buf[:])
+		if _, e.err = e.w.Write(e.out[:4]); e.err != nil {
+			return n, e.err
+		}
+		e.nbuf = 0
+	}
+
+	// encode whole blocks
+	for len(p) >= 3 {
+		nn := len(e.out) / 4 * 3
+		if nn > len(p) {
+			nn = len(p) - len(p)%3
+		}
+		base64.StdEncoding.Encode(e.out[:], p[:nn])
+		if _, e.err = e.w.Write(e.out[:nn/3*4]); e.err != nil {
+			return n, e.err
+		}
+		n += nn
+		p = p[nn:]
+	}
+
+	// stash the remainder
+	copy(e.buf[:], p)
+	e.nbuf = len(p)
+	n += len(p)
+	return n, nil
+}
+
+// Close flushes any pending output, including padding. It does
+// not close the underlying writer.
+func (e *base64Writer) Close() error {
+	if e.err == nil && e.nbuf > 0 {
+		base64.StdEncoding.Encode(e.out[:], e.buf[:e.nbuf])
+		_, e.err = e.w.Write(e.out[:base64.StdEncoding.EncodedLen(e.nbuf)])
+		e.nbuf = 0
+	}
+	return e.err
+}
+
+var _ io.WriteCloser = (*base64Writer)(nil)
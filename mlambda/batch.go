@@ -0,0 +1,325 @@
+package mlambda
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// Event-sources of batch records.
+const (
+	SourceSQS      = "aws:sqs"
+	SourceKinesis  = "aws:kinesis"
+	SourceDynamoDB = "aws:dynamodb"
+	SourceKafka    = "aws:kafka"
+)
+
+// BatchRecord is a record of an SQS, Kinesis, DynamoDB streams, or
+// Kafka (MSK or self-managed) event.
+type BatchRecord struct {
+	// ID identifies the record in partial-failure responses: the SQS
+	// message-id, the Kinesis or DynamoDB sequence-number, or, for
+	// Kafka, the topic-partition and offset ("topic-0@42").
+	ID string
+
+	// Source is the event-source, such as SourceSQS.
+	Source string
+
+	// Group is the record's ordering group: the SQS FIFO
+	// message-group, the Kinesis partition-key, the DynamoDB item-key,
+	// or the Kafka topic-partition. It is empty for standard SQS
+	// queues, whose records are unordered.
+	Group string
+
+	// Attempts is how many times the record has been delivered,
+	// including this time. It is only counted by SQS, and is zero for
+	// other sources.
+	Attempts int
+
+	// Raw is the record as delivered.
+	Raw jsontext.Value
+}
+
+// Decode unmarshals the record into the value pointed to by into.
+func (r *BatchRecord) Decode(into any) error {
+	return jsonv2.Unmarshal(r.Raw, into)
+}
+
+// ordered reports whether the records of r's group must be processed
+// in order.
+func (r *BatchRecord) ordered() bool {
+	return r.Source != SourceSQS || r.Group != ""
+}
+
+// BatchError is returned by a MicroBatchFunc to fail only some of the
+// records it was given.
+type BatchError struct {
+	// Failed holds the IDs of the failed records.
+	Failed []string
+	Err    error
+}
+
+// Error implements error.
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d records failed: %s", len(e.Failed), e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// MicroBatchFunc processes a group of records. Returning an error fails
+// every record, unless it is a *BatchError naming the failed ones.
+type MicroBatchFunc func(ctx context.Context, group string, records []*BatchRecord) error
+
+// MicroBatchOptions configures MicroBatchHandler.
+type MicroBatchOptions struct {
+	// MaxRecords and MaxBytes (of raw records) limit how many records
+	// are passed to each call. Zero means no limit.
+	MaxRecords int
+	MaxBytes   int
+
+	// Group, if set, assigns records to application-defined groups.
+	// By default records are grouped by BatchRecord.Group.
+	Group func(r *BatchRecord) string
+
+	// TimeReserve is how much of the invocation's time to leave when
+	// starting a call. Once less remains, the remaining records are
+	// reported as failed, so they are retried rather than cut off by
+	// the timeout.
+	TimeReserve time.Duration
+
+	// DeadLetters, if set, receives records which have failed
+	// MaxAttempts times. Once written, they are reported as processed,
+	// so they are not retried. Records whose attempts are not counted
+	// (those of all but SQS) are written on their first failure.
+	DeadLetters DeadLetterWriter
+	MaxAttempts int
+
+	// Checkpoint, if set, is called for each partition of a Kafka
+	// event with the offset of the last record processed (before any
+	// failure), so sinks can track their progress externally.
+	Checkpoint KafkaCheckpointFunc
+}
+
+// MicroBatchHandler adapts a function processing groups of records to
+// handle SQS, Kinesis, DynamoDB streams, and Kafka events.
+//
+// Records are divided into groups, and each group into batches of up to
+// opts.MaxRecords records (and opts.MaxBytes bytes), which are passed
+// to f in order. Failed records are reported in a partial batch
+// response, so the event-source mapping must enable
+// ReportBatchItemFailures. For ordered sources (all but standard SQS
+// queues), once a record of a group fails the rest of the group is not
+// processed and is reported as failed too, preserving their order when
+// retried. Kafka events do not support partial failures, so any failure
+// fails the invocation. With opts.DeadLetters, records which keep
+// failing are set aside rather than retried indefinitely.
+func MicroBatchHandler(f MicroBatchFunc, opts MicroBatchOptions) Handler {
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		records, err := readBatchRecords(r.Body)
+		if err != nil {
+			return err
+		}
+
+		var groups []string
+		grouped := map[string][]*BatchRecord{}
+		for _, rec := range records {
+			g := rec.Group
+			if opts.Group != nil {
+				g = opts.Group(rec)
+			}
+			if _, ok := grouped[g]; !ok {
+				groups = append(groups, g)
+			}
+			grouped[g] = append(grouped[g], rec)
+		}
+
+		var failed []string
+		var errored []FailedRecord
+		var firstErr error
+		for _, g := range groups {
+			pending := grouped[g]
+			for len(pending) > 0 {
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < opts.TimeReserve {
+					failed = append(failed, recordIDs(pending)...)
+					firstErr = cmp.Or(firstErr, context.DeadlineExceeded)
+					break
+				}
+
+				batch := nextMicroBatch(pending, opts)
+				pending = pending[len(batch):]
+				batchFailed, err := callMicroBatch(ctx, f, g, batch)
+				if err == nil {
+					continue
+				}
+				firstErr = cmp.Or(firstErr, err)
+				for _, r := range batchFailed {
+					errored = append(errored, FailedRecord{Record: r, Err: err})
+				}
+				if !batch[0].ordered() {
+					failed = append(failed, recordIDs(batchFailed)...)
+					continue
+				}
+				// fail everything from the first failed record on
+				i := slices.IndexFunc(batch, func(r *BatchRecord) bool {
+					return slices.Contains(batchFailed, r)
+				})
+				failed = append(failed, recordIDs(batch[max(i, 0):])...)
+				failed = append(failed, recordIDs(pending)...)
+				break
+			}
+		}
+
+		if opts.DeadLetters != nil && len(errored) > 0 {
+			failed = deadLetter(ctx, opts, errored, failed)
+		}
+		if opts.Checkpoint != nil && len(records) > 0 && records[0].Source == SourceKafka {
+			if err := checkpointKafka(ctx, opts.Checkpoint, records, failed); err != nil {
+				return err
+			}
+		}
+		if len(failed) > 0 && records[0].Source == SourceKafka {
+			return fmt.Errorf("processing records: %s", firstErr)
+		}
+		type itemFailure struct {
+			ItemIdentifier string `json:"itemIdentifier"`
+		}
+		resp := struct {
+			BatchItemFailures []itemFailure `json:"batchItemFailures"`
+		}{BatchItemFailures: []itemFailure{}}
+		for _, id := range failed {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, itemFailure{ItemIdentifier: id})
+		}
+		return jsonv2.MarshalWrite(w, &resp)
+	})
+}
+
+// callMicroBatch calls f, returning the records which failed.
+func callMicroBatch(ctx context.Context, f MicroBatchFunc, group string, batch []*BatchRecord) ([]*BatchRecord, error) {
+	err := f(ctx, group, batch)
+	if err == nil {
+		return nil, nil
+	}
+	var be *BatchError
+	if errors.As(err, &be) {
+		var failed []*BatchRecord
+		for _, r := range batch {
+			if slices.Contains(be.Failed, r.ID) {
+				failed = append(failed, r)
+			}
+		}
+		return failed, err
+	}
+	return batch, err
+}
+
+// nextMicroBatch returns the first records of pending within the
+// limits of opts, and always at least one record.
+func nextMicroBatch(pending []*BatchRecord, opts MicroBatchOptions) []*BatchRecord {
+	n, size := 0, 0
+	for n < len(pending) {
+		if opts.MaxRecords > 0 && n == opts.MaxRecords {
+			break
+		}
+		size += len(pending[n].Raw)
+		if opts.MaxBytes > 0 && size > opts.MaxBytes && n > 0 {
+			break
+		}
+		n++
+	}
+	return pending[:n]
+}
+
+func recordIDs(records []*BatchRecord) []string {
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+// readBatchRecords parses the records of an SQS, Kinesis, DynamoDB
+// streams, or Kafka event.
+func readBatchRecords(body io.Reader) ([]*BatchRecord, error) {
+	var event struct {
+		Records      []jsontext.Value            `json:"Records"`
+		EventSource  string                      `json:"eventSource"`
+		KafkaRecords map[string][]jsontext.Value `json:"records"`
+	}
+	if err := jsonv2.UnmarshalRead(body, &event); err != nil {
+		return nil, fmt.Errorf("decoding event: %s", err)
+	}
+
+	var records []*BatchRecord
+	if event.EventSource == SourceKafka || event.EventSource == "SelfManagedKafka" {
+		partitions := make([]string, 0, len(event.KafkaRecords))
+		for p := range event.KafkaRecords {
+			partitions = append(partitions, p)
+		}
+		sort.Strings(partitions)
+		for _, p := range partitions {
+			for _, raw := range event.KafkaRecords[p] {
+				var probe struct {
+					Offset int64 `json:"offset"`
+				}
+				if err := jsonv2.Unmarshal(raw, &probe); err != nil {
+					return nil, fmt.Errorf("decoding record: %s", err)
+				}
+				records = append(records, &BatchRecord{
+					ID:     p + "@" + strconv.FormatInt(probe.Offset, 10),
+					Source: SourceKafka,
+					Group:  p,
+					Raw:    raw,
+				})
+			}
+		}
+		return records, nil
+	}
+
+	for _, raw := range event.Records {
+		var probe struct {
+			EventSource string `json:"eventSource"`
+			MessageID   string `json:"messageId"`
+			Attributes  struct {
+				MessageGroupID          string `json:"MessageGroupId"`
+				ApproximateReceiveCount string `json:"ApproximateReceiveCount"`
+			} `json:"attributes"`
+			Kinesis struct {
+				SequenceNumber string `json:"sequenceNumber"`
+				PartitionKey   string `json:"partitionKey"`
+			} `json:"kinesis"`
+			DynamoDB struct {
+				SequenceNumber string         `json:"SequenceNumber"`
+				Keys           jsontext.Value `json:"Keys"`
+			} `json:"dynamodb"`
+		}
+		if err := jsonv2.Unmarshal(raw, &probe); err != nil {
+			return nil, fmt.Errorf("decoding record: %s", err)
+		}
+		rec := &BatchRecord{Source: probe.EventSource, Raw: raw}
+		switch probe.EventSource {
+		case SourceSQS:
+			rec.ID, rec.Group = probe.MessageID, probe.Attributes.MessageGroupID
+			rec.Attempts, _ = strconv.Atoi(probe.Attributes.ApproximateReceiveCount)
+		case SourceKinesis:
+			rec.ID, rec.Group = probe.Kinesis.SequenceNumber, probe.Kinesis.PartitionKey
+		case SourceDynamoDB:
+			rec.ID, rec.Group = probe.DynamoDB.SequenceNumber, string(probe.DynamoDB.Keys)
+		default:
+			return nil, fmt.Errorf("unsupported event-source %q", probe.EventSource)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
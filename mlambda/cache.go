@@ -0,0 +1,233 @@
+package mlambda
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultCacheEntries is the default for Cache.MaxEntries.
+const defaultCacheEntries = 1000
+
+// errCacheLoadPanicked is returned to the callers of GetOrLoad waiting
+// on a load which panicked.
+var errCacheLoadPanicked = errors.New("cache: load panicked")
+
+// Cache is an in-memory, least-recently-used cache, for values (such as
+// signing-keys, configuration, or reference data) worth keeping across
+// the invocations of a warm execution-environment. The zero value is
+// ready to use, and a Cache is safe for concurrent use.
+//
+// Hits and misses are recorded as the CacheHit and CacheMiss metrics
+// (with a Cache dimension of Name), through the invocation's Metrics.
+type Cache[K comparable, V any] struct {
+	// Name identifies the cache in metrics.
+	Name string
+
+	// MaxEntries bounds the number of entries; the least-recently-used
+	// are evicted to make room. The default is 1000.
+	MaxEntries int
+
+	// TTL is how long entries are kept. Zero means until evicted.
+	TTL time.Duration
+
+	// OnEvict, if set, is called with entries as they are evicted,
+	// expire, or are removed by Delete or Close. It is called once the
+	// cache is unlocked, so may itself use the cache.
+	OnEvict func(key K, value V)
+
+	mu      sync.Mutex
+	entries map[K]*list.Element
+	lru     list.List
+	loading map[K]*cacheLoad[V]
+	evicted []*cacheEntry[K, V]
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+type cacheLoad[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// Get returns the value cached for key.
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	c.mu.Lock()
+	v, ok := c.get(key, time.Now())
+	c.unlock()
+	c.record(ctx, ok)
+	return v, ok
+}
+
+// GetOrLoad returns the value cached for key, calling load to get it
+// if there is none. Concurrent calls for the same key share one call
+// of load. Errors are not cached.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, load func(ctx context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	if v, ok := c.get(key, time.Now()); ok {
+		c.unlock()
+		c.record(ctx, true)
+		return v, nil
+	}
+	if l, ok := c.loading[key]; ok {
+		c.unlock()
+		c.record(ctx, false)
+		select {
+		case <-l.done:
+			return l.value, l.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	l := &cacheLoad[V]{done: make(chan struct{})}
+	if c.loading == nil {
+		c.loading = map[K]*cacheLoad[V]{}
+	}
+	c.loading[key] = l
+	c.unlock()
+	c.record(ctx, false)
+
+	loaded := false
+	defer func() {
+		if loaded {
+			return
+		}
+		// load panicked: release the waiters, and let the panic
+		// continue
+		l.err = errCacheLoadPanicked
+		c.mu.Lock()
+		delete(c.loading, key)
+		c.unlock()
+		close(l.done)
+	}()
+	l.value, l.err = load(ctx)
+	loaded = true
+
+	c.mu.Lock()
+	delete(c.loading, key)
+	if l.err == nil {
+		c.set(key, l.value, time.Now())
+	}
+	c.unlock()
+	close(l.done)
+	return l.value, l.err
+}
+
+// Set caches value for key.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.set(key, value, time.Now())
+}
+
+// Delete removes key from the cache.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of entries, including any which have expired
+// but not yet been removed.
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.unlock()
+	return len(c.entries)
+}
+
+// Close removes every entry, calling OnEvict for each. It is a
+// FlushFunc, so it may be passed to Server.RegisterShutdown to release
+// resources held by cached values when the execution environment
+// shuts down.
+func (c *Cache[K, V]) Close(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
+
+// get returns the value for key, if it is present and not expired.
+// c.mu must be held.
+func (c *Cache[K, V]) get(key K, now time.Time) (V, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*cacheEntry[K, V])
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		c.remove(el)
+		var zero V
+		return zero, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+// set adds or replaces an entry, evicting the least-recently-used
+// entries if the cache is full. c.mu must be held.
+func (c *Cache[K, V]) set(key K, value V, now time.Time) {
+	var expires time.Time
+	if c.TTL > 0 {
+		expires = now.Add(c.TTL)
+	}
+	if c.entries == nil {
+		c.entries = map[K]*list.Element{}
+	}
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry[K, V])
+		e.value, e.expires = value, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry[K, V]{key: key, value: value, expires: expires})
+
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	for c.lru.Len() > maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes an entry. c.mu must be held, and OnEvict is called
+// for the entry once it is released by unlock.
+func (c *Cache[K, V]) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry[K, V])
+	delete(c.entries, e.key)
+	if c.OnEvict != nil {
+		c.evicted = append(c.evicted, e)
+	}
+}
+
+// unlock releases c.mu, then calls OnEvict for the entries removed
+// while it was held.
+func (c *Cache[K, V]) unlock() {
+	evicted := c.evicted
+	c.evicted = nil
+	c.mu.Unlock()
+	for _, e := range evicted {
+		c.OnEvict(e.key, e.value)
+	}
+}
+
+func (c *Cache[K, V]) record(ctx context.Context, hit bool) {
+	name := "CacheMiss"
+	if hit {
+		name = "CacheHit"
+	}
+	RecordMetric(ctx, name, 1, UnitCount, Dimension{Name: "Cache", Value: c.Name})
+}
@@ -0,0 +1,300 @@
+package mlambda
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// CBORCodec serializes values as CBOR (RFC 8949). Values are mapped as
+// they would be to JSON; see Codec.
+//
+// Decoding accepts indefinite-length items, and ignores tags (so a
+// tagged epoch-time decodes as a number). Map keys must be strings or
+// integers.
+var CBORCodec Codec = binaryCodec{cborFormat{}}
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// cborIndefinite is the additional-information of indefinite-length
+// items, and of the "break" ending them.
+const cborIndefinite = 31
+
+type cborFormat struct{}
+
+func (cborFormat) appendHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, m|27), n)
+}
+
+func (cborFormat) appendNull(b []byte) []byte {
+	return append(b, 0xf6)
+}
+
+func (cborFormat) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xf5)
+	}
+	return append(b, 0xf4)
+}
+
+func (f cborFormat) appendInt(b []byte, v int64) []byte {
+	if v < 0 {
+		return f.appendHead(b, cborNegInt, uint64(-1-v))
+	}
+	return f.appendHead(b, cborUint, uint64(v))
+}
+
+func (f cborFormat) appendUint(b []byte, v uint64) []byte {
+	return f.appendHead(b, cborUint, v)
+}
+
+func (cborFormat) appendFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(v))
+}
+
+func (f cborFormat) appendString(b []byte, s string) []byte {
+	return append(f.appendHead(b, cborText, uint64(len(s))), s...)
+}
+
+func (f cborFormat) appendBytes(b []byte, p []byte) []byte {
+	return append(f.appendHead(b, cborBytes, uint64(len(p))), p...)
+}
+
+func (f cborFormat) appendArrayHeader(b []byte, n int) []byte {
+	return f.appendHead(b, cborArray, uint64(n))
+}
+
+func (f cborFormat) appendMapHeader(b []byte, n int) []byte {
+	return f.appendHead(b, cborMap, uint64(n))
+}
+
+// readHead reads the head of an item, returning its major type,
+// additional information, and argument.
+func (cborFormat) readHead(data []byte) (major byte, info byte, arg uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, nil, errTruncated
+	}
+	major, info, data = data[0]>>5, data[0]&0x1f, data[1:]
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), data, nil
+	case info == cborIndefinite:
+		return major, info, 0, data, nil
+	case info <= 27:
+		size = 1 << (info - 24)
+	default:
+		return 0, 0, 0, nil, fmt.Errorf("invalid CBOR additional-information %d", info)
+	}
+	if len(data) < size {
+		return 0, 0, 0, nil, errTruncated
+	}
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		arg = binary.BigEndian.Uint64(data)
+	}
+	return major, info, arg, data[size:], nil
+}
+
+// readString reads the content of a (possibly indefinite-length) byte
+// or text string.
+func (f cborFormat) readString(major byte, info byte, n uint64, data []byte) ([]byte, []byte, error) {
+	if info != cborIndefinite {
+		if n > uint64(len(data)) {
+			return nil, nil, errTruncated
+		}
+		return data[:n], data[n:], nil
+	}
+	var s []byte
+	for {
+		if len(data) > 0 && data[0] == 0xff {
+			return s, data[1:], nil
+		}
+		chunkMajor, chunkInfo, chunkLen, rest, err := f.readHead(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if chunkMajor != major || chunkInfo == cborIndefinite {
+			return nil, nil, fmt.Errorf("invalid CBOR string chunk")
+		}
+		chunk, rest, err := f.readString(major, chunkInfo, chunkLen, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, data = append(s, chunk...), rest
+	}
+}
+
+func (f cborFormat) transcode(enc *jsontext.Encoder, data []byte, depth int) ([]byte, error) {
+	if depth > maxBinaryDepth {
+		return nil, fmt.Errorf("exceeded max depth of %d", maxBinaryDepth)
+	}
+	major, info, arg, data, err := f.readHead(data)
+	if err != nil {
+		return nil, err
+	}
+	if info == cborIndefinite && (major < cborBytes || major == cborTag) {
+		return nil, fmt.Errorf("invalid indefinite-length CBOR item of type %d", major)
+	}
+
+	switch major {
+	case cborUint:
+		return data, enc.WriteToken(jsontext.Uint(arg))
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer out of range")
+		}
+		return data, enc.WriteToken(jsontext.Int(-1 - int64(arg)))
+	case cborBytes:
+		b, rest, err := f.readString(major, info, arg, data)
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.String(base64.StdEncoding.EncodeToString(b)))
+	case cborText:
+		s, rest, err := f.readString(major, info, arg, data)
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.String(string(s)))
+	case cborArray, cborMap:
+		return f.transcodeContainer(enc, major, info, arg, data, depth)
+	case cborTag:
+		return f.transcode(enc, data, depth+1)
+	}
+
+	switch info {
+	case 20, 21:
+		return data, enc.WriteToken(jsontext.Bool(info == 21))
+	case 22, 23: // null, undefined
+		return data, enc.WriteToken(jsontext.Null)
+	case 25:
+		return data, enc.WriteToken(jsontext.Float(halfToFloat(uint16(arg))))
+	case 26:
+		return data, enc.WriteToken(jsontext.Float(float64(math.Float32frombits(uint32(arg)))))
+	case 27:
+		return data, enc.WriteToken(jsontext.Float(math.Float64frombits(arg)))
+	}
+	return nil, fmt.Errorf("unsupported CBOR simple value %d", arg)
+}
+
+func (f cborFormat) transcodeContainer(enc *jsontext.Encoder, major byte, info byte, n uint64, data []byte, depth int) ([]byte, error) {
+	isMap := major == cborMap
+	// each item is at least a byte, so reject lengths the data can't
+	// hold (before doubling the length of maps, which could overflow)
+	if info != cborIndefinite && (n > uint64(len(data)) || isMap && n > uint64(len(data))/2) {
+		return nil, errTruncated
+	}
+	if isMap {
+		n *= 2
+	}
+
+	begin, end := jsontext.ArrayStart, jsontext.ArrayEnd
+	if isMap {
+		begin, end = jsontext.ObjectStart, jsontext.ObjectEnd
+	}
+	if err := enc.WriteToken(begin); err != nil {
+		return nil, err
+	}
+	for i := uint64(0); info == cborIndefinite || i < n; i++ {
+		if info == cborIndefinite && len(data) > 0 && data[0] == 0xff {
+			if isMap && i%2 != 0 {
+				return nil, fmt.Errorf("CBOR map has a key without a value")
+			}
+			data = data[1:]
+			break
+		}
+		var err error
+		if isMap && i%2 == 0 {
+			data, err = f.transcodeKey(enc, data)
+		} else {
+			data, err = f.transcode(enc, data, depth+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, enc.WriteToken(end)
+}
+
+// transcodeKey writes a map key, which JSON requires to be a string.
+func (f cborFormat) transcodeKey(enc *jsontext.Encoder, data []byte) ([]byte, error) {
+	major, info, arg, rest, err := f.readHead(data)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborText:
+		s, rest, err := f.readString(major, info, arg, rest)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(s) {
+			return nil, fmt.Errorf("invalid UTF-8 in CBOR map key")
+		}
+		return rest, enc.WriteToken(jsontext.String(string(s)))
+	case cborUint:
+		return rest, enc.WriteToken(jsontext.String(strconv.FormatUint(arg, 10)))
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer out of range")
+		}
+		return rest, enc.WriteToken(jsontext.String(strconv.FormatInt(-1-int64(arg), 10)))
+	}
+	return nil, fmt.Errorf("unsupported CBOR map key of type %d", major)
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
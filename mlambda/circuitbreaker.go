@@ -0,0 +1,417 @@
+package mlambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+// breakerBuckets is the number of buckets a CircuitBreaker's window is
+// divided into. Outcomes age out of the window a bucket at a time.
+const breakerBuckets = 10
+
+// ErrCircuitOpen is returned by a CircuitBreaker which is rejecting
+// calls.
+var ErrCircuitOpen = errors.New("circuit-breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed allows calls, counting their failures.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen rejects calls with ErrCircuitOpen.
+	BreakerOpen
+
+	// BreakerHalfOpen allows a few calls, to probe whether the
+	// dependency has recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "BreakerState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// CircuitBreaker stops calling a failing dependency, so that calls
+// fail fast rather than using up the invocation's time waiting on it.
+//
+// The breaker starts closed. When at least FailureRate of the calls in
+// the last Window have failed (and there have been at least
+// MinRequests), it opens, rejecting calls for OpenDuration. It then
+// becomes half-open, allowing HalfOpenRequests calls at a time: if one
+// succeeds the breaker closes, and if one fails it opens again.
+//
+// Its state is held in memory, so it persists across invocations of a
+// warm execution-environment. With a Store, a breaker opening in one
+// environment also opens it in the others.
+type CircuitBreaker struct {
+	// Name identifies the breaker in logs, metrics, and the Store.
+	Name string
+
+	// FailureRate is the fraction of calls which must fail to open the
+	// breaker (default 0.5), over Window (default one minute), once
+	// there have been MinRequests (default 10).
+	FailureRate float64
+	Window      time.Duration
+	MinRequests int
+
+	// OpenDuration is how long the breaker stays open. The default is
+	// 30 seconds.
+	OpenDuration time.Duration
+
+	// HalfOpenRequests is how many calls may probe the dependency at
+	// once while half-open. The default is one.
+	HalfOpenRequests int
+
+	// IsFailure reports if a call's error counts as a failure, rather
+	// than a success. If nil, every error does. Calls ending with the
+	// caller's context being canceled count as neither.
+	IsFailure func(err error) bool
+
+	// Store, if set, shares the breaker's state with other execution
+	// environments. It is read at most every SyncInterval (default ten
+	// seconds).
+	Store        BreakerStore
+	SyncInterval time.Duration
+
+	mu        sync.Mutex
+	state     BreakerState
+	openUntil time.Time
+	probes    int
+	buckets   [breakerBuckets]breakerBucket
+	lastSync  time.Time
+}
+
+// breakerOutcome is the outcome of a call through a CircuitBreaker.
+type breakerOutcome int
+
+const (
+	breakerSuccess breakerOutcome = iota
+	breakerFailure
+
+	// breakerIgnored is a call which neither succeeded nor failed,
+	// such as one canceled by its caller.
+	breakerIgnored
+)
+
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// State returns the breaker's current state.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !time.Now().Before(b.openUntil) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Do calls f, unless the breaker is open, recording whether it failed.
+// If f panics, it is recorded as failing.
+func (b *CircuitBreaker) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	done, err := b.allow(ctx)
+	if err != nil {
+		return err
+	}
+	outcome := breakerFailure
+	defer func() { done(ctx, outcome) }()
+	err = f(ctx)
+	outcome = b.outcome(err)
+	return err
+}
+
+// outcome returns the outcome of a call which returned err.
+func (b *CircuitBreaker) outcome(err error) breakerOutcome {
+	switch {
+	case err == nil:
+		return breakerSuccess
+	case errors.Is(err, context.Canceled):
+		return breakerIgnored
+	case b.IsFailure != nil && !b.IsFailure(err):
+		return breakerSuccess
+	}
+	return breakerFailure
+}
+
+// Transport returns an http.RoundTripper which sends requests with rt
+// (or http.DefaultTransport if it is nil) through the breaker.
+// Requests failing to connect, and responses with a 5xx or 429 status,
+// are failures.
+func (b *CircuitBreaker) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &breakerTransport{breaker: b, next: rt}
+}
+
+type breakerTransport struct {
+	breaker *CircuitBreaker
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *breakerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	done, err := t.breaker.allow(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", r.Method, r.URL.Redacted(), err)
+	}
+	outcome := breakerFailure
+	defer func() { done(ctx, outcome) }()
+	resp, err := t.next.RoundTrip(r)
+	switch {
+	case err != nil:
+		outcome = t.breaker.outcome(err)
+	case resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		outcome = breakerSuccess
+	}
+	return resp, err
+}
+
+var _ http.RoundTripper = (*breakerTransport)(nil)
+
+// allow reports if a call may be made, returning a function to record
+// its outcome.
+func (b *CircuitBreaker) allow(ctx context.Context) (func(ctx context.Context, outcome breakerOutcome), error) {
+	b.sync(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == BreakerOpen && !now.Before(b.openUntil) {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+	switch b.state {
+	case BreakerOpen:
+		return nil, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes >= max(1, b.HalfOpenRequests) {
+			return nil, ErrCircuitOpen
+		}
+		b.probes++
+		return b.probeDone, nil
+	}
+	return b.record, nil
+}
+
+// record records the outcome of a call made while closed.
+func (b *CircuitBreaker) record(ctx context.Context, outcome breakerOutcome) {
+	if outcome == breakerIgnored {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	bucket := b.bucket(now)
+	if outcome == breakerSuccess {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+	if b.state != BreakerClosed || outcome == breakerSuccess {
+		return
+	}
+
+	var successes, failures int
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < b.window() {
+			successes += bk.successes
+			failures += bk.failures
+		}
+	}
+	total := successes + failures
+	rate := b.FailureRate
+	if rate <= 0 {
+		rate = 0.5
+	}
+	minRequests := b.MinRequests
+	if minRequests <= 0 {
+		minRequests = 10
+	}
+	if total >= minRequests && float64(failures) >= rate*float64(total) {
+		b.open(ctx, now)
+	}
+}
+
+// probeDone records the outcome of a call made while half-open. An
+// ignored probe frees its place for another.
+func (b *CircuitBreaker) probeDone(ctx context.Context, outcome breakerOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerHalfOpen {
+		return
+	}
+	b.probes--
+	switch outcome {
+	case breakerIgnored:
+		return
+	case breakerFailure:
+		b.open(ctx, time.Now())
+		return
+	}
+	b.state = BreakerClosed
+	b.buckets = [breakerBuckets]breakerBucket{}
+	slog.InfoContext(ctx, "circuit-breaker closed", "breaker", b.Name)
+}
+
+// open opens the breaker. b.mu must be held.
+func (b *CircuitBreaker) open(ctx context.Context, now time.Time) {
+	d := b.OpenDuration
+	if d <= 0 {
+		d = 30 * time.Second
+	}
+	b.state = BreakerOpen
+	b.openUntil = now.Add(d)
+	slog.WarnContext(ctx, "circuit-breaker opened", "breaker", b.Name, "until", b.openUntil)
+	RecordMetric(ctx, "CircuitBreakerOpened", 1, UnitCount, Dimension{Name: "Breaker", Value: b.Name})
+
+	if b.Store != nil {
+		// share the trip without holding up the caller
+		until := b.openUntil
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			if err := b.Store.Trip(ctx, b.Name, until); err != nil {
+				slog.WarnContext(ctx, "sharing circuit-breaker state", "breaker", b.Name, "error", err)
+			}
+		}()
+	}
+}
+
+// bucket returns the bucket for now, clearing it if it has aged out of
+// the window. b.mu must be held.
+func (b *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := max(1, b.window()/breakerBuckets)
+	start := now.Truncate(width)
+	bk := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bk.start.Equal(start) {
+		*bk = breakerBucket{start: start}
+	}
+	return bk
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window <= 0 {
+		return time.Minute
+	}
+	return b.Window
+}
+
+// sync opens the breaker if it has been opened in another execution
+// environment.
+func (b *CircuitBreaker) sync(ctx context.Context) {
+	if b.Store == nil {
+		return
+	}
+	interval := b.SyncInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	b.mu.Lock()
+	now := time.Now()
+	due := b.state == BreakerClosed && now.Sub(b.lastSync) >= interval
+	if due {
+		b.lastSync = now
+	}
+	b.mu.Unlock()
+	if !due {
+		return
+	}
+
+	until, err := b.Store.OpenUntil(ctx, b.Name)
+	if err != nil {
+		slog.WarnContext(ctx, "reading circuit-breaker state", "breaker", b.Name, "error", err)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerClosed && time.Now().Before(until) {
+		b.state = BreakerOpen
+		b.openUntil = until
+		slog.WarnContext(ctx, "circuit-breaker opened by another environment", "breaker", b.Name, "until", until)
+	}
+}
+
+// BreakerStore shares the state of circuit-breakers between execution
+// environments.
+type BreakerStore interface {
+	// OpenUntil returns when the named breaker's most recent opening
+	// ends, or the zero time if it has not been opened.
+	OpenUntil(ctx context.Context, name string) (time.Time, error)
+
+	// Trip records that the named breaker is open until the given
+	// time.
+	Trip(ctx context.Context, name string, until time.Time) error
+}
+
+// DynamoDBBreakerStore is a BreakerStore keeping breaker-states in a
+// DynamoDB table with a string partition-key named "id". Items have an
+// "openUntil" attribute (in unix milliseconds) and an "expiresAt"
+// attribute (in unix seconds) for DynamoDB's time-to-live to clean up.
+type DynamoDBBreakerStore struct {
+	Client *awsapi.Client
+	Table  string
+}
+
+// OpenUntil implements BreakerStore.
+func (d *DynamoDBBreakerStore) OpenUntil(ctx context.Context, name string) (time.Time, error) {
+	in := map[string]any{
+		"TableName": d.Table,
+		"Key":       map[string]ddbAttr{"id": ddbString(name)},
+	}
+	var out struct {
+		Item map[string]ddbAttr
+	}
+	if err := d.Client.DoJSON(ctx, dynamoDBService, "GetItem", in, &out); err != nil {
+		return time.Time{}, err
+	}
+	attr, ok := out.Item["openUntil"]
+	if !ok || attr.N == nil {
+		return time.Time{}, nil
+	}
+	ms, err := strconv.ParseInt(*attr.N, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid openUntil %q", *attr.N)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// Trip implements BreakerStore.
+func (d *DynamoDBBreakerStore) Trip(ctx context.Context, name string, until time.Time) error {
+	in := map[string]any{
+		"TableName": d.Table,
+		"Item": map[string]ddbAttr{
+			"id":        ddbString(name),
+			"openUntil": ddbNumber(until.UnixMilli()),
+			"expiresAt": ddbNumber(until.Add(time.Hour).Unix()),
+		},
+	}
+	return d.Client.DoJSON(ctx, dynamoDBService, "PutItem", in, nil)
+}
+
+var _ BreakerStore = (*DynamoDBBreakerStore)(nil)
@@ -0,0 +1,323 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+// maxResponseSize is the lambda limit for buffered responses.
+const maxResponseSize = 6 * 1024 * 1024
+
+// defaultClaimCheckExpiry is the default for S3ClaimCheckStore.Expires.
+const defaultClaimCheckExpiry = 15 * time.Minute
+
+// ClaimCheckStore holds responses which are too large to return from
+// the lambda directly. S3ClaimCheckStore implements it using S3, with
+// PresignGet returning a pre-signed GetObject URL.
+type ClaimCheckStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	PresignGet(ctx context.Context, key string) (string, error)
+}
+
+// S3ClaimCheckStore is a ClaimCheckStore keeping responses in an S3
+// bucket under Prefix. The URLs it returns are pre-signed GetObject
+// URLs, valid for Expires (default 15 minutes). The function needs
+// s3:PutObject and s3:GetObject; a lifecycle-rule on the prefix should
+// expire the objects.
+type S3ClaimCheckStore struct {
+	Client  *awsapi.Client
+	Bucket  string
+	Prefix  string
+	Expires time.Duration
+}
+
+// Put implements ClaimCheckStore. The body is streamed as an unsigned
+// payload, rather than read into memory to be hashed.
+func (s *S3ClaimCheckStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	u, err := s3URL(s.Client, s.Bucket, s.Prefix+key)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPut, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.Client.DoUnsignedPayload(ctx, s3Service, r, body, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return s3Error(resp, b)
+	}
+	return nil
+}
+
+// PresignGet implements ClaimCheckStore.
+func (s *S3ClaimCheckStore) PresignGet(ctx context.Context, key string) (string, error) {
+	u, err := s3URL(s.Client, s.Bucket, s.Prefix+key)
+	if err != nil {
+		return "", err
+	}
+	r, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	expires := s.Expires
+	if expires <= 0 {
+		expires = defaultClaimCheckExpiry
+	}
+	return s.Client.Presign(ctx, s3Service, r, expires)
+}
+
+var _ ClaimCheckStore = (*S3ClaimCheckStore)(nil)
+
+// ClaimCheckOptions configures offloading of oversized responses.
+type ClaimCheckOptions struct {
+	Store ClaimCheckStore
+
+	// Threshold is the response-size above which the response is
+	// offloaded. The default is a little under the lambda response
+	// limit, allowing for the response envelope (and base64 encoding
+	// of HTTP bodies).
+	Threshold int64
+
+	// KeyPrefix is prepended to the generated object-key.
+	KeyPrefix string
+
+	// SpoolDir is the directory used to hold responses while they are
+	// produced, once they are too big to hold in memory. If empty, the
+	// default temp-directory is used.
+	SpoolDir string
+}
+
+// ClaimCheck wraps h so that responses larger than the threshold are
+// written to the store, and the lambda instead returns a small pointer
+// document:
+//
+//	{"mlambdaClaimCheck": {"key": "...", "url": "...", "size": 12345}}
+//
+// Responses are buffered in full, so this is not compatible with
+// response-streaming. For HTTP responses see HttpOptions.ClaimCheck.
+func ClaimCheck(h Handler, opts ClaimCheckOptions) Handler {
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = maxResponseSize - 64*1024
+	}
+
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		sp := &spool{threshold: threshold, dir: opts.SpoolDir}
+		defer sp.Close()
+
+		err := h.Invoke(ctx, sp, r)
+		if err != nil {
+			return err
+		}
+
+		if !sp.overflowed() {
+			_, err := sp.WriteTo(w)
+			return err
+		}
+
+		key, url, err := sp.offload(ctx, opts, "application/octet-stream")
+		if err != nil {
+			return err
+		}
+
+		var doc struct {
+			ClaimCheck struct {
+				Key  string `json:"key"`
+				URL  string `json:"url"`
+				Size int64  `json:"size"`
+			} `json:"mlambdaClaimCheck"`
+		}
+		doc.ClaimCheck.Key = key
+		doc.ClaimCheck.URL = url
+		doc.ClaimCheck.Size = sp.size
+		return jsonv2.MarshalWrite(w, &doc)
+	})
+}
+
+// claimCheckHttp wraps h so that response-bodies larger than the threshold
+// are written to the store, and the client receives a 303 redirect
+// to the stored body.
+func claimCheckHttp(h http.Handler, opts ClaimCheckOptions) http.Handler {
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		// the body is base64 encoded in the response
+		threshold = (maxResponseSize - 64*1024) / 4 * 3
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := &spool{threshold: threshold, dir: opts.SpoolDir}
+		defer sp.Close()
+
+		srw := &spoolResponseWriter{header: http.Header{}, spool: sp}
+		h.ServeHTTP(srw, r)
+
+		status := srw.status
+		if status == 0 {
+			status = 200
+		}
+
+		if !sp.overflowed() {
+			for k, vs := range srw.header {
+				w.Header()[k] = vs
+			}
+			w.WriteHeader(status)
+			_, _ = sp.WriteTo(w)
+			return
+		}
+
+		if status/100 != 2 {
+			// only successful responses are redirected
+			slog.ErrorContext(r.Context(), "unsuccessful response too large to return", "status", status, "size", sp.size)
+			WriteProblem(w, r, NewProblem(http.StatusBadGateway, ""))
+			return
+		}
+
+		_, url, err := sp.offload(r.Context(), opts, srw.header.Get("Content-Type"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "storing large response", "error", err)
+			WriteProblem(w, r, NewProblem(http.StatusBadGateway, ""))
+			return
+		}
+
+		w.Header().Set("Location", url)
+		w.WriteHeader(http.StatusSeeOther)
+	})
+}
+
+type spoolResponseWriter struct {
+	header http.Header
+	status int
+	spool  *spool
+}
+
+// Header implements http.ResponseWriter.
+func (s *spoolResponseWriter) Header() http.Header {
+	return s.header
+}
+
+// Write implements http.ResponseWriter.
+func (s *spoolResponseWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = 200
+	}
+	return s.spool.Write(p)
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (s *spoolResponseWriter) WriteHeader(statusCode int) {
+	if s.status == 0 {
+		s.status = statusCode
+	}
+}
+
+var _ http.ResponseWriter = (*spoolResponseWriter)(nil)
+
+// spool accumulates a response, in memory up to the threshold and in a
+// temporary file beyond that.
+type spool struct {
+	threshold int64
+	dir       string
+
+	size int64
+	buf  bytes.Buffer
+	file *os.File
+}
+
+// Write implements io.Writer.
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.size+int64(len(p)) > s.threshold {
+		f, err := os.CreateTemp(s.dir, "mlambda-response-*")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		if _, err := s.buf.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *spool) overflowed() bool {
+	return s.file != nil
+}
+
+// WriteTo implements io.WriterTo.
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	if s.file == nil {
+		return s.buf.WriteTo(w)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, s.file)
+}
+
+// offload sends the spooled data to the claim-check store, returning the
+// object-key and a URL to retrieve it.
+func (s *spool) offload(ctx context.Context, opts ClaimCheckOptions, contentType string) (string, string, error) {
+	if s.file == nil {
+		return "", "", fmt.Errorf("response not spooled")
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	key := opts.KeyPrefix + hex.EncodeToString(id[:])
+
+	if err := opts.Store.Put(ctx, key, s.file, s.size, contentType); err != nil {
+		return "", "", fmt.Errorf("storing response: %s", err)
+	}
+	url, err := opts.Store.PresignGet(ctx, key)
+	if err != nil {
+		return "", "", fmt.Errorf("presigning response: %s", err)
+	}
+	return key, url, nil
+}
+
+// Close releases any temporary file.
+func (s *spool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	_ = os.Remove(s.file.Name())
+	s.file = nil
+	return err
+}
+
+var _ io.Writer = (*spool)(nil)
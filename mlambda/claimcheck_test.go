@@ -0,0 +1,136 @@
+package mlambda
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var testCredentials = awsapi.CredentialsProviderFunc(func(ctx context.Context) (awsapi.Credentials, error) {
+	return awsapi.Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		SessionToken:    "token",
+	}, nil
+})
+
+func TestS3ClaimCheckStorePresignGet(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints map[string]string
+		expires   time.Duration
+		wantURL   string
+		wantTTL   string
+	}{
+		{
+			name:    "virtual-hosted",
+			wantURL: "https://bucket.s3.us-west-2.amazonaws.com/responses/abc",
+			wantTTL: "900",
+		},
+		{
+			name:      "path-style",
+			endpoints: map[string]string{"s3": "http://localhost:9000"},
+			expires:   time.Hour,
+			wantURL:   "http://localhost:9000/bucket/responses/abc",
+			wantTTL:   "3600",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &S3ClaimCheckStore{
+				Client: &awsapi.Client{
+					Region:      "us-west-2",
+					Credentials: testCredentials,
+					Endpoints:   tt.endpoints,
+				},
+				Bucket:  "bucket",
+				Prefix:  "responses/",
+				Expires: tt.expires,
+			}
+			got, err := store.PresignGet(context.Background(), "abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := u.Query()
+			if base := strings.TrimSuffix(got, "?"+u.RawQuery); base != tt.wantURL {
+				t.Errorf("got URL %s, want %s", base, tt.wantURL)
+			}
+			if ttl := q.Get("X-Amz-Expires"); ttl != tt.wantTTL {
+				t.Errorf("got X-Amz-Expires %s, want %s", ttl, tt.wantTTL)
+			}
+			if tok := q.Get("X-Amz-Security-Token"); tok != "token" {
+				t.Errorf("got X-Amz-Security-Token %q, want the session token", tok)
+			}
+
+			// the signature must be the one for the signing-time in
+			// the URL
+			now, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, _ := http.NewRequest(http.MethodGet, tt.wantURL, nil)
+			creds, _ := testCredentials.Retrieve(context.Background())
+			ttl, _ := time.ParseDuration(tt.wantTTL + "s")
+			if want := awsapi.Presign(r, creds, "us-west-2", "s3", now, ttl); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestS3ClaimCheckStorePut(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, gotBody = r, string(b)
+		if strings.HasSuffix(r.URL.Path, "/denied") {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		}
+	}))
+	defer hs.Close()
+
+	store := &S3ClaimCheckStore{
+		Client: &awsapi.Client{
+			Region:      "us-west-2",
+			Credentials: testCredentials,
+			Endpoints:   map[string]string{"s3": hs.URL},
+		},
+		Bucket: "bucket",
+		Prefix: "responses/",
+	}
+	err := store.Put(context.Background(), "abc", strings.NewReader("large response"), 14, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodPut || got.URL.Path != "/bucket/responses/abc" {
+		t.Errorf("got %s %s, want PUT /bucket/responses/abc", got.Method, got.URL.Path)
+	}
+	if gotBody != "large response" || got.ContentLength != 14 {
+		t.Errorf("got body %q (length %d), want the response", gotBody, got.ContentLength)
+	}
+	if h := got.Header.Get("X-Amz-Content-Sha256"); h != awsapi.UnsignedPayload {
+		t.Errorf("got X-Amz-Content-Sha256 %q, want an unsigned payload", h)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("got Content-Type %q, want text/plain", ct)
+	}
+
+	err = store.Put(context.Background(), "denied", strings.NewReader("x"), 1, "")
+	if !awsapi.IsCode(err, "AccessDenied") {
+		t.Errorf("got %v, want AccessDenied", err)
+	}
+}
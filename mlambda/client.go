@@ -0,0 +1,285 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/runtimes-api.html
+//
+
+const apiVersion = "2018-06-01"
+
+// client implements the lambda-runtime API
+type client struct {
+	client   *http.Client
+	endpoint string
+
+	// invocationPrefix is the URL prefix shared by the per-request
+	// /response and /error endpoints.
+	invocationPrefix string
+
+	// nextRequest is the long-poll request for the next event. It is
+	// built once and re-used with a per-call context.
+	nextRequest *http.Request
+
+	// header-sets sent with every response or error.
+	responseHeader http.Header
+	errorHeader    http.Header
+
+	// retry is the policy for retrying failed requests for events and
+	// error-reports. Responses are streamed, so cannot be retried.
+	retry RetryPolicy
+}
+
+// newClientFromEnv creates an instance of *client from the
+// expected lambda environment variables.
+func newClientFromEnv() (*client, error) {
+	endpoint := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if endpoint == "" {
+		return nil, fmt.Errorf("AWS_LAMBDA_RUNTIME_API not set")
+	}
+	return newClient(http.DefaultClient, endpoint)
+}
+
+// newClient creates an instance of *client for the runtime API
+// at the given host:port.
+func newClient(httpClient *http.Client, endpoint string) (*client, error) {
+	c := &client{
+		client:           httpClient,
+		endpoint:         endpoint,
+		invocationPrefix: "http://" + endpoint + "/" + apiVersion + "/runtime/invocation/",
+		responseHeader:   http.Header{},
+		retry:            NoRetry,
+		errorHeader: http.Header{
+			"Content-Type": {"application/json"},
+		},
+	}
+
+	nextRequest, err := http.NewRequest("GET", c.invocationPrefix+"next", http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	c.nextRequest = nextRequest
+
+	return c, nil
+}
+
+type request struct {
+	body               io.ReadCloser
+	contentLength      int64
+	id                 string
+	deadline           time.Time
+	invokedFunctionArn string
+	traceId            string
+	clientContext      string
+	cognitoIdentity    string
+}
+
+// statusError is an unexpected HTTP status from the runtime API.
+type statusError struct {
+	op         string
+	statusCode int
+	status     string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected '%s' http-response: %v: %s", e.op, e.statusCode, e.status)
+}
+
+// retryableRuntimeError reports if a failed request to the runtime API
+// may succeed if retried: if it failed to connect, or the runtime API
+// had an internal error.
+func retryableRuntimeError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.statusCode >= 500
+	}
+	return true
+}
+
+// nextInvocation returns the next event to be processed.
+func (c *client) nextInvocation(ctx context.Context) (*request, error) {
+	var response *http.Response
+	err := retry(ctx, c.retry, retryableRuntimeError, func() error {
+		var err error
+		response, err = c.client.Do(c.nextRequest.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		if response.StatusCode/100 != 2 {
+			response.Body.Close()
+			return &statusError{op: "next", statusCode: response.StatusCode, status: response.Status}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	headers := response.Header
+
+	var r request
+	r.body = response.Body
+	r.contentLength = response.ContentLength
+	r.id = headers.Get("Lambda-Runtime-Aws-Request-Id")
+
+	deadlineMs, err := strconv.ParseInt(headers.Get("Lambda-Runtime-Deadline-Ms"), 10, 64)
+	if err == nil {
+		r.deadline = time.UnixMilli(deadlineMs)
+	}
+
+	r.invokedFunctionArn = headers.Get("Lambda-Runtime-Invoked-Function-Arn")
+	r.traceId = headers.Get("Lambda-Runtime-Trace-Id")
+	r.clientContext = headers.Get("Lambda-Runtime-Client-Context")
+	r.cognitoIdentity = headers.Get("Lambda-Runtime-Cognito-Identity")
+
+	return &r, nil
+}
+
+// httpIntegrationContentType is the content-type of streamed responses
+// beginning with an HTTP-integration prelude.
+const httpIntegrationContentType = "application/vnd.awslambda.http-integration-response"
+
+type responseOptions struct {
+	requestId string
+	body      io.Reader
+
+	// contentType, if set, is sent as the response's content-type.
+	contentType string
+
+	// streamError, if set, describes an error reading body. The error
+	// is reported in the request's trailers, so the response must be
+	// streamed.
+	streamError func(err error) errorOptions
+}
+
+// invocationResponse returns a response for a specific event.
+func (c *client) invocationResponse(ctx context.Context, opts responseOptions) error {
+	url := c.invocationPrefix + opts.requestId + "/response"
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", url, opts.body)
+	if err != nil {
+		return err
+	}
+	httpRequest.Header = c.responseHeader
+	if opts.contentType != "" {
+		httpRequest.Header = c.responseHeader.Clone()
+		httpRequest.Header.Set("Content-Type", opts.contentType)
+	}
+	if opts.streamError != nil {
+		httpRequest.Trailer = http.Header{
+			"Lambda-Runtime-Function-Error-Type": nil,
+			"Lambda-Runtime-Function-Error-Body": nil,
+		}
+		httpRequest.Body = io.NopCloser(&errorTrailerReader{
+			r:           opts.body,
+			trailer:     httpRequest.Trailer,
+			streamError: opts.streamError,
+		})
+	}
+
+	httpResponse, err := c.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+
+	_, _ = io.Copy(io.Discard, httpResponse.Body)
+	_ = httpResponse.Body.Close()
+
+	if httpResponse.StatusCode/100 != 2 {
+		return &statusError{op: "response", statusCode: httpResponse.StatusCode, status: httpResponse.Status}
+	}
+
+	return nil
+}
+
+type errorOptions struct {
+	requestId    string
+	errorMessage string
+	errorType    string
+	stackTrace   []string
+}
+
+// body returns the error's JSON description.
+func (opts errorOptions) body() ([]byte, error) {
+	var requestBody struct {
+		ErrorMessage string   `json:"errorMessage"`
+		ErrorType    string   `json:"errorType"`
+		StackTrace   []string `json:"stackTrace,omitempty"`
+	}
+
+	requestBody.ErrorMessage = opts.errorMessage
+	requestBody.ErrorType = opts.errorType
+	requestBody.StackTrace = opts.stackTrace
+
+	return json.Marshal(&requestBody)
+}
+
+// errorTrailerReader reads a streamed response's body. If reading
+// fails, it reports the error in the request's trailers and ends the
+// body, rather than abandoning the request.
+type errorTrailerReader struct {
+	r           io.Reader
+	trailer     http.Header
+	streamError func(err error) errorOptions
+	failed      bool
+}
+
+// Read implements io.Reader.
+func (t *errorTrailerReader) Read(p []byte) (int, error) {
+	if t.failed {
+		return 0, io.EOF
+	}
+	n, err := t.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.failed = true
+		opts := t.streamError(err)
+		b, _ := opts.body()
+		t.trailer.Set("Lambda-Runtime-Function-Error-Type", opts.errorType)
+		t.trailer.Set("Lambda-Runtime-Function-Error-Body", base64.StdEncoding.EncodeToString(b))
+		err = io.EOF
+	}
+	return n, err
+}
+
+// invocationError returns an error for a specific event.
+func (c *client) invocationError(ctx context.Context, opts errorOptions) error {
+	requestBytes, err := opts.body()
+	if err != nil {
+		return err
+	}
+
+	url := c.invocationPrefix + opts.requestId + "/error"
+	return retry(ctx, c.retry, retryableRuntimeError, func() error {
+		httpRequest, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBytes))
+		if err != nil {
+			return err
+		}
+
+		httpRequest.Header = c.errorHeader.Clone()
+		httpRequest.Header.Set("Lambda-Runtime-Function-Error-Type", opts.errorType)
+
+		resp, err := c.client.Do(httpRequest)
+		if err != nil {
+			return err
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return &statusError{op: "error", statusCode: resp.StatusCode, status: resp.Status}
+		}
+		return nil
+	})
+}
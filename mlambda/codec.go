@@ -0,0 +1,693 @@
+package mlambda
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elnormous/contenttype"
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// Codec converts between lambda events (and responses) and Go values.
+type Codec interface {
+	// Decode parses event into the value pointed to by into.
+	Decode(event []byte, into any) error
+	// Encode writes the serialized from to w.
+	Encode(w io.Writer, from any) error
+}
+
+// JSONCodec is the default Codec, serializing values as JSON.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+// Decode implements Codec.
+func (jsonCodec) Decode(event []byte, into any) error {
+	return jsonv2.Unmarshal(event, into)
+}
+
+// Encode implements Codec.
+func (jsonCodec) Encode(w io.Writer, from any) error {
+	return jsonv2.MarshalWrite(w, from)
+}
+
+var _ Codec = jsonCodec{}
+
+// binaryFormat is a JSON-like binary serialization, such as CBOR.
+type binaryFormat interface {
+	appendNull(b []byte) []byte
+	appendBool(b []byte, v bool) []byte
+	appendInt(b []byte, v int64) []byte
+	appendUint(b []byte, v uint64) []byte
+	appendFloat(b []byte, v float64) []byte
+	appendString(b []byte, s string) []byte
+	appendBytes(b []byte, p []byte) []byte
+	appendArrayHeader(b []byte, n int) []byte
+	appendMapHeader(b []byte, n int) []byte
+
+	// transcode writes the first value of data to enc as JSON,
+	// returning the rest of data.
+	transcode(enc *jsontext.Encoder, data []byte, depth int) ([]byte, error)
+}
+
+// maxBinaryDepth limits the nesting of decoded values.
+const maxBinaryDepth = 1000
+
+var errTruncated = errors.New("unexpected end of data")
+
+// binaryCodec implements a Codec with a binaryFormat. Values are mapped
+// as they would be to JSON: structs by their json struct-tags, and
+// types with MarshalJSON (or MarshalText) methods, such as time.Time,
+// by transcoding the JSON they produce. Values are otherwise encoded
+// directly, with []byte as a byte-string. Events are decoded by
+// transcoding them to JSON, so decoded byte-strings become base64
+// strings, which unmarshal into []byte.
+type binaryCodec struct {
+	format binaryFormat
+}
+
+// Decode implements Codec.
+func (c binaryCodec) Decode(event []byte, into any) error {
+	var buf bytes.Buffer
+	rest, err := c.format.transcode(jsontext.NewEncoder(&buf), event, 0)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("%d bytes of trailing data", len(rest))
+	}
+	return jsonv2.Unmarshal(buf.Bytes(), into)
+}
+
+// Encode implements Codec.
+func (c binaryCodec) Encode(w io.Writer, from any) error {
+	// values are made addressable, so methods with pointer-receivers
+	// are used, as they are by jsonv2
+	v := reflect.ValueOf(from)
+	if v.IsValid() {
+		v = reflect.New(v.Type()).Elem()
+		v.Set(reflect.ValueOf(from))
+	}
+	out, err := appendValue(c.format, nil, v, 0)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+var _ Codec = binaryCodec{}
+
+// appendBinary appends the next JSON value from dec to b in format f.
+func appendBinary(f binaryFormat, b []byte, dec *jsontext.Decoder) ([]byte, error) {
+	tok, err := dec.ReadToken()
+	if err != nil {
+		return nil, err
+	}
+	switch tok.Kind() {
+	case 'n':
+		return f.appendNull(b), nil
+	case 'f', 't':
+		return f.appendBool(b, tok.Bool()), nil
+	case '"':
+		return f.appendString(b, tok.String()), nil
+	case '0':
+		s := tok.String()
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return f.appendInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return f.appendUint(b, u), nil
+		}
+		return f.appendFloat(b, tok.Float()), nil
+	case '[', '{':
+		// the header needs the length, so encode the elements first
+		end := jsontext.Kind(']')
+		if tok.Kind() == '{' {
+			end = '}'
+		}
+		var elems []byte
+		var n int
+		for dec.PeekKind() != end {
+			if elems, err = appendBinary(f, elems, dec); err != nil {
+				return nil, err
+			}
+			n++
+		}
+		if _, err := dec.ReadToken(); err != nil {
+			return nil, err
+		}
+		if end == ']' {
+			b = f.appendArrayHeader(b, n)
+		} else {
+			b = f.appendMapHeader(b, n/2)
+		}
+		return append(b, elems...), nil
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+var (
+	marshalerV1Type   = reflect.TypeFor[jsonv2.MarshalerV1]()
+	marshalerV2Type   = reflect.TypeFor[jsonv2.MarshalerV2]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// marshalsAsJSON reports if values of t are encoded by transcoding their
+// JSON: those with their own marshaling methods, and time.Duration,
+// which jsonv2 formats specially.
+func marshalsAsJSON(t reflect.Type) bool {
+	if t == durationType {
+		return true
+	}
+	for _, m := range []reflect.Type{marshalerV1Type, marshalerV2Type, textMarshalerType} {
+		if t.Implements(m) || reflect.PointerTo(t).Implements(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendValue appends v to b in format f, mapping it as jsonv2 would map
+// it to JSON.
+func appendValue(f binaryFormat, b []byte, v reflect.Value, depth int) ([]byte, error) {
+	if depth > maxBinaryDepth {
+		return nil, fmt.Errorf("exceeded max depth of %d", maxBinaryDepth)
+	}
+	if !v.IsValid() {
+		return f.appendNull(b), nil
+	}
+	t := v.Type()
+	if marshalsAsJSON(t) {
+		if (t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface) && v.IsNil() {
+			return f.appendNull(b), nil
+		}
+		if v.CanAddr() {
+			// methods may have pointer-receivers
+			v = v.Addr()
+		}
+		return appendViaJSON(f, b, v.Interface())
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return f.appendNull(b), nil
+		}
+		return appendValue(f, b, v.Elem(), depth+1)
+	case reflect.Bool:
+		return f.appendBool(b, v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return f.appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return f.appendUint(b, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		fl := v.Float()
+		if math.IsNaN(fl) || math.IsInf(fl, 0) {
+			return nil, fmt.Errorf("unsupported value %v", fl)
+		}
+		return f.appendFloat(b, fl), nil
+	case reflect.String:
+		return f.appendString(b, v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && !marshalsAsJSON(t.Elem()) {
+			p := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(p), v)
+			return f.appendBytes(b, p), nil
+		}
+		b = f.appendArrayHeader(b, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if b, err = appendValue(f, b, v.Index(i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if marshalsAsJSON(t.Key()) {
+			return appendViaJSON(f, b, v.Interface())
+		}
+		elems, n, err := appendMapEntries(f, nil, v, depth)
+		if err != nil {
+			return nil, err
+		}
+		return append(f.appendMapHeader(b, n), elems...), nil
+	case reflect.Struct:
+		return appendStruct(f, b, v, depth)
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
+
+// appendViaJSON appends v by transcoding its JSON.
+func appendViaJSON(f binaryFormat, b []byte, v any) ([]byte, error) {
+	j, err := jsonv2.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return appendBinary(f, b, jsontext.NewDecoder(bytes.NewReader(j)))
+}
+
+// appendMapEntries appends the keys and values of a map, sorted by key,
+// returning the number of entries. Integer keys are kept as integers,
+// rather than being formatted as strings as they are in JSON.
+func appendMapEntries(f binaryFormat, b []byte, v reflect.Value, depth int) ([]byte, int, error) {
+	keys := v.MapKeys()
+	switch v.Type().Key().Kind() {
+	case reflect.String:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.String(), b.String()) })
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.Int(), b.Int()) })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.Uint(), b.Uint()) })
+	default:
+		return nil, 0, fmt.Errorf("unsupported map key type %v", v.Type().Key())
+	}
+	for _, k := range keys {
+		var err error
+		if b, err = appendValue(f, b, k, depth+1); err != nil {
+			return nil, 0, err
+		}
+		if b, err = appendValue(f, b, v.MapIndex(k), depth+1); err != nil {
+			return nil, 0, err
+		}
+	}
+	return b, len(keys), nil
+}
+
+// appendStruct appends a struct as a map of its JSON fields.
+func appendStruct(f binaryFormat, b []byte, v reflect.Value, depth int) ([]byte, error) {
+	st := binaryStructOf(v.Type())
+	if st.viaJSON {
+		if v.CanAddr() {
+			v = v.Addr()
+		}
+		return appendViaJSON(f, b, v.Interface())
+	}
+
+	var elems []byte
+	var n int
+	for _, field := range st.fields {
+		fv, ok := fieldByIndex(v, field.index)
+		if !ok || field.omitzero && isZero(fv) {
+			continue
+		}
+		start := len(elems)
+		elems = f.appendString(elems, field.name)
+		valueStart := len(elems)
+		var err error
+		if field.options != "" {
+			elems, err = appendFieldViaJSON(f, elems, fv, field.options)
+		} else {
+			elems, err = appendValue(f, elems, fv, depth+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if field.omitempty && isEmptyBinary(f, elems[valueStart:]) {
+			elems = elems[:start]
+			continue
+		}
+		n++
+	}
+	if st.fallback != nil {
+		if fv, ok := fieldByIndex(v, st.fallback); ok && !fv.IsNil() {
+			var m int
+			var err error
+			if elems, m, err = appendMapEntries(f, elems, fv, depth); err != nil {
+				return nil, err
+			}
+			n += m
+		}
+	}
+	return append(f.appendMapHeader(b, n), elems...), nil
+}
+
+// appendFieldViaJSON appends the value of a struct-field with tag
+// options, such as "string" or "format", which only jsonv2 applies.
+func appendFieldViaJSON(f binaryFormat, b []byte, v reflect.Value, options string) ([]byte, error) {
+	wrapper := reflect.New(reflect.StructOf([]reflect.StructField{{
+		Name: "V",
+		Type: v.Type(),
+		Tag:  reflect.StructTag(`json:"v,` + options + `"`),
+	}})).Elem()
+	wrapper.Field(0).Set(v)
+	j, err := jsonv2.Marshal(wrapper.Interface())
+	if err != nil {
+		return nil, err
+	}
+	dec := jsontext.NewDecoder(bytes.NewReader(j))
+	// skip the object-start and the name
+	for i := 0; i < 2; i++ {
+		if _, err := dec.ReadToken(); err != nil {
+			return nil, err
+		}
+	}
+	return appendBinary(f, b, dec)
+}
+
+// fieldByIndex returns a nested field, or false if it is within a nil
+// embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isZero implements the "omitzero" option.
+func isZero(v reflect.Value) bool {
+	type isZeroer interface{ IsZero() bool }
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return true
+	}
+	if z, ok := v.Interface().(isZeroer); ok {
+		return z.IsZero()
+	}
+	if v.CanAddr() {
+		if z, ok := v.Addr().Interface().(isZeroer); ok {
+			return z.IsZero()
+		}
+	}
+	return v.IsZero()
+}
+
+// isEmptyBinary implements the "omitempty" option, reporting if an
+// encoded value is null, or an empty string, array, or map.
+func isEmptyBinary(f binaryFormat, v []byte) bool {
+	for _, empty := range [][]byte{
+		f.appendNull(nil),
+		f.appendString(nil, ""),
+		f.appendBytes(nil, nil),
+		f.appendArrayHeader(nil, 0),
+		f.appendMapHeader(nil, 0),
+	} {
+		if bytes.Equal(v, empty) {
+			return true
+		}
+	}
+	return false
+}
+
+// binaryStruct describes how a struct-type is encoded.
+type binaryStruct struct {
+	fields []binaryField
+
+	// fallback is the index of an inlined map[string]T, if any.
+	fallback []int
+
+	// viaJSON is set for structs using features (such as quoted
+	// names, or "unknown" fields) which are only handled by jsonv2.
+	viaJSON bool
+}
+
+type binaryField struct {
+	name      string
+	index     []int
+	omitzero  bool
+	omitempty bool
+
+	// options are the tag-options applied by jsonv2.
+	options string
+}
+
+// binaryStructs caches binaryStructOf.
+var binaryStructs sync.Map
+
+// binaryStructOf returns the encoding of a struct-type, following
+// jsonv2's rules for struct-fields.
+func binaryStructOf(t reflect.Type) *binaryStruct {
+	if st, ok := binaryStructs.Load(t); ok {
+		return st.(*binaryStruct)
+	}
+	st := newBinaryStruct(t)
+	binaryStructs.Store(t, st)
+	return st
+}
+
+func newBinaryStruct(t reflect.Type) *binaryStruct {
+	type candidate struct {
+		binaryField
+		depth  int
+		tagged bool
+	}
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+
+	st := &binaryStruct{}
+	viaJSON := &binaryStruct{viaJSON: true}
+	var candidates []candidate
+	visited := map[reflect.Type]bool{}
+
+	// fields are found breadth-first, descending into inlined structs
+	queue := []embedded{{t: t}}
+	for depth := 0; len(queue) > 0; depth++ {
+		var next []embedded
+		for _, e := range queue {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				index := append(slices.Clone(e.index), i)
+				tag, tagged := sf.Tag.Lookup("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				if strings.HasPrefix(name, "'") {
+					return viaJSON
+				}
+
+				field := binaryField{name: cmp.Or(name, sf.Name), index: index}
+				inline := sf.Anonymous && name == ""
+				var options []string
+				for _, opt := range splitTagOptions(opts) {
+					switch {
+					case opt == "omitzero":
+						field.omitzero = true
+					case opt == "omitempty":
+						field.omitempty = true
+					case opt == "inline":
+						inline = true
+					case opt == "unknown":
+						return viaJSON
+					case opt == "string" || strings.HasPrefix(opt, "format:"):
+						options = append(options, opt)
+					}
+				}
+				field.options = strings.Join(options, ",")
+
+				ft := sf.Type
+				if ft.Kind() == reflect.Pointer && ft.Name() == "" {
+					ft = ft.Elem()
+				}
+				if !sf.IsExported() {
+					if !sf.Anonymous || ft.Kind() != reflect.Struct {
+						continue
+					}
+					// the fields of unexported embedded structs
+					// cannot be read through reflection
+					return viaJSON
+				}
+				if inline {
+					switch {
+					case ft.Kind() == reflect.Struct && !marshalsAsJSON(ft):
+						next = append(next, embedded{t: ft, index: index})
+						continue
+					case ft.Kind() == reflect.Map && ft.Key().Kind() == reflect.String && !marshalsAsJSON(ft.Key()):
+						if st.fallback != nil {
+							return viaJSON
+						}
+						st.fallback = index
+						continue
+					case name == "" && sf.Anonymous && !slices.Contains(strings.Split(opts, ","), "inline"):
+						// an embedded non-struct is an ordinary field
+					default:
+						return viaJSON
+					}
+				}
+				candidates = append(candidates, candidate{binaryField: field, depth: depth, tagged: tagged && name != ""})
+			}
+		}
+		queue = next
+	}
+
+	// as with Go's embedded fields, the shallowest field of a name wins,
+	// unless there are several, and only one is tagged with the name
+	byName := map[string][]candidate{}
+	for _, c := range candidates {
+		byName[c.name] = append(byName[c.name], c)
+	}
+	for _, cs := range byName {
+		minDepth := cs[0].depth
+		for _, c := range cs {
+			minDepth = min(minDepth, c.depth)
+		}
+		var shallowest, tagged []candidate
+		for _, c := range cs {
+			if c.depth == minDepth {
+				shallowest = append(shallowest, c)
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+		}
+		switch {
+		case len(shallowest) == 1:
+			st.fields = append(st.fields, shallowest[0].binaryField)
+		case len(tagged) == 1:
+			st.fields = append(st.fields, tagged[0].binaryField)
+		}
+	}
+	slices.SortFunc(st.fields, func(a, b binaryField) int { return slices.Compare(a.index, b.index) })
+	return st
+}
+
+// splitTagOptions splits the options of a json struct-tag at commas,
+// other than those within the single-quoted value of an option such
+// as "format".
+func splitTagOptions(opts string) []string {
+	var out []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range opts {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			out = append(out, opts[start:i])
+			start = i + 1
+		}
+	}
+	if opts != "" {
+		out = append(out, opts[start:])
+	}
+	return out
+}
+
+// TypedOptions configures the handler returned by
+// TypedHandlerWithOptions.
+type TypedOptions struct {
+	// Codec decodes events and encodes responses. If nil, JSONCodec
+	// is used.
+	Codec Codec
+}
+
+// TypedHandler adapts a function taking and returning Go values to a
+// Handler. Each event is decoded as JSON into an In, and f's result is
+// encoded as JSON in the response. An event which cannot be decoded, or
+// an error from f, fails the invocation.
+func TypedHandler[In, Out any](f func(ctx context.Context, in In) (Out, error)) Handler {
+	return TypedHandlerWithOptions(f, TypedOptions{})
+}
+
+// TypedHandlerWithOptions is like TypedHandler, with additional
+// configuration, such as a Codec for events which are not JSON.
+func TypedHandlerWithOptions[In, Out any](f func(ctx context.Context, in In) (Out, error), opts TypedOptions) Handler {
+	codec := opts.Codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		event, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("reading event: %s", err)
+		}
+		var in In
+		if err := codec.Decode(event, &in); err != nil {
+			return fmt.Errorf("decoding event: %s", err)
+		}
+		out, err := f(ctx, in)
+		if err != nil {
+			return err
+		}
+		if err := codec.Encode(w, out); err != nil {
+			return fmt.Errorf("encoding response: %s", err)
+		}
+		return nil
+	})
+}
+
+// MediaCodec pairs a Codec with the media type it serializes.
+type MediaCodec struct {
+	MediaType string
+	Codec     Codec
+}
+
+// DefaultMediaCodecs are the codecs chosen between by RequestCodec and
+// ResponseCodec, in order of preference.
+var DefaultMediaCodecs = []MediaCodec{
+	{MediaType: "application/json", Codec: JSONCodec},
+	{MediaType: "application/cbor", Codec: CBORCodec},
+	{MediaType: "application/msgpack", Codec: MessagePackCodec},
+	{MediaType: "application/x-msgpack", Codec: MessagePackCodec},
+}
+
+// RequestCodec returns the codec for an HTTP request-body, by its
+// Content-Type. A request without a Content-Type uses the first codec.
+// It returns false if the content-type is not supported, which
+// handlers should answer with a 415.
+func RequestCodec(r *http.Request, codecs []MediaCodec) (Codec, bool) {
+	if len(codecs) == 0 {
+		return nil, false
+	}
+	if r.Header.Get("Content-Type") == "" {
+		return codecs[0].Codec, true
+	}
+	mt, err := contenttype.GetMediaType(r)
+	if err != nil {
+		return nil, false
+	}
+	for _, c := range codecs {
+		if strings.EqualFold(mt.MIME(), c.MediaType) {
+			return c.Codec, true
+		}
+	}
+	return nil, false
+}
+
+// ResponseCodec returns the codec for an HTTP response, by the
+// request's Accept header. It returns false if no codec is acceptable,
+// which handlers should answer with a 406.
+func ResponseCodec(r *http.Request, codecs []MediaCodec) (MediaCodec, bool) {
+	available := make([]contenttype.MediaType, len(codecs))
+	for i, c := range codecs {
+		available[i] = contenttype.NewMediaType(c.MediaType)
+	}
+	mt, _, err := contenttype.GetAcceptableMediaType(r, available)
+	if err != nil {
+		return MediaCodec{}, false
+	}
+	for _, c := range codecs {
+		if strings.EqualFold(mt.MIME(), c.MediaType) {
+			return c, true
+		}
+	}
+	return MediaCodec{}, false
+}
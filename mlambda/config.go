@@ -0,0 +1,172 @@
+package mlambda
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ConfigFromEnv returns a Server configured from environment variables,
+// so deployments can tune the runtime without code changes. Unset
+// variables leave the Server's defaults. The variables are:
+//
+//	MLAMBDA_LOCAL_ADDR              LocalAddr
+//	MLAMBDA_STREAM_RESPONSES        StreamResponses
+//	MLAMBDA_FLUSH_SIZE              FlushSize
+//	MLAMBDA_FLUSH_INTERVAL          FlushInterval
+//	MLAMBDA_FLUSH_TIMEOUT           FlushTimeout
+//	MLAMBDA_FLUSH_ON_SHUTDOWN       FlushOnShutdown
+//	MLAMBDA_DEADLINE_WARNING        DeadlineWarning
+//	MLAMBDA_HEARTBEAT_INTERVAL      HeartbeatInterval
+//	MLAMBDA_WARMUP_TIMEOUT          WarmupTimeout
+//	MLAMBDA_MAX_EVENT_SIZE          MaxEventSize
+//	MLAMBDA_LOCAL_CONCURRENCY       LocalConcurrency
+//	MLAMBDA_LOCAL_QUEUE_DEPTH       LocalQueueDepth
+//	MLAMBDA_INVOCATION_HISTORY      InvocationHistory
+//	MLAMBDA_MEMSTATS                MemStats
+//	MLAMBDA_PANIC_GOROUTINE_DUMP    PanicGoroutineDump
+//	MLAMBDA_RUNTIME_RETRY           RuntimeRetry: "exponential",
+//	                                "decorrelated", or "none"
+//	MLAMBDA_RUNTIME_RETRY_ATTEMPTS  its MaxAttempts
+//	MLAMBDA_RUNTIME_RETRY_BASE      its Base delay
+//	MLAMBDA_RUNTIME_RETRY_MAX       its Max delay
+//
+// Durations are as accepted by time.ParseDuration (such as "500ms"),
+// and booleans by strconv.ParseBool. MLAMBDA_DEBUG, MLAMBDA_DIAG, and
+// MLAMBDA_PPROF, which the Server reads itself, are also validated.
+// Every invalid variable is reported in the returned error.
+//
+// The caller sets the Handler, and any other options which can't be
+// expressed as strings, on the returned Server.
+func ConfigFromEnv() (*Server, error) {
+	s := &Server{}
+	e := &envParser{}
+
+	if v, ok := e.lookup("MLAMBDA_LOCAL_ADDR"); ok {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			e.invalid("MLAMBDA_LOCAL_ADDR", v, err)
+		}
+		s.LocalAddr = v
+	}
+	e.bool("MLAMBDA_STREAM_RESPONSES", &s.StreamResponses)
+	e.int("MLAMBDA_FLUSH_SIZE", &s.FlushSize, 1)
+	e.duration("MLAMBDA_FLUSH_INTERVAL", &s.FlushInterval, false)
+	e.duration("MLAMBDA_FLUSH_TIMEOUT", &s.FlushTimeout, false)
+	e.bool("MLAMBDA_FLUSH_ON_SHUTDOWN", &s.FlushOnShutdown)
+	e.duration("MLAMBDA_DEADLINE_WARNING", &s.DeadlineWarning, true)
+	e.duration("MLAMBDA_HEARTBEAT_INTERVAL", &s.HeartbeatInterval, true)
+	e.duration("MLAMBDA_WARMUP_TIMEOUT", &s.WarmupTimeout, false)
+	var maxEventSize int
+	e.int("MLAMBDA_MAX_EVENT_SIZE", &maxEventSize, 0)
+	s.MaxEventSize = int64(maxEventSize)
+	e.int("MLAMBDA_LOCAL_CONCURRENCY", &s.LocalConcurrency, 0)
+	e.int("MLAMBDA_LOCAL_QUEUE_DEPTH", &s.LocalQueueDepth, 0)
+	// any negative value disables the history
+	e.int("MLAMBDA_INVOCATION_HISTORY", &s.InvocationHistory, math.MinInt)
+	e.bool("MLAMBDA_MEMSTATS", &s.MemStats)
+	e.bool("MLAMBDA_PANIC_GOROUTINE_DUMP", &s.PanicGoroutineDump)
+
+	e.retryPolicy(&s.RuntimeRetry)
+
+	var ignored bool
+	e.bool(debugEnvVar, &ignored)
+	e.bool(diagEnvVar, &ignored)
+	e.bool(profileEnvVar, &ignored)
+
+	if err := errors.Join(e.errs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// envParser parses environment variables, collecting errors.
+type envParser struct {
+	errs []error
+}
+
+func (e *envParser) lookup(name string) (string, bool) {
+	v, ok := os.LookupEnv(name)
+	return v, ok && v != ""
+}
+
+func (e *envParser) invalid(name string, v string, reason any) {
+	e.errs = append(e.errs, fmt.Errorf("invalid %s %q: %v", name, v, reason))
+}
+
+func (e *envParser) bool(name string, into *bool) {
+	v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.invalid(name, v, "not a boolean")
+		return
+	}
+	*into = b
+}
+
+// int parses an integer of at least minimum.
+func (e *envParser) int(name string, into *int, minimum int) {
+	v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.invalid(name, v, "not an integer")
+		return
+	}
+	if n < minimum {
+		e.invalid(name, v, fmt.Sprintf("less than %d", minimum))
+		return
+	}
+	*into = n
+}
+
+// duration parses a positive duration, or a negative one (to disable
+// a feature) if allowNegative is set.
+func (e *envParser) duration(name string, into *time.Duration, allowNegative bool) {
+	v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.invalid(name, v, "not a duration")
+		return
+	}
+	if d < 0 && !allowNegative {
+		e.invalid(name, v, "negative")
+		return
+	}
+	*into = d
+}
+
+// retryPolicy parses the MLAMBDA_RUNTIME_RETRY variables.
+func (e *envParser) retryPolicy(into *RetryPolicy) {
+	var attempts int
+	var base, ceiling time.Duration
+	e.int("MLAMBDA_RUNTIME_RETRY_ATTEMPTS", &attempts, 1)
+	e.duration("MLAMBDA_RUNTIME_RETRY_BASE", &base, false)
+	e.duration("MLAMBDA_RUNTIME_RETRY_MAX", &ceiling, false)
+
+	kind, ok := e.lookup("MLAMBDA_RUNTIME_RETRY")
+	if !ok && attempts == 0 && base == 0 && ceiling == 0 {
+		return
+	}
+	switch kind {
+	case "", "exponential":
+		*into = ExponentialBackoff{MaxAttempts: attempts, Base: base, Max: ceiling}
+	case "decorrelated":
+		*into = DecorrelatedJitter{MaxAttempts: attempts, Base: base, Max: ceiling}
+	case "none":
+		*into = NoRetry
+	default:
+		e.invalid("MLAMBDA_RUNTIME_RETRY", kind, `not "exponential", "decorrelated", or "none"`)
+	}
+}
@@ -0,0 +1,108 @@
+package mlambda
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// ConnectEvent is an Amazon Connect contact-flow invocation.
+type ConnectEvent struct {
+	Name    string
+	Details struct {
+		ContactData ConnectContactData
+
+		// Parameters are the function-parameters configured in the
+		// contact flow.
+		Parameters map[string]string
+	}
+}
+
+// ConnectContactData describes the contact a flow is handling.
+type ConnectContactData struct {
+	ContactId         string
+	InitialContactId  string
+	PreviousContactId string
+	Channel           string
+	InitiationMethod  string
+	InstanceARN       string
+	LanguageCode      string
+
+	// Attributes are the contact's user-defined attributes.
+	Attributes map[string]string
+
+	CustomerEndpoint *ConnectEndpoint
+	SystemEndpoint   *ConnectEndpoint
+	Queue            *struct {
+		ARN  string
+		Name string
+	}
+}
+
+// ConnectEndpoint is a contact's endpoint, such as a phone number.
+type ConnectEndpoint struct {
+	Address string
+	Type    string
+}
+
+// ConnectResponse is the response to a contact flow. Connect requires
+// a flat map of strings, whose entries the flow can read as
+// $.External.<key>.
+type ConnectResponse map[string]string
+
+// Set formats v as an attribute value: strings as-is, numbers and bools
+// with strconv, times in RFC 3339, durations in (possibly fractional)
+// seconds, fmt.Stringers with String, and nil as the empty string.
+// Other values are formatted with fmt.Sprint.
+func (c ConnectResponse) Set(key string, v any) {
+	c[key] = FormatConnectValue(v)
+}
+
+// FormatConnectValue formats a value as ConnectResponse.Set does.
+func FormatConnectValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case time.Duration:
+		return strconv.FormatFloat(v.Seconds(), 'f', -1, 64)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// ConnectHandler adapts a function to handle Amazon Connect
+// contact-flow invocations. An error fails the invocation, which the
+// flow handles with its "Error" branch.
+func ConnectHandler(f func(ctx context.Context, ev *ConnectEvent) (ConnectResponse, error)) Handler {
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		var ev ConnectEvent
+		if err := jsonv2.UnmarshalRead(r.Body, &ev, jsonv2.RejectUnknownMembers(false)); err != nil {
+			return fmt.Errorf("decoding event: %s", err)
+		}
+		resp, err := f(ctx, &ev)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			resp = ConnectResponse{}
+		}
+		return jsonv2.MarshalWrite(w, resp)
+	})
+}
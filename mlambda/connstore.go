@@ -0,0 +1,143 @@
+package mlambda
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var dynamoDBService = awsapi.Service{
+	SigningName:  "dynamodb",
+	JSONVersion:  "1.0",
+	TargetPrefix: "DynamoDB_20120810",
+}
+
+// Connection is a tracked WebSocket connection.
+type Connection struct {
+	ID string
+
+	// Key groups connections, such as by user or subscribed topic, so
+	// messages can be sent to all of a group's connections.
+	Key string
+
+	ConnectedAt time.Time
+}
+
+// ConnectionStore tracks active WebSocket connections. Connections are
+// typically added by the $connect route and removed by $disconnect.
+type ConnectionStore interface {
+	Add(ctx context.Context, conn Connection) error
+	Remove(ctx context.Context, id string) error
+
+	// List returns the connections with the given key.
+	List(ctx context.Context, key string) ([]Connection, error)
+}
+
+// DynamoDBConnectionStore is a ConnectionStore keeping connections in a
+// DynamoDB table with a string partition-key named "id". Connections
+// also have a string "key" attribute, and "connectedAt" (in unix
+// seconds).
+type DynamoDBConnectionStore struct {
+	Client *awsapi.Client
+	Table  string
+
+	// KeyIndex, if set, is a global secondary index keyed by "key",
+	// which List queries rather than scanning the table.
+	KeyIndex string
+
+	// TTL, if set, adds an "expiresAt" attribute (in unix seconds)
+	// so that DynamoDB's time-to-live can clean up connections whose
+	// $disconnect was missed. API Gateway closes connections after
+	// two hours.
+	TTL time.Duration
+}
+
+type ddbAttr struct {
+	S *string `json:",omitempty"`
+	N *string `json:",omitempty"`
+}
+
+func ddbString(s string) ddbAttr {
+	return ddbAttr{S: &s}
+}
+
+func ddbNumber(n int64) ddbAttr {
+	s := strconv.FormatInt(n, 10)
+	return ddbAttr{N: &s}
+}
+
+// Add implements ConnectionStore.
+func (d *DynamoDBConnectionStore) Add(ctx context.Context, conn Connection) error {
+	if conn.ConnectedAt.IsZero() {
+		conn.ConnectedAt = time.Now()
+	}
+	item := map[string]ddbAttr{
+		"id":          ddbString(conn.ID),
+		"key":         ddbString(conn.Key),
+		"connectedAt": ddbNumber(conn.ConnectedAt.Unix()),
+	}
+	if d.TTL > 0 {
+		item["expiresAt"] = ddbNumber(conn.ConnectedAt.Add(d.TTL).Unix())
+	}
+	in := map[string]any{"TableName": d.Table, "Item": item}
+	return d.Client.DoJSON(ctx, dynamoDBService, "PutItem", in, nil)
+}
+
+// Remove implements ConnectionStore.
+func (d *DynamoDBConnectionStore) Remove(ctx context.Context, id string) error {
+	in := map[string]any{
+		"TableName": d.Table,
+		"Key":       map[string]ddbAttr{"id": ddbString(id)},
+	}
+	return d.Client.DoJSON(ctx, dynamoDBService, "DeleteItem", in, nil)
+}
+
+// List implements ConnectionStore.
+func (d *DynamoDBConnectionStore) List(ctx context.Context, key string) ([]Connection, error) {
+	in := map[string]any{
+		"TableName":                 d.Table,
+		"ExpressionAttributeNames":  map[string]string{"#key": "key"},
+		"ExpressionAttributeValues": map[string]ddbAttr{":key": ddbString(key)},
+	}
+	op := "Scan"
+	if d.KeyIndex != "" {
+		op = "Query"
+		in["IndexName"] = d.KeyIndex
+		in["KeyConditionExpression"] = "#key = :key"
+	} else {
+		in["FilterExpression"] = "#key = :key"
+	}
+
+	var conns []Connection
+	for {
+		var out struct {
+			Items            []map[string]ddbAttr
+			LastEvaluatedKey map[string]ddbAttr
+		}
+		if err := d.Client.DoJSON(ctx, dynamoDBService, op, in, &out); err != nil {
+			return nil, err
+		}
+		for _, it := range out.Items {
+			conn := Connection{}
+			if v := it["id"].S; v != nil {
+				conn.ID = *v
+			}
+			if v := it["key"].S; v != nil {
+				conn.Key = *v
+			}
+			if v := it["connectedAt"].N; v != nil {
+				sec, _ := strconv.ParseInt(*v, 10, 64)
+				conn.ConnectedAt = time.Unix(sec, 0)
+			}
+			conns = append(conns, conn)
+		}
+		if out.LastEvaluatedKey == nil {
+			return conns, nil
+		}
+		in["ExclusiveStartKey"] = out.LastEvaluatedKey
+	}
+}
+
+var _ ConnectionStore = (*DynamoDBConnectionStore)(nil)
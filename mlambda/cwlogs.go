@@ -0,0 +1,149 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var cloudWatchLogsService = awsapi.Service{
+	SigningName:  "logs",
+	JSONVersion:  "1.1",
+	TargetPrefix: "Logs_20140328",
+}
+
+const (
+	// PutLogEvents limits
+	maxLogBatchEvents = 10000
+	maxLogBatchBytes  = 1024 * 1024
+	logEventOverhead  = 26
+	maxLogEventBytes  = 256*1024 - logEventOverhead
+)
+
+// CloudWatchLogs is an io.Writer which sends each line written to it as
+// a log-event to a CloudWatch log-group, using PutLogEvents. It can be
+// used to route selected records (such as audit-records) to a different
+// log-group than the function's own.
+//
+// Events are buffered until Flush is called. Register Flush with the
+// Server (see RegisterFlush) so events are sent at the end of each
+// invocation.
+type CloudWatchLogs struct {
+	Client        *awsapi.Client
+	LogGroupName  string
+	LogStreamName string
+
+	mu            sync.Mutex
+	partial       []byte
+	pending       []logEvent
+	streamCreated bool
+}
+
+type logEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// NewCloudWatchLogs returns a writer to the given log-group. The
+// log-stream is named after the function's own log-stream, and is
+// created on first use.
+func NewCloudWatchLogs(logGroupName string) (*CloudWatchLogs, error) {
+	client, err := awsapi.NewFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	stream := FunctionInfoFromEnv().LogStreamName
+	if stream == "" {
+		stream = "mlambda-" + newLocalRequestID()
+	}
+	return &CloudWatchLogs{
+		Client:        client,
+		LogGroupName:  logGroupName,
+		LogStreamName: stream,
+	}, nil
+}
+
+// Write implements io.Writer.
+func (c *CloudWatchLogs) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	data := append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		c.addEvent(now, data[:i])
+		data = data[i+1:]
+	}
+	c.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (c *CloudWatchLogs) addEvent(now int64, line []byte) {
+	if len(line) == 0 {
+		return
+	}
+	if len(line) > maxLogEventBytes {
+		line = line[:maxLogEventBytes]
+	}
+	c.pending = append(c.pending, logEvent{Timestamp: now, Message: string(line)})
+}
+
+// Flush sends buffered events to CloudWatch.
+func (c *CloudWatchLogs) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.partial) > 0 {
+		c.addEvent(time.Now().UnixMilli(), c.partial)
+		c.partial = nil
+	}
+
+	for len(c.pending) > 0 {
+		n, size := 0, 0
+		for n < len(c.pending) && n < maxLogBatchEvents {
+			eventSize := len(c.pending[n].Message) + logEventOverhead
+			if size+eventSize > maxLogBatchBytes {
+				break
+			}
+			size += eventSize
+			n++
+		}
+
+		if err := c.putLogEvents(ctx, c.pending[:n]); err != nil {
+			return err
+		}
+		c.pending = c.pending[n:]
+	}
+	c.pending = nil
+	return nil
+}
+
+func (c *CloudWatchLogs) putLogEvents(ctx context.Context, events []logEvent) error {
+	if !c.streamCreated {
+		err := c.Client.DoJSON(ctx, cloudWatchLogsService, "CreateLogStream", map[string]string{
+			"logGroupName":  c.LogGroupName,
+			"logStreamName": c.LogStreamName,
+		}, nil)
+		if err != nil && !awsapi.IsCode(err, "ResourceAlreadyExistsException") {
+			return err
+		}
+		c.streamCreated = true
+	}
+
+	var req struct {
+		LogGroupName  string     `json:"logGroupName"`
+		LogStreamName string     `json:"logStreamName"`
+		LogEvents     []logEvent `json:"logEvents"`
+	}
+	req.LogGroupName = c.LogGroupName
+	req.LogStreamName = c.LogStreamName
+	req.LogEvents = events
+	return c.Client.DoJSON(ctx, cloudWatchLogsService, "PutLogEvents", &req, nil)
+}
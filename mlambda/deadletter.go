@@ -0,0 +1,304 @@
+package mlambda
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var sqsService = awsapi.Service{
+	SigningName:  "sqs",
+	JSONVersion:  "1.0",
+	TargetPrefix: "AmazonSQS",
+}
+
+// maxSQSBatch is the most messages SQS sends or receives at once.
+const maxSQSBatch = 10
+
+// FailedRecord is a record which a MicroBatchFunc failed to process.
+type FailedRecord struct {
+	Record *BatchRecord
+	Err    error
+}
+
+// DeadLetterWriter stores records which could not be processed.
+type DeadLetterWriter interface {
+	WriteDeadLetters(ctx context.Context, records []FailedRecord) error
+}
+
+// DeadLetter is a stored failed record.
+type DeadLetter struct {
+	Source   string         `json:"source"`
+	ID       string         `json:"id"`
+	Group    string         `json:"group,omitempty"`
+	Error    string         `json:"error"`
+	Attempts int            `json:"attempts,omitzero"`
+	FailedAt time.Time      `json:"failedAt"`
+	Function string         `json:"function,omitempty"`
+	Record   jsontext.Value `json:"record"`
+}
+
+func newDeadLetter(f FailedRecord, now time.Time) *DeadLetter {
+	return &DeadLetter{
+		Source:   f.Record.Source,
+		ID:       f.Record.ID,
+		Group:    f.Record.Group,
+		Error:    f.Err.Error(),
+		Attempts: f.Record.Attempts,
+		FailedAt: now,
+		Function: FunctionInfoFromEnv().Name,
+		Record:   f.Record.Raw,
+	}
+}
+
+// BatchRecord returns the original record, so that it can be replayed
+// through a MicroBatchFunc.
+func (d *DeadLetter) BatchRecord() *BatchRecord {
+	return &BatchRecord{ID: d.ID, Source: d.Source, Group: d.Group, Attempts: d.Attempts, Raw: d.Record}
+}
+
+// deadLetter writes the errored records which have run out of attempts,
+// returning the IDs in failed which must still be reported as failures.
+func deadLetter(ctx context.Context, opts MicroBatchOptions, errored []FailedRecord, failed []string) []string {
+	var letters []FailedRecord
+	for _, f := range errored {
+		if f.Record.Attempts == 0 || f.Record.Attempts >= opts.MaxAttempts {
+			letters = append(letters, f)
+		}
+	}
+	if len(letters) == 0 {
+		return failed
+	}
+	if err := opts.DeadLetters.WriteDeadLetters(ctx, letters); err != nil {
+		slog.WarnContext(ctx, "writing dead-letters, leaving records to be retried", "error", err)
+		return failed
+	}
+	return slices.DeleteFunc(failed, func(id string) bool {
+		return slices.ContainsFunc(letters, func(f FailedRecord) bool { return f.Record.ID == id })
+	})
+}
+
+// SQSDeadLetters is a DeadLetterWriter sending each record, as a
+// DeadLetter document, to an SQS queue. The messages carry "source",
+// "error", and "attempts" message-attributes. The function needs
+// sqs:SendMessage on the queue, and replaying needs sqs:ReceiveMessage
+// and sqs:DeleteMessage.
+type SQSDeadLetters struct {
+	Client   *awsapi.Client
+	QueueURL string
+}
+
+// WriteDeadLetters implements DeadLetterWriter.
+func (q *SQSDeadLetters) WriteDeadLetters(ctx context.Context, records []FailedRecord) error {
+	now := time.Now()
+	fifo := strings.HasSuffix(q.QueueURL, ".fifo")
+	for len(records) > 0 {
+		n := min(len(records), maxSQSBatch)
+		var entries []map[string]any
+		for i, f := range records[:n] {
+			dl := newDeadLetter(f, now)
+			body, err := jsonv2.Marshal(dl)
+			if err != nil {
+				return err
+			}
+			entry := map[string]any{
+				"Id":          strconv.Itoa(i),
+				"MessageBody": string(body),
+				"MessageAttributes": map[string]any{
+					"source":   map[string]string{"DataType": "String", "StringValue": dl.Source},
+					"error":    map[string]string{"DataType": "String", "StringValue": dl.Error},
+					"attempts": map[string]string{"DataType": "Number", "StringValue": strconv.Itoa(dl.Attempts)},
+				},
+			}
+			if fifo {
+				sum := sha256.Sum256([]byte(dl.Source + "/" + dl.ID))
+				entry["MessageGroupId"] = cmp.Or(dl.Group, "default")
+				entry["MessageDeduplicationId"] = hex.EncodeToString(sum[:])
+			}
+			entries = append(entries, entry)
+		}
+
+		in := map[string]any{"QueueUrl": q.QueueURL, "Entries": entries}
+		var out struct {
+			Failed []struct {
+				Code    string
+				Message string
+			}
+		}
+		if err := q.Client.DoJSON(ctx, sqsService, "SendMessageBatch", in, &out); err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("%d messages failed: %s: %s", len(out.Failed), out.Failed[0].Code, out.Failed[0].Message)
+		}
+		records = records[n:]
+	}
+	return nil
+}
+
+// Replay receives dead-letters from the queue and passes each to f,
+// deleting those f succeeds with. It stops when the queue is empty, or
+// at the first error; unprocessed messages become visible again after
+// the queue's visibility-timeout. It returns how many were replayed.
+func (q *SQSDeadLetters) Replay(ctx context.Context, f func(ctx context.Context, dl *DeadLetter) error) (int, error) {
+	var replayed int
+	for {
+		in := map[string]any{"QueueUrl": q.QueueURL, "MaxNumberOfMessages": maxSQSBatch}
+		var out struct {
+			Messages []struct {
+				MessageId     string
+				ReceiptHandle string
+				Body          string
+			}
+		}
+		if err := q.Client.DoJSON(ctx, sqsService, "ReceiveMessage", in, &out); err != nil {
+			return replayed, err
+		}
+		if len(out.Messages) == 0 {
+			return replayed, nil
+		}
+		for _, m := range out.Messages {
+			var dl DeadLetter
+			if err := jsonv2.Unmarshal([]byte(m.Body), &dl); err != nil {
+				return replayed, fmt.Errorf("decoding message %s: %s", m.MessageId, err)
+			}
+			if err := f(ctx, &dl); err != nil {
+				return replayed, err
+			}
+			in := map[string]any{"QueueUrl": q.QueueURL, "ReceiptHandle": m.ReceiptHandle}
+			if err := q.Client.DoJSON(ctx, sqsService, "DeleteMessage", in, nil); err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+	}
+}
+
+var _ DeadLetterWriter = (*SQSDeadLetters)(nil)
+
+// S3DeadLetters is a DeadLetterWriter storing each record, as a
+// DeadLetter document, in an S3 bucket under Prefix, keyed by its
+// source and id (so a record which fails again replaces its earlier
+// dead-letter). The function needs s3:PutObject, and replaying needs
+// s3:ListBucket, s3:GetObject and s3:DeleteObject.
+type S3DeadLetters struct {
+	Client *awsapi.Client
+	Bucket string
+	Prefix string
+}
+
+// WriteDeadLetters implements DeadLetterWriter.
+func (s *S3DeadLetters) WriteDeadLetters(ctx context.Context, records []FailedRecord) error {
+	now := time.Now()
+	for _, f := range records {
+		dl := newDeadLetter(f, now)
+		body, err := jsonv2.Marshal(dl)
+		if err != nil {
+			return err
+		}
+		key := s.Prefix + strings.ReplaceAll(dl.Source, ":", "-") + "/" + url.PathEscape(dl.ID) + ".json"
+		if _, err := s.do(ctx, http.MethodPut, key, nil, body); err != nil {
+			return fmt.Errorf("writing %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// Replay passes each dead-letter under the prefix to f, deleting those
+// f succeeds with. It stops at the first error, and returns how many
+// were replayed.
+func (s *S3DeadLetters) Replay(ctx context.Context, f func(ctx context.Context, dl *DeadLetter) error) (int, error) {
+	var replayed int
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		b, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return replayed, fmt.Errorf("listing dead-letters: %s", err)
+		}
+		var list struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(b, &list); err != nil {
+			return replayed, fmt.Errorf("listing dead-letters: %s", err)
+		}
+
+		for _, obj := range list.Contents {
+			b, err := s.do(ctx, http.MethodGet, obj.Key, nil, nil)
+			if err != nil {
+				return replayed, fmt.Errorf("reading %s: %s", obj.Key, err)
+			}
+			var dl DeadLetter
+			if err := jsonv2.Unmarshal(b, &dl); err != nil {
+				return replayed, fmt.Errorf("decoding %s: %s", obj.Key, err)
+			}
+			if err := f(ctx, &dl); err != nil {
+				return replayed, err
+			}
+			if _, err := s.do(ctx, http.MethodDelete, obj.Key, nil, nil); err != nil {
+				return replayed, fmt.Errorf("deleting %s: %s", obj.Key, err)
+			}
+			replayed++
+		}
+		if !list.IsTruncated {
+			return replayed, nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// do sends an S3 request for key (or the bucket, if key is empty),
+// returning the response body.
+func (s *S3DeadLetters) do(ctx context.Context, method string, key string, query url.Values, body []byte) ([]byte, error) {
+	u, err := s3URL(s.Client, s.Bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+
+	r, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.Client.Do(ctx, s3Service, r, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, s3Error(resp, b)
+	}
+	return b, nil
+}
+
+var _ DeadLetterWriter = (*S3DeadLetters)(nil)
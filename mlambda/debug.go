@@ -0,0 +1,61 @@
+package mlambda
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// Debug-dumps are enabled by setting the MLAMBDA_DEBUG environment
+// variable to a true value (such as "1"). The raw event and the
+// serialized response of each invocation are logged, truncated to
+// debugDumpLimit bytes.
+const debugEnvVar = "MLAMBDA_DEBUG"
+
+const debugDumpLimit = 16 * 1024
+
+func debugEnabled() bool {
+	v, _ := strconv.ParseBool(os.Getenv(debugEnvVar))
+	return v
+}
+
+// dumpEvent logs the start of the event in body. The returned reader
+// must be used in place of body for further reads.
+func (s *Server) dumpEvent(ctx context.Context, requestId string, body io.Reader) io.Reader {
+	br := bufio.NewReaderSize(body, debugDumpLimit)
+	peeked, _ := br.Peek(debugDumpLimit)
+	s.logDump(ctx, "debug: event", requestId, peeked, len(peeked) == debugDumpLimit)
+	return br
+}
+
+func (s *Server) logDump(ctx context.Context, msg string, requestId string, data []byte, truncated bool) {
+	data = s.redactor().RedactJSON(append([]byte(nil), data...))
+	s.logger().LogAttrs(ctx, slog.LevelInfo, msg,
+		slog.String("requestId", requestId),
+		slog.String("data", string(data)),
+		slog.Bool("truncated", truncated),
+	)
+}
+
+// captureWriter holds on to the first limit bytes written to it.
+type captureWriter struct {
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+// Write implements io.Writer.
+func (c *captureWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := c.limit - len(c.buf); len(p) > room {
+		p = p[:room]
+		c.truncated = true
+	}
+	c.buf = append(c.buf, p...)
+	return n, nil
+}
+
+var _ io.Writer = (*captureWriter)(nil)
@@ -0,0 +1,166 @@
+package mlambda
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// Diagnostics are enabled by setting the MLAMBDA_DIAG environment variable
+// to a true value (such as "1").
+//
+// HttpHandler then serves a diagnostics document at /__diag. For other
+// handlers the server recognizes a "diagnostics event":
+//
+//	{"mlambdaDiag": {}}
+//
+// and responds with the document instead of invoking the handler. The
+// document includes runtime statistics, build information, the lambda
+// and mlambda environment variables, and recent invocations.
+const diagEnvVar = "MLAMBDA_DIAG"
+
+// diagPath is the HttpHandler diagnostics route.
+const diagPath = "/__diag"
+
+func diagEnabled() bool {
+	v, _ := strconv.ParseBool(os.Getenv(diagEnvVar))
+	return v
+}
+
+// diagEnvPrefixes selects the environment variables included in the
+// diagnostics document. Credentials are deliberately excluded.
+var diagEnvPrefixes = []string{"AWS_LAMBDA_", "AWS_REGION", "AWS_EXECUTION_ENV", "MLAMBDA_", "GOGC", "GOMEMLIMIT", "GOMAXPROCS", "GODEBUG"}
+
+type diagnostics struct {
+	Time        time.Time           `json:"time"`
+	Runtime     diagRuntime         `json:"runtime"`
+	Build       map[string]string   `json:"build,omitempty"`
+	Env         map[string]string   `json:"env"`
+	Invocations []invocationSummary `json:"recentInvocations"`
+}
+
+type diagRuntime struct {
+	GoVersion    string     `json:"goVersion"`
+	GOOS         string     `json:"goos"`
+	GOARCH       string     `json:"goarch"`
+	NumCPU       int        `json:"numCpu"`
+	GOMAXPROCS   int        `json:"gomaxprocs"`
+	NumGoroutine int        `json:"numGoroutine"`
+	InitDuration string     `json:"initDuration"`
+	Memory       diagMemory `json:"memory"`
+}
+
+type diagMemory struct {
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapSys      uint64 `json:"heapSys"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"numGc"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+}
+
+// diagnostics collects the diagnostics document.
+func (s *Server) diagnostics() *diagnostics {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	d := &diagnostics{
+		Time: time.Now().UTC(),
+		Runtime: diagRuntime{
+			GoVersion:    runtime.Version(),
+			GOOS:         runtime.GOOS,
+			GOARCH:       runtime.GOARCH,
+			NumCPU:       runtime.NumCPU(),
+			GOMAXPROCS:   runtime.GOMAXPROCS(0),
+			NumGoroutine: runtime.NumGoroutine(),
+			InitDuration: s.initDuration.String(),
+			Memory: diagMemory{
+				HeapAlloc:    ms.HeapAlloc,
+				HeapSys:      ms.HeapSys,
+				HeapObjects:  ms.HeapObjects,
+				Sys:          ms.Sys,
+				NumGC:        ms.NumGC,
+				PauseTotalNs: ms.PauseTotalNs,
+			},
+		},
+		Env:         map[string]string{},
+		Invocations: s.history.list(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		d.Build = map[string]string{
+			"path":    info.Path,
+			"version": info.Main.Version,
+		}
+		for _, setting := range info.Settings {
+			if strings.HasPrefix(setting.Key, "vcs.") {
+				d.Build[setting.Key] = setting.Value
+			}
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		for _, prefix := range diagEnvPrefixes {
+			if strings.HasPrefix(k, prefix) {
+				d.Env[k] = v
+				break
+			}
+		}
+	}
+
+	return d
+}
+
+type diagKey struct{}
+
+// contextWithDiagnostics makes the diagnostics available to adapters
+// serving their own diagnostics route.
+func contextWithDiagnostics(ctx context.Context, f func() *diagnostics) context.Context {
+	return context.WithValue(ctx, diagKey{}, f)
+}
+
+func diagnosticsFromContext(ctx context.Context) (func() *diagnostics, bool) {
+	f, ok := ctx.Value(diagKey{}).(func() *diagnostics)
+	return f, ok
+}
+
+// diagHandler serves the diagnostics document over HTTP.
+func diagHandler(f func() *diagnostics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = jsonv2.MarshalWrite(w, f())
+	})
+}
+
+type diagEvent struct {
+	MlambdaDiag *struct{} `json:"mlambdaDiag"`
+}
+
+// peekDiagEvent checks if the event in body is a diagnostics-event. The
+// returned reader must be used in place of body for further reads.
+func peekDiagEvent(body io.Reader) (bool, io.Reader) {
+	br := bufio.NewReaderSize(body, maxProfileEventSize)
+	peeked, err := br.Peek(maxProfileEventSize)
+	if !errors.Is(err, io.EOF) || !bytes.Contains(peeked, []byte(`"mlambdaDiag"`)) {
+		return false, br
+	}
+
+	var ev diagEvent
+	if err := jsonv2.Unmarshal(peeked, &ev); err != nil || ev.MlambdaDiag == nil {
+		return false, br
+	}
+	return true, br
+}
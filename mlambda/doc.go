@@ -0,0 +1,25 @@
+// Package mlambda is a minimal runtime for Go AWS Lambda functions on
+// the "OS only" runtimes (provided.al2023 and friends).
+//
+// A Server polls the lambda runtime API for events and passes each to
+// its Handler, sending back whatever the handler writes:
+//
+//	srv := mlambda.Server{Handler: mlambda.HttpHandler(mux)}
+//	err := srv.Start(ctx)
+//
+// HttpHandler adapts an http.Handler to API Gateway (REST and HTTP
+// API), function-URL, and Application Load Balancer events, and the
+// package provides middleware for such handlers: AccessLog,
+// RouteMetrics, RateLimit, and problem-document errors (see
+// WriteProblem). When AWS_LAMBDA_RUNTIME_API is not set,
+// as when running locally, Start serves the handler on localhost
+// instead.
+//
+// TypedHandler adapts a function taking and returning Go values,
+// decoding events with a Codec: JSON by default, or CBORCodec or
+// MessagePackCodec. HTTP handlers can choose between codecs by
+// content-type with RequestCodec and ResponseCodec.
+//
+// The AWS services the runtime integrates with (such as CloudWatch
+// Logs) are called through package awsapi rather than the AWS SDK.
+package mlambda
@@ -0,0 +1,199 @@
+package mlambda
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// maxEMFMetrics is the CloudWatch limit on metrics in a single
+// EMF document.
+const maxEMFMetrics = 100
+
+// EMF is a Metrics implementation which writes measurements to the
+// function's log using the CloudWatch embedded metric format.
+//
+// Measurements are buffered and written when Flush is called. The
+// Server registers Flush to run at the end of every invocation when
+// an *EMF is its Metrics.
+//
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+type EMF struct {
+	// Namespace is the CloudWatch namespace for the metrics.
+	Namespace string
+
+	// Dimensions are added to every metric.
+	Dimensions []Dimension
+
+	// Writer receives the EMF documents. If nil, os.Stdout is used.
+	Writer io.Writer
+
+	mu      sync.Mutex
+	pending []emfValue
+}
+
+type emfValue struct {
+	name  string
+	value float64
+	unit  Unit
+	dims  []Dimension
+}
+
+// NewEMF returns an EMF emitter for the given namespace, with a
+// "FunctionName" dimension taken from the lambda environment.
+func NewEMF(namespace string) *EMF {
+	e := &EMF{Namespace: namespace}
+	if name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); name != "" {
+		e.Dimensions = []Dimension{{Name: "FunctionName", Value: name}}
+	}
+	return e
+}
+
+// Record implements Metrics.
+func (e *EMF) Record(ctx context.Context, name string, value float64, unit Unit, dims ...Dimension) {
+	e.mu.Lock()
+	e.pending = append(e.pending, emfValue{name: name, value: value, unit: unit, dims: dims})
+	e.mu.Unlock()
+}
+
+// Flush writes all buffered measurements.
+func (e *EMF) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	pending := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	w := e.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+
+	// each document shares one set of dimensions, so we group
+	// measurements by their dimensions.
+	groups := map[string][]emfValue{}
+	var keys []string
+	for _, v := range pending {
+		v.dims = append(append([]Dimension(nil), e.Dimensions...), v.dims...)
+		k := dimensionKey(v.dims)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], v)
+	}
+
+	now := time.Now().UnixMilli()
+	for _, k := range keys {
+		for _, doc := range e.documents(now, groups[k]) {
+			if _, err := w.Write(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// documents builds EMF documents for measurements sharing dimensions.
+// Repeated measurements of the same metric are combined into an array
+// of values.
+func (e *EMF) documents(timestamp int64, vs []emfValue) [][]byte {
+	type metric struct {
+		unit   Unit
+		values []float64
+	}
+	metrics := map[string]*metric{}
+	var names []string
+	for _, v := range vs {
+		m, ok := metrics[v.name]
+		if !ok {
+			m = &metric{unit: v.unit}
+			metrics[v.name] = m
+			names = append(names, v.name)
+		}
+		m.values = append(m.values, v.value)
+	}
+	dims := vs[0].dims
+
+	var docs [][]byte
+	for len(names) > 0 {
+		chunk := names
+		if len(chunk) > maxEMFMetrics {
+			chunk = chunk[:maxEMFMetrics]
+		}
+		names = names[len(chunk):]
+
+		var dst []byte
+		dst = append(dst, `{"_aws":{"Timestamp":`...)
+		dst = append(dst, jsontext.Int(timestamp).String()...)
+		dst = append(dst, `,"CloudWatchMetrics":[{"Namespace":`...)
+		dst, _ = jsontext.AppendQuote(dst, e.Namespace)
+		dst = append(dst, `,"Dimensions":[[`...)
+		for i, d := range dims {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst, _ = jsontext.AppendQuote(dst, d.Name)
+		}
+		dst = append(dst, `]],"Metrics":[`...)
+		for i, name := range chunk {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, `{"Name":`...)
+			dst, _ = jsontext.AppendQuote(dst, name)
+			dst = append(dst, `,"Unit":`...)
+			dst, _ = jsontext.AppendQuote(dst, string(metrics[name].unit))
+			dst = append(dst, '}')
+		}
+		dst = append(dst, `]}]}`...)
+
+		for _, d := range dims {
+			dst = append(dst, ',')
+			dst, _ = jsontext.AppendQuote(dst, d.Name)
+			dst = append(dst, ':')
+			dst, _ = jsontext.AppendQuote(dst, d.Value)
+		}
+		for _, name := range chunk {
+			dst = append(dst, ',')
+			dst, _ = jsontext.AppendQuote(dst, name)
+			dst = append(dst, ':')
+			values := metrics[name].values
+			if len(values) == 1 {
+				dst = append(dst, jsontext.Float(values[0]).String()...)
+				continue
+			}
+			dst = append(dst, '[')
+			for i, v := range values {
+				if i > 0 {
+					dst = append(dst, ',')
+				}
+				dst = append(dst, jsontext.Float(v).String()...)
+			}
+			dst = append(dst, ']')
+		}
+		dst = append(dst, "}\n"...)
+		docs = append(docs, dst)
+	}
+	return docs
+}
+
+// dimensionKey returns a string identifying a set of dimensions.
+func dimensionKey(dims []Dimension) string {
+	parts := make([]string, len(dims))
+	for i, d := range dims {
+		parts[i] = d.Name + "\x00" + d.Value
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\x01")
+}
+
+var _ Metrics = (*EMF)(nil)
@@ -0,0 +1,410 @@
+// Package emulator implements the lambda Runtime API, so that runtimes
+// and function binaries can be run and invoked outside of AWS, such as
+// in CI or in local setups of several functions.
+//
+// An Emulator is an http.Handler serving the Runtime API. Serve it, run
+// the function with AWS_LAMBDA_RUNTIME_API set to the server's
+// host:port, and send it events with Invoke:
+//
+//	emu := &emulator.Emulator{Timeout: 10 * time.Second}
+//	srv := httptest.NewServer(emu)
+//	defer srv.Close()
+//
+//	cmd := exec.Command("./bootstrap")
+//	cmd.Env = append(os.Environ(), "AWS_LAMBDA_RUNTIME_API="+strings.TrimPrefix(srv.URL, "http://"))
+//	err := cmd.Start()
+//	...
+//	resp, err := emu.Invoke(ctx, []byte(`{"hello":"world"}`))
+//
+// Each Emulator is one function (with any number of runtime processes
+// polling it); use an Emulator per function for several functions.
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/runtimes-api.html
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const apiPrefix = "/2018-06-01/runtime/"
+
+// MaxResponseSize is the largest buffered (non-streaming) response the
+// emulator accepts, matching the lambda service.
+const MaxResponseSize = 6 << 20
+
+// ErrTimeout is returned by Invoke when the function does not respond
+// before its deadline.
+var ErrTimeout = errors.New("emulator: invocation timed out")
+
+// Emulator serves the lambda Runtime API for one function.
+type Emulator struct {
+	// FunctionArn is sent as the invoked-function ARN. The default
+	// is a made-up ARN.
+	FunctionArn string
+
+	// Timeout is the time each invocation is allowed, from the
+	// function receiving it, before Invoke returns ErrTimeout. The
+	// default is three seconds, as for a function.
+	Timeout time.Duration
+
+	once    sync.Once
+	mux     *http.ServeMux
+	queue   chan *invocation
+	initErr chan struct{}
+
+	mu          sync.Mutex
+	pending     map[string]*invocation
+	initFailure *FunctionError
+}
+
+// InvokeOptions holds the optional invocation-metadata passed to the
+// function.
+type InvokeOptions struct {
+	// TraceID is the X-Ray trace-header. If empty, one is generated.
+	TraceID string
+
+	// ClientContext and CognitoIdentity are JSON documents, as sent
+	// by mobile SDKs.
+	ClientContext   string
+	CognitoIdentity string
+}
+
+// Response is the response to an invocation.
+type Response struct {
+	// Body is the response payload. For streamed responses it is
+	// read as the function writes it, and reading it returns a
+	// *FunctionError if the function reports an error mid-stream.
+	// The body must be read or closed.
+	Body io.ReadCloser
+
+	// Streaming reports if the function sent the response in
+	// response-streaming mode.
+	Streaming bool
+}
+
+// FunctionError is an error reported by the function, either for an
+// invocation or while initializing.
+type FunctionError struct {
+	Type       string   `json:"errorType"`
+	Message    string   `json:"errorMessage"`
+	StackTrace []string `json:"stackTrace,omitempty"`
+}
+
+// Error implements error.
+func (e *FunctionError) Error() string {
+	if e.Type == "" {
+		return e.Message
+	}
+	return e.Type + ": " + e.Message
+}
+
+type invocation struct {
+	id      string
+	payload []byte
+	opts    InvokeOptions
+
+	// done is closed once resp or err is set.
+	done chan struct{}
+	resp *Response
+	err  error
+}
+
+func (e *Emulator) init() {
+	e.once.Do(func() {
+		e.queue = make(chan *invocation)
+		e.initErr = make(chan struct{})
+		e.pending = map[string]*invocation{}
+
+		e.mux = http.NewServeMux()
+		e.mux.HandleFunc("GET "+apiPrefix+"invocation/next", e.next)
+		e.mux.HandleFunc("POST "+apiPrefix+"invocation/{id}/response", e.response)
+		e.mux.HandleFunc("POST "+apiPrefix+"invocation/{id}/error", e.error)
+		e.mux.HandleFunc("POST "+apiPrefix+"init/error", e.initError)
+	})
+}
+
+// ServeHTTP implements http.Handler.
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.init()
+	e.mux.ServeHTTP(w, r)
+}
+
+// InitError returns the error the function reported while initializing,
+// if any.
+func (e *Emulator) InitError() error {
+	e.init()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.initFailure == nil {
+		return nil
+	}
+	return e.initFailure
+}
+
+// Invoke sends an event to the function, and waits for its response.
+// If the function reports an error, it is returned as a *FunctionError.
+// Invoke waits as long as ctx allows for a runtime to poll for the
+// event, as when the function is busy or starting.
+func (e *Emulator) Invoke(ctx context.Context, payload []byte) (*Response, error) {
+	return e.InvokeWithOptions(ctx, payload, InvokeOptions{})
+}
+
+// InvokeWithOptions is like Invoke, with additional metadata.
+func (e *Emulator) InvokeWithOptions(ctx context.Context, payload []byte, opts InvokeOptions) (*Response, error) {
+	e.init()
+
+	inv := &invocation{
+		id:      newID(),
+		payload: payload,
+		opts:    opts,
+		done:    make(chan struct{}),
+	}
+	if inv.opts.TraceID == "" {
+		inv.opts.TraceID = newTraceID()
+	}
+
+	// registered before it is handed out, so that a response can
+	// always find it
+	e.mu.Lock()
+	e.pending[inv.id] = inv
+	e.mu.Unlock()
+
+	// wait for a runtime to poll for the event
+	select {
+	case e.queue <- inv:
+	case <-ctx.Done():
+		e.abandon(inv)
+		return nil, ctx.Err()
+	case <-e.initErr:
+		e.abandon(inv)
+		return nil, e.InitError()
+	}
+
+	timer := time.NewTimer(e.timeout())
+	defer timer.Stop()
+
+	select {
+	case <-inv.done:
+		return inv.resp, inv.err
+	case <-ctx.Done():
+		if !e.abandon(inv) {
+			<-inv.done
+			return inv.resp, inv.err
+		}
+		return nil, ctx.Err()
+	case <-timer.C:
+		if !e.abandon(inv) {
+			<-inv.done
+			return inv.resp, inv.err
+		}
+		return nil, ErrTimeout
+	}
+}
+
+// abandon removes an invocation which is no longer waited for. It
+// returns false if the invocation was already being answered.
+func (e *Emulator) abandon(inv *invocation) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.pending[inv.id]; !ok {
+		return false
+	}
+	delete(e.pending, inv.id)
+	return true
+}
+
+// claim removes and returns a pending invocation, so it is answered at
+// most once.
+func (e *Emulator) claim(id string) (*invocation, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	inv, ok := e.pending[id]
+	delete(e.pending, id)
+	return inv, ok
+}
+
+// next serves the long-poll for the next event.
+func (e *Emulator) next(w http.ResponseWriter, r *http.Request) {
+	var inv *invocation
+	select {
+	case inv = <-e.queue:
+	case <-r.Context().Done():
+		return
+	}
+
+	deadline := time.Now().Add(e.timeout())
+
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("Lambda-Runtime-Aws-Request-Id", inv.id)
+	h.Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(deadline.UnixMilli(), 10))
+	h.Set("Lambda-Runtime-Invoked-Function-Arn", e.functionArn())
+	h.Set("Lambda-Runtime-Trace-Id", inv.opts.TraceID)
+	if inv.opts.ClientContext != "" {
+		h.Set("Lambda-Runtime-Client-Context", inv.opts.ClientContext)
+	}
+	if inv.opts.CognitoIdentity != "" {
+		h.Set("Lambda-Runtime-Cognito-Identity", inv.opts.CognitoIdentity)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(inv.payload)
+}
+
+// response serves a function's response to an invocation.
+func (e *Emulator) response(w http.ResponseWriter, r *http.Request) {
+	inv, ok := e.claim(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "InvalidRequestID", "unknown or already answered request-id")
+		return
+	}
+
+	if r.Header.Get("Lambda-Runtime-Function-Response-Mode") != "streaming" {
+		body, err := io.ReadAll(io.LimitReader(r.Body, MaxResponseSize+1))
+		if err != nil {
+			inv.finish(nil, fmt.Errorf("emulator: reading response: %s", err))
+			writeError(w, http.StatusBadRequest, "InvalidResponse", err.Error())
+			return
+		}
+		if len(body) > MaxResponseSize {
+			inv.finish(nil, &FunctionError{
+				Type:    "Function.ResponseSizeTooLarge",
+				Message: "response payload size exceeded maximum allowed payload size",
+			})
+			writeError(w, http.StatusRequestEntityTooLarge, "RequestEntityTooLarge", "response too large")
+			return
+		}
+		inv.finish(&Response{Body: io.NopCloser(bytes.NewReader(body))}, nil)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// stream the body to the invoker as it arrives, then check the
+	// trailers for a mid-stream error
+	pr, pw := io.Pipe()
+	inv.finish(&Response{Body: pr, Streaming: true}, nil)
+	_, err := io.Copy(pw, r.Body)
+	if err != nil {
+		pw.CloseWithError(fmt.Errorf("emulator: reading response: %s", err))
+		// the invoker may have closed the body early
+		_, _ = io.Copy(io.Discard, r.Body)
+	} else if fe := trailerError(r.Trailer); fe != nil {
+		pw.CloseWithError(fe)
+	} else {
+		pw.Close()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// error serves a function's error for an invocation.
+func (e *Emulator) error(w http.ResponseWriter, r *http.Request) {
+	inv, ok := e.claim(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "InvalidRequestID", "unknown or already answered request-id")
+		return
+	}
+	inv.finish(nil, readFunctionError(r))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// initError serves a function's report of failing to initialize.
+// Waiting and later invocations fail with the error.
+func (e *Emulator) initError(w http.ResponseWriter, r *http.Request) {
+	fe := readFunctionError(r)
+
+	e.mu.Lock()
+	first := e.initFailure == nil
+	if first {
+		e.initFailure = fe
+	}
+	e.mu.Unlock()
+	if first {
+		close(e.initErr)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// finish completes an invocation.
+func (inv *invocation) finish(resp *Response, err error) {
+	inv.resp = resp
+	inv.err = err
+	close(inv.done)
+}
+
+func (e *Emulator) timeout() time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	}
+	return 3 * time.Second
+}
+
+func (e *Emulator) functionArn() string {
+	if e.FunctionArn != "" {
+		return e.FunctionArn
+	}
+	return "arn:aws:lambda:us-east-1:000000000000:function:emulated"
+}
+
+// readFunctionError reads an error-document from a request.
+func readFunctionError(r *http.Request) *FunctionError {
+	var fe FunctionError
+	body, _ := io.ReadAll(io.LimitReader(r.Body, MaxResponseSize))
+	if err := json.Unmarshal(body, &fe); err != nil {
+		fe.Message = string(body)
+	}
+	if fe.Type == "" {
+		fe.Type = r.Header.Get("Lambda-Runtime-Function-Error-Type")
+	}
+	return &fe
+}
+
+// trailerError returns the error reported in the trailers of a
+// streamed response, if any.
+func trailerError(t http.Header) *FunctionError {
+	typ := t.Get("Lambda-Runtime-Function-Error-Type")
+	if typ == "" {
+		return nil
+	}
+	fe := &FunctionError{Type: typ}
+	if b, err := base64.StdEncoding.DecodeString(t.Get("Lambda-Runtime-Function-Error-Body")); err == nil {
+		_ = json.Unmarshal(b, fe)
+	}
+	fe.Type = typ
+	return fe
+}
+
+// writeError writes an error-response in the Runtime API's format.
+func writeError(w http.ResponseWriter, status int, typ string, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(FunctionError{Type: typ, Message: msg})
+}
+
+// newID returns a random request-id, formatted as a UUID.
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// newTraceID returns a random, unsampled X-Ray trace-header.
+func newTraceID() string {
+	var b [20]byte
+	_, _ = rand.Read(b[:])
+	h := hex.EncodeToString(b[:])
+	return fmt.Sprintf("Root=1-%08x-%s;Parent=%s;Sampled=0", time.Now().Unix(), h[:24], h[24:40])
+}
@@ -0,0 +1,217 @@
+package emulator_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+	"github.com/aslatter/aws-go-lambda-demo/mlambda/emulator"
+)
+
+// httpEvent is a minimal function URL event.
+const httpEvent = `{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"GET","path":"/"}}}`
+
+// runtimeAPI serves an Emulator, recording the content-type of each
+// response the function sends.
+type runtimeAPI struct {
+	emu *emulator.Emulator
+
+	mu           sync.Mutex
+	contentTypes []string
+}
+
+func (a *runtimeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/response") {
+		a.mu.Lock()
+		a.contentTypes = append(a.contentTypes, r.Header.Get("Content-Type"))
+		a.mu.Unlock()
+	}
+	a.emu.ServeHTTP(w, r)
+}
+
+func (a *runtimeAPI) lastContentType() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.contentTypes) == 0 {
+		return ""
+	}
+	return a.contentTypes[len(a.contentTypes)-1]
+}
+
+// serve runs srv against a new Emulator until the test ends.
+func serve(t *testing.T, srv *mlambda.Server, timeout time.Duration) *runtimeAPI {
+	t.Helper()
+
+	api := &runtimeAPI{emu: &emulator.Emulator{Timeout: timeout}}
+	hs := httptest.NewServer(api)
+	t.Cleanup(hs.Close)
+	t.Setenv("AWS_LAMBDA_RUNTIME_API", strings.TrimPrefix(hs.URL, "http://"))
+
+	srv.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.Start(ctx)
+	return api
+}
+
+// invoke sends an event, returning the response body and whether it
+// was streamed.
+func invoke(t *testing.T, emu *emulator.Emulator, payload string) (string, bool, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := emu.Invoke(ctx, []byte(payload))
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), resp.Streaming, err
+}
+
+// functionError returns the type of a *emulator.FunctionError.
+func functionError(t *testing.T, err error) string {
+	t.Helper()
+
+	var fe *emulator.FunctionError
+	if !errors.As(err, &fe) {
+		t.Fatalf("got error %v, want a *FunctionError", err)
+	}
+	return fe.Type
+}
+
+func TestBuffered(t *testing.T) {
+	api := serve(t, &mlambda.Server{
+		Handler: mlambda.HandlerFunc(func(ctx context.Context, w io.Writer, r *mlambda.Request) error {
+			event, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(event, []byte(`"fail"`)) {
+				return errors.New("failed")
+			}
+			_, err = w.Write(event)
+			return err
+		}),
+	}, 5*time.Second)
+
+	body, streaming, err := invoke(t, api.emu, `{"hello":"world"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"hello":"world"}` || streaming {
+		t.Errorf("got %q (streaming %v), want the event back, buffered", body, streaming)
+	}
+
+	_, _, err = invoke(t, api.emu, `"fail"`)
+	if got := functionError(t, err); got != "Handler.Error" {
+		t.Errorf("got error type %q, want Handler.Error", got)
+	}
+
+	// the runtime carries on after an error
+	if _, _, err := invoke(t, api.emu, `{}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamed(t *testing.T) {
+	api := serve(t, &mlambda.Server{
+		StreamResponses: true,
+		Handler: mlambda.HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, "partial")
+			w.(http.Flusher).Flush()
+			if r.URL.Query().Has("panic") {
+				panic("mid-stream")
+			}
+		})),
+	}, 5*time.Second)
+
+	body, streaming, err := invoke(t, api.emu, httpEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !streaming || !strings.HasSuffix(body, "partial") {
+		t.Errorf("got %q (streaming %v), want a streamed response ending in the body", body, streaming)
+	}
+	if ct := api.lastContentType(); ct != "application/vnd.awslambda.http-integration-response" {
+		t.Errorf("got content-type %q, want the HTTP-integration content-type", ct)
+	}
+
+	// an error after the response has started is reported in the
+	// trailers
+	event := strings.Replace(httpEvent, `"rawPath":"/"`, `"rawPath":"/","rawQueryString":"panic=1"`, 1)
+	body, _, err = invoke(t, api.emu, event)
+	if got := functionError(t, err); got != "Handler.Panic" {
+		t.Errorf("got error type %q, want Handler.Panic", got)
+	}
+	if !strings.HasSuffix(body, "partial") {
+		t.Errorf("got %q, want the body written before the panic", body)
+	}
+}
+
+func TestInitError(t *testing.T) {
+	emu := &emulator.Emulator{}
+	hs := httptest.NewServer(emu)
+	defer hs.Close()
+
+	// mlambda.Server does not report init errors, so this is what
+	// a runtime which fails to initialize sends
+	resp, err := http.Post(hs.URL+"/2018-06-01/runtime/init/error", "application/json",
+		strings.NewReader(`{"errorType":"Runtime.ConfigError","errorMessage":"missing TABLE"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", resp.StatusCode)
+	}
+
+	if got := functionError(t, emu.InitError()); got != "Runtime.ConfigError" {
+		t.Errorf("got init error type %q, want Runtime.ConfigError", got)
+	}
+	_, _, err = invoke(t, emu, `{}`)
+	if got := functionError(t, err); got != "Runtime.ConfigError" {
+		t.Errorf("got error type %q, want Runtime.ConfigError", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	api := serve(t, &mlambda.Server{
+		Handler: mlambda.HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Has("slow") {
+				// ignores its deadline, and responds after the
+				// invocation was abandoned
+				<-release
+			}
+			_, _ = io.WriteString(w, "done")
+		})),
+	}, 200*time.Millisecond)
+
+	event := strings.Replace(httpEvent, `"rawPath":"/"`, `"rawPath":"/","rawQueryString":"slow=1"`, 1)
+	_, _, err := invoke(t, api.emu, event)
+	if !errors.Is(err, emulator.ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	close(release)
+
+	// the late response is rejected, and the runtime moves on
+	body, _, err := invoke(t, api.emu, httpEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"isBase64Encoded":true,"statusCode":200,"body":"ZG9uZQ=="}`; body != want {
+		t.Errorf("got %q, want the response to the second event", body)
+	}
+}
@@ -0,0 +1,148 @@
+package mlambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+)
+
+// ErrorClass categorizes invocation failures, so that application bugs
+// can be told apart from platform or adapter problems.
+type ErrorClass string
+
+const (
+	// ErrorClassHandler is an error returned by the handler.
+	ErrorClassHandler ErrorClass = "Handler"
+
+	// ErrorClassPanic is a panic in the handler.
+	ErrorClassPanic ErrorClass = "Panic"
+
+	// ErrorClassTimeout is a handler which ran out of time.
+	ErrorClassTimeout ErrorClass = "Timeout"
+
+	// ErrorClassDecode is an event which could not be decoded.
+	ErrorClassDecode ErrorClass = "Decode"
+
+	// ErrorClassUpload is a failure sending the response to the
+	// lambda service.
+	ErrorClassUpload ErrorClass = "Upload"
+
+	// ErrorClassEventTooLarge is an event larger than
+	// Server.MaxEventSize.
+	ErrorClassEventTooLarge ErrorClass = "EventTooLarge"
+)
+
+// errorType returns the error-type reported to the lambda service.
+func (c ErrorClass) errorType() string {
+	switch c {
+	case ErrorClassPanic:
+		return "Handler.Panic"
+	case ErrorClassTimeout:
+		return "Handler.Timeout"
+	case ErrorClassDecode:
+		return "Handler.DecodeError"
+	case ErrorClassUpload:
+		return "Runtime.UploadError"
+	case ErrorClassEventTooLarge:
+		return "Runtime.EventTooLarge"
+	}
+	return "Handler.Error"
+}
+
+// DecodeError is returned by adapters when an event cannot be decoded
+// into the shape the adapter expects.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "decoding event: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is the error reported for a handler which panicked.
+type PanicError struct {
+	Value any
+
+	// Stack is the stack of the panicking goroutine.
+	Stack string
+
+	// Goroutines holds the stacks of all goroutines, truncated. It is
+	// only collected if the Server's PanicGoroutineDump is set.
+	Goroutines string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// newPanicError captures the stack of the current (panicking) goroutine.
+func newPanicError(v any) *PanicError {
+	return &PanicError{Value: v, Stack: string(debug.Stack())}
+}
+
+// stackTrace returns the stack (and goroutine-dump, if any) as a list of
+// lines, as expected by the lambda error-reporting API.
+func (e *PanicError) stackTrace() []string {
+	trace := strings.Split(strings.TrimSpace(e.Stack), "\n")
+	if e.Goroutines != "" {
+		trace = append(trace, "", "all goroutines:")
+		trace = append(trace, strings.Split(strings.TrimSpace(e.Goroutines), "\n")...)
+	}
+	return trace
+}
+
+// classifyError determines the class of a handler-error. The context is
+// the handler's context.
+func classifyError(ctx context.Context, err error) ErrorClass {
+	var panicErr *PanicError
+	var decodeErr *DecodeError
+	switch {
+	case errors.As(err, &panicErr):
+		return ErrorClassPanic
+	case errors.Is(err, ErrEventTooLarge):
+		return ErrorClassEventTooLarge
+	case errors.As(err, &decodeErr):
+		return ErrorClassDecode
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrorClassTimeout
+	}
+	return ErrorClassHandler
+}
+
+// recordError publishes an error-count for the class.
+func (s *Server) recordError(ctx context.Context, class ErrorClass) {
+	s.record(ctx, "Errors", 1, UnitCount, Dimension{Name: "ErrorClass", Value: string(class)})
+}
+
+// maxPanicGoroutineDump bounds the goroutine-dump attached to panic
+// reports, which must fit in the error-payload sent to lambda.
+const maxPanicGoroutineDump = 32 * 1024
+
+// handlePanic builds the error for a recovered handler-panic, and logs it.
+// It must be called from the deferred recovery function.
+func (s *Server) handlePanic(ctx context.Context, v any) *PanicError {
+	err := newPanicError(v)
+	if s.PanicGoroutineDump {
+		err.Goroutines = goroutineDump(maxPanicGoroutineDump)
+	}
+
+	attrs := []slog.Attr{
+		slog.String("requestId", RequestIDFromContext(ctx)),
+		slog.String("panic", fmt.Sprint(v)),
+		slog.String("stack", err.Stack),
+	}
+	if err.Goroutines != "" {
+		attrs = append(attrs, slog.String("goroutines", err.Goroutines))
+	}
+	s.logger().LogAttrs(ctx, slog.LevelError, "handler panic", attrs...)
+	s.logHistory(ctx, "panic")
+
+	return err
+}
@@ -0,0 +1,347 @@
+package mlambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var eventBridgeService = awsapi.Service{
+	SigningName:  "events",
+	JSONVersion:  "1.1",
+	TargetPrefix: "AWSEvents",
+}
+
+const (
+	// PutEvents limits
+	maxPutEventsEntries = 10
+	maxPutEventsBytes   = 256 * 1024
+
+	publishRetryDelay = 100 * time.Millisecond
+)
+
+// Event is an event to publish to EventBridge.
+type Event struct {
+	Source     string
+	DetailType string
+
+	// Detail is marshaled as JSON.
+	Detail any
+
+	Resources []string
+
+	// Time is when the event happened. If zero, EventBridge uses the
+	// time it was published.
+	Time time.Time
+}
+
+// eventEntry is a PutEvents request-entry.
+type eventEntry struct {
+	Source       string
+	DetailType   string
+	Detail       string
+	EventBusName string
+	Resources    []string `json:",omitempty"`
+	Time         int64    `json:",omitzero"`
+}
+
+// size approximates the size PutEvents counts against its limit.
+func (e *eventEntry) size() int {
+	n := len(e.Source) + len(e.DetailType) + len(e.Detail)
+	for _, r := range e.Resources {
+		n += len(r)
+	}
+	if e.Time != 0 {
+		n += 14
+	}
+	return n
+}
+
+// EventPublisher publishes events to an EventBridge bus, batching them
+// into PutEvents calls and retrying entries which fail. The function
+// needs events:PutEvents on the bus.
+//
+// If OutboxTable is set, events are first recorded in that DynamoDB
+// table (with a string partition-key named "id") and removed once
+// published. Events which fail to publish stay in the outbox and are
+// published by later calls to Publish or Drain, from any
+// execution-environment. To make recording events atomic with other
+// writes, add the items from OutboxItems to the same
+// TransactWriteItems call, and call Drain after it succeeds. Either
+// way, events may be delivered more than once.
+type EventPublisher struct {
+	Client   *awsapi.Client
+	EventBus string
+
+	// OutboxTable, if set, is the DynamoDB table to record events in
+	// until they are published.
+	OutboxTable string
+
+	// MaxAttempts is how many times to try publishing an event before
+	// giving up (or leaving it in the outbox). The default is 3.
+	MaxAttempts int
+
+	// Retry, if set, is the policy for retrying failed events, in
+	// place of MaxAttempts with an ExponentialBackoff from 100ms.
+	// Failed entries, connection failures, throttling, and service
+	// errors are retried; other errors from PutEvents are not.
+	Retry RetryPolicy
+
+	// drained is set once the outbox has been found empty, and cleared
+	// when an event is left in it. It starts clear, to pick up events
+	// left by other environments.
+	drained atomic.Bool
+}
+
+// Publish publishes events. With an outbox, Publish first publishes
+// any events left in it, and an error means only that some events were
+// left to be published later.
+func (p *EventPublisher) Publish(ctx context.Context, events ...Event) error {
+	entries, err := p.entries(events)
+	if err != nil {
+		return err
+	}
+	if p.OutboxTable == "" {
+		return p.putEvents(ctx, entries)
+	}
+
+	// drain a page at a time, so a backlog doesn't hold up callers
+	if err := p.drain(ctx, 1); err != nil {
+		slog.WarnContext(ctx, "draining outbox", "error", err)
+	}
+	keys, err := p.record(ctx, entries)
+	if err != nil {
+		return fmt.Errorf("recording events: %s", err)
+	}
+	if err := p.putEvents(ctx, entries); err != nil {
+		p.drained.Store(false)
+		return fmt.Errorf("publishing events, leaving them in the outbox: %s", err)
+	}
+	p.remove(ctx, keys)
+	return nil
+}
+
+// OutboxItems returns TransactWriteItems members recording events in
+// the outbox, to be written along with the change they describe.
+func (p *EventPublisher) OutboxItems(events ...Event) ([]map[string]any, error) {
+	entries, err := p.entries(events)
+	if err != nil {
+		return nil, err
+	}
+	var items []map[string]any
+	for i := range entries {
+		item, err := p.outboxItem(&entries[i])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, map[string]any{
+			"Put": map[string]any{"TableName": p.OutboxTable, "Item": item},
+		})
+	}
+	p.drained.Store(false)
+	return items, nil
+}
+
+// Drain publishes events left in the outbox, a page at a time, until it
+// is empty.
+func (p *EventPublisher) Drain(ctx context.Context) error {
+	return p.drain(ctx, 0)
+}
+
+// drain publishes up to pages pages of events from the outbox, or all
+// of them if pages is zero.
+func (p *EventPublisher) drain(ctx context.Context, pages int) error {
+	if p.OutboxTable == "" || p.drained.Load() {
+		return nil
+	}
+	in := map[string]any{
+		"TableName":      p.OutboxTable,
+		"Limit":          maxPutEventsEntries,
+		"ConsistentRead": true,
+	}
+	for page := 1; ; page++ {
+		var out struct {
+			Items            []map[string]ddbAttr
+			LastEvaluatedKey map[string]ddbAttr
+		}
+		if err := p.Client.DoJSON(ctx, dynamoDBService, "Scan", in, &out); err != nil {
+			return fmt.Errorf("reading outbox: %s", err)
+		}
+
+		var keys []string
+		var entries []eventEntry
+		for _, it := range out.Items {
+			key, entry := it["id"].S, it["entry"].S
+			if key == nil || entry == nil {
+				continue
+			}
+			var e eventEntry
+			if err := jsonv2.Unmarshal([]byte(*entry), &e); err != nil {
+				// it will never publish, so don't let it block the outbox
+				slog.ErrorContext(ctx, "discarding undecodable outbox entry", "error", err, "key", *key)
+				p.remove(ctx, []string{*key})
+				continue
+			}
+			keys = append(keys, *key)
+			entries = append(entries, e)
+		}
+		if err := p.putEvents(ctx, entries); err != nil {
+			return err
+		}
+		p.remove(ctx, keys)
+
+		if out.LastEvaluatedKey == nil {
+			p.drained.Store(true)
+			return nil
+		}
+		if page == pages {
+			return nil
+		}
+		in["ExclusiveStartKey"] = out.LastEvaluatedKey
+	}
+}
+
+func (p *EventPublisher) entries(events []Event) ([]eventEntry, error) {
+	entries := make([]eventEntry, len(events))
+	for i, ev := range events {
+		detail, err := jsonv2.Marshal(ev.Detail)
+		if err != nil {
+			return nil, fmt.Errorf("encoding event detail: %s", err)
+		}
+		entries[i] = eventEntry{
+			Source:       ev.Source,
+			DetailType:   ev.DetailType,
+			Detail:       string(detail),
+			EventBusName: p.EventBus,
+			Resources:    ev.Resources,
+		}
+		if !ev.Time.IsZero() {
+			entries[i].Time = ev.Time.Unix()
+		}
+	}
+	return entries, nil
+}
+
+// putEvents publishes entries in batches, retrying failed entries.
+func (p *EventPublisher) putEvents(ctx context.Context, entries []eventEntry) error {
+	for len(entries) > 0 {
+		n, size := 0, 0
+		for n < len(entries) && n < maxPutEventsEntries {
+			size += entries[n].size()
+			if size > maxPutEventsBytes && n > 0 {
+				break
+			}
+			n++
+		}
+		if err := p.putBatch(ctx, entries[:n]); err != nil {
+			return err
+		}
+		entries = entries[n:]
+	}
+	return nil
+}
+
+func (p *EventPublisher) putBatch(ctx context.Context, entries []eventEntry) error {
+	policy := p.Retry
+	if policy == nil {
+		policy = ExponentialBackoff{MaxAttempts: p.MaxAttempts, Base: publishRetryDelay, NoJitter: true}
+	}
+	// if we can't tell which entries failed, retrying could duplicate
+	// the others
+	var unattributed bool
+	retryable := func(err error) bool { return !unattributed && retryableAWSError(err) }
+	return retry(ctx, policy, retryable, func() error {
+		in := map[string]any{"Entries": entries}
+		var out struct {
+			FailedEntryCount int
+			Entries          []struct {
+				ErrorCode    string
+				ErrorMessage string
+			}
+		}
+		err := p.Client.DoJSON(ctx, eventBridgeService, "PutEvents", in, &out)
+		if err != nil || out.FailedEntryCount == 0 {
+			return err
+		}
+
+		// retry only the failed entries, which are reported in order
+		var failed []eventEntry
+		for i, e := range out.Entries {
+			if e.ErrorCode != "" && i < len(entries) {
+				failed = append(failed, entries[i])
+				err = fmt.Errorf("%d events failed: %s: %s", out.FailedEntryCount, e.ErrorCode, e.ErrorMessage)
+			}
+		}
+		if failed == nil {
+			unattributed = true
+			return fmt.Errorf("%d events failed", out.FailedEntryCount)
+		}
+		entries = failed
+		return err
+	})
+}
+
+// retryableAWSError reports if a failed AWS API call may succeed if
+// retried: if it failed to connect, the service had an internal error,
+// or the request was throttled. Other errors, such as AccessDenied or
+// ValidationException, would fail the same way again.
+func retryableAWSError(err error) bool {
+	var apiErr *awsapi.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 ||
+			apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.Code == "ThrottlingException"
+	}
+	return true
+}
+
+func (p *EventPublisher) outboxItem(entry *eventEntry) (map[string]ddbAttr, error) {
+	b, err := jsonv2.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]ddbAttr{
+		"id":    ddbString(newLocalRequestID()),
+		"entry": ddbString(string(b)),
+	}, nil
+}
+
+// record adds entries to the outbox, returning their keys.
+func (p *EventPublisher) record(ctx context.Context, entries []eventEntry) ([]string, error) {
+	var keys []string
+	for i := range entries {
+		item, err := p.outboxItem(&entries[i])
+		if err != nil {
+			return nil, err
+		}
+		in := map[string]any{"TableName": p.OutboxTable, "Item": item}
+		if err := p.Client.DoJSON(ctx, dynamoDBService, "PutItem", in, nil); err != nil {
+			return nil, err
+		}
+		keys = append(keys, *item["id"].S)
+	}
+	return keys, nil
+}
+
+// remove deletes published entries from the outbox. If it fails the
+// entry is published again later, so consumers must tolerate
+// duplicates (as they must with EventBridge anyway).
+func (p *EventPublisher) remove(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		in := map[string]any{
+			"TableName": p.OutboxTable,
+			"Key":       map[string]ddbAttr{"id": ddbString(key)},
+		}
+		if err := p.Client.DoJSON(ctx, dynamoDBService, "DeleteItem", in, nil); err != nil {
+			slog.WarnContext(ctx, "removing event from outbox", "error", err, "key", key)
+		}
+	}
+}
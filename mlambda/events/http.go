@@ -0,0 +1,221 @@
+// Package events defines the payloads lambda delivers from its event
+// sources, and the responses it expects, for building and inspecting
+// events in functions and their tests.
+//
+// The types have json tags for both encoding/json and
+// github.com/go-json-experiment/json.
+package events
+
+import "encoding/json"
+
+// APIGatewayV2HTTPRequest is an API Gateway HTTP API (payload version
+// 2.0) or function-URL event.
+//
+// https://docs.aws.amazon.com/apigateway/latest/developerguide/http-api-develop-integrations-lambda.html
+type APIGatewayV2HTTPRequest struct {
+	Version               string                         `json:"version"`
+	RouteKey              string                         `json:"routeKey"`
+	RawPath               string                         `json:"rawPath"`
+	RawQueryString        string                         `json:"rawQueryString"`
+	Cookies               []string                       `json:"cookies,omitempty"`
+	Headers               map[string]string              `json:"headers,omitempty"`
+	QueryStringParameters map[string]string              `json:"queryStringParameters,omitempty"`
+	PathParameters        map[string]string              `json:"pathParameters,omitempty"`
+	StageVariables        map[string]string              `json:"stageVariables,omitempty"`
+	RequestContext        APIGatewayV2HTTPRequestContext `json:"requestContext"`
+	Body                  string                         `json:"body,omitempty"`
+	IsBase64Encoded       bool                           `json:"isBase64Encoded"`
+}
+
+// APIGatewayV2HTTPRequestContext is the request-context of a version
+// 2.0 event.
+type APIGatewayV2HTTPRequestContext struct {
+	AccountID      string                             `json:"accountId"`
+	ApiID          string                             `json:"apiId"`
+	Authentication *APIGatewayV2Authentication        `json:"authentication,omitempty"`
+	Authorizer     *APIGatewayV2Authorizer            `json:"authorizer,omitempty"`
+	DomainName     string                             `json:"domainName"`
+	DomainPrefix   string                             `json:"domainPrefix"`
+	HTTP           APIGatewayV2HTTPRequestContextHTTP `json:"http"`
+	RequestID      string                             `json:"requestId"`
+	RouteKey       string                             `json:"routeKey"`
+	Stage          string                             `json:"stage"`
+	Time           string                             `json:"time"`
+	TimeEpoch      int64                              `json:"timeEpoch"`
+}
+
+// APIGatewayV2HTTPRequestContextHTTP describes the HTTP request of a
+// version 2.0 event.
+type APIGatewayV2HTTPRequestContextHTTP struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Protocol  string `json:"protocol"`
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+// APIGatewayV2Authentication holds the client-certificate of a mutual
+// TLS request.
+type APIGatewayV2Authentication struct {
+	ClientCert struct {
+		ClientCertPem string `json:"clientCertPem"`
+		SubjectDN     string `json:"subjectDN"`
+		IssuerDN      string `json:"issuerDN"`
+		SerialNumber  string `json:"serialNumber"`
+		Validity      struct {
+			NotBefore string `json:"notBefore"`
+			NotAfter  string `json:"notAfter"`
+		} `json:"validity"`
+	} `json:"clientCert"`
+}
+
+// APIGatewayV2Authorizer is the output of a route's authorizer. At
+// most one of the fields is set.
+type APIGatewayV2Authorizer struct {
+	JWT    *APIGatewayV2JWTAuthorizer `json:"jwt,omitempty"`
+	IAM    *APIGatewayV2IAMAuthorizer `json:"iam,omitempty"`
+	Lambda map[string]any             `json:"lambda,omitempty"`
+}
+
+// APIGatewayV2JWTAuthorizer holds the claims and scopes of a verified
+// JWT.
+type APIGatewayV2JWTAuthorizer struct {
+	Claims map[string]string `json:"claims"`
+	Scopes []string          `json:"scopes"`
+}
+
+// APIGatewayV2IAMAuthorizer describes a caller using IAM authorization.
+type APIGatewayV2IAMAuthorizer struct {
+	AccessKey       string                       `json:"accessKey"`
+	AccountID       string                       `json:"accountId"`
+	CallerID        string                       `json:"callerId"`
+	PrincipalOrgID  string                       `json:"principalOrgId"`
+	UserArn         string                       `json:"userArn"`
+	UserID          string                       `json:"userId"`
+	CognitoIdentity *APIGatewayV2CognitoIdentity `json:"cognitoIdentity,omitempty"`
+}
+
+// APIGatewayV2CognitoIdentity describes a caller authenticated with a
+// Cognito identity-pool.
+type APIGatewayV2CognitoIdentity struct {
+	AMR            []string `json:"amr"`
+	IdentityID     string   `json:"identityId"`
+	IdentityPoolID string   `json:"identityPoolId"`
+}
+
+// APIGatewayV2HTTPResponse is the response to a version 2.0 event.
+type APIGatewayV2HTTPResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// APIGatewayProxyRequest is an API Gateway REST API (or HTTP API
+// payload version 1.0) event.
+//
+// https://docs.aws.amazon.com/apigateway/latest/developerguide/set-up-lambda-proxy-integrations.html
+type APIGatewayProxyRequest struct {
+	Version                         string                        `json:"version,omitempty"`
+	Resource                        string                        `json:"resource"`
+	Path                            string                        `json:"path"`
+	HTTPMethod                      string                        `json:"httpMethod"`
+	Headers                         map[string]string             `json:"headers"`
+	MultiValueHeaders               map[string][]string           `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string             `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string           `json:"multiValueQueryStringParameters"`
+	PathParameters                  map[string]string             `json:"pathParameters"`
+	StageVariables                  map[string]string             `json:"stageVariables"`
+	RequestContext                  APIGatewayProxyRequestContext `json:"requestContext"`
+	Body                            string                        `json:"body"`
+	IsBase64Encoded                 bool                          `json:"isBase64Encoded"`
+}
+
+// APIGatewayProxyRequestContext is the request-context of a version
+// 1.0 event.
+type APIGatewayProxyRequestContext struct {
+	AccountID         string                  `json:"accountId"`
+	ApiID             string                  `json:"apiId"`
+	Authorizer        map[string]any          `json:"authorizer,omitempty"`
+	DomainName        string                  `json:"domainName"`
+	DomainPrefix      string                  `json:"domainPrefix"`
+	ExtendedRequestID string                  `json:"extendedRequestId"`
+	HTTPMethod        string                  `json:"httpMethod"`
+	Identity          APIGatewayProxyIdentity `json:"identity"`
+	Path              string                  `json:"path"`
+	Protocol          string                  `json:"protocol"`
+	RequestID         string                  `json:"requestId"`
+	RequestTime       string                  `json:"requestTime"`
+	RequestTimeEpoch  int64                   `json:"requestTimeEpoch"`
+	ResourceID        string                  `json:"resourceId"`
+	ResourcePath      string                  `json:"resourcePath"`
+	Stage             string                  `json:"stage"`
+}
+
+// APIGatewayProxyIdentity describes the caller of a version 1.0 event.
+type APIGatewayProxyIdentity struct {
+	AccessKey                     string          `json:"accessKey"`
+	AccountID                     string          `json:"accountId"`
+	APIKey                        string          `json:"apiKey"`
+	APIKeyID                      string          `json:"apiKeyId"`
+	Caller                        string          `json:"caller"`
+	ClientCert                    json.RawMessage `json:"clientCert,omitempty"`
+	CognitoAuthenticationProvider string          `json:"cognitoAuthenticationProvider"`
+	CognitoAuthenticationType     string          `json:"cognitoAuthenticationType"`
+	CognitoIdentityID             string          `json:"cognitoIdentityId"`
+	CognitoIdentityPoolID         string          `json:"cognitoIdentityPoolId"`
+	PrincipalOrgID                string          `json:"principalOrgId"`
+	SourceIP                      string          `json:"sourceIp"`
+	User                          string          `json:"user"`
+	UserAgent                     string          `json:"userAgent"`
+	UserArn                       string          `json:"userArn"`
+}
+
+// APIGatewayProxyResponse is the response to a version 1.0 event.
+type APIGatewayProxyResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// ALBTargetGroupRequest is an Application Load Balancer event. Either
+// the single- or multi-value fields are set, depending on the
+// target-group's configuration. Unlike API Gateway events, the path and
+// query-parameters are not decoded.
+//
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/lambda-functions.html
+type ALBTargetGroupRequest struct {
+	HTTPMethod                      string                       `json:"httpMethod"`
+	Path                            string                       `json:"path"`
+	QueryStringParameters           map[string]string            `json:"queryStringParameters,omitempty"`
+	MultiValueQueryStringParameters map[string][]string          `json:"multiValueQueryStringParameters,omitempty"`
+	Headers                         map[string]string            `json:"headers,omitempty"`
+	MultiValueHeaders               map[string][]string          `json:"multiValueHeaders,omitempty"`
+	RequestContext                  ALBTargetGroupRequestContext `json:"requestContext"`
+	Body                            string                       `json:"body"`
+	IsBase64Encoded                 bool                         `json:"isBase64Encoded"`
+}
+
+// ALBTargetGroupRequestContext identifies the target-group of an ALB
+// event.
+type ALBTargetGroupRequestContext struct {
+	ELB struct {
+		TargetGroupArn string `json:"targetGroupArn"`
+	} `json:"elb"`
+}
+
+// ALBTargetGroupResponse is the response to an ALB event. Use
+// MultiValueHeaders if, and only if, the target-group has multi-value
+// headers enabled.
+type ALBTargetGroupResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	StatusDescription string              `json:"statusDescription"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
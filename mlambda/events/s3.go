@@ -0,0 +1,58 @@
+package events
+
+import "time"
+
+// S3Event is a notification of changes to objects in an S3 bucket.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html
+type S3Event struct {
+	Records []S3EventRecord `json:"Records"`
+}
+
+// S3EventRecord is a record of an S3Event.
+type S3EventRecord struct {
+	EventVersion      string              `json:"eventVersion"`
+	EventSource       string              `json:"eventSource"`
+	AWSRegion         string              `json:"awsRegion"`
+	EventTime         time.Time           `json:"eventTime"`
+	EventName         string              `json:"eventName"`
+	UserIdentity      S3UserIdentity      `json:"userIdentity"`
+	RequestParameters S3RequestParameters `json:"requestParameters"`
+	ResponseElements  map[string]string   `json:"responseElements"`
+	S3                S3Entity            `json:"s3"`
+}
+
+// S3UserIdentity identifies the principal which caused an event.
+type S3UserIdentity struct {
+	PrincipalID string `json:"principalId"`
+}
+
+// S3RequestParameters describes the request which caused an event.
+type S3RequestParameters struct {
+	SourceIPAddress string `json:"sourceIPAddress"`
+}
+
+// S3Entity describes the bucket and object of an event.
+type S3Entity struct {
+	SchemaVersion   string   `json:"s3SchemaVersion"`
+	ConfigurationID string   `json:"configurationId"`
+	Bucket          S3Bucket `json:"bucket"`
+	Object          S3Object `json:"object"`
+}
+
+// S3Bucket identifies a bucket.
+type S3Bucket struct {
+	Name          string         `json:"name"`
+	OwnerIdentity S3UserIdentity `json:"ownerIdentity"`
+	Arn           string         `json:"arn"`
+}
+
+// S3Object identifies an object. The key is URL-encoded (with spaces
+// as '+'), as in an HTML form.
+type S3Object struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
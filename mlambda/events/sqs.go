@@ -0,0 +1,82 @@
+package events
+
+import "time"
+
+// SQSEvent is a batch of messages from an SQS queue.
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/with-sqs.html
+type SQSEvent struct {
+	Records []SQSMessage `json:"Records"`
+}
+
+// SQSMessage is a message of an SQSEvent.
+type SQSMessage struct {
+	MessageID              string                         `json:"messageId"`
+	ReceiptHandle          string                         `json:"receiptHandle"`
+	Body                   string                         `json:"body"`
+	Attributes             map[string]string              `json:"attributes"`
+	MessageAttributes      map[string]SQSMessageAttribute `json:"messageAttributes"`
+	MD5OfBody              string                         `json:"md5OfBody"`
+	MD5OfMessageAttributes string                         `json:"md5OfMessageAttributes,omitempty"`
+	EventSource            string                         `json:"eventSource"`
+	EventSourceARN         string                         `json:"eventSourceARN"`
+	AWSRegion              string                         `json:"awsRegion"`
+}
+
+// SQSMessageAttribute is a message-attribute of an SQSMessage.
+type SQSMessageAttribute struct {
+	DataType         string   `json:"dataType"`
+	StringValue      *string  `json:"stringValue,omitempty"`
+	BinaryValue      []byte   `json:"binaryValue,omitempty"`
+	StringListValues []string `json:"stringListValues"`
+	BinaryListValues [][]byte `json:"binaryListValues"`
+}
+
+// BatchItemFailure identifies a record which failed, in a partial
+// batch response.
+type BatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// BatchResponse is the partial batch response to SQS, Kinesis, and
+// DynamoDB stream events, listing the records to retry.
+type BatchResponse struct {
+	BatchItemFailures []BatchItemFailure `json:"batchItemFailures"`
+}
+
+// SNSEvent is a notification from an SNS topic.
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/with-sns.html
+type SNSEvent struct {
+	Records []SNSEventRecord `json:"Records"`
+}
+
+// SNSEventRecord is a record of an SNSEvent.
+type SNSEventRecord struct {
+	EventVersion         string    `json:"EventVersion"`
+	EventSubscriptionArn string    `json:"EventSubscriptionArn"`
+	EventSource          string    `json:"EventSource"`
+	SNS                  SNSEntity `json:"Sns"`
+}
+
+// SNSEntity is a message published to an SNS topic.
+type SNSEntity struct {
+	Type              string                         `json:"Type"`
+	MessageID         string                         `json:"MessageId"`
+	TopicArn          string                         `json:"TopicArn"`
+	Subject           string                         `json:"Subject"`
+	Message           string                         `json:"Message"`
+	Timestamp         time.Time                      `json:"Timestamp"`
+	SignatureVersion  string                         `json:"SignatureVersion"`
+	Signature         string                         `json:"Signature"`
+	SigningCertURL    string                         `json:"SigningCertUrl"`
+	UnsubscribeURL    string                         `json:"UnsubscribeUrl"`
+	MessageAttributes map[string]SNSMessageAttribute `json:"MessageAttributes"`
+}
+
+// SNSMessageAttribute is a message-attribute of an SNSEntity. Binary
+// values are base64 encoded.
+type SNSMessageAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
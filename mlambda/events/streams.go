@@ -0,0 +1,112 @@
+package events
+
+// KinesisEvent is a batch of records from a Kinesis data stream.
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/with-kinesis.html
+type KinesisEvent struct {
+	Records []KinesisEventRecord `json:"Records"`
+}
+
+// KinesisEventRecord is a record of a KinesisEvent.
+type KinesisEventRecord struct {
+	EventID           string        `json:"eventID"`
+	EventName         string        `json:"eventName"`
+	EventSource       string        `json:"eventSource"`
+	EventSourceArn    string        `json:"eventSourceARN"`
+	EventVersion      string        `json:"eventVersion"`
+	AWSRegion         string        `json:"awsRegion"`
+	InvokeIdentityArn string        `json:"invokeIdentityArn"`
+	Kinesis           KinesisRecord `json:"kinesis"`
+}
+
+// KinesisRecord is the data of a Kinesis record.
+// ApproximateArrivalTimestamp is in seconds since the epoch.
+type KinesisRecord struct {
+	KinesisSchemaVersion        string  `json:"kinesisSchemaVersion"`
+	PartitionKey                string  `json:"partitionKey"`
+	SequenceNumber              string  `json:"sequenceNumber"`
+	Data                        []byte  `json:"data"`
+	ApproximateArrivalTimestamp float64 `json:"approximateArrivalTimestamp"`
+	EncryptionType              string  `json:"encryptionType,omitempty"`
+}
+
+// DynamoDBEvent is a batch of records from a DynamoDB stream.
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/with-ddb.html
+type DynamoDBEvent struct {
+	Records []DynamoDBEventRecord `json:"Records"`
+}
+
+// DynamoDBEventRecord is a record of a DynamoDBEvent. EventName is
+// "INSERT", "MODIFY", or "REMOVE".
+type DynamoDBEventRecord struct {
+	EventID        string                `json:"eventID"`
+	EventName      string                `json:"eventName"`
+	EventSource    string                `json:"eventSource"`
+	EventSourceArn string                `json:"eventSourceARN"`
+	EventVersion   string                `json:"eventVersion"`
+	AWSRegion      string                `json:"awsRegion"`
+	UserIdentity   *DynamoDBUserIdentity `json:"userIdentity,omitempty"`
+	Change         DynamoDBStreamRecord  `json:"dynamodb"`
+}
+
+// DynamoDBUserIdentity identifies the DynamoDB service, for items
+// deleted by TTL.
+type DynamoDBUserIdentity struct {
+	Type        string `json:"type"`
+	PrincipalID string `json:"principalId"`
+}
+
+// DynamoDBStreamRecord is the change of a DynamoDB stream record.
+// ApproximateCreationDateTime is in seconds since the epoch.
+type DynamoDBStreamRecord struct {
+	ApproximateCreationDateTime float64                   `json:"ApproximateCreationDateTime,omitempty"`
+	Keys                        map[string]AttributeValue `json:"Keys,omitempty"`
+	NewImage                    map[string]AttributeValue `json:"NewImage,omitempty"`
+	OldImage                    map[string]AttributeValue `json:"OldImage,omitempty"`
+	SequenceNumber              string                    `json:"SequenceNumber"`
+	SizeBytes                   int64                     `json:"SizeBytes"`
+	StreamViewType              string                    `json:"StreamViewType"`
+}
+
+// AttributeValue is a DynamoDB attribute-value. Exactly one of the
+// fields is set. Numbers are strings, to keep their precision.
+type AttributeValue struct {
+	S    *string                   `json:"S,omitempty"`
+	N    *string                   `json:"N,omitempty"`
+	B    []byte                    `json:"B,omitempty"`
+	SS   []string                  `json:"SS,omitempty"`
+	NS   []string                  `json:"NS,omitempty"`
+	BS   [][]byte                  `json:"BS,omitempty"`
+	M    map[string]AttributeValue `json:"M,omitempty"`
+	L    []AttributeValue          `json:"L,omitempty"`
+	NULL *bool                     `json:"NULL,omitempty"`
+	BOOL *bool                     `json:"BOOL,omitempty"`
+}
+
+// KafkaEvent is a batch of records from an Amazon MSK or self-managed
+// Kafka cluster.
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/with-msk.html
+type KafkaEvent struct {
+	EventSource      string `json:"eventSource"`
+	EventSourceArn   string `json:"eventSourceArn,omitempty"`
+	BootstrapServers string `json:"bootstrapServers"`
+
+	// Records are keyed by "topic-partition".
+	Records map[string][]KafkaRecord `json:"records"`
+}
+
+// KafkaRecord is a record of a KafkaEvent. The timestamp is in
+// milliseconds since the epoch. Each header maps its name to its value,
+// as an array of bytes.
+type KafkaRecord struct {
+	Topic         string             `json:"topic"`
+	Partition     int32              `json:"partition"`
+	Offset        int64              `json:"offset"`
+	Timestamp     int64              `json:"timestamp"`
+	TimestampType string             `json:"timestampType"`
+	Key           []byte             `json:"key,omitempty"`
+	Value         []byte             `json:"value,omitempty"`
+	Headers       []map[string][]int `json:"headers"`
+}
@@ -0,0 +1,44 @@
+package mlambda
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrEventTooLarge is the invocation error for events larger than
+// Server.MaxEventSize.
+var ErrEventTooLarge = errors.New("mlambda: event too large")
+
+// eventTooLarge returns the error for an event exceeding limit.
+func eventTooLarge(limit int64) error {
+	return fmt.Errorf("%w: the limit is %d bytes", ErrEventTooLarge, limit)
+}
+
+// eventLimitReader fails reads once more than limit bytes have been
+// read, recording that it did so.
+type eventLimitReader struct {
+	r        io.Reader
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+// Read implements io.Reader.
+func (l *eventLimitReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, eventTooLarge(l.limit)
+	}
+	// read one byte past the limit, to tell an event of exactly
+	// the limit from a larger one
+	if max := l.limit - l.n + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		l.exceeded = true
+		return 0, eventTooLarge(l.limit)
+	}
+	return n, err
+}
@@ -0,0 +1,104 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+//
+// https://docs.aws.amazon.com/lambda/latest/dg/runtimes-extensions-api.html
+//
+
+const extensionAPIVersion = "2020-01-01"
+
+// extensionClient implements the lambda-extensions API, for registering
+// an internal extension.
+type extensionClient struct {
+	client *http.Client
+	prefix string
+
+	// id is assigned when the extension registers.
+	id string
+}
+
+// newExtensionClient creates an instance of *extensionClient for the
+// extensions API at the given host:port.
+func newExtensionClient(httpClient *http.Client, endpoint string) *extensionClient {
+	return &extensionClient{
+		client: httpClient,
+		prefix: "http://" + endpoint + "/" + extensionAPIVersion + "/extension/",
+	}
+}
+
+// register registers the extension for the given events. It must be
+// called before the runtime requests its first invocation.
+func (c *extensionClient) register(ctx context.Context, name string, events []string) error {
+	var requestBody struct {
+		Events []string `json:"events"`
+	}
+	requestBody.Events = events
+	if requestBody.Events == nil {
+		requestBody.Events = []string{}
+	}
+
+	requestBytes, err := json.Marshal(&requestBody)
+	if err != nil {
+		return err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, "POST", c.prefix+"register", bytes.NewReader(requestBytes))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Lambda-Extension-Name", name)
+
+	resp, err := c.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected 'register' http-response: %v: %s", resp.StatusCode, resp.Status)
+	}
+
+	c.id = resp.Header.Get("Lambda-Extension-Identifier")
+	return nil
+}
+
+type extensionEvent struct {
+	EventType      string `json:"eventType"`
+	DeadlineMs     int64  `json:"deadlineMs"`
+	RequestID      string `json:"requestId"`
+	ShutdownReason string `json:"shutdownReason"`
+}
+
+// nextEvent blocks until the next event the extension registered for.
+func (c *extensionClient) nextEvent(ctx context.Context) (*extensionEvent, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, "GET", c.prefix+"event/next", http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Lambda-Extension-Identifier", c.id)
+
+	resp, err := c.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected 'event/next' http-response: %v: %s", resp.StatusCode, resp.Status)
+	}
+
+	var ev extensionEvent
+	if err := json.NewDecoder(resp.Body).Decode(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
@@ -0,0 +1,142 @@
+package mlambda
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFlushSize     = 16 * 1024
+	defaultFlushInterval = 20 * time.Millisecond
+)
+
+// Flusher is implemented by response-writers which buffer output. Handlers
+// producing incremental output (such as server-sent events) may call Flush
+// to send any buffered data immediately.
+type Flusher interface {
+	Flush() error
+}
+
+// Flush flushes w if it implements Flusher or http.Flusher, and is
+// a no-op otherwise.
+func Flush(w io.Writer) error {
+	switch f := w.(type) {
+	case Flusher:
+		return f.Flush()
+	case http.Flusher:
+		f.Flush()
+	}
+	return nil
+}
+
+// coalescingWriter buffers small writes, sending them on to the
+// underlying writer once size bytes are buffered or interval has
+// passed since the oldest buffered write.
+//
+// This keeps latency low for handlers which trickle out small writes
+// while letting bulk-writers produce reasonably sized chunks.
+type coalescingWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      []byte
+	size     int
+	interval time.Duration
+	timer    *time.Timer
+	err      error
+}
+
+func newCoalescingWriter(w io.Writer, size int, interval time.Duration) *coalescingWriter {
+	if size <= 0 {
+		size = defaultFlushSize
+	}
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	return &coalescingWriter{
+		w:        w,
+		buf:      make([]byte, 0, size),
+		size:     size,
+		interval: interval,
+	}
+}
+
+// Write implements io.Writer.
+func (c *coalescingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	// large writes skip the buffer entirely
+	if len(c.buf)+len(p) > c.size {
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+		if len(p) >= c.size {
+			n, err := c.w.Write(p)
+			c.err = err
+			return n, err
+		}
+	}
+
+	if len(c.buf) == 0 && len(p) > 0 {
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.interval, c.timerFlush)
+		} else {
+			c.timer.Reset(c.interval)
+		}
+	}
+	c.buf = append(c.buf, p...)
+
+	if len(c.buf) >= c.size {
+		if err := c.flushLocked(); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush implements Flusher.
+func (c *coalescingWriter) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked()
+}
+
+// Close flushes any buffered data and stops the flush-timer. It
+// does not close the underlying writer.
+func (c *coalescingWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	return c.flushLocked()
+}
+
+func (c *coalescingWriter) timerFlush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.flushLocked()
+}
+
+func (c *coalescingWriter) flushLocked() error {
+	if c.err != nil {
+		return c.err
+	}
+	if len(c.buf) == 0 {
+		return nil
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	_, c.err = c.w.Write(c.buf)
+	c.buf = c.buf[:0]
+	return c.err
+}
+
+var _ Flusher = (*coalescingWriter)(nil)
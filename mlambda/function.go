@@ -0,0 +1,40 @@
+package mlambda
+
+import (
+	"os"
+	"strconv"
+)
+
+// FunctionInfo describes the running function, as reported by the
+// lambda environment variables. Fields are empty when not running
+// in lambda.
+type FunctionInfo struct {
+	Name     string
+	Version  string
+	Region   string
+	MemoryMB int
+
+	// LogGroupName and LogStreamName are where the platform sends
+	// the function's stdout and stderr.
+	LogGroupName  string
+	LogStreamName string
+
+	// InitializationType is "on-demand", "provisioned-concurrency",
+	// or "snap-start".
+	InitializationType string
+}
+
+// FunctionInfoFromEnv reads the function's configuration from the
+// environment.
+func FunctionInfoFromEnv() FunctionInfo {
+	memory, _ := strconv.Atoi(os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE"))
+	return FunctionInfo{
+		Name:               os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+		Version:            os.Getenv("AWS_LAMBDA_FUNCTION_VERSION"),
+		Region:             os.Getenv("AWS_REGION"),
+		MemoryMB:           memory,
+		LogGroupName:       os.Getenv("AWS_LAMBDA_LOG_GROUP_NAME"),
+		LogStreamName:      os.Getenv("AWS_LAMBDA_LOG_STREAM_NAME"),
+		InitializationType: os.Getenv("AWS_LAMBDA_INITIALIZATION_TYPE"),
+	}
+}
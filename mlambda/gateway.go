@@ -0,0 +1,113 @@
+package mlambda
+
+import (
+	"context"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// GatewayContext holds the API Gateway request-context of an HTTP
+// request handled by HttpHandler.
+type GatewayContext struct {
+	AccountID  string
+	ApiID      string
+	DomainName string
+	RequestID  string
+	RouteKey   string
+	Stage      string
+	SourceIP   string
+	UserAgent  string
+
+	// StageVariables are the variables of the API stage.
+	StageVariables map[string]string
+
+	// Authorizer is the output of the route's authorizer, if any.
+	Authorizer Authorizer
+}
+
+// Authorizer holds the output of an API Gateway authorizer. At most one
+// of the fields is set, depending on the type of authorizer.
+type Authorizer struct {
+	JWT    *JWTAuthorizer `json:"jwt"`
+	IAM    *IAMAuthorizer `json:"iam"`
+	Lambda map[string]any `json:"lambda"`
+}
+
+// JWTAuthorizer holds the verified claims and scopes of a JWT authorizer.
+type JWTAuthorizer struct {
+	Claims map[string]string `json:"claims"`
+	Scopes []string          `json:"scopes"`
+}
+
+// IAMAuthorizer describes the caller of a route using IAM authorization.
+type IAMAuthorizer struct {
+	AccessKey       string `json:"accessKey"`
+	AccountID       string `json:"accountId"`
+	CallerID        string `json:"callerId"`
+	PrincipalOrgID  string `json:"principalOrgId"`
+	UserArn         string `json:"userArn"`
+	UserID          string `json:"userId"`
+	CognitoIdentity *struct {
+		AMR            []string `json:"amr"`
+		IdentityID     string   `json:"identityId"`
+		IdentityPoolID string   `json:"identityPoolId"`
+	} `json:"cognitoIdentity"`
+}
+
+// Principal returns a description of the authenticated caller, or the
+// empty string if the caller is not known.
+func (a Authorizer) Principal() string {
+	switch {
+	case a.JWT != nil:
+		for _, claim := range []string{"sub", "cognito:username", "username", "client_id"} {
+			if v := a.JWT.Claims[claim]; v != "" {
+				return v
+			}
+		}
+	case a.IAM != nil:
+		if a.IAM.CognitoIdentity != nil && a.IAM.CognitoIdentity.IdentityID != "" {
+			return a.IAM.CognitoIdentity.IdentityID
+		}
+		return a.IAM.UserArn
+	case a.Lambda != nil:
+		if v, ok := a.Lambda["principalId"].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+type gatewayContextKey struct{}
+
+// ContextWithGatewayContext returns a copy of ctx holding gc.
+func ContextWithGatewayContext(ctx context.Context, gc *GatewayContext) context.Context {
+	return context.WithValue(ctx, gatewayContextKey{}, gc)
+}
+
+// GatewayContextFromContext returns the API Gateway request-context of
+// the HTTP request being handled.
+func GatewayContextFromContext(ctx context.Context) (*GatewayContext, bool) {
+	gc, ok := ctx.Value(gatewayContextKey{}).(*GatewayContext)
+	return gc, ok
+}
+
+// newGatewayContext builds a GatewayContext from the raw event.
+func newGatewayContext(r *httpRequest) *GatewayContext {
+	rc := &r.RequestContext
+	gc := &GatewayContext{
+		AccountID:      rc.AccountID,
+		ApiID:          rc.ApiID,
+		DomainName:     rc.DomainName,
+		RequestID:      rc.RequestID,
+		RouteKey:       rc.RouteKey,
+		Stage:          rc.Stage,
+		SourceIP:       rc.Http.SourceIP,
+		UserAgent:      rc.Http.UserAgent,
+		StageVariables: r.StageVariables,
+	}
+	if len(rc.Authorizer) > 0 {
+		// an authorizer we don't understand is treated as absent
+		_ = jsonv2.Unmarshal(rc.Authorizer, &gc.Authorizer)
+	}
+	return gc
+}
@@ -0,0 +1,200 @@
+package mlambda
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	jsonv2 "github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var glueService = awsapi.Service{
+	SigningName:  "glue",
+	JSONVersion:  "1.1",
+	TargetPrefix: "AWSGlue",
+}
+
+// Glue Schema Registry framing: a header-version byte, a compression
+// byte, and the 16-byte schema-version id, followed by the payload.
+const (
+	glueHeaderVersion   = 3
+	glueCompressionNone = 0
+	glueCompressionZlib = 5
+	glueHeaderSize      = 18
+)
+
+// defaultMaxSchemaPayloadSize is the default for
+// SchemaDecoder.MaxPayloadSize: the lambda event limit.
+const defaultMaxSchemaPayloadSize = 6 << 20
+
+// Schema data-formats.
+const (
+	SchemaFormatAvro     = "AVRO"
+	SchemaFormatJSON     = "JSON"
+	SchemaFormatProtobuf = "PROTOBUF"
+)
+
+// SchemaVersion is a version of a registered schema.
+type SchemaVersion struct {
+	ID         string
+	DataFormat string
+	Definition string
+}
+
+// SchemaRegistry looks up schema versions by id.
+type SchemaRegistry interface {
+	GetSchemaVersion(ctx context.Context, id string) (*SchemaVersion, error)
+}
+
+// GlueSchemaRegistry is a SchemaRegistry backed by the AWS Glue Schema
+// Registry. The function needs glue:GetSchemaVersion.
+type GlueSchemaRegistry struct {
+	Client *awsapi.Client
+}
+
+// GetSchemaVersion implements SchemaRegistry.
+func (g *GlueSchemaRegistry) GetSchemaVersion(ctx context.Context, id string) (*SchemaVersion, error) {
+	in := map[string]any{"SchemaVersionId": id}
+	var out struct {
+		SchemaVersionId  string
+		DataFormat       string
+		SchemaDefinition string
+	}
+	if err := g.Client.DoJSON(ctx, glueService, "GetSchemaVersion", in, &out); err != nil {
+		return nil, fmt.Errorf("getting schema version %s: %s", id, err)
+	}
+	return &SchemaVersion{ID: out.SchemaVersionId, DataFormat: out.DataFormat, Definition: out.SchemaDefinition}, nil
+}
+
+var _ SchemaRegistry = (*GlueSchemaRegistry)(nil)
+
+// CachedSchemaRegistry wraps r, remembering the schema versions it
+// returns. Schema versions cannot change, so they are kept for the life
+// of the execution-environment, and warm invocations don't look them
+// up again.
+func CachedSchemaRegistry(r SchemaRegistry) SchemaRegistry {
+	return &cachedSchemaRegistry{r: r, versions: map[string]*SchemaVersion{}}
+}
+
+type cachedSchemaRegistry struct {
+	r SchemaRegistry
+
+	mu       sync.Mutex
+	versions map[string]*SchemaVersion
+}
+
+// GetSchemaVersion implements SchemaRegistry.
+func (c *cachedSchemaRegistry) GetSchemaVersion(ctx context.Context, id string) (*SchemaVersion, error) {
+	c.mu.Lock()
+	v, ok := c.versions[id]
+	c.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+	v, err := c.r.GetSchemaVersion(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.versions[id] = v
+	c.mu.Unlock()
+	return v, nil
+}
+
+// SchemaDecodeFunc parses a payload written with schema into the value
+// pointed to by into. It typically adapts an Avro or protobuf library.
+type SchemaDecodeFunc func(schema *SchemaVersion, payload []byte, into any) error
+
+// SchemaDecoder decodes Kafka record-values (or other messages)
+// serialized with the Glue Schema Registry serializers.
+type SchemaDecoder struct {
+	// Registry looks up the schema each value was written with. Wrap
+	// it with CachedSchemaRegistry to avoid repeated lookups.
+	Registry SchemaRegistry
+
+	// Decoders parse payloads, by data-format (such as
+	// SchemaFormatAvro). JSON payloads are unmarshalled as JSON unless
+	// a decoder is given for SchemaFormatJSON; other formats need a
+	// decoder.
+	Decoders map[string]SchemaDecodeFunc
+
+	// MaxPayloadSize bounds the size of decompressed payloads, so a
+	// small compressed value can't exhaust the function's memory. The
+	// default is 6MiB.
+	MaxPayloadSize int64
+}
+
+// Decode parses value into the value pointed to by into. Values without
+// Glue Schema Registry framing are unmarshalled as JSON.
+func (d *SchemaDecoder) Decode(ctx context.Context, value []byte, into any) error {
+	limit := d.MaxPayloadSize
+	if limit <= 0 {
+		limit = defaultMaxSchemaPayloadSize
+	}
+	id, payload, ok, err := parseGlueSchemaFrame(value, limit)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return jsonv2.Unmarshal(value, into)
+	}
+
+	schema, err := d.Registry.GetSchemaVersion(ctx, id)
+	if err != nil {
+		return err
+	}
+	if decode, ok := d.Decoders[schema.DataFormat]; ok {
+		return decode(schema, payload, into)
+	}
+	if schema.DataFormat == SchemaFormatJSON {
+		return jsonv2.Unmarshal(payload, into)
+	}
+	return fmt.Errorf("no decoder for schema data-format %q", schema.DataFormat)
+}
+
+// ParseGlueSchemaFrame splits a value serialized with the Glue Schema
+// Registry serializers into its schema-version id and (decompressed)
+// payload. It returns false if value does not have the framing, and an
+// error if the payload decompresses to more than 6MiB.
+func ParseGlueSchemaFrame(value []byte) (id string, payload []byte, ok bool, err error) {
+	return parseGlueSchemaFrame(value, defaultMaxSchemaPayloadSize)
+}
+
+// parseGlueSchemaFrame is ParseGlueSchemaFrame, with payloads
+// decompressing to more than limit bytes rejected.
+func parseGlueSchemaFrame(value []byte, limit int64) (id string, payload []byte, ok bool, err error) {
+	if len(value) < glueHeaderSize || value[0] != glueHeaderVersion {
+		return "", nil, false, nil
+	}
+	compression := value[1]
+	if compression != glueCompressionNone && compression != glueCompressionZlib {
+		return "", nil, false, nil
+	}
+
+	u := hex.EncodeToString(value[2:glueHeaderSize])
+	id = u[0:8] + "-" + u[8:12] + "-" + u[12:16] + "-" + u[16:20] + "-" + u[20:]
+	payload = value[glueHeaderSize:]
+
+	if compression == glueCompressionZlib {
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return "", nil, false, fmt.Errorf("decompressing payload: %s", err)
+		}
+		defer zr.Close()
+		// read one byte past the limit, to tell a payload of exactly
+		// the limit from a larger one
+		if payload, err = io.ReadAll(io.LimitReader(zr, limit+1)); err != nil {
+			return "", nil, false, fmt.Errorf("decompressing payload: %s", err)
+		}
+		if int64(len(payload)) > limit {
+			return "", nil, false, fmt.Errorf("decompressed payload exceeds %d bytes", limit)
+		}
+	}
+	return id, payload, true, nil
+}
@@ -0,0 +1,324 @@
+package mlambda
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// GraphQLRequest is a GraphQL operation to execute.
+type GraphQLRequest struct {
+	Query         string                    `json:"query"`
+	OperationName string                    `json:"operationName,omitempty"`
+	Variables     map[string]any            `json:"variables,omitempty"`
+	Extensions    map[string]jsontext.Value `json:"extensions,omitempty"`
+}
+
+// GraphQLExecutor executes an operation, returning the response: an
+// object with "data" and/or "errors" members. It is typically a thin
+// wrapper around a GraphQL library, such as graphql-go's graphql.Do or
+// gqlgen's executor.
+type GraphQLExecutor func(ctx context.Context, req *GraphQLRequest) any
+
+// PersistedQueryCache holds the queries registered with automatic
+// persisted queries, keyed by their SHA-256 hash (in hex).
+type PersistedQueryCache interface {
+	Get(ctx context.Context, hash string) (string, bool)
+	Add(ctx context.Context, hash string, query string)
+}
+
+// GraphQLOptions configures GraphQLHandler.
+type GraphQLOptions struct {
+	// PersistedQueries, if set, enables automatic persisted queries
+	// (as implemented by Apollo clients). Use NewPersistedQueryCache
+	// for an in-memory cache, which is per execution-environment.
+	PersistedQueries PersistedQueryCache
+}
+
+// GraphQLHandler returns an http.Handler serving GraphQL over HTTP.
+//
+// POST requests carry the operation as JSON (or, with the content-type
+// application/graphql, just the query). GET requests carry it in the
+// "query", "operationName", "variables", and "extensions" parameters,
+// and may only execute queries, so that mutations are not triggered by
+// links or cached by CDNs. Malformed requests receive a 400; otherwise
+// the executor's response is sent with a 200.
+//
+// For example, with graphql-go and the schema
+//
+//	type Query { thing(id: ID!): Thing }
+//	type Thing { id: ID! name: String! }
+//
+// the executor is
+//
+//	func(ctx context.Context, req *mlambda.GraphQLRequest) any {
+//		return graphql.Do(graphql.Params{
+//			Schema:         schema,
+//			RequestString:  req.Query,
+//			OperationName:  req.OperationName,
+//			VariableValues: req.Variables,
+//			Context:        ctx,
+//		})
+//	}
+//
+// and the handler is mounted as
+//
+//	mux.Handle("/graphql", mlambda.GraphQLHandler(exec, mlambda.GraphQLOptions{
+//		PersistedQueries: mlambda.NewPersistedQueryCache(1000),
+//	}))
+func GraphQLHandler(exec GraphQLExecutor, opts GraphQLOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GraphQLRequest
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if err := graphQLFromQuery(r, &req); err != nil {
+				writeGraphQLError(w, http.StatusBadRequest, err.Error(), "")
+				return
+			}
+		case http.MethodPost:
+			if err := graphQLFromBody(r, &req); err != nil {
+				writeGraphQLError(w, http.StatusBadRequest, err.Error(), "")
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			writeGraphQLError(w, http.StatusMethodNotAllowed, "GraphQL requests must be GET or POST", "")
+			return
+		}
+
+		if opts.PersistedQueries != nil {
+			if ok := resolvePersistedQuery(w, r, &req, opts.PersistedQueries); !ok {
+				return
+			}
+		}
+		if req.Query == "" {
+			writeGraphQLError(w, http.StatusBadRequest, "no query", "")
+			return
+		}
+		if r.Method != http.MethodPost {
+			if op := graphQLOperationType(req.Query, req.OperationName); op != "query" {
+				w.Header().Set("Allow", "POST")
+				writeGraphQLError(w, http.StatusMethodNotAllowed, op+" operations must be POSTed", "")
+				return
+			}
+		}
+
+		resp := exec(r.Context(), &req)
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsonv2.MarshalWrite(w, resp)
+	})
+}
+
+func graphQLFromQuery(r *http.Request, req *GraphQLRequest) error {
+	q := r.URL.Query()
+	req.Query = q.Get("query")
+	req.OperationName = q.Get("operationName")
+	if v := q.Get("variables"); v != "" {
+		if err := jsonv2.Unmarshal([]byte(v), &req.Variables); err != nil {
+			return fmt.Errorf("invalid variables: %s", err)
+		}
+	}
+	if v := q.Get("extensions"); v != "" {
+		if err := jsonv2.Unmarshal([]byte(v), &req.Extensions); err != nil {
+			return fmt.Errorf("invalid extensions: %s", err)
+		}
+	}
+	return nil
+}
+
+func graphQLFromBody(r *http.Request, req *GraphQLRequest) error {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == "application/graphql" {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		req.Query = string(b)
+		return nil
+	}
+	if err := jsonv2.UnmarshalRead(r.Body, req); err != nil {
+		return fmt.Errorf("invalid request: %s", err)
+	}
+	return nil
+}
+
+// resolvePersistedQuery implements automatic persisted queries: a
+// request with a hash but no query uses the registered query, and a
+// request with both registers the query. It returns false if it has
+// responded.
+func resolvePersistedQuery(w http.ResponseWriter, r *http.Request, req *GraphQLRequest, cache PersistedQueryCache) bool {
+	raw, ok := req.Extensions["persistedQuery"]
+	if !ok {
+		return true
+	}
+	var pq struct {
+		Version    int    `json:"version"`
+		SHA256Hash string `json:"sha256Hash"`
+	}
+	if err := jsonv2.Unmarshal(raw, &pq); err != nil || pq.Version != 1 || pq.SHA256Hash == "" {
+		writeGraphQLError(w, http.StatusBadRequest, "invalid persistedQuery extension", "")
+		return false
+	}
+	hash := strings.ToLower(pq.SHA256Hash)
+
+	if req.Query == "" {
+		query, ok := cache.Get(r.Context(), hash)
+		if !ok {
+			// clients retry with the full query
+			writeGraphQLError(w, http.StatusOK, "PersistedQueryNotFound", "PERSISTED_QUERY_NOT_FOUND")
+			return false
+		}
+		req.Query = query
+		return true
+	}
+
+	sum := sha256.Sum256([]byte(req.Query))
+	if hex.EncodeToString(sum[:]) != hash {
+		writeGraphQLError(w, http.StatusBadRequest, "provided sha256Hash does not match query", "")
+		return false
+	}
+	cache.Add(r.Context(), hash, req.Query)
+	return true
+}
+
+// writeGraphQLError writes a GraphQL response holding a single error.
+func writeGraphQLError(w http.ResponseWriter, status int, message string, code string) {
+	e := map[string]any{"message": message}
+	if code != "" {
+		e["extensions"] = map[string]any{"code": code}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = jsonv2.MarshalWrite(w, map[string]any{"errors": []any{e}})
+}
+
+// graphQLOperationType returns the type ("query", "mutation", or
+// "subscription") of the named operation in a document, or of its first
+// operation if name is empty. It only scans the top-level definitions,
+// leaving validation to the executor; unknown operations are reported
+// as queries.
+func graphQLOperationType(doc string, name string) string {
+	toks := graphQLTokens(doc)
+	depth := 0
+	inFragment := false
+	for i := 0; i < len(toks); i++ {
+		switch tok := toks[i]; tok {
+		case "{", "(":
+			if depth == 0 && tok == "{" && !inFragment && name == "" {
+				// a shorthand query: "{ ... }"
+				return "query"
+			}
+			depth++
+		case "}", ")":
+			depth--
+			if depth == 0 {
+				inFragment = false
+			}
+		case "fragment":
+			if depth == 0 {
+				inFragment = true
+			}
+		case "query", "mutation", "subscription":
+			if depth != 0 {
+				continue
+			}
+			var opName string
+			if i+1 < len(toks) && !strings.ContainsAny(toks[i+1], "{}()") {
+				opName = toks[i+1]
+				i++
+			}
+			if name == "" || opName == name {
+				return tok
+			}
+		}
+	}
+	return "query"
+}
+
+// graphQLTokens returns the punctuation and names of a document,
+// skipping strings, comments, and other tokens.
+func graphQLTokens(doc string) []string {
+	var toks []string
+	for i := 0; i < len(doc); {
+		c := doc[i]
+		switch {
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			if strings.HasPrefix(doc[i:], `"""`) {
+				end := strings.Index(doc[i+3:], `"""`)
+				if end < 0 {
+					return toks
+				}
+				i += end + 6
+				continue
+			}
+			i++
+			for i < len(doc) && doc[i] != '"' {
+				if doc[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case c == '{' || c == '}' || c == '(' || c == ')':
+			toks = append(toks, doc[i:i+1])
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(doc) && (doc[i] == '_' || doc[i] >= 'a' && doc[i] <= 'z' || doc[i] >= 'A' && doc[i] <= 'Z' || doc[i] >= '0' && doc[i] <= '9') {
+				i++
+			}
+			toks = append(toks, doc[start:i])
+		default:
+			i++
+		}
+	}
+	return toks
+}
+
+// NewPersistedQueryCache returns an in-memory PersistedQueryCache
+// holding up to size queries. Once full, registering another query
+// evicts an arbitrary one.
+func NewPersistedQueryCache(size int) PersistedQueryCache {
+	return &persistedQueryCache{size: size, queries: map[string]string{}}
+}
+
+type persistedQueryCache struct {
+	size int
+
+	mu      sync.Mutex
+	queries map[string]string
+}
+
+// Get implements PersistedQueryCache.
+func (c *persistedQueryCache) Get(ctx context.Context, hash string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q, ok := c.queries[hash]
+	return q, ok
+}
+
+// Add implements PersistedQueryCache.
+func (c *persistedQueryCache) Add(ctx context.Context, hash string, query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.queries[hash]; !ok && len(c.queries) >= c.size {
+		for k := range c.queries {
+			delete(c.queries, k)
+			break
+		}
+	}
+	c.queries[hash] = query
+}
@@ -0,0 +1,53 @@
+package mlambda
+
+import (
+	"cmp"
+	"net/http"
+	"strconv"
+)
+
+// HealthCheckOptions configures the answer to Application Load
+// Balancer health-checks.
+type HealthCheckOptions struct {
+	// Path is the health-check path configured on the target-group,
+	// such as "/healthz".
+	Path string
+
+	// Status is the response status-code. The default is 200.
+	Status int
+
+	// Body is the response body. The default is empty.
+	Body string
+
+	// ContentType is the content-type of Body. The default is
+	// "text/plain; charset=utf-8".
+	ContentType string
+}
+
+// isHealthCheck reports if r is a load balancer health-check.
+func (o *HealthCheckOptions) isHealthCheck(f payloadFormat, r *http.Request) bool {
+	if f != payloadALB && f != payloadALBMultiValue {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return r.URL.Path == o.Path
+}
+
+// healthCheckHandler answers health-checks without calling the
+// application's handler.
+func healthCheckHandler(o *HealthCheckOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Cache-Control", "no-store")
+		if o.Body != "" {
+			h.Set("Content-Type", cmp.Or(o.ContentType, "text/plain; charset=utf-8"))
+			h.Set("Content-Length", strconv.Itoa(len(o.Body)))
+		}
+		w.WriteHeader(cmp.Or(o.Status, http.StatusOK))
+		if r.Method != http.MethodHead {
+			_, _ = w.Write([]byte(o.Body))
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const defaultHeartbeatInterval = time.Minute
+
+// startHeartbeat periodically logs the progress of a streaming response,
+// so operators can follow long-running exports. The returned function
+// stops the heartbeat.
+func (s *Server) startHeartbeat(ctx context.Context, requestId string, start time.Time, deadline time.Time, bytesSent func() int64) (stop func()) {
+	interval := s.HeartbeatInterval
+	if interval == 0 {
+		interval = defaultHeartbeatInterval
+	}
+	if interval < 0 || !s.StreamResponses {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+
+			attrs := []slog.Attr{
+				slog.String("requestId", requestId),
+				slog.Int64("bytesSent", bytesSent()),
+				slog.Duration("elapsed", time.Since(start)),
+			}
+			if !deadline.IsZero() {
+				attrs = append(attrs, slog.Duration("remaining", time.Until(deadline)))
+			}
+			s.logger().LogAttrs(ctx, slog.LevelInfo, "streaming response in progress", attrs...)
+		}
+	}()
+	return func() { close(done) }
+}
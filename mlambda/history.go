@@ -0,0 +1,145 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultHistorySize = 32
+
+// invocationSummary describes a completed invocation. Recent summaries
+// are kept for postmortems: they are logged when a handler panics or
+// the execution environment shuts down, and included in diagnostics.
+type invocationSummary struct {
+	RequestID  string    `json:"requestId"`
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"durationMs"`
+	Route      string    `json:"route,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	ErrorType  string    `json:"errorType,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// LogValue implements slog.LogValuer.
+func (i invocationSummary) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("requestId", i.RequestID),
+		slog.Time("time", i.Time),
+		slog.Float64("durationMs", i.DurationMs),
+	}
+	if i.Route != "" {
+		attrs = append(attrs, slog.String("route", i.Route))
+	}
+	if i.Status != 0 {
+		attrs = append(attrs, slog.Int("status", i.Status))
+	}
+	if i.ErrorType != "" {
+		attrs = append(attrs, slog.String("errorType", i.ErrorType), slog.String("error", i.Error))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// invocationHistory is a ring-buffer of recent invocation summaries.
+type invocationHistory struct {
+	mu      sync.Mutex
+	entries []invocationSummary
+	next    int
+}
+
+// add records a summary, keeping at most size entries.
+func (h *invocationHistory) add(size int, e invocationSummary) {
+	if size <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) < size {
+		h.entries = append(h.entries, e)
+		return
+	}
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// list returns the summaries, oldest first.
+func (h *invocationHistory) list() []invocationSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]invocationSummary, 0, len(h.entries))
+	out = append(out, h.entries[h.next:]...)
+	out = append(out, h.entries[:h.next]...)
+	return out
+}
+
+func (s *Server) historySize() int {
+	if s.InvocationHistory == 0 {
+		return defaultHistorySize
+	}
+	return s.InvocationHistory
+}
+
+// recordHistory adds a completed invocation to the history.
+func (s *Server) recordHistory(r *invocationReport) {
+	route, status := r.http.get()
+	s.history.add(s.historySize(), invocationSummary{
+		RequestID:  r.requestId,
+		Time:       r.start,
+		DurationMs: float64(r.duration) / float64(time.Millisecond),
+		Route:      route,
+		Status:     status,
+		ErrorType:  r.errorType,
+		Error:      r.errorMessage,
+	})
+}
+
+// logHistory logs the recent invocations, giving context when something
+// goes badly wrong.
+func (s *Server) logHistory(ctx context.Context, reason string) {
+	entries := s.history.list()
+	if len(entries) == 0 {
+		return
+	}
+	invocations := make([]any, len(entries))
+	for i, e := range entries {
+		invocations[i] = e
+	}
+	s.logger().LogAttrs(ctx, slog.LevelInfo, "recent invocations",
+		slog.String("reason", reason),
+		slog.Any("invocations", invocations),
+	)
+}
+
+// httpOutcome holds the result of an HTTP request, reported by
+// HttpHandler through the invocation context. It is written by the
+// handler's goroutine, which may still be running when the invocation
+// is recorded, so is guarded by mu.
+type httpOutcome struct {
+	mu     sync.Mutex
+	route  string
+	status int
+}
+
+// get returns the recorded route and status.
+func (o *httpOutcome) get() (route string, status int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.route, o.status
+}
+
+type httpOutcomeKey struct{}
+
+func contextWithHttpOutcome(ctx context.Context, o *httpOutcome) context.Context {
+	return context.WithValue(ctx, httpOutcomeKey{}, o)
+}
+
+// observeHttpOutcome records the route and status of an HTTP request.
+func observeHttpOutcome(ctx context.Context, route string, status int) {
+	if o, ok := ctx.Value(httpOutcomeKey{}).(*httpOutcome); ok {
+		o.mu.Lock()
+		o.route = route
+		o.status = status
+		o.mu.Unlock()
+	}
+}
@@ -0,0 +1,214 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultReplayCacheSize is the default for HMACOptions.ReplayCacheSize.
+const defaultReplayCacheSize = 10000
+
+// HMACOptions configures VerifyHMAC.
+type HMACOptions struct {
+	// Header is the request-header holding the signature, such as
+	// "X-Hub-Signature-256". Prefix is removed from its value before
+	// decoding, such as "sha256=". Signatures may be hex or base64
+	// encoded.
+	Header string
+	Prefix string
+
+	// Hash is the hash-function of the HMAC. If nil, SHA-256 is used.
+	Hash func() hash.Hash
+
+	// Keys returns the shared secrets requests may be signed with.
+	// More than one key may be returned while keys are rotated. It is
+	// called for each request, so it should cache keys fetched from
+	// elsewhere.
+	Keys func(ctx context.Context) ([][]byte, error)
+
+	// TimestampHeader, if set, is a request-header holding the time
+	// the request was signed, in seconds since the epoch. Requests
+	// signed more than Tolerance (default five minutes) from now are
+	// rejected, and the timestamp is included in the signed payload.
+	TimestampHeader string
+	Tolerance       time.Duration
+
+	// Payload returns the signed payload of a request. If nil, it is
+	// the body, preceded by the timestamp and a "." if TimestampHeader
+	// is set.
+	Payload func(timestamp string, body []byte) []byte
+
+	// ReplayCacheSize bounds the number of recent signatures
+	// remembered, to reject replayed requests within the tolerance.
+	// If it is zero, 10000 is used; a negative value disables replay
+	// detection. Like RateLimit, the cache is per execution-environment.
+	// Replays are only detected when TimestampHeader is set: without
+	// a timestamp a sender may legitimately repeat a signature, such
+	// as when redelivering a webhook.
+	ReplayCacheSize int
+
+	// Problem is the response to requests which fail verification. If
+	// nil, a generic 401 problem is used.
+	Problem *Problem
+}
+
+// VerifyHMAC returns middleware which rejects requests without a valid
+// HMAC signature of their body, as sent by webhooks (GitHub-style, or
+// Slack-style with a timestamp), before h is called. This
+// authenticates requests to function URLs with the NONE auth-type.
+//
+// The request body is read in full to verify it, and replaced for h.
+func VerifyHMAC(h http.Handler, opts HMACOptions) http.Handler {
+	if opts.Header == "" {
+		panic("mlambda: HMACOptions requires Header")
+	}
+	if opts.Keys == nil {
+		panic("mlambda: HMACOptions requires Keys")
+	}
+	if opts.Hash == nil {
+		opts.Hash = sha256.New
+	}
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = 5 * time.Minute
+	}
+	if opts.Payload == nil {
+		opts.Payload = defaultHMACPayload
+	}
+	if opts.ReplayCacheSize == 0 {
+		opts.ReplayCacheSize = defaultReplayCacheSize
+	}
+	if opts.Problem == nil {
+		opts.Problem = NewProblem(http.StatusUnauthorized, "")
+	}
+	v := &hmacVerifier{opts: opts, seen: map[string]time.Time{}}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, "reading request body"))
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if reason := v.verify(r, body, time.Now()); reason != "" {
+			slog.WarnContext(r.Context(), "rejected request signature", "reason", reason)
+			WriteProblem(w, r, opts.Problem)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func defaultHMACPayload(timestamp string, body []byte) []byte {
+	if timestamp == "" {
+		return body
+	}
+	return append([]byte(timestamp+"."), body...)
+}
+
+type hmacVerifier struct {
+	opts HMACOptions
+
+	mu sync.Mutex
+	// seen maps recent signatures to when they may be forgotten.
+	seen map[string]time.Time
+}
+
+// verify checks the signature of a request, returning why it is not
+// valid, or the empty string if it is.
+func (v *hmacVerifier) verify(r *http.Request, body []byte, now time.Time) string {
+	encoded, ok := strings.CutPrefix(r.Header.Get(v.opts.Header), v.opts.Prefix)
+	if !ok || encoded == "" {
+		return "missing signature"
+	}
+	sig, ok := decodeSignature(encoded)
+	if !ok {
+		return "malformed signature"
+	}
+
+	var timestamp string
+	if v.opts.TimestampHeader != "" {
+		timestamp = r.Header.Get(v.opts.TimestampHeader)
+		secs, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return "malformed timestamp"
+		}
+		if d := now.Sub(time.Unix(secs, 0)); d > v.opts.Tolerance || d < -v.opts.Tolerance {
+			return "timestamp outside tolerance"
+		}
+	}
+
+	keys, err := v.opts.Keys(r.Context())
+	if err != nil {
+		return "getting keys: " + err.Error()
+	}
+	payload := v.opts.Payload(timestamp, body)
+	var valid bool
+	for _, key := range keys {
+		mac := hmac.New(v.opts.Hash, key)
+		mac.Write(payload)
+		if hmac.Equal(mac.Sum(nil), sig) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "signature mismatch"
+	}
+
+	if timestamp != "" && v.opts.ReplayCacheSize > 0 && !v.remember(string(sig), now) {
+		return "replayed signature"
+	}
+	return ""
+}
+
+// remember records a signature, reporting false if it was already
+// seen within the tolerance.
+func (v *hmacVerifier) remember(sig string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if exp, ok := v.seen[sig]; ok && now.Before(exp) {
+		return false
+	}
+	if len(v.seen) >= v.opts.ReplayCacheSize {
+		for s, exp := range v.seen {
+			if !now.Before(exp) {
+				delete(v.seen, s)
+			}
+		}
+		// still full: forget an arbitrary signature
+		for s := range v.seen {
+			if len(v.seen) < v.opts.ReplayCacheSize {
+				break
+			}
+			delete(v.seen, s)
+		}
+	}
+	// a signature is replayable for as long as its timestamp is
+	// within the tolerance, either side of now
+	v.seen[sig] = now.Add(2 * v.opts.Tolerance)
+	return true
+}
+
+// decodeSignature decodes a hex or base64 signature.
+func decodeSignature(s string) ([]byte, bool) {
+	if b, err := hex.DecodeString(s); err == nil {
+		return b, true
+	}
+	enc, s := base64Body(s, false)
+	b, err := enc.DecodeString(s)
+	return b, err == nil
+}
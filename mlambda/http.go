@@ -0,0 +1,595 @@
+package mlambda
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// HttpOptions configures the adapter returned by HttpHandlerWithOptions.
+type HttpOptions struct {
+	// SpillThreshold is the request-body size above which the body
+	// is written to a temporary file, as the event is read, rather
+	// than held in memory. Zero means bodies are never spilled.
+	SpillThreshold int64
+
+	// SpillDir is the directory for spilled request-bodies. If empty,
+	// the default temp-directory (/tmp in lambda) is used.
+	SpillDir string
+
+	// ClaimCheck, if set, offloads response-bodies too large to
+	// return through lambda to a store such as S3. The client receives
+	// a 303 redirect to the stored body instead.
+	ClaimCheck *ClaimCheckOptions
+
+	// RequestIDHeader, if set, is a response-header (such as
+	// "X-Request-Id") set on every response to the lambda request-id,
+	// so callers can correlate failures with CloudWatch logs. Handlers
+	// may override it.
+	RequestIDHeader string
+
+	// UseGatewayRequestID uses the API Gateway request-id rather than
+	// the lambda request-id for RequestIDHeader.
+	UseGatewayRequestID bool
+
+	// StrictBase64 rejects base64 request-bodies other than padded
+	// standard base64, as API Gateway sends. By default unpadded and
+	// URL-safe base64 are also accepted.
+	StrictBase64 bool
+
+	// HealthCheck, if set, answers Application Load Balancer
+	// health-checks in the adapter, without calling the handler (or
+	// any middleware), so probes stay cheap.
+	HealthCheck *HealthCheckOptions
+}
+
+// HttpHandler adapts an http.Handler to handle HTTP events from any
+// of lambda's HTTP triggers: API Gateway HTTP APIs (payload versions
+// 1.0 and 2.0), REST APIs, function URLs, and Application Load
+// Balancers. The format is detected from each event, and the response
+// is returned in the matching format, so one function can be deployed
+// behind any of them.
+//
+// ALB target-groups without multi-value headers enabled can return only
+// one value per header, so multiple values are joined with commas; this
+// does not work for Set-Cookie.
+//
+// Lambda cannot return HTTP trailers, so any the handler declares (with
+// the "Trailer" header or http.TrailerPrefix) are discarded. RPC
+// protocols which carry trailers in the body or headers, such as
+// ConnectRPC and gRPC-Web, work with binary bodies and (when streaming
+// responses) server-streaming calls; gRPC itself, which requires HTTP/2
+// trailers, does not. Requests are delivered whole, so client-streaming
+// calls receive every message at once, and bidirectional streaming is
+// not possible.
+//
+// https://docs.aws.amazon.com/apigateway/latest/developerguide/http-api-develop-integrations-lambda.html
+func HttpHandler(h http.Handler) Handler {
+	return HttpHandlerWithOptions(h, HttpOptions{})
+}
+
+// HttpHandlerWithOptions is like HttpHandler, with additional
+// configuration.
+func HttpHandlerWithOptions(h http.Handler, opts HttpOptions) Handler {
+	if opts.ClaimCheck != nil {
+		h = claimCheckHttp(h, *opts.ClaimCheck)
+	}
+
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+
+		decodeStart := time.Now()
+
+		var proxyRequest httpRequest
+		var body io.ReadCloser
+		var contentLength, bodySize int64
+		var err error
+		if opts.SpillThreshold > 0 {
+			// large bodies are spilled as the event is read
+			body, contentLength, bodySize, err = decodeHttpEventSpilling(r.Body, &proxyRequest, opts.StrictBase64, opts.SpillThreshold, opts.SpillDir)
+			if err != nil {
+				return &DecodeError{Err: err}
+			}
+		} else {
+			err = jsonv2.UnmarshalRead(r.Body, &proxyRequest)
+			if err != nil {
+				return &DecodeError{Err: err}
+			}
+			bodySize = int64(len(proxyRequest.Body))
+			body, contentLength, err = requestBody(proxyRequest.Body, proxyRequest.IsBase64Encoded, opts.StrictBase64, 0, "")
+			if err != nil {
+				return &DecodeError{Err: err}
+			}
+		}
+		defer body.Close()
+
+		RecordMetric(ctx, "RequestBodySize", float64(bodySize), UnitBytes,
+			Dimension{Name: "Encoding", Value: bodyEncoding(proxyRequest.IsBase64Encoded)})
+		proxyRequest.Body = ""
+		format := proxyRequest.format()
+		proxyRequest.normalize(format)
+		observeDecode(ctx, time.Since(decodeStart))
+
+		var httpReq http.Request
+		httpReq.Header = http.Header{}
+
+		httpReq.Body = body
+		httpReq.ContentLength = contentLength
+
+		// RawPath + RawQueryString
+		urlStr := proxyRequest.RawPath
+		if proxyRequest.RawQueryString != "" {
+			urlStr = urlStr + "?" + proxyRequest.RawQueryString
+		}
+		if urlStr != "" {
+			parsedUrl, err := url.ParseRequestURI(urlStr)
+			if err != nil {
+				return fmt.Errorf("parsing rawpath and rawquery: %s", err)
+			}
+			httpReq.URL = parsedUrl
+			httpReq.RequestURI = urlStr
+		} else {
+			// ?
+			httpReq.URL = &url.URL{}
+		}
+
+		// Cookies
+		// these may get over-ridden by the headers?
+		cookieStr := strings.Join(proxyRequest.Cookies, "; ")
+		if cookieStr != "" {
+			httpReq.Header.Set("Cookie", cookieStr)
+		}
+
+		// User Agent
+		// may get over-ridden in main header-loop
+		httpReq.Header.Set("User-Agent", proxyRequest.RequestContext.Http.UserAgent)
+
+		// Headers
+		// lambda concatenates headers for some reason - we
+		// do not try to un-concat them
+		for k, v := range proxyRequest.Headers {
+			httpReq.Header.Set(k, v)
+		}
+		for k, vs := range proxyRequest.MultiValueHeaders {
+			httpReq.Header.Del(k)
+			for _, v := range vs {
+				httpReq.Header.Add(k, v)
+			}
+		}
+
+		// Query String Parameters
+		// nothing to do - Go parses them from the RawQueryString
+
+		// Domain name -> Host
+		httpReq.Host = proxyRequest.RequestContext.DomainName
+
+		// Method
+		httpReq.Method = proxyRequest.RequestContext.Http.Method
+
+		// Path
+		// nothing to do
+
+		// Protocol
+		// RPC handlers (such as connect-go's) check the version
+		httpReq.Proto = proxyRequest.RequestContext.Http.Protocol
+		if major, minor, ok := http.ParseHTTPVersion(httpReq.Proto); ok {
+			httpReq.ProtoMajor, httpReq.ProtoMinor = major, minor
+		}
+
+		// Source IP
+		// there's no port, but this is where handlers will look
+		httpReq.RemoteAddr = proxyRequest.RequestContext.Http.SourceIP
+
+		// Path parameters
+		// nothing to do
+
+		// Trace context
+		if t, ok := traceContextFromHeaders(httpReq.Header); ok {
+			ctx = ContextWithTraceContext(ctx, t)
+		}
+
+		// Request context
+		ctx = ContextWithGatewayContext(ctx, newGatewayContext(&proxyRequest))
+
+		stream := streamingFromContext(ctx)
+		rw := responseWriter{w: w, header: http.Header{}, streaming: stream != nil, stream: stream, format: format}
+
+		if opts.RequestIDHeader != "" {
+			requestId := RequestIDFromContext(ctx)
+			if opts.UseGatewayRequestID && proxyRequest.RequestContext.RequestID != "" {
+				requestId = proxyRequest.RequestContext.RequestID
+			}
+			if requestId != "" {
+				rw.header.Set(opts.RequestIDHeader, requestId)
+			}
+		}
+		handler := h
+		if diag, ok := diagnosticsFromContext(ctx); ok && httpReq.URL.Path == diagPath {
+			handler = diagHandler(diag)
+		}
+		if opts.HealthCheck != nil && opts.HealthCheck.isHealthCheck(format, &httpReq) {
+			handler = healthCheckHandler(opts.HealthCheck)
+		}
+		req, label := withRouteLabel(httpReq.WithContext(ctx))
+		handler.ServeHTTP(&rw, req)
+
+		serializeStart := time.Now()
+		err = rw.finish()
+		observeHttpOutcome(ctx, routeOf(h, req, label), rw.status)
+		observeSerialize(ctx, time.Since(serializeStart)+rw.headerDuration)
+
+		RecordMetric(ctx, "ResponseBodySize", float64(rw.bodyBytes), UnitBytes,
+			Dimension{Name: "Encoding", Value: bodyEncoding(false)})
+		if !rw.streaming {
+			RecordMetric(ctx, "ResponseBodySize", float64(base64.StdEncoding.EncodedLen(int(rw.bodyBytes))), UnitBytes,
+				Dimension{Name: "Encoding", Value: bodyEncoding(true)})
+		}
+		return err
+	})
+}
+
+type httpRequest struct {
+	Version               string             `json:"version"`
+	RoutKey               string             `json:"routeKey"`
+	RawPath               string             `json:"rawPath"`
+	RawQueryString        string             `json:"rawQueryString"`
+	Cookies               []string           `json:"cookies"`
+	Headers               map[string]string  `json:"headers"`
+	QueryStringParameters map[string]string  `json:"queryStringParameters"`
+	RequestContext        httpRequestContext `json:"requestContext"`
+	Body                  string             `json:"body"`
+	PathParameters        map[string]string  `json:"pathParameters"`
+	IsBase64Encoded       bool               `json:"isBase64Encoded"`
+	StageVariables        map[string]string  `json:"stageVariables"`
+
+	// payload version 1.0 and ALB fields
+	Resource                        string              `json:"resource"`
+	Path                            string              `json:"path"`
+	HttpMethod                      string              `json:"httpMethod"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+}
+
+type httpRequestContext struct {
+	AccountID      string          `json:"accountId"`
+	ApiID          string          `json:"apiId"`
+	Authentication json.RawMessage `json:"authentication"`
+	Authorizer     json.RawMessage `json:"authorizer"`
+	DomainName     string          `json:"domainName"`
+	DomainPrefix   string          `json:"domainPrefix"`
+	Http           struct {
+		Method    string `json:"method"`
+		Path      string `json:"path"`
+		Protocol  string `json:"protocol"`
+		SourceIP  string `json:"sourceIp"`
+		UserAgent string `json:"userAgent"`
+	} `json:"http"`
+	RequestID string `json:"requestId"`
+	RouteKey  string `json:"routeKey"`
+	Stage     string `json:"stage"`
+	Time      string `json:"time"`
+	TimeEpoch int64  `json:"timeEpoch"`
+
+	// payload version 1.0 fields
+	Protocol string `json:"protocol"`
+	Identity struct {
+		SourceIP  string `json:"sourceIp"`
+		UserAgent string `json:"userAgent"`
+
+		// set for routes using IAM authorization
+		AccessKey             string `json:"accessKey"`
+		AccountID             string `json:"accountId"`
+		Caller                string `json:"caller"`
+		PrincipalOrgID        string `json:"principalOrgId"`
+		User                  string `json:"user"`
+		UserArn               string `json:"userArn"`
+		CognitoIdentityID     string `json:"cognitoIdentityId"`
+		CognitoIdentityPoolID string `json:"cognitoIdentityPoolId"`
+	} `json:"identity"`
+
+	// ALB fields
+	ELB *struct {
+		TargetGroupArn string `json:"targetGroupArn"`
+	} `json:"elb"`
+}
+
+// bodyEncoding names the encoding of a body, for size-metrics.
+func bodyEncoding(isBase64 bool) string {
+	if isBase64 {
+		return "base64"
+	}
+	return "raw"
+}
+
+type responseWriter struct {
+	mu          sync.Mutex
+	w           io.Writer
+	body        io.Writer
+	enc         *base64Writer
+	streaming   bool
+	stream      *streamingResponse
+	format      payloadFormat
+	sentHeaders bool
+	status      int
+	header      http.Header
+
+	// bodyBytes is the size of the body, before any encoding.
+	bodyBytes int64
+
+	// headerDuration is the time spent serializing the response
+	// status and headers.
+	headerDuration time.Duration
+
+	// err is the first error writing to w. Once set, the response
+	// is corrupt and all further writes fail.
+	err error
+}
+
+// Header implements http.ResponseWriter.
+func (r *responseWriter) Header() http.Header {
+	return r.header
+}
+
+// Write implements http.ResponseWriter.
+func (r *responseWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sendHeaders(200)
+	if r.err != nil {
+		return 0, r.err
+	}
+	len, err := r.body.Write(p)
+	r.bodyBytes += int64(len)
+	if err != nil {
+		r.err = err
+	}
+	return len, err
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (r *responseWriter) WriteHeader(statusCode int) {
+	r.mu.Lock()
+	r.sendHeaders(statusCode)
+	r.mu.Unlock()
+}
+
+// Flush implements http.Flusher.
+//
+// When not streaming the response body is base64 encoded, so up
+// to two bytes of the most recent write may remain buffered.
+func (r *responseWriter) Flush() {
+	r.mu.Lock()
+	r.sendHeaders(200)
+	if r.err == nil {
+		r.err = Flush(r.w)
+	}
+	r.mu.Unlock()
+}
+
+func (r *responseWriter) sendHeaders(statusCode int) {
+	if r.sentHeaders {
+		return
+	}
+	r.sentHeaders = true
+	r.status = statusCode
+	r.dropTrailers()
+
+	start := time.Now()
+	defer func() { r.headerDuration = time.Since(start) }()
+
+	if r.streaming {
+		r.sendStreamingPrelude(statusCode)
+		return
+	}
+
+	// manually construct JSON response, leaving a "spot"
+	// for the streaming body
+	var dst []byte
+	dst = append(dst, []byte("{")...)
+
+	dst, _ = jsontext.AppendQuote(dst, "isBase64Encoded")
+	dst = append(dst, []byte(":")...)
+	dst = append(dst, []byte(jsontext.Bool(true).String())...)
+	dst = append(dst, []byte(",")...)
+
+	dst, _ = jsontext.AppendQuote(dst, "statusCode")
+	dst = append(dst, []byte(":")...)
+	dst = append(dst, []byte(jsontext.Int(int64(statusCode)).String())...)
+	dst = append(dst, []byte(",")...)
+
+	// ALB requires a status-line description
+	if r.format == payloadALB || r.format == payloadALBMultiValue {
+		dst, _ = jsontext.AppendQuote(dst, "statusDescription")
+		dst = append(dst, []byte(":")...)
+		dst, _ = jsontext.AppendQuote(dst, fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)))
+		dst = append(dst, []byte(",")...)
+	}
+
+	// cookies - only version 2.0 has a property for them, otherwise
+	// they are sent as headers
+	if r.format == payloadV2 {
+		dst = r.appendCookies(dst)
+	}
+
+	// headers
+	if r.format == payloadALB {
+		dst = r.appendJoinedHeaders(dst)
+	} else {
+		dst = r.appendMultiValueHeaders(dst)
+	}
+
+	// start 'body' prop, and open-quote for body-string
+	dst, _ = jsontext.AppendQuote(dst, "body")
+	dst = append(dst, []byte(":\"")...)
+
+	r.write(dst)
+
+	// prep body-writer
+	r.enc = getBase64Writer(r.w)
+	r.body = r.enc
+}
+
+// sendStreamingPrelude writes the metadata-prelude used by response-streaming
+// HTTP integrations: a JSON object holding the status-code, headers, and
+// cookies, followed by eight null bytes. The body follows verbatim.
+func (r *responseWriter) sendStreamingPrelude(statusCode int) {
+	var dst []byte
+	dst = append(dst, []byte("{")...)
+
+	// cookies
+	dst = r.appendCookies(dst)
+
+	// headers - multi-value headers are not supported, so
+	// we join them.
+	dst = r.appendJoinedHeaders(dst)
+
+	dst, _ = jsontext.AppendQuote(dst, "statusCode")
+	dst = append(dst, []byte(":")...)
+	dst = append(dst, []byte(jsontext.Int(int64(statusCode)).String())...)
+	dst = append(dst, []byte("}")...)
+
+	dst = append(dst, make([]byte, 8)...)
+
+	// the runtime only looks for the prelude if the response is
+	// sent with the HTTP-integration content-type
+	if r.stream != nil {
+		r.stream.httpIntegration.Store(true)
+	}
+	r.write(dst)
+
+	r.body = r.w
+}
+
+// appendMultiValueHeaders appends the headers as a "multiValueHeaders"
+// property.
+func (r *responseWriter) appendMultiValueHeaders(dst []byte) []byte {
+	if len(r.header) == 0 {
+		return dst
+	}
+	dst, _ = jsontext.AppendQuote(dst, "multiValueHeaders")
+	dst = append(dst, []byte(":{")...)
+
+	var needsComma bool
+	for k, vs := range r.header {
+		if needsComma {
+			dst = append(dst, []byte(",")...)
+		}
+		needsComma = true
+		dst, _ = jsontext.AppendQuote(dst, k)
+		dst = append(dst, []byte(":[")...)
+		for i, v := range vs {
+			if i > 0 {
+				dst = append(dst, []byte(",")...)
+			}
+			dst, _ = jsontext.AppendQuote(dst, v)
+		}
+		dst = append(dst, []byte("]")...)
+	}
+
+	return append(dst, []byte("},")...)
+}
+
+// appendJoinedHeaders appends the headers as a "headers" property,
+// joining multiple values with commas.
+func (r *responseWriter) appendJoinedHeaders(dst []byte) []byte {
+	if len(r.header) == 0 {
+		return dst
+	}
+	dst, _ = jsontext.AppendQuote(dst, "headers")
+	dst = append(dst, []byte(":{")...)
+
+	var needsComma bool
+	for k, vs := range r.header {
+		if needsComma {
+			dst = append(dst, []byte(",")...)
+		}
+		needsComma = true
+		dst, _ = jsontext.AppendQuote(dst, k)
+		dst = append(dst, []byte(":")...)
+		dst, _ = jsontext.AppendQuote(dst, strings.Join(vs, ","))
+	}
+
+	return append(dst, []byte("},")...)
+}
+
+// dropTrailers removes trailer declarations and values from the
+// headers, as they cannot be sent.
+func (r *responseWriter) dropTrailers() {
+	r.header.Del("Trailer")
+	for k := range r.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			delete(r.header, k)
+		}
+	}
+}
+
+// appendCookies moves any set-cookie headers into a "cookies" property.
+func (r *responseWriter) appendCookies(dst []byte) []byte {
+	cs := r.header.Values("set-cookie")
+	r.header.Del("set-cookie")
+	if len(cs) > 0 {
+		dst, _ = jsontext.AppendQuote(dst, "cookies")
+		dst = append(dst, []byte(":[")...)
+		for i, c := range cs {
+			if i > 0 {
+				dst = append(dst, []byte(",")...)
+			}
+			dst, _ = jsontext.AppendQuote(dst, c)
+		}
+		dst = append(dst, []byte("],")...)
+	}
+	return dst
+}
+
+// write sends framing-data (as opposed to body-data) to the
+// underlying writer, recording any error.
+func (r *responseWriter) write(p []byte) {
+	for len(p) > 0 && r.err == nil {
+		var n int
+		n, r.err = r.w.Write(p)
+		if n == 0 && r.err == nil {
+			// the writer isn't making progress
+			r.err = io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+}
+
+// finish completes the response, returning the first error
+// encountered writing it.
+func (r *responseWriter) finish() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sendHeaders(200)
+
+	if !r.streaming {
+		// flush body
+		if err := r.enc.Close(); err != nil && r.err == nil {
+			r.err = err
+		}
+		putBase64Writer(r.enc)
+		r.enc = nil
+
+		// close body-string and response object
+		r.write([]byte("\"}"))
+	}
+
+	if r.err != nil {
+		return fmt.Errorf("writing http response: %w", r.err)
+	}
+	return nil
+}
+
+var _ http.ResponseWriter = (*responseWriter)(nil)
+var _ http.Flusher = (*responseWriter)(nil)
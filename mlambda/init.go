@@ -0,0 +1,43 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// processStart approximates when the process started. Package
+// initialization runs before main, so this is close enough to
+// measure init-duration.
+var processStart = time.Now()
+
+const (
+	// initTimeout is the init-phase limit imposed by the lambda service
+	// for on-demand functions.
+	initTimeout = 10 * time.Second
+
+	// initWarnThreshold is the init-duration beyond which we log a
+	// warning.
+	initWarnThreshold = initTimeout * 8 / 10
+)
+
+// recordInit reports how long the process took to get from start to the
+// first request for work, which is when the lambda service considers
+// the init-phase complete.
+func (s *Server) recordInit(ctx context.Context, initDone time.Time) {
+	d := initDone.Sub(processStart)
+	s.initDuration = d
+
+	s.record(ctx, "InitDuration", float64(d)/float64(time.Millisecond), UnitMilliseconds)
+
+	level := slog.LevelInfo
+	msg := "init complete"
+	if d >= initWarnThreshold {
+		level = slog.LevelWarn
+		msg = "init duration is approaching the lambda init timeout"
+	}
+	s.logger().LogAttrs(ctx, level, msg,
+		slog.Duration("initDuration", d),
+		slog.Duration("initTimeout", initTimeout),
+	)
+}
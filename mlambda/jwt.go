@@ -0,0 +1,269 @@
+package mlambda
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// JWTOptions configures VerifyJWT.
+type JWTOptions struct {
+	// Issuer is the required "iss" claim, such as
+	// "https://cognito-idp.us-east-1.amazonaws.com/us-east-1_example".
+	Issuer string
+
+	// Audiences are the accepted values of the audience claim. If
+	// empty, the audience is not checked.
+	Audiences []string
+
+	// AudienceClaim is the claim checked against Audiences. The
+	// default is "aud"; Cognito access-tokens use "client_id".
+	AudienceClaim string
+
+	// JWKSURL is the URL of the issuer's signing-keys. If empty, it
+	// is discovered from the issuer's OpenID configuration.
+	JWKSURL string
+
+	// HTTPClient fetches keys. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// KeyCacheTTL is how long keys are used before being refetched.
+	// The default is one hour. Keys are also refetched (at most once
+	// a minute) when a token is signed with an unknown key.
+	KeyCacheTTL time.Duration
+
+	// Leeway allows for clock-skew when checking the "exp" and "nbf"
+	// claims. The default is one minute.
+	Leeway time.Duration
+
+	// Problem is the response to requests without a valid token. If
+	// nil, a generic 401 problem is used.
+	Problem *Problem
+}
+
+// VerifyJWT returns middleware which validates the bearer-token of
+// each request, for deployments without an API Gateway JWT authorizer
+// (such as function URLs). Requests without a valid token are
+// rejected.
+//
+// The token's claims and scopes are available to h as they would be
+// from an authorizer: through the JWT field of the GatewayContext's
+// Authorizer (see GatewayContextFromContext).
+func VerifyJWT(h http.Handler, opts JWTOptions) http.Handler {
+	if opts.Issuer == "" {
+		panic("mlambda: JWTOptions requires Issuer")
+	}
+	if opts.AudienceClaim == "" {
+		opts.AudienceClaim = "aud"
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.KeyCacheTTL <= 0 {
+		opts.KeyCacheTTL = time.Hour
+	}
+	if opts.Leeway <= 0 {
+		opts.Leeway = time.Minute
+	}
+	if opts.Problem == nil {
+		opts.Problem = NewProblem(http.StatusUnauthorized, "")
+	}
+	keys := &jwks{
+		client:     opts.HTTPClient,
+		issuer:     opts.Issuer,
+		url:        opts.JWKSURL,
+		ttl:        opts.KeyCacheTTL,
+		minRefresh: time.Minute,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			WriteProblem(w, r, opts.Problem)
+			return
+		}
+		claims, err := verifyJWT(r, token, keys, &opts, time.Now())
+		if err != nil {
+			slog.WarnContext(r.Context(), "rejected bearer-token", "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			WriteProblem(w, r, opts.Problem)
+			return
+		}
+
+		var gc GatewayContext
+		if prev, ok := GatewayContextFromContext(r.Context()); ok {
+			gc = *prev
+		}
+		gc.Authorizer = Authorizer{JWT: jwtAuthorizer(claims)}
+		h.ServeHTTP(w, r.WithContext(ContextWithGatewayContext(r.Context(), &gc)))
+	})
+}
+
+// bearerToken returns the bearer-token of a request.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// verifyJWT checks the signature and claims of a token, returning its
+// claims.
+func verifyJWT(r *http.Request, token string, keys *jwks, opts *JWTOptions, now time.Time) (map[string]jsontext.Value, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decoding header: %s", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %s", err)
+	}
+
+	key, err := keys.key(r.Context(), header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]jsontext.Value
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding claims: %s", err)
+	}
+	var std struct {
+		Iss string  `json:"iss"`
+		Exp float64 `json:"exp"`
+		Nbf float64 `json:"nbf"`
+	}
+	if err := decodeJWTPart(parts[1], &std); err != nil {
+		return nil, fmt.Errorf("decoding claims: %s", err)
+	}
+	if std.Iss != opts.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", std.Iss)
+	}
+	if std.Exp == 0 || now.After(time.Unix(int64(std.Exp), 0).Add(opts.Leeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if std.Nbf != 0 && now.Add(opts.Leeway).Before(time.Unix(int64(std.Nbf), 0)) {
+		return nil, fmt.Errorf("token not yet valid")
+	}
+	if len(opts.Audiences) > 0 {
+		// the audience may be a string or an array of strings
+		var auds []string
+		raw := claims[opts.AudienceClaim]
+		if err := jsonv2.Unmarshal(raw, &auds); err != nil {
+			var aud string
+			_ = jsonv2.Unmarshal(raw, &aud)
+			auds = []string{aud}
+		}
+		if !slices.ContainsFunc(auds, func(a string) bool { return slices.Contains(opts.Audiences, a) }) {
+			return nil, fmt.Errorf("unexpected audience")
+		}
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(s string, into any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return jsonv2.Unmarshal(b, into, jsonv2.RejectUnknownMembers(false))
+}
+
+// verifyJWTSignature checks a signature made with the algorithm alg.
+// The key must be of the type the algorithm uses, so a token cannot
+// choose a weaker algorithm than its key.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	digest := func() []byte {
+		h := hash.New()
+		h.Write([]byte(signed))
+		return h.Sum(nil)
+	}
+
+	var ok bool
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case hash == 0:
+		case strings.HasPrefix(alg, "RS"):
+			ok = rsa.VerifyPKCS1v15(k, hash, digest(), sig) == nil
+		case strings.HasPrefix(alg, "PS"):
+			ok = rsa.VerifyPSS(k, hash, digest(), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && hash != 0 && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			ok = ecdsa.Verify(k, digest(), r, s)
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" {
+			ok = ed25519.Verify(k, []byte(signed), sig)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("invalid %q signature", alg)
+	}
+	return nil
+}
+
+// jwtAuthorizer converts claims to the form API Gateway passes them:
+// as strings, with scopes taken from the "scope" or "scp" claim.
+func jwtAuthorizer(claims map[string]jsontext.Value) *JWTAuthorizer {
+	a := &JWTAuthorizer{Claims: make(map[string]string, len(claims))}
+	for k, v := range claims {
+		switch v.Kind() {
+		case '"':
+			var s string
+			_ = jsonv2.Unmarshal(v, &s)
+			a.Claims[k] = s
+		case '0':
+			var f float64
+			_ = jsonv2.Unmarshal(v, &f)
+			a.Claims[k] = strconv.FormatFloat(f, 'f', -1, 64)
+		default:
+			a.Claims[k] = string(v)
+		}
+	}
+	if err := jsonv2.Unmarshal(claims["scp"], &a.Scopes); err != nil || len(a.Scopes) == 0 {
+		a.Scopes = strings.Fields(a.Claims["scope"])
+	}
+	return a
+}
@@ -0,0 +1,121 @@
+package mlambda
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// KafkaRecord is a record of a Kafka (MSK or self-managed) event.
+type KafkaRecord struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+
+	// Headers are the record's headers, in order. Kafka allows a key
+	// to repeat.
+	Headers []KafkaHeader
+}
+
+// KafkaHeader is a Kafka record-header.
+type KafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+// Kafka parses a Kafka batch-record.
+func (r *BatchRecord) Kafka() (*KafkaRecord, error) {
+	if r.Source != SourceKafka {
+		return nil, fmt.Errorf("record is from %s, not Kafka", r.Source)
+	}
+	var raw struct {
+		Topic     string `json:"topic"`
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+		Timestamp int64  `json:"timestamp"`
+		Key       []byte `json:"key"`
+		Value     []byte `json:"value"`
+
+		// each header is an object with a single member, whose
+		// value is an array of bytes
+		Headers []map[string][]int `json:"headers"`
+	}
+	if err := jsonv2.Unmarshal(r.Raw, &raw); err != nil {
+		return nil, fmt.Errorf("decoding Kafka record: %s", err)
+	}
+
+	k := &KafkaRecord{
+		Topic:     raw.Topic,
+		Partition: raw.Partition,
+		Offset:    raw.Offset,
+		Timestamp: time.UnixMilli(raw.Timestamp),
+		Key:       raw.Key,
+		Value:     raw.Value,
+	}
+	for _, h := range raw.Headers {
+		for key, ints := range h {
+			v := make([]byte, len(ints))
+			for i, n := range ints {
+				v[i] = byte(n)
+			}
+			k.Headers = append(k.Headers, KafkaHeader{Key: key, Value: v})
+		}
+	}
+	return k, nil
+}
+
+// Header returns the value of the first header with the given key.
+func (k *KafkaRecord) Header(key string) ([]byte, bool) {
+	for _, h := range k.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return nil, false
+}
+
+// KafkaCheckpointFunc records that the records of a topic-partition
+// have been processed up to, and including, offset.
+type KafkaCheckpointFunc func(ctx context.Context, topic string, partition int, offset int64) error
+
+// checkpointKafka calls checkpoint for each partition with the offset
+// of the last record processed before any failure.
+func checkpointKafka(ctx context.Context, checkpoint KafkaCheckpointFunc, records []*BatchRecord, failed []string) error {
+	type partition struct {
+		topic string
+		num   int
+	}
+	var partitions []partition
+	last := map[partition]int64{}
+	stopped := map[partition]bool{}
+	for _, r := range records {
+		k, err := r.Kafka()
+		if err != nil {
+			return err
+		}
+		p := partition{k.Topic, k.Partition}
+		if stopped[p] {
+			continue
+		}
+		if slices.Contains(failed, r.ID) {
+			stopped[p] = true
+			continue
+		}
+		if _, ok := last[p]; !ok {
+			partitions = append(partitions, p)
+		}
+		last[p] = k.Offset
+	}
+	for _, p := range partitions {
+		if err := checkpoint(ctx, p.topic, p.num, last[p]); err != nil {
+			return fmt.Errorf("checkpointing %s-%d: %s", p.topic, p.num, err)
+		}
+	}
+	return nil
+}
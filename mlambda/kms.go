@@ -0,0 +1,305 @@
+package mlambda
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var kmsService = awsapi.Service{
+	SigningName:  "kms",
+	JSONVersion:  "1.1",
+	TargetPrefix: "TrentService",
+}
+
+// encryptedPrefix marks values encrypted by a FieldEncryptor.
+const encryptedPrefix = "mlenc:v1:"
+
+// maxDecryptedKeys bounds the data-keys a FieldEncryptor keeps for
+// decryption.
+const maxDecryptedKeys = 100
+
+// FieldEncryptor encrypts values (such as the PII fields of a JSON
+// document) with envelope encryption: each value is encrypted with
+// AES-256-GCM under a data-key, which is itself encrypted by a KMS key
+// and stored alongside the value. Encrypted values are strings, so
+// encrypted documents remain valid JSON and can be logged, sent to
+// dead-letter queues, or replayed without revealing the plaintext.
+//
+// Data-keys are cached and re-used, so most values are encrypted and
+// decrypted without calling KMS. The function needs kms:GenerateDataKey
+// to encrypt and kms:Decrypt to decrypt.
+type FieldEncryptor struct {
+	Client *awsapi.Client
+
+	// KeyID is the KMS key (an id, ARN, or alias) data-keys are
+	// generated under.
+	KeyID string
+
+	// EncryptionContext is bound to data-keys, and must match to
+	// decrypt them. It is logged in CloudTrail, so must not itself be
+	// sensitive.
+	EncryptionContext map[string]string
+
+	// KeyTTL is how long a data-key is used to encrypt (default five
+	// minutes), and MaxKeyUses how many values it may encrypt (default
+	// 10000). Decrypted data-keys are also kept for KeyTTL.
+	KeyTTL     time.Duration
+	MaxKeyUses int
+
+	mu         sync.Mutex
+	current    *dataKey
+	decryption map[string]*dataKey
+}
+
+type dataKey struct {
+	aead      cipher.AEAD
+	encrypted []byte
+	expires   time.Time
+	uses      int
+}
+
+// Encrypt encrypts a value.
+func (e *FieldEncryptor) Encrypt(ctx context.Context, plaintext []byte) (string, error) {
+	return e.seal(ctx, plaintext, nil)
+}
+
+// Decrypt decrypts a value returned by Encrypt.
+func (e *FieldEncryptor) Decrypt(ctx context.Context, value string) ([]byte, error) {
+	return e.open(ctx, value, nil)
+}
+
+// IsEncrypted reports if a value was produced by a FieldEncryptor.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// EncryptFields encrypts the named fields of a JSON object, replacing
+// each value (of any type) with an encrypted string. Nested fields are
+// named with dots, such as "customer.email". Missing fields are
+// ignored. Each value is bound to its field-name, so encrypted values
+// cannot be moved between fields.
+func (e *FieldEncryptor) EncryptFields(ctx context.Context, doc []byte, fields ...string) ([]byte, error) {
+	return e.transformFields(doc, fields, func(field string, v jsontext.Value) (jsontext.Value, error) {
+		if v.Kind() == '"' && IsEncrypted(jsonString(v)) {
+			return v, nil
+		}
+		s, err := e.seal(ctx, v, []byte(field))
+		if err != nil {
+			return nil, err
+		}
+		return jsonv2.Marshal(s)
+	})
+}
+
+// DecryptFields reverses EncryptFields, restoring the original values
+// of the named fields. Fields which are not encrypted are left as they
+// are.
+func (e *FieldEncryptor) DecryptFields(ctx context.Context, doc []byte, fields ...string) ([]byte, error) {
+	return e.transformFields(doc, fields, func(field string, v jsontext.Value) (jsontext.Value, error) {
+		if v.Kind() != '"' || !IsEncrypted(jsonString(v)) {
+			return v, nil
+		}
+		return e.open(ctx, jsonString(v), []byte(field))
+	})
+}
+
+func jsonString(v jsontext.Value) string {
+	var s string
+	_ = jsonv2.Unmarshal(v, &s)
+	return s
+}
+
+// transformFields applies f to the named fields of a JSON object.
+func (e *FieldEncryptor) transformFields(doc []byte, fields []string, f func(field string, v jsontext.Value) (jsontext.Value, error)) ([]byte, error) {
+	var obj map[string]jsontext.Value
+	if err := jsonv2.Unmarshal(doc, &obj); err != nil {
+		return nil, fmt.Errorf("decoding document: %s", err)
+	}
+	for _, field := range fields {
+		if err := transformField(obj, field, strings.Split(field, "."), f); err != nil {
+			return nil, err
+		}
+	}
+	return jsonv2.Marshal(obj, jsonv2.Deterministic(true))
+}
+
+func transformField(obj map[string]jsontext.Value, field string, path []string, f func(field string, v jsontext.Value) (jsontext.Value, error)) error {
+	v, ok := obj[path[0]]
+	if !ok {
+		return nil
+	}
+	if len(path) == 1 {
+		nv, err := f(field, v)
+		if err != nil {
+			return fmt.Errorf("field %s: %s", field, err)
+		}
+		obj[path[0]] = nv
+		return nil
+	}
+	if v.Kind() != '{' {
+		return nil
+	}
+	var child map[string]jsontext.Value
+	if err := jsonv2.Unmarshal(v, &child); err != nil {
+		return err
+	}
+	if err := transformField(child, field, path[1:], f); err != nil {
+		return err
+	}
+	nv, err := jsonv2.Marshal(child, jsonv2.Deterministic(true))
+	if err != nil {
+		return err
+	}
+	obj[path[0]] = nv
+	return nil
+}
+
+// seal encrypts plaintext, returning
+// prefix + base64(encrypted data-key) + ":" + base64(nonce + ciphertext).
+func (e *FieldEncryptor) seal(ctx context.Context, plaintext []byte, aad []byte) (string, error) {
+	k, err := e.encryptionKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plaintext)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := k.aead.Seal(nonce, nonce, plaintext, aad)
+	return encryptedPrefix + base64.RawURLEncoding.EncodeToString(k.encrypted) + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (e *FieldEncryptor) open(ctx context.Context, value string, aad []byte) ([]byte, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return nil, errors.New("not an encrypted value")
+	}
+	keyPart, dataPart, ok := strings.Cut(rest, ":")
+	if !ok {
+		return nil, errors.New("malformed encrypted value")
+	}
+	encryptedKey, err := base64.RawURLEncoding.DecodeString(keyPart)
+	if err != nil {
+		return nil, errors.New("malformed encrypted value")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(dataPart)
+	if err != nil {
+		return nil, errors.New("malformed encrypted value")
+	}
+
+	k, err := e.decryptionKey(ctx, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < k.aead.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("decrypting value: authentication failed")
+	}
+	return plaintext, nil
+}
+
+// encryptionKey returns the current data-key, generating a new one if
+// it has expired or been used too often.
+func (e *FieldEncryptor) encryptionKey(ctx context.Context) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	maxUses := e.MaxKeyUses
+	if maxUses <= 0 {
+		maxUses = 10000
+	}
+	now := time.Now()
+	if k := e.current; k != nil && now.Before(k.expires) && k.uses < maxUses {
+		k.uses++
+		return k, nil
+	}
+
+	var out struct {
+		Plaintext      []byte `json:"Plaintext"`
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}
+	err := e.Client.DoJSON(ctx, kmsService, "GenerateDataKey", map[string]any{
+		"KeyId":             e.KeyID,
+		"KeySpec":           "AES_256",
+		"EncryptionContext": e.EncryptionContext,
+	}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("generating data-key: %s", err)
+	}
+	k, err := e.newDataKey(out.Plaintext, out.CiphertextBlob, now)
+	if err != nil {
+		return nil, err
+	}
+	k.uses = 1
+	e.current = k
+	e.cacheDecryptionKey(k)
+	return k, nil
+}
+
+// decryptionKey returns the data-key encrypted as encryptedKey.
+func (e *FieldEncryptor) decryptionKey(ctx context.Context, encryptedKey []byte) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if k, ok := e.decryption[string(encryptedKey)]; ok && now.Before(k.expires) {
+		return k, nil
+	}
+
+	var out struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	err := e.Client.DoJSON(ctx, kmsService, "Decrypt", map[string]any{
+		"CiphertextBlob":    encryptedKey,
+		"EncryptionContext": e.EncryptionContext,
+	}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data-key: %s", err)
+	}
+	k, err := e.newDataKey(out.Plaintext, encryptedKey, now)
+	if err != nil {
+		return nil, err
+	}
+	e.cacheDecryptionKey(k)
+	return k, nil
+}
+
+func (e *FieldEncryptor) newDataKey(plaintext []byte, encrypted []byte, now time.Time) (*dataKey, error) {
+	block, err := aes.NewCipher(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("data-key: %s", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("data-key: %s", err)
+	}
+	ttl := e.KeyTTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &dataKey{aead: aead, encrypted: encrypted, expires: now.Add(ttl)}, nil
+}
+
+func (e *FieldEncryptor) cacheDecryptionKey(k *dataKey) {
+	if e.decryption == nil || len(e.decryption) >= maxDecryptedKeys {
+		e.decryption = map[string]*dataKey{}
+	}
+	e.decryption[string(k.encrypted)] = k
+}
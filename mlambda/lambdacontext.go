@@ -0,0 +1,53 @@
+package mlambda
+
+import (
+	"context"
+	"time"
+)
+
+// LambdaContext describes the invocation being handled.
+type LambdaContext struct {
+	// RequestID is the lambda request-id for the invocation.
+	RequestID string
+
+	// InvokedFunctionArn is the ARN used to invoke the function,
+	// which may include a version or alias.
+	InvokedFunctionArn string
+
+	// Deadline is when the invocation times out.
+	Deadline time.Time
+
+	// TraceID is the X-Ray trace-header for the invocation.
+	TraceID string
+
+	// ClientContext and CognitoIdentity are supplied by the
+	// mobile SDKs, and are otherwise empty.
+	ClientContext   string
+	CognitoIdentity string
+
+	// ColdStart is true for the first invocation handled by the
+	// process.
+	ColdStart bool
+}
+
+type lambdaContextKey struct{}
+
+// ContextWithLambdaContext returns a copy of ctx holding lc.
+func ContextWithLambdaContext(ctx context.Context, lc *LambdaContext) context.Context {
+	return context.WithValue(ctx, lambdaContextKey{}, lc)
+}
+
+// FromContext returns the LambdaContext of the invocation being handled.
+func FromContext(ctx context.Context) (*LambdaContext, bool) {
+	lc, ok := ctx.Value(lambdaContextKey{}).(*LambdaContext)
+	return lc, ok
+}
+
+// RequestIDFromContext returns the lambda request-id of the invocation
+// being handled, or the empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	if lc, ok := FromContext(ctx); ok {
+		return lc.RequestID
+	}
+	return ""
+}
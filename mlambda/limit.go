@@ -0,0 +1,62 @@
+package mlambda
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrOverloaded is returned by a concurrency-limited handler when
+// its queue is full.
+var ErrOverloaded = errors.New("mlambda: too many concurrent requests")
+
+// LimitConcurrency wraps h so that at most workers invocations run at
+// once. Up to queue further invocations wait for a free worker; beyond
+// that ErrOverloaded is returned immediately.
+//
+// The lambda service never sends a function-instance more than one
+// event at a time, so this is only useful when the handler is served
+// some other way (such as by the local server).
+func LimitConcurrency(h Handler, workers int, queue int) Handler {
+	if workers <= 0 {
+		return h
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &limiter{
+		h:        h,
+		workers:  make(chan struct{}, workers),
+		admitted: make(chan struct{}, workers+queue),
+	}
+}
+
+type limiter struct {
+	h Handler
+
+	// workers holds a token for each running invocation, and
+	// admitted for each running or waiting invocation.
+	workers  chan struct{}
+	admitted chan struct{}
+}
+
+// Invoke implements Handler.
+func (l *limiter) Invoke(ctx context.Context, w io.Writer, r *Request) error {
+	select {
+	case l.admitted <- struct{}{}:
+	default:
+		return ErrOverloaded
+	}
+	defer func() { <-l.admitted }()
+
+	select {
+	case l.workers <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-l.workers }()
+
+	return l.h.Invoke(ctx, w, r)
+}
+
+var _ Handler = (*limiter)(nil)
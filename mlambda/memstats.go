@@ -0,0 +1,65 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// MemStats summarizes the memory and GC activity of the process
+// during a single invocation.
+//
+// Allocations by other goroutines (including leftovers from previous
+// invocations) are included, so values are approximate.
+type MemStats struct {
+	// HeapAlloc and Sys are the heap-size and total memory obtained
+	// from the OS at the end of the invocation.
+	HeapAlloc uint64
+	Sys       uint64
+
+	// TotalAlloc and Mallocs are the bytes and objects allocated
+	// during the invocation.
+	TotalAlloc uint64
+	Mallocs    uint64
+
+	// NumGC and GCPause are the GC cycles completed during the
+	// invocation, and the total time the world was stopped for them.
+	NumGC   uint32
+	GCPause time.Duration
+}
+
+// memStatsDelta computes the per-invocation stats from snapshots taken
+// before and after.
+func memStatsDelta(before, after *runtime.MemStats) MemStats {
+	return MemStats{
+		HeapAlloc:  after.HeapAlloc,
+		Sys:        after.Sys,
+		TotalAlloc: after.TotalAlloc - before.TotalAlloc,
+		Mallocs:    after.Mallocs - before.Mallocs,
+		NumGC:      after.NumGC - before.NumGC,
+		GCPause:    time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+	}
+}
+
+// recordMemStats publishes memory-stats to the metrics-hooks.
+func (s *Server) recordMemStats(ctx context.Context, m MemStats) {
+	s.record(ctx, "HeapAlloc", float64(m.HeapAlloc), UnitBytes)
+	s.record(ctx, "Sys", float64(m.Sys), UnitBytes)
+	s.record(ctx, "TotalAlloc", float64(m.TotalAlloc), UnitBytes)
+	s.record(ctx, "Mallocs", float64(m.Mallocs), UnitCount)
+	s.record(ctx, "NumGC", float64(m.NumGC), UnitCount)
+	s.record(ctx, "GCPause", float64(m.GCPause)/float64(time.Millisecond), UnitMilliseconds)
+}
+
+// LogValue implements slog.LogValuer.
+func (m MemStats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Uint64("heapAlloc", m.HeapAlloc),
+		slog.Uint64("sys", m.Sys),
+		slog.Uint64("totalAlloc", m.TotalAlloc),
+		slog.Uint64("mallocs", m.Mallocs),
+		slog.Uint64("numGC", uint64(m.NumGC)),
+		slog.Duration("gcPause", m.GCPause),
+	)
+}
@@ -0,0 +1,67 @@
+package mlambda
+
+import "context"
+
+// Unit is the unit of a metric. The values match the units
+// understood by CloudWatch.
+type Unit string
+
+const (
+	UnitNone         Unit = "None"
+	UnitCount        Unit = "Count"
+	UnitBytes        Unit = "Bytes"
+	UnitMilliseconds Unit = "Milliseconds"
+)
+
+// Dimension is a name/value pair qualifying a metric.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// Metrics receives measurements recorded by the Server and by the
+// middleware in this package. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	Record(ctx context.Context, name string, value float64, unit Unit, dims ...Dimension)
+}
+
+type MetricsFunc func(ctx context.Context, name string, value float64, unit Unit, dims ...Dimension)
+
+// Record implements Metrics.
+func (f MetricsFunc) Record(ctx context.Context, name string, value float64, unit Unit, dims ...Dimension) {
+	f(ctx, name, value, unit, dims...)
+}
+
+var _ Metrics = (MetricsFunc)(nil)
+
+// record sends a measurement to the server's metrics-hook, if any.
+func (s *Server) record(ctx context.Context, name string, value float64, unit Unit, dims ...Dimension) {
+	if s.Metrics == nil {
+		return
+	}
+	s.Metrics.Record(ctx, name, value, unit, dims...)
+}
+
+type metricsKey struct{}
+
+// ContextWithMetrics returns a copy of ctx holding m. The server places
+// its Metrics on the context of each invocation.
+func ContextWithMetrics(ctx context.Context, m Metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// MetricsFromContext returns the Metrics for the current invocation, if
+// any.
+func MetricsFromContext(ctx context.Context) (Metrics, bool) {
+	m, ok := ctx.Value(metricsKey{}).(Metrics)
+	return m, ok && m != nil
+}
+
+// RecordMetric sends a measurement to the Metrics of the current
+// invocation, if any. It is intended for use by adapters and middleware.
+func RecordMetric(ctx context.Context, name string, value float64, unit Unit, dims ...Dimension) {
+	if m, ok := MetricsFromContext(ctx); ok {
+		m.Record(ctx, name, value, unit, dims...)
+	}
+}
@@ -0,0 +1,56 @@
+package mlambda
+
+import (
+	"net/http"
+)
+
+// statusRecorder wraps an http.ResponseWriter, recording the status and
+// size of the response for use by middleware.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (s *statusRecorder) WriteHeader(statusCode int) {
+	if s.status == 0 {
+		s.status = statusCode
+	}
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter.
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = 200
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (s *statusRecorder) Flush() {
+	if s.status == 0 {
+		s.status = 200
+	}
+	_ = Flush(s.ResponseWriter)
+}
+
+// Unwrap supports http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// statusCode returns the response status, which is 200 if the handler
+// never wrote anything.
+func (s *statusRecorder) statusCode() int {
+	if s.status == 0 {
+		return 200
+	}
+	return s.status
+}
+
+var _ http.ResponseWriter = (*statusRecorder)(nil)
+var _ http.Flusher = (*statusRecorder)(nil)
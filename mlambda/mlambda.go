@@ -0,0 +1,629 @@
+package mlambda
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// Request represents a single incoming lambda event.
+type Request struct {
+	Body io.Reader
+}
+
+// Handler handles lambda events. Invoke reads the event from r and
+// writes the response to w; a returned error is reported to the lambda
+// service as a failed invocation.
+type Handler interface {
+	Invoke(ctx context.Context, w io.Writer, r *Request) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, w io.Writer, r *Request) error
+
+// Invoke implements Handler.
+func (h HandlerFunc) Invoke(ctx context.Context, w io.Writer, r *Request) error {
+	return h(ctx, w, r)
+}
+
+var _ Handler = (HandlerFunc)(nil)
+
+// Server receives lambda invocations, handles them with the supplied
+// handler, and returns the handler's response.
+type Server struct {
+	Handler Handler
+
+	// StreamResponses sends responses to the lambda service using
+	// response-streaming mode, which allows the caller to start
+	// receiving the response before the handler has completed.
+	//
+	// The function must be invoked via an API which supports
+	// streaming (such as a function URL configured with the
+	// RESPONSE_STREAM invoke-mode).
+	StreamResponses bool
+
+	// FlushSize and FlushInterval control write-coalescing when
+	// streaming responses. Handler-output is buffered until
+	// FlushSize bytes are pending or FlushInterval has passed since
+	// the oldest pending write. Handlers may also flush explicitly
+	// (see Flusher). The defaults are 16KiB and 20ms.
+	FlushSize     int
+	FlushInterval time.Duration
+
+	// ProfileSink receives profiles captured in response to profile
+	// events when profiling is enabled with MLAMBDA_PPROF. If nil,
+	// profiles are written to the temp-directory.
+	ProfileSink ProfileSinkFunc
+
+	// Logger receives log-output from the runtime. If nil,
+	// slog.Default() is used.
+	Logger *slog.Logger
+
+	// ReportSampling controls which invocations produce a REPORT log
+	// line. If nil, all invocations are logged.
+	ReportSampling *LogSampling
+
+	// Metrics receives measurements about each invocation. If nil,
+	// no metrics are recorded.
+	Metrics Metrics
+
+	// MemStats enables collection of memory and GC statistics for each
+	// invocation. They are sent to Metrics and logged at the end of
+	// each invocation. Reading memory statistics briefly stops the
+	// world, so this is off by default.
+	MemStats bool
+
+	// LocalConcurrency limits how many requests the local server
+	// handles at once, with up to LocalQueueDepth further requests
+	// waiting. Requests beyond that are rejected with a 503 status.
+	// Zero means no limit. See also LimitConcurrency.
+	LocalConcurrency int
+	LocalQueueDepth  int
+
+	// DeadlineWarning is how long before an invocation's deadline
+	// the server logs diagnostics (the lifecycle stage and a goroutine
+	// dump) if the invocation is still running. The default is one
+	// second; a negative value disables the warning.
+	DeadlineWarning time.Duration
+
+	// Warmups are run concurrently when the server starts, before
+	// any invocations are handled. The server waits up to
+	// WarmupTimeout (default five seconds) for them to complete.
+	Warmups       []Warmup
+	WarmupTimeout time.Duration
+
+	// Redactor masks sensitive values in events and responses logged
+	// in debug-mode (see MLAMBDA_DEBUG) and in error-messages reported
+	// to the lambda service. If nil, DefaultRedactor is used.
+	Redactor Redactor
+
+	// PanicGoroutineDump captures the stacks of all goroutines when a
+	// handler panics, and includes them (truncated) in the logged
+	// error and the error reported to the lambda service. This helps
+	// diagnose deadlocks and goroutines leaked across invocations.
+	PanicGoroutineDump bool
+
+	// HeartbeatInterval is how often progress is logged while a
+	// streaming response is being sent. The default is one minute;
+	// a negative value disables heartbeats.
+	HeartbeatInterval time.Duration
+
+	// FlushTimeout bounds how long the server waits for functions
+	// registered with RegisterFlush at the end of each invocation. The
+	// default is 500ms.
+	FlushTimeout time.Duration
+
+	// FlushOnShutdown runs the functions registered with RegisterFlush
+	// and RegisterShutdown when the lambda service shuts down the
+	// execution environment, so telemetry buffered beyond the end of
+	// an invocation is delivered.
+	// This registers an internal extension with the lambda service.
+	FlushOnShutdown bool
+
+	// MaxEventSize caps the size of events read from the lambda
+	// service. Larger events fail with ErrEventTooLarge: before the
+	// handler is called if the size is known up front, or else when
+	// the handler reads past the limit. Zero means no limit beyond
+	// the lambda service's own.
+	MaxEventSize int64
+
+	// InvocationHistory is how many recent invocations are kept in
+	// memory. Their summaries are logged if a handler panics or the
+	// execution environment shuts down (see FlushOnShutdown), and are
+	// included in diagnostics (see MLAMBDA_DIAG). The default is 32;
+	// a negative value disables the history.
+	InvocationHistory int
+
+	// RuntimeRetry is the policy for retrying requests to the lambda
+	// runtime API for events and to report errors, should they fail to
+	// connect or receive a 5xx status. The default is three attempts
+	// with ExponentialBackoff from 50ms; use NoRetry to fail at once.
+	RuntimeRetry RetryPolicy
+
+	// LocalAddr is the address the local server listens on when not
+	// running in lambda. The default is "localhost:8080".
+	LocalAddr string
+
+	client    *client
+	profiling bool
+	debug     bool
+	diag      bool
+
+	// history holds recent invocations, for postmortems.
+	history invocationHistory
+
+	// initDuration is zero until the first invocation is received.
+	initDuration time.Duration
+
+	// stage is the current lifecycle-stage.
+	stage atomic.Int32
+
+	// cpuProfile is a CPU profile started by a profile event.
+	cpuProfile cpuCapture
+
+	flushMu       sync.Mutex
+	flushFuncs    []FlushFunc
+	shutdownFuncs []FlushFunc
+}
+
+// Start process lambda invocations indefinitely.
+func (s *Server) Start(ctx context.Context) error {
+	s.profiling = profilingEnabled()
+	s.debug = debugEnabled()
+	s.diag = diagEnabled()
+	if f, ok := s.Metrics.(interface{ Flush(context.Context) error }); ok {
+		s.RegisterFlush(f.Flush)
+	}
+	s.runWarmups(ctx)
+
+	c, err := newClientFromEnv()
+	if err != nil {
+		// run a local HTTP version of the lambda if we aren't
+		// actually running in AWS.
+		return s.serveLocal(ctx)
+	}
+
+	s.client = c
+	c.retry = s.RuntimeRetry
+	if c.retry == nil {
+		c.retry = ExponentialBackoff{Base: 50 * time.Millisecond}
+	}
+	if s.StreamResponses {
+		c.responseHeader.Set("Lambda-Runtime-Function-Response-Mode", "streaming")
+	}
+	if s.FlushOnShutdown {
+		s.startShutdownFlush(ctx, c.endpoint)
+	}
+
+	// main loop
+	for {
+		select {
+		case <-ctx.Done():
+			// TODO - logging
+			return nil
+		default:
+		}
+
+		err := s.doWork(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) doWork(parentCtx context.Context) error {
+	// request new work
+
+	// no timeout
+	s.setStage(stagePolling)
+	pollStart := time.Now()
+	req, err := s.client.nextInvocation(parentCtx)
+	if err != nil {
+		return err
+	}
+	report := invocationReport{
+		requestId: req.id,
+		start:     time.Now(),
+		coldStart: s.initDuration == 0,
+	}
+	report.timings.pollWait = report.start.Sub(pollStart)
+	if report.coldStart {
+		s.recordInit(parentCtx, pollStart)
+		s.record(parentCtx, "ColdStart", 1, UnitCount)
+	}
+	s.setStage(stageHandler)
+	defer func() {
+		io.Copy(io.Discard, req.body)
+		req.body.Close()
+	}()
+
+	// deferred before memory-stats are collected, so it runs after.
+	defer func() {
+		report.duration = time.Since(report.start)
+		s.logReport(parentCtx, &report)
+		s.recordHistory(&report)
+	}()
+
+	// deliver telemetry once everything about the invocation has
+	// been recorded, before asking for the next one.
+	defer s.runFlushes(parentCtx)
+
+	if s.MemStats {
+		var before runtime.MemStats
+		runtime.ReadMemStats(&before)
+		defer func() {
+			var after runtime.MemStats
+			runtime.ReadMemStats(&after)
+			m := memStatsDelta(&before, &after)
+			s.recordMemStats(parentCtx, m)
+			report.memStats = &m
+		}()
+	}
+
+	var ctx context.Context
+	var ctxDone func()
+
+	if req.deadline.IsZero() {
+		// this doesn't do much, but it does ensure that if there
+		// is some control-flow bug in this code the handler-goroutine
+		// will be running with a canceled context.
+		ctx, ctxDone = context.WithCancel(parentCtx)
+	} else {
+		ctx, ctxDone = context.WithDeadline(parentCtx, req.deadline)
+	}
+	defer ctxDone()
+
+	ctx = ContextWithLambdaContext(ctx, &LambdaContext{
+		RequestID:          req.id,
+		InvokedFunctionArn: req.invokedFunctionArn,
+		Deadline:           req.deadline,
+		TraceID:            req.traceId,
+		ClientContext:      req.clientContext,
+		CognitoIdentity:    req.cognitoIdentity,
+		ColdStart:          report.coldStart,
+	})
+	ctx = contextWithStageTimings(ctx, &report.timings)
+	ctx = contextWithHttpOutcome(ctx, &report.http)
+	if s.Metrics != nil {
+		ctx = ContextWithMetrics(ctx, s.Metrics)
+	}
+	if s.diag {
+		ctx = contextWithDiagnostics(ctx, s.diagnostics)
+	}
+
+	stopWatchdog := s.startWatchdog(parentCtx, req.id, report.start, req.deadline)
+	defer stopWatchdog()
+
+	// This is the tricky bit. We want to offer a Writer
+	// to the handler because it's a better interface, but
+	// the lambda-response goes back to AWS in an HTTP request
+	// body so we need a Reader.
+	//
+	// The answer is to use `io.Pipe()`. As soon as the handler
+	// send any data down its half of the pipe we send off the
+	// response to the Lambda service.
+
+	pipeReader, pipeWriter := io.Pipe()
+	defer func() {
+		pipeReader.Close()
+		pipeWriter.Close()
+	}()
+
+	// in streaming mode small writes are coalesced before being
+	// sent down the pipe.
+	var w io.Writer = pipeWriter
+	var cw *coalescingWriter
+	var stream *streamingResponse
+	if s.StreamResponses {
+		cw = newCoalescingWriter(pipeWriter, s.FlushSize, s.FlushInterval)
+		w = cw
+		stream = &streamingResponse{}
+		ctx = context.WithValue(ctx, streamingKey{}, stream)
+	}
+
+	// count what we send and receive, for size-metrics
+	eventBody := &countingReader{r: req.body}
+	responseWriter := &countingWriter{w: w}
+	w = responseWriter
+
+	stopHeartbeat := s.startHeartbeat(parentCtx, req.id, report.start, req.deadline, responseWriter.n.Load)
+	defer stopHeartbeat()
+
+	var body io.Reader = eventBody
+	var limiter *eventLimitReader
+	if s.MaxEventSize > 0 {
+		limiter = &eventLimitReader{r: eventBody, limit: s.MaxEventSize}
+		body = limiter
+	}
+	if s.debug {
+		body = s.dumpEvent(parentCtx, req.id, body)
+	}
+
+	handler := s.Handler
+	// set for profile and diagnostic events, which are answered
+	// by the server itself
+	var controlEvent bool
+	if s.MaxEventSize > 0 && req.contentLength > s.MaxEventSize {
+		handler = HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+			return eventTooLarge(s.MaxEventSize)
+		})
+	}
+	if s.profiling {
+		var ev *profileEvent
+		ev, body = peekProfileEvent(body)
+		if ev != nil {
+			controlEvent = true
+			handler = HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+				return s.handleProfileEvent(ctx, w, ev)
+			})
+		}
+	}
+	if s.diag {
+		var isDiag bool
+		isDiag, body = peekDiagEvent(body)
+		if isDiag {
+			controlEvent = true
+			handler = HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+				return jsonv2.MarshalWrite(w, s.diagnostics())
+			})
+		}
+	}
+	if s.profiling && !controlEvent {
+		// counted once the response has been sent
+		defer s.cpuProfiledInvocation(parentCtx)
+	}
+
+	// set once the handler returns
+	var handlerDuration atomic.Int64
+
+	handlerErr := make(chan error, 1)
+	go func() {
+		var err error
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					err = s.handlePanic(ctx, v)
+				}
+			}()
+			err = handler.Invoke(ctx, w, &Request{
+				Body: body,
+			})
+		}()
+		if err == nil && cw != nil {
+			err = cw.Close()
+		}
+		handlerEnd := time.Now()
+
+		// the handler's error may not say why the event could
+		// not be read
+		if limiter != nil && limiter.exceeded {
+			err = eventTooLarge(s.MaxEventSize)
+		}
+
+		_, _ = io.Copy(io.Discard, body)
+		_, _ = io.Copy(io.Discard, eventBody)
+		s.record(ctx, "EventSize", float64(eventBody.n), UnitBytes)
+		s.record(ctx, "ResponseSize", float64(responseWriter.n.Load()), UnitBytes)
+
+		handlerDuration.Store(int64(handlerEnd.Sub(report.start)))
+		s.record(ctx, "HandlerDuration", float64(handlerEnd.Sub(report.start))/float64(time.Millisecond), UnitMilliseconds)
+
+		handlerErr <- err
+		s.setStage(stageUpload)
+		if err != nil {
+			// signal the reader something abnormal happened
+			// (and stop our waiter from waiting ...)
+			//
+			// if we've already started sending the request back
+			// to the lambda service this still gets reported as
+			// an error, but the message is not obvious.
+			//
+			// I'm not sure what this looks like for a streaming
+			// response lambda - hopefully an incomplete chunk-stream
+			// (or stream-rst).
+			pipeWriter.CloseWithError(err)
+		} else {
+			// normal exit - signal EOF
+			pipeWriter.Close()
+		}
+	}()
+
+	// wait for the handler to start writing data.
+	// once it has done so, start sending the response
+	// back up.
+	bufReader := bufio.NewReader(pipeReader)
+	_, err = bufReader.Peek(1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		class := classifyError(ctx, err)
+		report.errorType = class.errorType()
+		report.errorMessage = s.redactor().RedactText(err.Error())
+		s.recordError(parentCtx, class)
+
+		var stackTrace []string
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			stackTrace = panicErr.stackTrace()
+		}
+
+		// TODO - do something with error?
+		_ = s.client.invocationError(parentCtx, errorOptions{
+			requestId:    req.id,
+			errorType:    report.errorType,
+			errorMessage: report.errorMessage,
+			stackTrace:   stackTrace,
+		})
+		return nil
+	}
+
+	// the app-handler has started producing a response, so
+	// we're going to start sending it up.
+	//
+	// at this point, if the handler returns an error after
+	// we get this far the reader we pass to the Go http
+	// client will return that error from its 'Read' method.
+	// When streaming, the error is reported in the request's
+	// trailers. Otherwise the result is a truncated payload,
+	// which the Lambda service treats as an error.
+	//
+	// TODO - do something with error-return?
+	responseBody := &countingReader{r: bufReader}
+	var capture *captureWriter
+	if s.debug {
+		capture = &captureWriter{limit: debugDumpLimit}
+		responseBody.r = io.TeeReader(bufReader, capture)
+	}
+	opts := responseOptions{
+		requestId: req.id,
+		body:      responseBody,
+	}
+	if stream != nil {
+		// the prelude is written before the first byte reaches
+		// the pipe, so is visible once Peek returns
+		if stream.httpIntegration.Load() {
+			opts.contentType = httpIntegrationContentType
+		}
+		opts.streamError = func(err error) errorOptions {
+			var stackTrace []string
+			var panicErr *PanicError
+			if errors.As(err, &panicErr) {
+				stackTrace = panicErr.stackTrace()
+			}
+			return errorOptions{
+				requestId:    req.id,
+				errorType:    classifyError(ctx, err).errorType(),
+				errorMessage: s.redactor().RedactText(err.Error()),
+				stackTrace:   stackTrace,
+			}
+		}
+	}
+	uploadErr := s.client.invocationResponse(parentCtx, opts)
+	report.responseBytes = responseBody.n
+	if d := handlerDuration.Load(); d != 0 {
+		report.timings.handler = time.Duration(d)
+		report.timings.upload = time.Since(report.start.Add(report.timings.handler))
+	}
+	s.recordTimings(parentCtx, &report.timings)
+	if capture != nil {
+		s.logDump(parentCtx, "debug: response", req.id, capture.buf, capture.truncated)
+	}
+
+	// the handler may still be running if the upload failed
+	var class ErrorClass
+	select {
+	case err := <-handlerErr:
+		if err != nil {
+			class = classifyError(ctx, err)
+			report.errorMessage = s.redactor().RedactText(err.Error())
+		}
+	default:
+	}
+	if uploadErr != nil && class == "" {
+		class = ErrorClassUpload
+		report.errorMessage = s.redactor().RedactText(uploadErr.Error())
+	}
+	if class != "" {
+		report.errorType = class.errorType()
+		s.recordError(parentCtx, class)
+	}
+
+	return nil
+}
+
+// serveLocal runs the handler on an HTTP-server on localhost. It is intended
+// for testing out the handler locally.
+func (s *Server) serveLocal(ctx context.Context) error {
+	addr := cmp.Or(s.LocalAddr, "localhost:8080")
+	fmt.Println("Serving lambda on ", addr)
+
+	lambdaHandler := LimitConcurrency(s.Handler, s.LocalConcurrency, s.LocalQueueDepth)
+
+	var handler http.Handler = &httpAdapter{
+		handler: lambdaHandler,
+		withContext: func(ctx context.Context) context.Context {
+			if s.diag {
+				ctx = contextWithDiagnostics(ctx, s.diagnostics)
+			}
+			return ctx
+		},
+		done: s.runFlushes,
+	}
+
+	if s.profiling || s.diag {
+		mux := http.NewServeMux()
+		if s.profiling {
+			mux.Handle("/debug/pprof/", pprofHandler())
+		}
+		if s.diag {
+			mux.Handle(diagPath, diagHandler(s.diagnostics))
+		}
+		mux.Handle("/", handler)
+		handler = mux
+	}
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, close := context.WithTimeout(context.Background(), 5*time.Second)
+		defer close()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	return srv.ListenAndServe()
+}
+
+// newLocalRequestID generates a request-id for the local server, in
+// the same format as lambda request-ids.
+func newLocalRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (s *Server) redactor() Redactor {
+	if s.Redactor != nil {
+		return s.Redactor
+	}
+	return DefaultRedactor
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+type streamingKey struct{}
+
+// streamingResponse is the state of a response being sent in
+// response-streaming mode.
+type streamingResponse struct {
+	// httpIntegration is set once the handler has written the
+	// metadata-prelude of an HTTP-integration response.
+	httpIntegration atomic.Bool
+}
+
+// streamingFromContext returns the state of the current invocation's
+// streamed response, or nil if it is not being streamed.
+func streamingFromContext(ctx context.Context) *streamingResponse {
+	v, _ := ctx.Value(streamingKey{}).(*streamingResponse)
+	return v
+}
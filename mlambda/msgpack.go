@@ -0,0 +1,335 @@
+package mlambda
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// MessagePackCodec serializes values as MessagePack. Values are mapped
+// as they would be to JSON; see Codec.
+//
+// Decoded timestamps (extension type -1) become RFC 3339 strings, which
+// unmarshal into time.Time; other extension types are rejected. Map
+// keys must be strings or integers.
+var MessagePackCodec Codec = binaryCodec{msgpackFormat{}}
+
+// msgpackTimestamp is the extension type of timestamps.
+const msgpackTimestamp = -1
+
+type msgpackFormat struct{}
+
+func (msgpackFormat) appendNull(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+func (msgpackFormat) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func (f msgpackFormat) appendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return f.appendUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func (msgpackFormat) appendUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+}
+
+func (msgpackFormat) appendFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func (msgpackFormat) appendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func (msgpackFormat) appendBytes(b []byte, p []byte) []byte {
+	switch n := len(p); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, p...)
+}
+
+func (msgpackFormat) appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func (msgpackFormat) appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+// readUint reads a big-endian unsigned integer of size bytes.
+func (msgpackFormat) readUint(data []byte, size int) (uint64, []byte, error) {
+	if len(data) < size {
+		return 0, nil, errTruncated
+	}
+	var v uint64
+	switch size {
+	case 1:
+		v = uint64(data[0])
+	case 2:
+		v = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		v = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		v = binary.BigEndian.Uint64(data)
+	}
+	return v, data[size:], nil
+}
+
+// readBytes reads a length of lenSize bytes followed by that many
+// bytes.
+func (f msgpackFormat) readBytes(data []byte, lenSize int) ([]byte, []byte, error) {
+	n, data, err := f.readUint(data, lenSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > uint64(len(data)) {
+		return nil, nil, errTruncated
+	}
+	return data[:n], data[n:], nil
+}
+
+// readInt reads a value which must be an integer, such as a map key.
+func (f msgpackFormat) readInt(data []byte) (string, []byte, bool, error) {
+	c := data[0]
+	switch {
+	case c <= 0x7f:
+		return strconv.Itoa(int(c)), data[1:], true, nil
+	case c >= 0xe0:
+		return strconv.Itoa(int(int8(c))), data[1:], true, nil
+	case c >= 0xcc && c <= 0xcf:
+		v, rest, err := f.readUint(data[1:], 1<<(c-0xcc))
+		return strconv.FormatUint(v, 10), rest, true, err
+	case c >= 0xd0 && c <= 0xd3:
+		size := 1 << (c - 0xd0)
+		v, rest, err := f.readUint(data[1:], size)
+		return strconv.FormatInt(signExtend(v, size), 10), rest, true, err
+	}
+	return "", nil, false, nil
+}
+
+// signExtend interprets the low size bytes of v as a signed integer.
+func signExtend(v uint64, size int) int64 {
+	shift := 64 - 8*size
+	return int64(v<<shift) >> shift
+}
+
+func (f msgpackFormat) transcode(enc *jsontext.Encoder, data []byte, depth int) ([]byte, error) {
+	if depth > maxBinaryDepth {
+		return nil, fmt.Errorf("exceeded max depth of %d", maxBinaryDepth)
+	}
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+	c := data[0]
+	switch {
+	case c <= 0x7f:
+		return data[1:], enc.WriteToken(jsontext.Uint(uint64(c)))
+	case c >= 0xe0:
+		return data[1:], enc.WriteToken(jsontext.Int(int64(int8(c))))
+	case c <= 0x8f:
+		return f.transcodeContainer(enc, true, uint64(c&0x0f), data[1:], depth)
+	case c <= 0x9f:
+		return f.transcodeContainer(enc, false, uint64(c&0x0f), data[1:], depth)
+	case c <= 0xbf:
+		n := int(c & 0x1f)
+		if n > len(data)-1 {
+			return nil, errTruncated
+		}
+		return data[1+n:], enc.WriteToken(jsontext.String(string(data[1 : 1+n])))
+	}
+
+	data = data[1:]
+	switch c {
+	case 0xc0:
+		return data, enc.WriteToken(jsontext.Null)
+	case 0xc2, 0xc3:
+		return data, enc.WriteToken(jsontext.Bool(c == 0xc3))
+	case 0xc4, 0xc5, 0xc6: // bin 8, 16, 32
+		b, rest, err := f.readBytes(data, 1<<(c-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.String(base64.StdEncoding.EncodeToString(b)))
+	case 0xc7, 0xc8, 0xc9: // ext 8, 16, 32
+		n, rest, err := f.readUint(data, 1<<(c-0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return f.transcodeExt(enc, int(n), rest)
+	case 0xca:
+		v, rest, err := f.readUint(data, 4)
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.Float(float64(math.Float32frombits(uint32(v)))))
+	case 0xcb:
+		v, rest, err := f.readUint(data, 8)
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.Float(math.Float64frombits(v)))
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8, 16, 32, 64
+		v, rest, err := f.readUint(data, 1<<(c-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.Uint(v))
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8, 16, 32, 64
+		size := 1 << (c - 0xd0)
+		v, rest, err := f.readUint(data, size)
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.Int(signExtend(v, size)))
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1, 2, 4, 8, 16
+		return f.transcodeExt(enc, 1<<(c-0xd4), data)
+	case 0xd9, 0xda, 0xdb: // str 8, 16, 32
+		s, rest, err := f.readBytes(data, 1<<(c-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return rest, enc.WriteToken(jsontext.String(string(s)))
+	case 0xdc, 0xdd: // array 16, 32
+		n, rest, err := f.readUint(data, 2<<(c-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return f.transcodeContainer(enc, false, n, rest, depth)
+	case 0xde, 0xdf: // map 16, 32
+		n, rest, err := f.readUint(data, 2<<(c-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return f.transcodeContainer(enc, true, n, rest, depth)
+	}
+	return nil, fmt.Errorf("invalid MessagePack type 0x%02x", c)
+}
+
+func (f msgpackFormat) transcodeContainer(enc *jsontext.Encoder, isMap bool, n uint64, data []byte, depth int) ([]byte, error) {
+	if isMap {
+		n *= 2
+	}
+	// each item is at least a byte, so reject lengths the data can't hold
+	if n > uint64(len(data)) {
+		return nil, errTruncated
+	}
+
+	begin, end := jsontext.ArrayStart, jsontext.ArrayEnd
+	if isMap {
+		begin, end = jsontext.ObjectStart, jsontext.ObjectEnd
+	}
+	if err := enc.WriteToken(begin); err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		if len(data) == 0 {
+			return nil, errTruncated
+		}
+		if isMap && i%2 == 0 {
+			// JSON requires string keys
+			if key, rest, ok, err := f.readInt(data); ok {
+				if err != nil {
+					return nil, err
+				}
+				if err := enc.WriteToken(jsontext.String(key)); err != nil {
+					return nil, err
+				}
+				data = rest
+				continue
+			}
+			if c := data[0]; !(c >= 0xa0 && c <= 0xbf || c >= 0xd9 && c <= 0xdb) {
+				return nil, fmt.Errorf("unsupported MessagePack map key of type 0x%02x", c)
+			}
+		}
+		var err error
+		if data, err = f.transcode(enc, data, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return data, enc.WriteToken(end)
+}
+
+// transcodeExt writes an extension value of n bytes, the first of
+// which is its type.
+func (f msgpackFormat) transcodeExt(enc *jsontext.Encoder, n int, data []byte) ([]byte, error) {
+	if len(data) < 1+n {
+		return nil, errTruncated
+	}
+	typ, b, rest := int8(data[0]), data[1:1+n], data[1+n:]
+	if typ != msgpackTimestamp {
+		return nil, fmt.Errorf("unsupported MessagePack extension type %d", typ)
+	}
+
+	var t time.Time
+	switch n {
+	case 4:
+		t = time.Unix(int64(binary.BigEndian.Uint32(b)), 0)
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		t = time.Unix(int64(v&(1<<34-1)), int64(v>>34))
+	case 12:
+		t = time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b)))
+	default:
+		return nil, fmt.Errorf("invalid MessagePack timestamp of %d bytes", n)
+	}
+	return rest, enc.WriteToken(jsontext.String(t.UTC().Format(time.RFC3339Nano)))
+}
@@ -0,0 +1,76 @@
+package mlambda
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// NDJSONContentType is the media-type for newline-delimited JSON.
+const NDJSONContentType = "application/x-ndjson"
+
+// NDJSONWriter writes a stream of newline-delimited JSON records.
+//
+// Each record is flushed as soon as it is written, so consumers see
+// records as they are produced. Writes block while the consumer is
+// not keeping up (the response is sent through a pipe), which
+// naturally slows a producer to the pace of the consumer.
+type NDJSONWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	buf     []byte
+	records int
+	err     error
+}
+
+// NewNDJSONWriter returns a writer emitting records to w. The context
+// is checked before each record, so producers stop once the invocation
+// is canceled or times out.
+//
+// If w is an http.ResponseWriter with no content-type set, the
+// content-type is set to NDJSONContentType.
+func NewNDJSONWriter(ctx context.Context, w io.Writer) *NDJSONWriter {
+	if rw, ok := w.(http.ResponseWriter); ok && rw.Header().Get("Content-Type") == "" {
+		rw.Header().Set("Content-Type", NDJSONContentType)
+	}
+	return &NDJSONWriter{ctx: ctx, w: w}
+}
+
+// Encode writes v as a single JSON record followed by a newline, and
+// flushes it. Once Encode returns an error all further calls return
+// the same error.
+func (n *NDJSONWriter) Encode(v any) error {
+	if n.err != nil {
+		return n.err
+	}
+	if err := n.ctx.Err(); err != nil {
+		n.err = err
+		return err
+	}
+
+	buf, err := jsonv2.Marshal(v)
+	if err != nil {
+		// a bad record doesn't corrupt the stream
+		return err
+	}
+	n.buf = append(append(n.buf[:0], buf...), '\n')
+
+	if _, err := n.w.Write(n.buf); err != nil {
+		n.err = err
+		return err
+	}
+	if err := Flush(n.w); err != nil {
+		n.err = err
+		return err
+	}
+
+	n.records++
+	return nil
+}
+
+// Records returns the number of records written.
+func (n *NDJSONWriter) Records() int {
+	return n.records
+}
@@ -0,0 +1,202 @@
+package mlambda
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// payloadFormat is the shape of an HTTP event, which determines the
+// shape of the response.
+type payloadFormat int
+
+const (
+	// payloadV2 is the API Gateway HTTP API (payload version 2.0)
+	// and function-URL format.
+	payloadV2 payloadFormat = iota
+
+	// payloadV1 is the API Gateway REST API (and HTTP API payload
+	// version 1.0) format.
+	payloadV1
+
+	// payloadALB is the Application Load Balancer format, with
+	// single-value headers.
+	payloadALB
+
+	// payloadALBMultiValue is the Application Load Balancer format
+	// for target-groups with multi-value headers enabled.
+	payloadALBMultiValue
+)
+
+// format sniffs the payload format of the event.
+func (r *httpRequest) format() payloadFormat {
+	switch {
+	case r.RequestContext.ELB != nil:
+		if r.MultiValueHeaders != nil || r.MultiValueQueryStringParameters != nil {
+			return payloadALBMultiValue
+		}
+		return payloadALB
+	case r.Version == "2.0":
+		return payloadV2
+	case r.HttpMethod != "":
+		return payloadV1
+	}
+	return payloadV2
+}
+
+// normalize fills in the version 2.0 fields of a version 1.0 or ALB
+// event, so the rest of the adapter only deals with one shape.
+func (r *httpRequest) normalize(f payloadFormat) {
+	rc := &r.RequestContext
+	switch f {
+	case payloadV1:
+		// the path and query-parameters are decoded
+		r.RawPath = (&url.URL{Path: r.Path}).EscapedPath()
+		q := url.Values(r.MultiValueQueryStringParameters)
+		if q == nil {
+			q = url.Values{}
+			for k, v := range r.QueryStringParameters {
+				q.Set(k, v)
+			}
+		}
+		r.RawQueryString = q.Encode()
+		rc.Http.Method = r.HttpMethod
+		rc.Http.Path = r.Path
+		rc.Http.Protocol = rc.Protocol
+		rc.Http.SourceIP = rc.Identity.SourceIP
+		rc.Http.UserAgent = rc.Identity.UserAgent
+		if rc.RouteKey == "" && r.Resource != "" {
+			rc.RouteKey = r.HttpMethod + " " + r.Resource
+		}
+		if a := v1Authorizer(rc); a != nil {
+			rc.Authorizer, _ = jsonv2.Marshal(a)
+		}
+	case payloadALB, payloadALBMultiValue:
+		// the path and query-parameters are passed as the client
+		// sent them
+		r.RawPath = r.Path
+		r.RawQueryString = rawQuery(r.MultiValueQueryStringParameters, r.QueryStringParameters)
+		rc.Http.Method = r.HttpMethod
+		rc.Http.Path = r.Path
+		rc.Http.Protocol = "HTTP/1.1"
+		rc.Http.SourceIP = albSourceIP(r)
+		rc.DomainName = albHeader(r, "host")
+	}
+}
+
+// v1Authorizer returns the authorizer of a version 1.0 event in the
+// version 2.0 shape, or nil if there is none. Cognito user-pool claims
+// are at the top of the authorizer, a lambda authorizer's context is
+// flattened into it alongside the principalId, and IAM callers are
+// described by the identity.
+func v1Authorizer(rc *httpRequestContext) *Authorizer {
+	var fields map[string]jsontext.Value
+	if len(rc.Authorizer) > 0 {
+		// an authorizer we don't understand is treated as absent
+		_ = jsonv2.Unmarshal(rc.Authorizer, &fields)
+	}
+	for k, v := range fields {
+		if v.Kind() == 'n' {
+			delete(fields, k)
+		}
+	}
+
+	switch id := &rc.Identity; {
+	case fields["jwt"] != nil || fields["lambda"] != nil || fields["iam"] != nil:
+		// already in the version 2.0 shape
+		return nil
+	case fields["claims"].Kind() == '{':
+		var claims map[string]jsontext.Value
+		if err := jsonv2.Unmarshal(fields["claims"], &claims); err != nil {
+			return nil
+		}
+		jwt := &JWTAuthorizer{Claims: make(map[string]string, len(claims))}
+		for k, v := range claims {
+			// claims are strings, but other values are kept as
+			// their JSON text
+			if v.Kind() == '"' {
+				var s string
+				_ = jsonv2.Unmarshal(v, &s)
+				jwt.Claims[k] = s
+			} else {
+				jwt.Claims[k] = string(v)
+			}
+		}
+		_ = jsonv2.Unmarshal(fields["scopes"], &jwt.Scopes)
+		return &Authorizer{JWT: jwt}
+	case len(fields) > 0:
+		var lambda map[string]any
+		if err := jsonv2.Unmarshal(rc.Authorizer, &lambda); err != nil {
+			return nil
+		}
+		return &Authorizer{Lambda: lambda}
+	case id.UserArn != "" || id.AccessKey != "":
+		iam := &IAMAuthorizer{
+			AccessKey:      id.AccessKey,
+			AccountID:      id.AccountID,
+			CallerID:       id.Caller,
+			PrincipalOrgID: id.PrincipalOrgID,
+			UserArn:        id.UserArn,
+			UserID:         id.User,
+		}
+		if id.CognitoIdentityID != "" {
+			iam.CognitoIdentity = &struct {
+				AMR            []string `json:"amr"`
+				IdentityID     string   `json:"identityId"`
+				IdentityPoolID string   `json:"identityPoolId"`
+			}{IdentityID: id.CognitoIdentityID, IdentityPoolID: id.CognitoIdentityPoolID}
+		}
+		return &Authorizer{IAM: iam}
+	}
+	return nil
+}
+
+// rawQuery joins query-parameters which have not been decoded.
+func rawQuery(multi map[string][]string, single map[string]string) string {
+	if multi == nil {
+		multi = make(map[string][]string, len(single))
+		for k, v := range single {
+			multi[k] = []string{v}
+		}
+	}
+	keys := make([]string, 0, len(multi))
+	for k := range multi {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range multi[k] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(k)
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// albHeader returns the last value of a request-header of an ALB
+// event. ALB sends header-names in lower-case.
+func albHeader(r *httpRequest, name string) string {
+	if vs := r.MultiValueHeaders[name]; len(vs) > 0 {
+		return vs[len(vs)-1]
+	}
+	return r.Headers[name]
+}
+
+// albSourceIP returns the client address of an ALB event, which the
+// load balancer appends to X-Forwarded-For.
+func albSourceIP(r *httpRequest) string {
+	xff := albHeader(r, "x-forwarded-for")
+	if i := strings.LastIndexByte(xff, ','); i >= 0 {
+		xff = xff[i+1:]
+	}
+	return strings.TrimSpace(xff)
+}
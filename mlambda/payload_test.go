@@ -0,0 +1,54 @@
+package mlambda
+
+import (
+	"testing"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+func TestV1AuthorizerPrincipal(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestContext string
+		want           string
+	}{
+		{
+			name:           "cognito user-pool",
+			requestContext: `{"authorizer":{"claims":{"sub":"user-1","email_verified":"true"}}}`,
+			want:           "user-1",
+		},
+		{
+			name:           "lambda",
+			requestContext: `{"authorizer":{"principalId":"user-2","integrationLatency":12}}`,
+			want:           "user-2",
+		},
+		{
+			name:           "iam",
+			requestContext: `{"identity":{"accessKey":"AKIDEXAMPLE","userArn":"arn:aws:iam::123456789012:user/alice"}}`,
+			want:           "arn:aws:iam::123456789012:user/alice",
+		},
+		{
+			name:           "cognito identity",
+			requestContext: `{"identity":{"accessKey":"ASIAEXAMPLE","userArn":"arn:aws:sts::123456789012:assumed-role/r/s","cognitoIdentityId":"us-east-1:abc"}}`,
+			want:           "us-east-1:abc",
+		},
+		{
+			name:           "none",
+			requestContext: `{"identity":{"userArn":null},"authorizer":{"claims":null,"scopes":null}}`,
+			want:           "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r httpRequest
+			event := `{"httpMethod":"GET","path":"/","requestContext":` + tt.requestContext + `}`
+			if err := jsonv2.Unmarshal([]byte(event), &r, jsonv2.RejectUnknownMembers(false)); err != nil {
+				t.Fatal(err)
+			}
+			r.normalize(r.format())
+			if got := newGatewayContext(&r).Authorizer.Principal(); got != tt.want {
+				t.Errorf("got principal %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package mlambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// ErrDropRecord is returned by a pipe-enrichment function to leave the
+// record out of the enriched batch, so it is not sent to the target.
+var ErrDropRecord = errors.New("drop record")
+
+// PipeRecord is a record of an EventBridge Pipes batch, such as an SQS
+// message or a Kinesis record, as a set of raw JSON members. Enrichment
+// functions can read and replace individual members while passing the
+// rest (such as the source's metadata) through unchanged.
+type PipeRecord map[string]jsontext.Value
+
+// Decode unmarshals the member name into the value pointed to by into.
+// It returns an error if the record has no such member.
+func (p PipeRecord) Decode(name string, into any) error {
+	v, ok := p[name]
+	if !ok {
+		return fmt.Errorf("record has no %q", name)
+	}
+	return jsonv2.Unmarshal(v, into)
+}
+
+// Set replaces (or adds) the member name.
+func (p PipeRecord) Set(name string, v any) error {
+	b, err := jsonv2.Marshal(v)
+	if err != nil {
+		return err
+	}
+	p[name] = b
+	return nil
+}
+
+// PipeEnrichmentHandler adapts a function to handle the enrichment step
+// of an EventBridge pipe. Pipes invokes the function with a batch of
+// records (a JSON array), and passes on the array it returns.
+//
+// f is called with each record in order, and its results are returned
+// in the same order. Records for which f returns ErrDropRecord are left
+// out; any other error fails the invocation, so the pipe retries the
+// whole batch. Use PipeRecord as In and Out to modify records while
+// preserving their metadata.
+func PipeEnrichmentHandler[In, Out any](f func(ctx context.Context, in In) (Out, error)) Handler {
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		var batch []In
+		if err := jsonv2.UnmarshalRead(r.Body, &batch); err != nil {
+			return fmt.Errorf("decoding batch: %s", err)
+		}
+		out := make([]Out, 0, len(batch))
+		for i, in := range batch {
+			o, err := f(ctx, in)
+			if errors.Is(err, ErrDropRecord) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("enriching record %d: %w", i, err)
+			}
+			out = append(out, o)
+		}
+		return jsonv2.MarshalWrite(w, out)
+	})
+}
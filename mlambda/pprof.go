@@ -0,0 +1,222 @@
+package mlambda
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// Profiling is enabled by setting the MLAMBDA_PPROF environment variable
+// to a true value (such as "1").
+//
+// When serving locally the net/http/pprof handlers are mounted
+// under /debug/pprof/.
+//
+// When running in AWS the server recognizes a "profile event" of the form:
+//
+//	{"mlambdaProfile": {"kind": "cpu", "invocations": 10}}
+//
+// and, instead of invoking the handler, captures the requested profile
+// and passes it to the Server's ProfileSink (or writes it to /tmp). The
+// kind may be "cpu" or any profile known to runtime/pprof (such as
+// "heap", "goroutine", or "allocs").
+//
+// A CPU profile taken while answering the profile event would only see
+// an idle process, so instead the event starts the profiler and it runs
+// over the next "invocations" events (default one). It is stopped and
+// sent to the sink once they complete, or when one of them approaches
+// its deadline.
+const profileEnvVar = "MLAMBDA_PPROF"
+
+// maxProfileEventSize bounds how much of an event we peek at
+// when looking for a profile event.
+const maxProfileEventSize = 512
+
+// ProfileSinkFunc receives a captured profile. The name is suitable
+// for use as a file-name or object-key.
+type ProfileSinkFunc func(ctx context.Context, name string, profile []byte) error
+
+func profilingEnabled() bool {
+	v, _ := strconv.ParseBool(os.Getenv(profileEnvVar))
+	return v
+}
+
+// pprofHandler serves the net/http/pprof endpoints.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+type profileEvent struct {
+	MlambdaProfile *struct {
+		Kind        string `json:"kind"`
+		Invocations int    `json:"invocations"`
+	} `json:"mlambdaProfile"`
+}
+
+// cpuCapture is a CPU profile being collected across invocations.
+type cpuCapture struct {
+	mu sync.Mutex
+	// buf is nil unless a profile is being collected.
+	buf       *bytes.Buffer
+	remaining int
+}
+
+// peekProfileEvent checks if the event in body is a profile-event. The
+// returned reader must be used in place of body for further reads.
+func peekProfileEvent(body io.Reader) (*profileEvent, io.Reader) {
+	br := bufio.NewReaderSize(body, maxProfileEventSize)
+	peeked, err := br.Peek(maxProfileEventSize)
+	if !errors.Is(err, io.EOF) || !bytes.Contains(peeked, []byte(`"mlambdaProfile"`)) {
+		// too big or not interesting
+		return nil, br
+	}
+
+	var ev profileEvent
+	if err := jsonv2.Unmarshal(peeked, &ev); err != nil || ev.MlambdaProfile == nil {
+		return nil, br
+	}
+	return &ev, br
+}
+
+// captureProfile collects a runtime/pprof profile, returning the
+// serialized profile.
+func captureProfile(kind string) ([]byte, error) {
+	var buf bytes.Buffer
+	p := runtimepprof.Lookup(kind)
+	if p == nil {
+		return nil, fmt.Errorf("unknown profile %q", kind)
+	}
+	if err := p.WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeProfile hands a profile to the configured sink, returning
+// a description of where the profile was sent.
+func (s *Server) writeProfile(ctx context.Context, kind string, profile []byte) (string, error) {
+	name := fmt.Sprintf("%s-%s.pprof", kind, time.Now().UTC().Format("20060102T150405.000Z"))
+	if s.ProfileSink != nil {
+		return name, s.ProfileSink(ctx, name, profile)
+	}
+	path := filepath.Join(os.TempDir(), name)
+	return path, os.WriteFile(path, profile, 0o644)
+}
+
+// startCPUProfile starts a CPU profile which runs over the next
+// invocations events.
+func (s *Server) startCPUProfile(invocations int) error {
+	c := &s.cpuProfile
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.buf != nil {
+		return errors.New("a CPU profile is already being captured")
+	}
+	buf := &bytes.Buffer{}
+	if err := runtimepprof.StartCPUProfile(buf); err != nil {
+		return err
+	}
+	c.buf = buf
+	c.remaining = max(invocations, 1)
+	return nil
+}
+
+// cpuProfiledInvocation is called when an invocation covered by a CPU
+// profile completes. The profile is stopped and written once the last
+// of them is done.
+func (s *Server) cpuProfiledInvocation(ctx context.Context) {
+	c := &s.cpuProfile
+	c.mu.Lock()
+	if c.buf == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.remaining--
+	done := c.remaining <= 0
+	c.mu.Unlock()
+
+	if done {
+		s.stopCPUProfile(ctx)
+	}
+}
+
+// stopCPUProfile stops a running CPU profile, if any, and writes it to
+// the sink.
+func (s *Server) stopCPUProfile(ctx context.Context) {
+	c := &s.cpuProfile
+	c.mu.Lock()
+	buf := c.buf
+	if buf != nil {
+		runtimepprof.StopCPUProfile()
+		c.buf = nil
+	}
+	c.mu.Unlock()
+	if buf == nil {
+		return
+	}
+
+	location, err := s.writeProfile(ctx, "cpu", buf.Bytes())
+	if err != nil {
+		s.logger().LogAttrs(ctx, slog.LevelWarn, "writing CPU profile",
+			slog.String("error", err.Error()))
+		return
+	}
+	s.logger().LogAttrs(ctx, slog.LevelInfo, "wrote CPU profile",
+		slog.String("location", location),
+		slog.Int("bytes", buf.Len()))
+}
+
+// handleProfileEvent responds to a profile-event.
+func (s *Server) handleProfileEvent(ctx context.Context, w io.Writer, ev *profileEvent) error {
+	kind := ev.MlambdaProfile.Kind
+
+	var resp struct {
+		Kind        string `json:"kind"`
+		Location    string `json:"location,omitempty"`
+		Bytes       int    `json:"bytes,omitzero"`
+		Invocations int    `json:"invocations,omitzero"`
+	}
+	resp.Kind = kind
+
+	if kind == "cpu" {
+		invocations := max(ev.MlambdaProfile.Invocations, 1)
+		if err := s.startCPUProfile(invocations); err != nil {
+			return err
+		}
+		resp.Invocations = invocations
+		return jsonv2.MarshalWrite(w, &resp)
+	}
+
+	profile, err := captureProfile(kind)
+	if err != nil {
+		return err
+	}
+	location, err := s.writeProfile(ctx, kind, profile)
+	if err != nil {
+		return fmt.Errorf("writing profile: %s", err)
+	}
+	resp.Location = location
+	resp.Bytes = len(profile)
+	return jsonv2.MarshalWrite(w, &resp)
+}
@@ -0,0 +1,100 @@
+package mlambda
+
+import (
+	"errors"
+	"net/http"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// ProblemContentType is the media-type of RFC 7807 problem documents.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem document describing an HTTP error.
+//
+// Problem implements error, so handlers may return (or wrap) one and
+// have WriteError render it.
+type Problem struct {
+	// Type is a URI identifying the kind of problem. If empty,
+	// "about:blank" is implied.
+	Type string `json:"type,omitempty"`
+
+	// Title is a short, human-readable summary of the kind of problem.
+	Title string `json:"title,omitempty"`
+
+	// Status is the HTTP status-code.
+	Status int `json:"status,omitempty"`
+
+	// Detail is an explanation specific to this occurrence.
+	Detail string `json:"detail,omitempty"`
+
+	// Instance identifies this occurrence. WriteProblem fills it in
+	// with the lambda request-id if it is empty.
+	Instance string `json:"instance,omitempty"`
+
+	// Extensions are additional members of the problem document.
+	Extensions map[string]any `json:",inline"`
+}
+
+// Error implements error.
+func (p *Problem) Error() string {
+	title := p.Title
+	if title == "" {
+		title = http.StatusText(p.Status)
+	}
+	if p.Detail != "" {
+		return title + ": " + p.Detail
+	}
+	return title
+}
+
+// NewProblem returns a problem with the given status, titled with
+// the standard status-text.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Status: status,
+		Title:  http.StatusText(status),
+		Detail: detail,
+	}
+}
+
+// ProblemError is implemented by application-errors which know how to
+// describe themselves as a problem document.
+type ProblemError interface {
+	error
+	Problem() *Problem
+}
+
+// WriteProblem writes p as the response.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	doc := *p
+	if doc.Status == 0 {
+		doc.Status = http.StatusInternalServerError
+	}
+	if doc.Instance == "" {
+		if id := RequestIDFromContext(r.Context()); id != "" {
+			doc.Instance = "urn:lambda:request:" + id
+		}
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(doc.Status)
+	_ = jsonv2.MarshalWrite(w, &doc)
+}
+
+// WriteError converts err to a problem document and writes it as the
+// response. A *Problem or ProblemError in err's chain is used as-is;
+// other errors produce a generic 500 response which does not disclose
+// the error.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *Problem
+	var pe ProblemError
+	switch {
+	case errors.As(err, &p):
+	case errors.As(err, &pe):
+		p = pe.Problem()
+	default:
+		p = NewProblem(http.StatusInternalServerError, "")
+	}
+	WriteProblem(w, r, p)
+}
@@ -0,0 +1,105 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// ProtoContentType is the content-type of protobuf HTTP bodies.
+const ProtoContentType = "application/x-protobuf"
+
+// ProtoOptions configures NewProtoHandler. The package does not depend
+// on a protobuf library, so Marshal and Unmarshal (which are required)
+// adapt one, for example:
+//
+//	mlambda.ProtoOptions{
+//		Marshal: func(m any) ([]byte, error) {
+//			return proto.Marshal(m.(proto.Message))
+//		},
+//		Unmarshal: func(b []byte, m any) error {
+//			return proto.Unmarshal(b, m.(proto.Message))
+//		},
+//	}
+type ProtoOptions struct {
+	// Marshal serializes a response message.
+	Marshal func(m any) ([]byte, error)
+
+	// Unmarshal parses b into the request message m.
+	Unmarshal func(b []byte, m any) error
+}
+
+// NewProtoHandler adapts a function taking and returning protobuf
+// messages to a Handler. It accepts two kinds of events:
+//
+//   - A JSON string holding the base64-encoded request, as sent when
+//     invoking the function directly. The response is likewise a JSON
+//     string holding the base64-encoded response message.
+//   - An HTTP event, from a function URL or API Gateway, whose body is
+//     the raw request message. The response body is the raw response
+//     message, with the content-type ProtoContentType. Errors from f
+//     are sent as problem documents (see WriteError).
+//
+// For direct invocations, an event which cannot be decoded, or an error
+// from f, fails the invocation.
+func NewProtoHandler[I any, In interface{ *I }, Out any](f func(ctx context.Context, in In) (Out, error), opts ProtoOptions) Handler {
+	if opts.Marshal == nil || opts.Unmarshal == nil {
+		panic("mlambda: ProtoOptions requires Marshal and Unmarshal")
+	}
+
+	call := func(ctx context.Context, b []byte) ([]byte, error) {
+		in := In(new(I))
+		if err := opts.Unmarshal(b, in); err != nil {
+			return nil, NewProblem(http.StatusBadRequest, fmt.Sprintf("decoding message: %s", err))
+		}
+		out, err := f(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return opts.Marshal(out)
+	}
+
+	httpHandler := HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		resp, err := call(r.Context(), b)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", ProtoContentType)
+		_, _ = w.Write(resp)
+	}))
+
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		event, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("reading event: %s", err)
+		}
+		if trimmed := bytes.TrimSpace(event); len(trimmed) == 0 || trimmed[0] != '"' {
+			return httpHandler.Invoke(ctx, w, &Request{Body: bytes.NewReader(event)})
+		}
+
+		var encoded string
+		if err := jsonv2.Unmarshal(event, &encoded); err != nil {
+			return fmt.Errorf("decoding event: %s", err)
+		}
+		b, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("decoding event: %s", err)
+		}
+		resp, err := call(ctx, b)
+		if err != nil {
+			return err
+		}
+		return jsonv2.MarshalWrite(w, base64.StdEncoding.EncodeToString(resp))
+	})
+}
@@ -0,0 +1,140 @@
+package mlambda
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRateLimitKeys is the default for RateLimitOptions.MaxKeys.
+const defaultRateLimitKeys = 10000
+
+// RateLimitOptions configures RateLimit.
+type RateLimitOptions struct {
+	// Rate is the sustained number of requests allowed per second for
+	// each key, and Burst the number allowed at once. If Burst is less
+	// than one, one is used.
+	Rate  float64
+	Burst int
+
+	// Key returns the key a request is limited by. If nil, the source
+	// IP address is used. Requests with an empty key are not limited.
+	Key func(r *http.Request) string
+
+	// MaxKeys bounds the number of keys tracked. If it is zero, 10000
+	// is used.
+	MaxKeys int
+
+	// Problem is the response to limited requests. If nil, a generic
+	// 429 problem is used.
+	Problem *Problem
+}
+
+// RateLimit returns middleware allowing each key (by default each
+// source IP) Rate requests per second, with bursts of up to Burst.
+// Limited requests receive a 429 with a Retry-After header.
+//
+// The limits are held in memory, so each lambda execution-environment
+// enforces them separately: the limit seen by a client is multiplied
+// by the number of environments serving it. It is a defence against
+// a single abusive client, not a quota.
+func RateLimit(h http.Handler, opts RateLimitOptions) http.Handler {
+	if opts.Rate <= 0 {
+		return h
+	}
+	if opts.Burst < 1 {
+		opts.Burst = 1
+	}
+	if opts.Key == nil {
+		opts.Key = sourceIP
+	}
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = defaultRateLimitKeys
+	}
+	if opts.Problem == nil {
+		opts.Problem = NewProblem(http.StatusTooManyRequests, "")
+	}
+	rl := &rateLimiter{opts: opts, buckets: map[string]*tokenBucket{}}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := opts.Key(r)
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if wait := rl.take(key, time.Now()); wait > 0 {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			WriteProblem(w, r, opts.Problem)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// sourceIP returns the client address of a request. Requests from API
+// Gateway carry the source IP (without a port) as their RemoteAddr.
+func sourceIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+type rateLimiter struct {
+	opts RateLimitOptions
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds the tokens available to a key as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token for key, returning how long to wait for one if
+// none are available.
+func (rl *rateLimiter) take(key string, now time.Time) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= rl.opts.MaxKeys {
+			rl.prune(now)
+		}
+		b = &tokenBucket{tokens: float64(rl.opts.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	b.refill(now, rl.opts.Rate, rl.opts.Burst)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rl.opts.Rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// prune forgets keys whose buckets have refilled, which behave the same
+// as new keys. If every key is still limited, all are forgotten.
+func (rl *rateLimiter) prune(now time.Time) {
+	for key, b := range rl.buckets {
+		b.refill(now, rl.opts.Rate, rl.opts.Burst)
+		if b.tokens >= float64(rl.opts.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+	if len(rl.buckets) >= rl.opts.MaxKeys {
+		clear(rl.buckets)
+	}
+}
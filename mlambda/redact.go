@@ -0,0 +1,176 @@
+package mlambda
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// Redacted replaces sensitive values.
+const Redacted = "[REDACTED]"
+
+// Redactor masks sensitive data before it is logged or reported. It is
+// used by the debug-dumper (see MLAMBDA_DEBUG), the access-log middleware,
+// and when reporting errors to the lambda service.
+type Redactor interface {
+	// RedactHeader returns the value to log for an HTTP header.
+	RedactHeader(name string, value string) string
+
+	// RedactJSON returns data with sensitive values masked. The data
+	// may be truncated (and so invalid) JSON. It may modify data in
+	// place.
+	RedactJSON(data []byte) []byte
+
+	// RedactText returns free-form text, such as an error message,
+	// with sensitive values masked.
+	RedactText(s string) string
+}
+
+// RedactFunc adapts a function to a Redactor, applying it to JSON and
+// text. Headers are passed through unchanged.
+type RedactFunc func(data []byte) []byte
+
+// RedactHeader implements Redactor.
+func (f RedactFunc) RedactHeader(name string, value string) string {
+	return value
+}
+
+// RedactJSON implements Redactor.
+func (f RedactFunc) RedactJSON(data []byte) []byte {
+	return f(data)
+}
+
+// RedactText implements Redactor.
+func (f RedactFunc) RedactText(s string) string {
+	return string(f([]byte(s)))
+}
+
+var _ Redactor = (RedactFunc)(nil)
+
+// DefaultRedactor masks common credentials: the Authorization, Cookie,
+// Set-Cookie, and X-Api-Key headers (including where they appear in
+// API Gateway events), and bearer-tokens in text.
+var DefaultRedactor Redactor = &PathRedactor{
+	Headers: []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"},
+	Paths: []string{
+		"headers.authorization",
+		"headers.cookie",
+		"headers.x-api-key",
+		"multiValueHeaders.authorization",
+		"multiValueHeaders.cookie",
+		"multiValueHeaders.x-api-key",
+		"cookies",
+	},
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)bearer\s+[a-z0-9._~+/=-]+`),
+	},
+}
+
+// PathRedactor is a configurable Redactor.
+type PathRedactor struct {
+	// Headers lists the HTTP headers to mask.
+	Headers []string
+
+	// Paths lists the JSON values to mask, as dot-separated object
+	// keys. A "*" matches any key or array-element. Object keys are
+	// matched case-insensitively.
+	//
+	// If the JSON cannot be parsed (for example because it is
+	// truncated) string-values with the same final key are masked
+	// wherever they appear.
+	Paths []string
+
+	// Patterns are masked wherever they are found in text.
+	Patterns []*regexp.Regexp
+}
+
+// RedactHeader implements Redactor.
+func (p *PathRedactor) RedactHeader(name string, value string) string {
+	name = http.CanonicalHeaderKey(name)
+	for _, h := range p.Headers {
+		if http.CanonicalHeaderKey(h) == name {
+			return Redacted
+		}
+	}
+	return value
+}
+
+// RedactText implements Redactor.
+func (p *PathRedactor) RedactText(s string) string {
+	for _, re := range p.Patterns {
+		s = re.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
+
+// RedactJSON implements Redactor.
+func (p *PathRedactor) RedactJSON(data []byte) []byte {
+	if len(p.Paths) == 0 {
+		return data
+	}
+
+	var v any
+	if err := jsonv2.Unmarshal(data, &v); err != nil {
+		return p.redactUnparsed(data)
+	}
+
+	for _, path := range p.Paths {
+		redactPath(v, strings.Split(path, "."))
+	}
+	out, err := jsonv2.Marshal(v)
+	if err != nil {
+		return p.redactUnparsed(data)
+	}
+	return out
+}
+
+// redactUnparsed masks string-values by key when the JSON can't be
+// parsed.
+func (p *PathRedactor) redactUnparsed(data []byte) []byte {
+	for _, path := range p.Paths {
+		key := path[strings.LastIndex(path, ".")+1:]
+		if key == "*" {
+			continue
+		}
+		re := regexp.MustCompile(`(?i)("` + regexp.QuoteMeta(key) + `"\s*:\s*)("(?:[^"\\]|\\.)*"|\[[^\]]*\])`)
+		data = re.ReplaceAll(data, []byte(`${1}"`+Redacted+`"`))
+	}
+	return data
+}
+
+// redactPath masks the values at path within v.
+func redactPath(v any, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	last := len(path) == 1
+
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if path[0] != "*" && !strings.EqualFold(path[0], k) {
+				continue
+			}
+			if last {
+				v[k] = Redacted
+				continue
+			}
+			redactPath(child, path[1:])
+		}
+	case []any:
+		if path[0] != "*" {
+			return
+		}
+		for i, child := range v {
+			if last {
+				v[i] = Redacted
+				continue
+			}
+			redactPath(child, path[1:])
+		}
+	}
+}
+
+var _ Redactor = (*PathRedactor)(nil)
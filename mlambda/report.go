@@ -0,0 +1,94 @@
+package mlambda
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// invocationReport summarizes a single invocation, and is logged
+// once the invocation is complete.
+type invocationReport struct {
+	requestId     string
+	start         time.Time
+	duration      time.Duration
+	responseBytes int64
+	coldStart     bool
+	errorType     string
+	errorMessage  string
+	memStats      *MemStats
+	timings       stageTimings
+	http          httpOutcome
+}
+
+// billedDuration estimates the billed duration, which lambda rounds
+// up to the next millisecond.
+func (r *invocationReport) billedDuration() time.Duration {
+	return (r.duration + time.Millisecond - 1).Truncate(time.Millisecond)
+}
+
+// logReport emits the end-of-invocation log line. Similar to the platform's
+// REPORT line, but from the perspective of the runtime.
+func (s *Server) logReport(ctx context.Context, r *invocationReport) {
+	if !s.ReportSampling.shouldLog(r.errorType != "", r.coldStart) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("requestId", r.requestId),
+		slog.Duration("duration", r.duration),
+		slog.Int64("billedDurationMs", r.billedDuration().Milliseconds()),
+		slog.Int64("responseBytes", r.responseBytes),
+		slog.Bool("coldStart", r.coldStart),
+	}
+	if r.coldStart {
+		attrs = append(attrs, slog.Duration("initDuration", s.initDuration))
+	}
+	if r.errorType != "" {
+		attrs = append(attrs, slog.String("errorType", r.errorType))
+	}
+	attrs = append(attrs, slog.Any("timings", &r.timings))
+	if r.memStats != nil {
+		attrs = append(attrs, slog.Any("memStats", *r.memStats))
+	}
+	s.logger().LogAttrs(ctx, slog.LevelInfo, "REPORT", attrs...)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var _ io.Reader = (*countingReader)(nil)
+
+// countingWriter counts the bytes written through it. The count may be
+// read while writes are in progress.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Flush implements Flusher.
+func (c *countingWriter) Flush() error {
+	return Flush(c.w)
+}
+
+var _ io.Writer = (*countingWriter)(nil)
+var _ Flusher = (*countingWriter)(nil)
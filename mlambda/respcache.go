@@ -0,0 +1,399 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCacheOptions configures CacheResponses.
+type ResponseCacheOptions struct {
+	// Name identifies the cache in metrics (see Cache). The default is
+	// "responses".
+	Name string
+
+	// TTL is how long responses are fresh, unless they set a max-age
+	// (or s-maxage) in their Cache-Control header. The default is one
+	// minute.
+	TTL time.Duration
+
+	// StaleWhileRevalidate is how long after becoming stale a response
+	// may still be served, while it is refreshed in the background.
+	// Zero disables it.
+	StaleWhileRevalidate time.Duration
+
+	// Vary names request-headers which select different responses,
+	// such as "Accept". Responses with a Vary header naming others are
+	// not cached.
+	Vary []string
+
+	// Key, if set, adds to the cache-key of a request, such as to
+	// cache responses per tenant. Requests with an Authorization
+	// header are only cached if Key is set.
+	Key func(r *http.Request) string
+
+	// MaxEntries bounds the number of cached responses (default 1000),
+	// and MaxBodySize the size of each (default 1MiB).
+	MaxEntries  int
+	MaxBodySize int
+}
+
+// CacheResponses returns middleware which caches the responses of h to
+// GET requests, keyed by path, query, the Vary request-headers, and
+// opts.Key. It is for read-heavy endpoints served without API Gateway
+// caching, such as through function URLs or an ALB.
+//
+// Responses with a 200, 203, 204, 301, 404, or 410 status are cached,
+// unless they set cookies or their Cache-Control forbids it. Only the
+// headers set by h are cached, so headers set per-request by outer
+// middleware (such as HttpOptions.RequestIDHeader) are not replayed.
+// Cached responses are served with an Age header, and an X-Cache
+// header of "HIT", "STALE", or "MISS". Conditional requests are
+// answered from the cache's ETag and Last-Modified with a 304.
+//
+// The cache is held in memory, so each execution-environment has its
+// own. Background revalidation only progresses while the environment
+// is handling invocations.
+func CacheResponses(h http.Handler, opts ResponseCacheOptions) http.Handler {
+	if opts.Name == "" {
+		opts.Name = "responses"
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	rc := &responseCache{
+		h:    h,
+		opts: opts,
+		cache: &Cache[string, *cachedResponse]{
+			Name:       opts.Name,
+			MaxEntries: opts.MaxEntries,
+		},
+		revalidating: map[string]bool{},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+			(r.Header.Get("Authorization") != "" && opts.Key == nil) ||
+			hasCacheDirective(r.Header, "no-store") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key := rc.key(r)
+
+		now := time.Now()
+		if cr, ok := rc.cache.Get(r.Context(), key); ok && now.Before(cr.staleUntil) {
+			status := "HIT"
+			if !now.Before(cr.freshUntil) {
+				status = "STALE"
+				rc.revalidate(r, key)
+			}
+			cr.write(w, r, status, now)
+			return
+		}
+		if r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &responseCapture{w: w, base: w.Header().Clone(), limit: opts.MaxBodySize}
+		w.Header().Set("X-Cache", "MISS")
+		h.ServeHTTP(rec, r)
+		rc.store(key, rec)
+	})
+}
+
+type responseCache struct {
+	h     http.Handler
+	opts  ResponseCacheOptions
+	cache *Cache[string, *cachedResponse]
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+// key returns the cache-key of a request.
+func (rc *responseCache) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.URL.EscapedPath())
+	b.WriteByte('?')
+	b.WriteString(r.URL.Query().Encode())
+	for _, name := range rc.opts.Vary {
+		b.WriteString("\x00")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	if rc.opts.Key != nil {
+		b.WriteString("\x00")
+		b.WriteString(rc.opts.Key(r))
+	}
+	return b.String()
+}
+
+// revalidate refreshes a stale response in the background, unless it
+// is already being refreshed.
+func (rc *responseCache) revalidate(r *http.Request, key string) {
+	rc.mu.Lock()
+	if rc.revalidating[key] {
+		rc.mu.Unlock()
+		return
+	}
+	rc.revalidating[key] = true
+	rc.mu.Unlock()
+
+	r = r.Clone(context.WithoutCancel(r.Context()))
+	r.Method = http.MethodGet
+	// the refreshed response is cached whole
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+	go func() {
+		defer func() {
+			rc.mu.Lock()
+			delete(rc.revalidating, key)
+			rc.mu.Unlock()
+			if err := recover(); err != nil {
+				slog.ErrorContext(r.Context(), "revalidating cached response", "error", err)
+			}
+		}()
+		rec := &responseCapture{header: http.Header{}, limit: rc.opts.MaxBodySize}
+		rc.h.ServeHTTP(rec, r)
+		rc.store(key, rec)
+	}()
+}
+
+// cacheableStatuses are the statuses of responses which are cached.
+var cacheableStatuses = []int{200, 203, 204, 301, 404, 410}
+
+// store caches a captured response, if it may be cached.
+func (rc *responseCache) store(key string, rec *responseCapture) {
+	header := rec.header
+	if header == nil && rec.w != nil {
+		// the handler wrote nothing
+		header = rec.handlerHeader()
+	}
+	if rec.overflow || !slices.Contains(cacheableStatuses, rec.statusCode()) ||
+		header.Get("Set-Cookie") != "" ||
+		hasCacheDirective(header, "no-store") || hasCacheDirective(header, "private") || hasCacheDirective(header, "no-cache") {
+		return
+	}
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !slices.ContainsFunc(rc.opts.Vary, func(s string) bool { return strings.EqualFold(s, name) }) {
+				return
+			}
+		}
+	}
+
+	ttl := rc.opts.TTL
+	if maxAge, ok := cacheMaxAge(header); ok {
+		ttl = maxAge
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	header = header.Clone()
+	header.Del("X-Cache")
+	header.Del("Age")
+	rc.cache.Set(key, &cachedResponse{
+		status:     rec.statusCode(),
+		header:     header,
+		body:       bytes.Clone(rec.body.Bytes()),
+		stored:     now,
+		freshUntil: now.Add(ttl),
+		staleUntil: now.Add(ttl + rc.opts.StaleWhileRevalidate),
+	})
+}
+
+type cachedResponse struct {
+	status     int
+	header     http.Header
+	body       []byte
+	stored     time.Time
+	freshUntil time.Time
+	staleUntil time.Time
+}
+
+func (cr *cachedResponse) write(w http.ResponseWriter, r *http.Request, status string, now time.Time) {
+	h := w.Header()
+	for k, v := range cr.header {
+		h[k] = slices.Clone(v)
+	}
+	h.Set("Age", strconv.Itoa(int(now.Sub(cr.stored)/time.Second)))
+	h.Set("X-Cache", status)
+	if cr.notModified(r) {
+		for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			h.Del(k)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(cr.status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(cr.body)
+	}
+}
+
+// notModified reports if the request's If-None-Match or
+// If-Modified-Since header is satisfied by the cached response.
+func (cr *cachedResponse) notModified(r *http.Request) bool {
+	if cr.status != http.StatusOK {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		// If-Modified-Since is ignored when If-None-Match is present
+		etag := strings.TrimPrefix(cr.header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(cr.header.Get("Last-Modified"))
+	return err == nil && !modified.After(ims)
+}
+
+// hasCacheDirective reports if a Cache-Control header contains a
+// directive.
+func hasCacheDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if strings.EqualFold(name, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheMaxAge returns the s-maxage or max-age of a Cache-Control
+// header.
+func cacheMaxAge(h http.Header) (time.Duration, bool) {
+	var maxAge time.Duration
+	var found bool
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			secs, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil {
+				continue
+			}
+			switch strings.ToLower(name) {
+			case "s-maxage":
+				return time.Duration(secs) * time.Second, true
+			case "max-age":
+				maxAge, found = time.Duration(secs)*time.Second, true
+			}
+		}
+	}
+	return maxAge, found
+}
+
+// responseCapture records a response (up to limit bytes of its body)
+// while passing it on to w. If w is nil, the response is only recorded.
+// Headers of w which are unchanged from base (those set before the
+// handler ran) are not recorded.
+type responseCapture struct {
+	w        http.ResponseWriter
+	base     http.Header
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// Header implements http.ResponseWriter.
+func (c *responseCapture) Header() http.Header {
+	if c.w == nil {
+		return c.header
+	}
+	return c.w.Header()
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (c *responseCapture) WriteHeader(statusCode int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = statusCode
+	if c.w != nil {
+		c.header = c.handlerHeader()
+		c.w.WriteHeader(statusCode)
+	}
+}
+
+// handlerHeader returns the headers of w set by the handler.
+func (c *responseCapture) handlerHeader() http.Header {
+	h := c.w.Header().Clone()
+	for k, v := range h {
+		if slices.Equal(v, c.base[k]) {
+			delete(h, k)
+		}
+	}
+	return h
+}
+
+// Write implements http.ResponseWriter.
+func (c *responseCapture) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflow {
+		if c.body.Len()+len(p) > c.limit {
+			c.overflow = true
+			c.body = bytes.Buffer{}
+		} else {
+			c.body.Write(p)
+		}
+	}
+	if c.w == nil {
+		return len(p), nil
+	}
+	return c.w.Write(p)
+}
+
+// Flush implements http.Flusher.
+func (c *responseCapture) Flush() {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.w != nil {
+		_ = Flush(c.w)
+	}
+}
+
+// Unwrap supports http.ResponseController.
+func (c *responseCapture) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+// statusCode returns the response status, which is 200 if the handler
+// never wrote anything.
+func (c *responseCapture) statusCode() int {
+	if c.status == 0 {
+		return http.StatusOK
+	}
+	return c.status
+}
+
+var _ http.ResponseWriter = (*responseCapture)(nil)
+var _ http.Flusher = (*responseCapture)(nil)
@@ -0,0 +1,127 @@
+package mlambda
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy decides whether, and after how long, to retry a failed
+// operation.
+type RetryPolicy interface {
+	// Backoff is called after attempt (counting from 1) has failed,
+	// with the delay before that attempt (zero for the first). It
+	// returns how long to wait before trying again, or false to give
+	// up.
+	Backoff(attempt int, prev time.Duration) (time.Duration, bool)
+}
+
+// ExponentialBackoff doubles the delay between attempts, from Base up
+// to Max, with "full jitter": each delay is chosen at random between
+// zero and its exponential value, so clients retrying together spread
+// out.
+type ExponentialBackoff struct {
+	// MaxAttempts is how many times an operation is tried, including
+	// the first. The default is 3.
+	MaxAttempts int
+
+	// Base is the first delay (default 100ms) and Max the largest
+	// (default 5s).
+	Base time.Duration
+	Max  time.Duration
+
+	// NoJitter disables jitter, so delays are exactly Base, 2*Base,
+	// 4*Base, and so on.
+	NoJitter bool
+}
+
+// Backoff implements RetryPolicy.
+func (b ExponentialBackoff) Backoff(attempt int, prev time.Duration) (time.Duration, bool) {
+	if attempt >= b.maxAttempts() {
+		return 0, false
+	}
+	base, ceiling := b.bounds()
+	d := ceiling
+	// compared against the shifted ceiling, as a large base shifted
+	// far enough overflows
+	if shift := attempt - 1; base <= ceiling>>shift {
+		d = base << shift
+	}
+	if !b.NoJitter {
+		d = rand.N(d + 1)
+	}
+	return d, true
+}
+
+func (b ExponentialBackoff) maxAttempts() int {
+	if b.MaxAttempts <= 0 {
+		return 3
+	}
+	return b.MaxAttempts
+}
+
+func (b ExponentialBackoff) bounds() (time.Duration, time.Duration) {
+	base, ceiling := b.Base, b.Max
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	if ceiling <= 0 {
+		ceiling = 5 * time.Second
+	}
+	return base, max(base, ceiling)
+}
+
+var _ RetryPolicy = ExponentialBackoff{}
+
+// DecorrelatedJitter chooses each delay at random between Base and
+// three times the previous delay, capped at Max. It spreads retries
+// more evenly than ExponentialBackoff while still backing off quickly.
+type DecorrelatedJitter struct {
+	// MaxAttempts, Base, and Max are as for ExponentialBackoff.
+	MaxAttempts int
+	Base        time.Duration
+	Max         time.Duration
+}
+
+// Backoff implements RetryPolicy.
+func (b DecorrelatedJitter) Backoff(attempt int, prev time.Duration) (time.Duration, bool) {
+	e := ExponentialBackoff{MaxAttempts: b.MaxAttempts, Base: b.Base, Max: b.Max}
+	if attempt >= e.maxAttempts() {
+		return 0, false
+	}
+	base, ceiling := e.bounds()
+	upper := ceiling
+	if prev <= ceiling/3 {
+		upper = max(base, 3*prev)
+	}
+	return min(ceiling, base+rand.N(upper-base+1)), true
+}
+
+var _ RetryPolicy = DecorrelatedJitter{}
+
+// NoRetry is a RetryPolicy which never retries.
+var NoRetry RetryPolicy = ExponentialBackoff{MaxAttempts: 1}
+
+// retry calls f until it succeeds, it returns an error retryable
+// reports false for, or policy gives up. The last error is returned.
+func retry(ctx context.Context, policy RetryPolicy, retryable func(error) bool, f func() error) error {
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		var ok bool
+		delay, ok = policy.Backoff(attempt, delay)
+		if !ok {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
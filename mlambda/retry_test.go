@@ -0,0 +1,55 @@
+package mlambda
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ExponentialBackoff
+		attempt int
+		want    time.Duration
+		wantOK  bool
+	}{
+		{"first", ExponentialBackoff{NoJitter: true}, 1, 100 * time.Millisecond, true},
+		{"second", ExponentialBackoff{NoJitter: true}, 2, 200 * time.Millisecond, true},
+		{"out of attempts", ExponentialBackoff{NoJitter: true}, 3, 0, false},
+		{"capped", ExponentialBackoff{MaxAttempts: 10, Max: time.Second, NoJitter: true}, 9, time.Second, true},
+		{"large base", ExponentialBackoff{MaxAttempts: 100, Base: 10 * time.Second, Max: time.Hour, NoJitter: true}, 31, time.Hour, true},
+		{"large base, last shift", ExponentialBackoff{MaxAttempts: 100, Base: 10 * time.Second, Max: time.Hour, NoJitter: true}, 64, time.Hour, true},
+		{"large base, past shift", ExponentialBackoff{MaxAttempts: 1000, Base: 10 * time.Second, Max: time.Hour, NoJitter: true}, 99, time.Hour, true},
+		{"base above max", ExponentialBackoff{MaxAttempts: 100, Base: time.Minute, Max: time.Second, NoJitter: true}, 40, time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.policy.Backoff(tt.attempt, 0)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+
+			// jittered delays stay between zero and the delay
+			jittered := tt.policy
+			jittered.NoJitter = false
+			for range 100 {
+				got, _ := jittered.Backoff(tt.attempt, 0)
+				if got < 0 || got > tt.want {
+					t.Fatalf("got jittered delay %v, want at most %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	policy := DecorrelatedJitter{MaxAttempts: 100, Base: 10 * time.Second, Max: time.Duration(1<<62 - 1)}
+	var prev time.Duration
+	for attempt := 1; attempt < 100; attempt++ {
+		d, ok := policy.Backoff(attempt, prev)
+		if !ok || d < policy.Base || d > policy.Max {
+			t.Fatalf("attempt %d: got %v, %v, want a delay between Base and Max", attempt, d, ok)
+		}
+		prev = d
+	}
+}
@@ -0,0 +1,97 @@
+package mlambda
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// routeLabel holds the route of a request, which may be set by the
+// handler after middleware has started handling the request.
+type routeLabel struct {
+	route string
+}
+
+type routeLabelKey struct{}
+
+// withRouteLabel returns a request whose context can hold a route-label,
+// re-using an existing label-holder if there is one.
+func withRouteLabel(r *http.Request) (*http.Request, *routeLabel) {
+	if l, ok := r.Context().Value(routeLabelKey{}).(*routeLabel); ok {
+		return r, l
+	}
+	l := &routeLabel{}
+	return r.WithContext(context.WithValue(r.Context(), routeLabelKey{}, l)), l
+}
+
+// SetRoute labels the request with a route (such as "GET /thing/{id}"),
+// for use by RouteMetrics and other middleware. It is only needed when
+// the route cannot be determined automatically.
+func SetRoute(r *http.Request, route string) {
+	if l, ok := r.Context().Value(routeLabelKey{}).(*routeLabel); ok {
+		l.route = route
+	}
+}
+
+// routeOf determines the route of a request to h. An explicit label (see
+// SetRoute) takes precedence, followed by the matching pattern if h is
+// an *http.ServeMux.
+func routeOf(h http.Handler, r *http.Request, label *routeLabel) string {
+	if label != nil && label.route != "" {
+		return label.route
+	}
+	if mux, ok := h.(*http.ServeMux); ok {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return pattern
+		}
+	}
+	return "unknown"
+}
+
+// RouteMetricsOptions configures RouteMetrics.
+type RouteMetricsOptions struct {
+	// Metrics receives the measurements. If nil, the Metrics of the
+	// current invocation is used (see MetricsFromContext).
+	Metrics Metrics
+
+	// Route labels requests. If nil, requests are labeled as
+	// described by SetRoute.
+	Route func(r *http.Request) string
+}
+
+// RouteMetrics returns middleware recording, for each request, a
+// "RouteRequests" count and a "RouteLatency" measurement with "Route"
+// and "StatusClass" (such as "2xx") dimensions.
+func RouteMetrics(h http.Handler, opts RouteMetricsOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, label := withRouteLabel(r)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+
+		var route string
+		if opts.Route != nil {
+			route = opts.Route(r)
+		} else {
+			route = routeOf(h, r, label)
+		}
+
+		ctx := r.Context()
+		m := opts.Metrics
+		if m == nil {
+			var ok bool
+			if m, ok = MetricsFromContext(ctx); !ok {
+				return
+			}
+		}
+
+		dims := []Dimension{
+			{Name: "Route", Value: route},
+			{Name: "StatusClass", Value: strconv.Itoa(rec.statusCode()/100) + "xx"},
+		}
+		m.Record(ctx, "RouteRequests", 1, UnitCount, dims...)
+		m.Record(ctx, "RouteLatency", float64(time.Since(start))/float64(time.Millisecond), UnitMilliseconds, dims...)
+	})
+}
@@ -0,0 +1,41 @@
+package mlambda
+
+import (
+	"cmp"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var s3Service = awsapi.Service{
+	SigningName: "s3",
+}
+
+// s3URL returns the URL of an object in a bucket (or of the bucket, if
+// key is empty).
+func s3URL(c *awsapi.Client, bucket string, key string) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint(s3Service))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.Endpoints[s3Service.SigningName]; ok {
+		// overridden endpoints (such as local emulators) use path-style
+		u.Path = "/" + bucket
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path += "/" + key
+	return u, nil
+}
+
+// s3Error builds an *awsapi.APIError from an S3 XML error-response.
+func s3Error(resp *http.Response, body []byte) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	_ = xml.Unmarshal(body, &e)
+	return &awsapi.APIError{StatusCode: resp.StatusCode, Code: cmp.Or(e.Code, resp.Status), Message: e.Message}
+}
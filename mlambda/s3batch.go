@@ -0,0 +1,149 @@
+package mlambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// S3 Batch Operations result-codes.
+const (
+	S3BatchSucceeded        = "Succeeded"
+	S3BatchTemporaryFailure = "TemporaryFailure"
+	S3BatchPermanentFailure = "PermanentFailure"
+)
+
+// S3BatchJob describes the S3 Batch Operations job a task belongs to.
+type S3BatchJob struct {
+	ID           string
+	InvocationID string
+
+	// SchemaVersion is the invocation schema-version, "1.0" or
+	// "2.0".
+	SchemaVersion string
+
+	// UserArguments are the job's user-arguments (only with schema
+	// 2.0).
+	UserArguments map[string]string
+}
+
+// S3BatchTask is an object to process.
+type S3BatchTask struct {
+	TaskID    string
+	Bucket    string
+	Key       string
+	VersionID string
+}
+
+// S3BatchFunc processes a task, returning a result-string to include in
+// the job's completion report. Errors fail the task permanently, unless
+// wrapped with RetryS3BatchTask.
+type S3BatchFunc func(ctx context.Context, job *S3BatchJob, task *S3BatchTask) (string, error)
+
+type retryS3BatchTask struct {
+	err error
+}
+
+// Error implements error.
+func (e *retryS3BatchTask) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *retryS3BatchTask) Unwrap() error {
+	return e.err
+}
+
+// RetryS3BatchTask marks an error returned by an S3BatchFunc as
+// temporary, so S3 retries the task.
+func RetryS3BatchTask(err error) error {
+	return &retryS3BatchTask{err: err}
+}
+
+// S3BatchHandler adapts a function to handle S3 Batch Operations
+// invocations, calling it for each task and reporting each result.
+// Both invocation schema-versions (1.0 and 2.0) are supported, and the
+// response uses the version of the invocation.
+func S3BatchHandler(f S3BatchFunc) Handler {
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		var ev struct {
+			InvocationSchemaVersion string `json:"invocationSchemaVersion"`
+			InvocationID            string `json:"invocationId"`
+			Job                     struct {
+				ID            string            `json:"id"`
+				UserArguments map[string]string `json:"userArguments"`
+			} `json:"job"`
+			Tasks []struct {
+				TaskID      string `json:"taskId"`
+				S3Key       string `json:"s3Key"`
+				S3VersionID string `json:"s3VersionId"`
+				S3BucketARN string `json:"s3BucketArn"` // 1.0
+				S3Bucket    string `json:"s3Bucket"`    // 2.0
+			} `json:"tasks"`
+		}
+		if err := jsonv2.UnmarshalRead(r.Body, &ev); err != nil {
+			return fmt.Errorf("decoding event: %s", err)
+		}
+		if ev.InvocationSchemaVersion != "1.0" && ev.InvocationSchemaVersion != "2.0" {
+			return fmt.Errorf("unsupported invocation schema-version %q", ev.InvocationSchemaVersion)
+		}
+		job := &S3BatchJob{
+			ID:            ev.Job.ID,
+			InvocationID:  ev.InvocationID,
+			SchemaVersion: ev.InvocationSchemaVersion,
+			UserArguments: ev.Job.UserArguments,
+		}
+
+		type result struct {
+			TaskID       string `json:"taskId"`
+			ResultCode   string `json:"resultCode"`
+			ResultString string `json:"resultString"`
+		}
+		results := make([]result, 0, len(ev.Tasks))
+		for _, t := range ev.Tasks {
+			res := result{TaskID: t.TaskID, ResultCode: S3BatchSucceeded}
+
+			// keys are URL-encoded, with spaces as "+"
+			key, err := url.QueryUnescape(t.S3Key)
+			if err == nil {
+				task := &S3BatchTask{
+					TaskID:    t.TaskID,
+					Bucket:    taskBucket(t.S3Bucket, t.S3BucketARN),
+					Key:       key,
+					VersionID: t.S3VersionID,
+				}
+				res.ResultString, err = f(ctx, job, task)
+			}
+
+			var retry *retryS3BatchTask
+			switch {
+			case errors.As(err, &retry):
+				res.ResultCode, res.ResultString = S3BatchTemporaryFailure, err.Error()
+			case err != nil:
+				res.ResultCode, res.ResultString = S3BatchPermanentFailure, err.Error()
+			}
+			results = append(results, res)
+		}
+
+		return jsonv2.MarshalWrite(w, map[string]any{
+			"invocationSchemaVersion": ev.InvocationSchemaVersion,
+			"treatMissingKeysAs":      S3BatchPermanentFailure,
+			"invocationId":            ev.InvocationID,
+			"results":                 results,
+		})
+	})
+}
+
+// taskBucket returns the bucket of a task: its name, or the name in its
+// ARN ("arn:aws:s3:::bucket").
+func taskBucket(name string, arn string) string {
+	if name != "" {
+		return name
+	}
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
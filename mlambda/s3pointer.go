@@ -0,0 +1,256 @@
+package mlambda
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+// s3PointerClass is the marker used by the AWS "extended client"
+// libraries (such as the SQS and SNS extended clients) for payloads
+// which have been offloaded to S3.
+const s3PointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+// maxPointerSize bounds how much of an event we peek at when looking
+// for an S3 pointer.
+const maxPointerSize = 2048
+
+// defaultMaxPayloadSize is the default for
+// DereferenceOptions.MaxPayloadSize.
+const defaultMaxPayloadSize = 32 << 20
+
+// PayloadStore fetches offloaded payloads. S3PayloadStore implements it
+// with S3 GetObject.
+type PayloadStore interface {
+	Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
+}
+
+// S3PayloadStore is a PayloadStore reading payloads with S3 GetObject.
+// The function needs s3:GetObject on the buckets the pointers refer to.
+type S3PayloadStore struct {
+	Client *awsapi.Client
+}
+
+// Get implements PayloadStore.
+func (s *S3PayloadStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	u, err := s3URL(s.Client, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Client.Do(ctx, s3Service, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		b, err := io.ReadAll(io.LimitReader(resp.Body, maxPointerSize))
+		if err != nil {
+			return nil, err
+		}
+		return nil, s3Error(resp, b)
+	}
+	return resp.Body, nil
+}
+
+var _ PayloadStore = (*S3PayloadStore)(nil)
+
+// DereferenceOptions configures DereferencePayloadsWithOptions.
+type DereferenceOptions struct {
+	// MaxPayloadSize bounds the bytes read for an event, including
+	// the payloads its pointers refer to, so a pointer to a huge
+	// object fails with ErrEventTooLarge rather than exhausting the
+	// function's memory. The default is 32MiB.
+	MaxPayloadSize int64
+}
+
+// DereferencePayloads wraps h so that S3 pointers, as produced by the
+// AWS extended client libraries:
+//
+//	["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"key"}]
+//
+// are replaced with the referenced payload before h is invoked. The
+// pointer may be the whole event, the body of a record in an SQS event
+// (Records[].body), or the message of a record in an SNS event
+// (Records[].Sns.Message). Other events are passed through unchanged.
+func DereferencePayloads(h Handler, store PayloadStore) Handler {
+	return DereferencePayloadsWithOptions(h, store, DereferenceOptions{})
+}
+
+// DereferencePayloadsWithOptions is like DereferencePayloads, with
+// additional options.
+func DereferencePayloadsWithOptions(h Handler, store PayloadStore, opts DereferenceOptions) Handler {
+	if opts.MaxPayloadSize <= 0 {
+		opts.MaxPayloadSize = defaultMaxPayloadSize
+	}
+
+	return HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		br := bufio.NewReaderSize(r.Body, maxPointerSize)
+		peeked, err := br.Peek(maxPointerSize)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+
+		r2 := *r
+		r2.Body = br
+		if bucket, key, ok := parseS3Pointer(peeked); ok && errors.Is(err, io.EOF) {
+			payload, err := store.Get(ctx, bucket, key)
+			if err != nil {
+				return fmt.Errorf("fetching payload s3://%s/%s: %s", bucket, key, err)
+			}
+			defer payload.Close()
+
+			r2.Body = &eventLimitReader{r: payload, limit: opts.MaxPayloadSize}
+			return h.Invoke(ctx, w, &r2)
+		}
+
+		if trimmed := bytes.TrimSpace(peeked); len(trimmed) > 0 && trimmed[0] == '{' {
+			// records may hold pointers, so the event is read whole
+			limit := &eventLimitReader{r: br, limit: opts.MaxPayloadSize}
+			event, err := io.ReadAll(limit)
+			if err != nil {
+				return err
+			}
+			if bytes.Contains(event, []byte(s3PointerClass)) {
+				// the payloads share what is left of the limit
+				limit.r = nil
+				event, err = dereferenceRecords(ctx, store, event, limit)
+				if err != nil {
+					return err
+				}
+			}
+			r2.Body = bytes.NewReader(event)
+		}
+		return h.Invoke(ctx, w, &r2)
+	})
+}
+
+// dereferenceRecords replaces the S3 pointers in the records of an SQS
+// or SNS event. The payloads are read through limit.
+func dereferenceRecords(ctx context.Context, store PayloadStore, event []byte, limit *eventLimitReader) ([]byte, error) {
+	var envelope map[string]jsontext.Value
+	if err := jsonv2.Unmarshal(event, &envelope); err != nil {
+		// not ours to reject
+		return event, nil
+	}
+	var records []map[string]jsontext.Value
+	if err := jsonv2.Unmarshal(envelope["Records"], &records); err != nil {
+		return event, nil
+	}
+
+	changed := false
+	for _, record := range records {
+		// SQS
+		if v, ok, err := dereferenceString(ctx, store, record["body"], limit); err != nil {
+			return nil, err
+		} else if ok {
+			record["body"] = v
+			changed = true
+		}
+
+		// SNS
+		var sns map[string]jsontext.Value
+		if record["Sns"].Kind() != '{' || jsonv2.Unmarshal(record["Sns"], &sns) != nil {
+			continue
+		}
+		v, ok, err := dereferenceString(ctx, store, sns["Message"], limit)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		sns["Message"] = v
+		if record["Sns"], err = jsonv2.Marshal(sns, jsonv2.Deterministic(true)); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return event, nil
+	}
+
+	var err error
+	if envelope["Records"], err = jsonv2.Marshal(records, jsonv2.Deterministic(true)); err != nil {
+		return nil, err
+	}
+	return jsonv2.Marshal(envelope, jsonv2.Deterministic(true))
+}
+
+// dereferenceString fetches the payload referenced by a JSON string
+// holding an S3 pointer, returning it as a JSON string. The payload is
+// read through limit.
+func dereferenceString(ctx context.Context, store PayloadStore, v jsontext.Value, limit *eventLimitReader) (jsontext.Value, bool, error) {
+	if v.Kind() != '"' {
+		return nil, false, nil
+	}
+	var s string
+	if err := jsonv2.Unmarshal(v, &s); err != nil {
+		return nil, false, nil
+	}
+	bucket, key, ok := parseS3Pointer([]byte(s))
+	if !ok {
+		return nil, false, nil
+	}
+
+	payload, err := store.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("fetching payload s3://%s/%s: %s", bucket, key, err)
+	}
+	defer payload.Close()
+	limit.r = payload
+	b, err := io.ReadAll(limit)
+	if errors.Is(err, ErrEventTooLarge) {
+		return nil, false, err
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("fetching payload s3://%s/%s: %s", bucket, key, err)
+	}
+	nv, err := jsonv2.Marshal(string(b))
+	if err != nil {
+		return nil, false, err
+	}
+	return nv, true, nil
+}
+
+// parseS3Pointer parses an extended-client S3 pointer.
+func parseS3Pointer(b []byte) (bucket string, key string, ok bool) {
+	b = bytes.TrimSpace(b)
+	if !bytes.HasPrefix(b, []byte("[")) || !bytes.Contains(b, []byte(s3PointerClass)) {
+		return "", "", false
+	}
+
+	var envelope []jsontext.Value
+	if err := jsonv2.Unmarshal(b, &envelope); err != nil || len(envelope) != 2 {
+		return "", "", false
+	}
+
+	var class string
+	if err := jsonv2.Unmarshal(envelope[0], &class); err != nil || class != s3PointerClass {
+		return "", "", false
+	}
+
+	var pointer struct {
+		Bucket string `json:"s3BucketName"`
+		Key    string `json:"s3Key"`
+	}
+	if err := jsonv2.Unmarshal(envelope[1], &pointer); err != nil {
+		return "", "", false
+	}
+	if pointer.Bucket == "" || pointer.Key == "" {
+		return "", "", false
+	}
+	return pointer.Bucket, pointer.Key, true
+}
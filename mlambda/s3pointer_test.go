@@ -0,0 +1,107 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+func TestDereferencePayloads(t *testing.T) {
+	objects := map[string]string{
+		"/bucket/small": `{"hello":"world"}`,
+		"/bucket/large": strings.Repeat("x", 300),
+	}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		obj, ok := objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		io.WriteString(w, obj)
+	}))
+	defer hs.Close()
+
+	store := &S3PayloadStore{
+		Client: &awsapi.Client{
+			Region:      "us-east-1",
+			Credentials: testCredentials,
+			Endpoints:   map[string]string{"s3": hs.URL},
+		},
+	}
+	echo := HandlerFunc(func(ctx context.Context, w io.Writer, r *Request) error {
+		_, err := io.Copy(w, r.Body)
+		return err
+	})
+	h := DereferencePayloadsWithOptions(echo, store, DereferenceOptions{MaxPayloadSize: 200})
+
+	pointer := func(key string) string {
+		return `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"` + key + `"}]`
+	}
+	tests := []struct {
+		name    string
+		event   string
+		want    string
+		wantErr error
+		wantAPI string
+	}{
+		{
+			name:  "pointer",
+			event: pointer("small"),
+			want:  `{"hello":"world"}`,
+		},
+		{
+			name:  "sqs record",
+			event: `{"Records":[{"body":` + strconv.Quote(pointer("small")) + `}]}`,
+			want:  `{"Records":[{"body":"{\"hello\":\"world\"}"}]}`,
+		},
+		{
+			name:  "no pointer",
+			event: `{"Records":[{"body":"plain"}]}`,
+			want:  `{"Records":[{"body":"plain"}]}`,
+		},
+		{
+			name:    "too large",
+			event:   pointer("large"),
+			wantErr: ErrEventTooLarge,
+		},
+		{
+			name:    "too large in a record",
+			event:   `{"Records":[{"body":` + strconv.Quote(pointer("large")) + `}]}`,
+			wantErr: ErrEventTooLarge,
+		},
+		{
+			name:    "missing",
+			event:   pointer("missing"),
+			wantAPI: "NoSuchKey",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := h.Invoke(context.Background(), &out, &Request{Body: strings.NewReader(tt.event)})
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got %v, want %v", err, tt.wantErr)
+				}
+			case tt.wantAPI != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantAPI) {
+					t.Errorf("got %v, want %s", err, tt.wantAPI)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case out.String() != tt.want:
+				t.Errorf("got %s, want %s", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package mlambda
+
+import "math/rand/v2"
+
+// LogSampling controls which invocations (or requests) produce
+// per-invocation log output, to limit log-ingestion costs for
+// high-volume functions.
+//
+// By default failures and cold starts are always logged, and
+// successful invocations are logged at SuccessRate.
+type LogSampling struct {
+	// SuccessRate is the fraction, from 0 to 1, of successful
+	// invocations which are logged.
+	SuccessRate float64
+
+	// SampleErrors applies SuccessRate to failed invocations as
+	// well, rather than logging all of them.
+	SampleErrors bool
+
+	// SampleColdStarts applies sampling to cold starts, rather than
+	// logging all of them.
+	SampleColdStarts bool
+}
+
+// shouldLog decides if an invocation is logged. A nil *LogSampling
+// logs everything.
+func (l *LogSampling) shouldLog(failed bool, coldStart bool) bool {
+	if l == nil {
+		return true
+	}
+	if failed && !l.SampleErrors {
+		return true
+	}
+	if coldStart && !l.SampleColdStarts {
+		return true
+	}
+	return rand.Float64() < l.SuccessRate
+}
@@ -0,0 +1,51 @@
+package mlambda
+
+import "net/http"
+
+// SecurityHeaders are security-related response headers. Empty fields
+// are not set.
+type SecurityHeaders struct {
+	StrictTransportSecurity string
+	ContentSecurityPolicy   string
+	ContentTypeOptions      string
+	FrameOptions            string
+	ReferrerPolicy          string
+	PermissionsPolicy       string
+	CrossOriginOpenerPolicy string
+}
+
+// DefaultSecurityHeaders suit an API which serves no HTML: nothing may
+// be loaded, framed, or sniffed, and clients must use HTTPS for a
+// year.
+var DefaultSecurityHeaders = SecurityHeaders{
+	StrictTransportSecurity: "max-age=31536000; includeSubDomains",
+	ContentSecurityPolicy:   "default-src 'none'; frame-ancestors 'none'",
+	ContentTypeOptions:      "nosniff",
+	FrameOptions:            "DENY",
+	ReferrerPolicy:          "no-referrer",
+	CrossOriginOpenerPolicy: "same-origin",
+}
+
+// SetSecurityHeaders returns middleware which sets the given headers on
+// every response. They are set before h is called, so h may change or
+// remove them for particular responses.
+func SetSecurityHeaders(h http.Handler, headers SecurityHeaders) http.Handler {
+	set := []struct{ name, value string }{
+		{"Strict-Transport-Security", headers.StrictTransportSecurity},
+		{"Content-Security-Policy", headers.ContentSecurityPolicy},
+		{"X-Content-Type-Options", headers.ContentTypeOptions},
+		{"X-Frame-Options", headers.FrameOptions},
+		{"Referrer-Policy", headers.ReferrerPolicy},
+		{"Permissions-Policy", headers.PermissionsPolicy},
+		{"Cross-Origin-Opener-Policy", headers.CrossOriginOpenerPolicy},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wh := w.Header()
+		for _, s := range set {
+			if s.value != "" {
+				wh.Set(s.name, s.value)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,155 @@
+package mlambda
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var secretsManagerService = awsapi.Service{
+	SigningName:  "secretsmanager",
+	JSONVersion:  "1.1",
+	TargetPrefix: "secretsmanager",
+}
+
+// getSecretString returns the current string-value of a secret.
+func getSecretString(ctx context.Context, client *awsapi.Client, secretID string) (string, error) {
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	err := client.DoJSON(ctx, secretsManagerService, "GetSecretValue", map[string]string{
+		"SecretId": secretID,
+	}, &out)
+	return out.SecretString, err
+}
+
+// secretRefreshTimeout bounds background refreshes of secrets.
+const secretRefreshTimeout = 10 * time.Second
+
+// SecretCache caches the values of Secrets Manager secrets, so they
+// can be read on every invocation without calling Secrets Manager.
+//
+// Once a secret is older than TTL (default five minutes) its cached
+// value is still returned, while it is refreshed in the background, so
+// only the first read of a secret waits. If refreshing fails, the
+// cached value continues to be used. When a rotation invalidates a
+// cached value before it is refreshed, Refresh (or WithSecret) reads
+// the new value at once, so functions survive rotations without being
+// restarted.
+//
+// The function needs secretsmanager:GetSecretValue on the secrets.
+type SecretCache struct {
+	Client *awsapi.Client
+	TTL    time.Duration
+
+	mu      sync.Mutex
+	secrets map[string]*cachedSecret
+}
+
+type cachedSecret struct {
+	// mu serializes fetches of the secret
+	mu sync.Mutex
+
+	value      string
+	fetched    time.Time
+	ok         bool
+	refreshing bool
+}
+
+func (c *SecretCache) entry(secretID string) *cachedSecret {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.secrets == nil {
+		c.secrets = map[string]*cachedSecret{}
+	}
+	s, ok := c.secrets[secretID]
+	if !ok {
+		s = &cachedSecret{}
+		c.secrets[secretID] = s
+	}
+	return s
+}
+
+// GetSecret returns the value of a secret.
+func (c *SecretCache) GetSecret(ctx context.Context, secretID string) (string, error) {
+	s := c.entry(secretID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ok {
+		return c.fetch(ctx, secretID, s)
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	if time.Since(s.fetched) >= ttl && !s.refreshing {
+		s.refreshing = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), secretRefreshTimeout)
+			defer cancel()
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.refreshing = false
+			if _, err := c.fetch(ctx, secretID, s); err != nil {
+				// keep using the old value until the next refresh
+				slog.WarnContext(ctx, "refreshing secret", "error", err)
+				s.fetched = time.Now()
+			}
+		}()
+	}
+	return s.value, nil
+}
+
+// Refresh reads the current value of a secret, replacing any cached
+// value. Concurrent calls share one read.
+func (c *SecretCache) Refresh(ctx context.Context, secretID string) (string, error) {
+	start := time.Now()
+	s := c.entry(secretID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ok && s.fetched.After(start) {
+		// refreshed while we waited
+		return s.value, nil
+	}
+	return c.fetch(ctx, secretID, s)
+}
+
+// WithSecret calls f with the value of a secret. If f fails with an
+// error for which isAuthFailure reports true (such as a database
+// rejecting a rotated password), the secret is refreshed and f called
+// again with the new value.
+func (c *SecretCache) WithSecret(ctx context.Context, secretID string, isAuthFailure func(error) bool, f func(secret string) error) error {
+	v, err := c.GetSecret(ctx, secretID)
+	if err != nil {
+		return err
+	}
+	err = f(v)
+	if err == nil || !isAuthFailure(err) {
+		return err
+	}
+	nv, rerr := c.Refresh(ctx, secretID)
+	if rerr != nil {
+		slog.WarnContext(ctx, "refreshing secret after auth failure", "error", rerr)
+		return err
+	}
+	if nv == v {
+		return err
+	}
+	return f(nv)
+}
+
+// fetch reads a secret into s, which must be locked.
+func (c *SecretCache) fetch(ctx context.Context, secretID string, s *cachedSecret) (string, error) {
+	v, err := getSecretString(ctx, c.Client, secretID)
+	if err != nil {
+		return "", fmt.Errorf("getting secret %s: %s", secretID, err)
+	}
+	s.value, s.fetched, s.ok = v, time.Now(), true
+	return v, nil
+}
@@ -0,0 +1,49 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownFlushTimeout bounds the flush at shutdown. The lambda service
+// allows 500ms between SIGTERM and SIGKILL when internal extensions
+// are registered.
+const shutdownFlushTimeout = 400 * time.Millisecond
+
+// startShutdownFlush registers an internal extension, which causes the
+// lambda service to send SIGTERM to the process before shutting it down,
+// and runs the registered flush- and shutdown-functions when the signal
+// arrives.
+//
+// Internal extensions can't register for the SHUTDOWN event itself, so
+// the extension registers for no events.
+func (s *Server) startShutdownFlush(ctx context.Context, endpoint string) {
+	ec := newExtensionClient(s.client.client, endpoint)
+	if err := ec.register(ctx, "mlambda-shutdown", nil); err != nil {
+		s.logger().LogAttrs(ctx, slog.LevelWarn, "registering shutdown extension failed",
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	// the extension must request its next event to signal it has
+	// initialized. Having registered for no events, the request
+	// blocks until the process exits.
+	go func() {
+		_, _ = ec.nextEvent(ctx)
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		s.logger().LogAttrs(ctx, slog.LevelInfo, "shutting down")
+		s.logHistory(ctx, "shutdown")
+		s.flush(ctx, shutdownFlushTimeout, true)
+		os.Exit(0)
+	}()
+}
@@ -0,0 +1,54 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewLogHandler wraps h so that every record logged with the context of
+// an invocation is annotated with the invocation's requestId,
+// functionArn, and coldStart attributes (see LambdaContext).
+//
+// Setting the result as the default handler (slog.SetDefault) correlates
+// output from any code logging with slog and a context, including
+// third-party libraries.
+//
+// If the handler has been given a group (with WithGroup), the attributes
+// are added within that group.
+func NewLogHandler(h slog.Handler) slog.Handler {
+	return &logHandler{h: h}
+}
+
+type logHandler struct {
+	h slog.Handler
+}
+
+// Enabled implements slog.Handler.
+func (l *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return l.h.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (l *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if lc, ok := FromContext(ctx); ok {
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String("requestId", lc.RequestID),
+			slog.String("functionArn", lc.InvokedFunctionArn),
+			slog.Bool("coldStart", lc.ColdStart),
+		)
+	}
+	return l.h.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (l *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{h: l.h.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (l *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{h: l.h.WithGroup(name)}
+}
+
+var _ slog.Handler = (*logHandler)(nil)
@@ -0,0 +1,363 @@
+package mlambda
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	jsonv2 "github.com/go-json-experiment/json"
+)
+
+// requestBody returns a reader for the request-body in an http-event,
+// along with the decoded length.
+//
+// If the body is larger than spillThreshold (and spillThreshold is
+// positive) it is decoded to a temporary file, which is removed when
+// the returned reader is closed.
+func requestBody(body string, isBase64 bool, strictBase64 bool, spillThreshold int64, spillDir string) (io.ReadCloser, int64, error) {
+	size := int64(len(body))
+	enc := base64.StdEncoding
+	if isBase64 {
+		enc, body = base64Body(body, strictBase64)
+		size = int64(base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(body, "="))))
+	}
+
+	if spillThreshold <= 0 || size <= spillThreshold {
+		b := []byte(body)
+		if isBase64 {
+			var err error
+			b, err = enc.DecodeString(body)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return io.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+	}
+
+	var src io.Reader = strings.NewReader(body)
+	if isBase64 {
+		src = base64.NewDecoder(enc, src)
+	}
+
+	f, err := os.CreateTemp(spillDir, "mlambda-body-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	spilled := &spillFile{f}
+
+	n, err := io.Copy(f, src)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spilled.Close()
+		return nil, 0, err
+	}
+
+	return spilled, n, nil
+}
+
+// spillFile is a temporary file which is removed on Close.
+type spillFile struct {
+	*os.File
+}
+
+// Close implements io.Closer.
+func (f *spillFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
+
+// decodeHttpEventSpilling decodes an http-event, streaming the content
+// of its "body" member to a temporary file (once it is larger than
+// spillThreshold) as the event is read, rather than holding it in
+// memory. It returns the request-body, its decoded length, and the
+// length of the body member as sent.
+func decodeHttpEventSpilling(r io.Reader, into *httpRequest, strictBase64 bool, spillThreshold int64, spillDir string) (io.ReadCloser, int64, int64, error) {
+	raw := &spillBuffer{threshold: spillThreshold, dir: spillDir}
+	ex := &bodyExtractor{r: r, sink: raw}
+	if err := jsonv2.UnmarshalRead(ex, into); err != nil {
+		raw.Close()
+		return nil, 0, 0, err
+	}
+	if !ex.found {
+		// the body wasn't found where it was expected (such as with
+		// an escaped member-name), so was decoded in full
+		raw.Close()
+		body, n, err := requestBody(into.Body, into.IsBase64Encoded, strictBase64, spillThreshold, spillDir)
+		return body, n, int64(len(into.Body)), err
+	}
+	rawSize := raw.n
+	if !into.IsBase64Encoded {
+		body, err := raw.reader()
+		return body, rawSize, rawSize, err
+	}
+
+	if raw.f == nil {
+		body, n, err := requestBody(raw.buf.String(), true, strictBase64, 0, "")
+		return body, n, rawSize, err
+	}
+	defer raw.Close()
+	if _, err := raw.f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	var src io.Reader = raw.f
+	enc := base64.StdEncoding
+	if !strictBase64 {
+		src = &lenientBase64Reader{r: src}
+		enc = base64.RawStdEncoding
+	}
+	decoded := &spillBuffer{threshold: spillThreshold, dir: spillDir}
+	if _, err := io.Copy(decoded, base64.NewDecoder(enc, src)); err != nil {
+		decoded.Close()
+		return nil, 0, 0, err
+	}
+	body, err := decoded.reader()
+	return body, decoded.n, rawSize, err
+}
+
+// spillBuffer holds written data in memory until there is more than
+// threshold bytes, then moves it to a temporary file.
+type spillBuffer struct {
+	threshold int64
+	dir       string
+
+	buf bytes.Buffer
+	f   *os.File
+	n   int64
+}
+
+// Write implements io.Writer.
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.f == nil && b.n+int64(len(p)) > b.threshold {
+		f, err := os.CreateTemp(b.dir, "mlambda-body-*")
+		if err != nil {
+			return 0, err
+		}
+		b.f = f
+		if _, err := b.f.Write(b.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		b.buf = bytes.Buffer{}
+	}
+	var n int
+	var err error
+	if b.f != nil {
+		n, err = b.f.Write(p)
+	} else {
+		n, err = b.buf.Write(p)
+	}
+	b.n += int64(n)
+	return n, err
+}
+
+// reader returns a reader for the written data, which must be closed.
+func (b *spillBuffer) reader() (io.ReadCloser, error) {
+	if b.f == nil {
+		return io.NopCloser(bytes.NewReader(b.buf.Bytes())), nil
+	}
+	spilled := &spillFile{b.f}
+	if _, err := b.f.Seek(0, io.SeekStart); err != nil {
+		spilled.Close()
+		return nil, err
+	}
+	return spilled, nil
+}
+
+// Close discards the written data.
+func (b *spillBuffer) Close() error {
+	b.buf = bytes.Buffer{}
+	if b.f == nil {
+		return nil
+	}
+	return (&spillFile{b.f}).Close()
+}
+
+// lenientBase64Reader maps URL-safe base64 to the standard alphabet,
+// and drops padding, for decoding with base64.RawStdEncoding. See
+// base64Body.
+type lenientBase64Reader struct {
+	r io.Reader
+}
+
+// Read implements io.Reader.
+func (l *lenientBase64Reader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	k := 0
+	for _, c := range p[:n] {
+		switch c {
+		case '=':
+			continue
+		case '-':
+			c = '+'
+		case '_':
+			c = '/'
+		}
+		p[k] = c
+		k++
+	}
+	return k, err
+}
+
+// bodyExtractor reads an http-event (a JSON object) from r, writing the
+// unescaped content of its top-level "body" member to sink, and passing
+// on the rest of the event with an empty body.
+type bodyExtractor struct {
+	r     io.Reader
+	sink  io.Writer
+	found bool
+
+	// the position in the event
+	depth     int
+	inString  bool
+	escaped   bool
+	expectKey bool
+	key       []byte
+	keyDone   bool // the last string at depth 1 was a member-name
+	diverting bool
+
+	// escape-sequence being decoded while diverting
+	hex       []byte
+	surrogate rune
+	out       []byte
+}
+
+// Read implements io.Reader.
+func (e *bodyExtractor) Read(p []byte) (int, error) {
+	for {
+		n, err := e.r.Read(p)
+		k := 0
+		e.out = e.out[:0]
+		for _, c := range p[:n] {
+			if e.diverting {
+				if !e.divert(c) {
+					continue
+				}
+				// the closing quote
+				e.diverting = false
+				e.inString = false
+			} else {
+				e.scan(c)
+			}
+			p[k] = c
+			k++
+		}
+		if len(e.out) > 0 {
+			if _, werr := e.sink.Write(e.out); werr != nil {
+				return 0, werr
+			}
+		}
+		if k > 0 || err != nil {
+			return k, err
+		}
+	}
+}
+
+// scan follows the structure of the event, outside the body.
+func (e *bodyExtractor) scan(c byte) {
+	if e.inString {
+		switch {
+		case e.escaped:
+			e.escaped = false
+		case c == '\\':
+			e.escaped = true
+		case c == '"':
+			e.inString = false
+			if e.key != nil {
+				e.keyDone = true
+				return
+			}
+		}
+		if e.key != nil {
+			e.key = append(e.key, c)
+		}
+		return
+	}
+
+	switch c {
+	case '"':
+		e.inString = true
+		if e.depth == 1 && e.expectKey {
+			e.expectKey = false
+			e.key = make([]byte, 0, 8)
+			return
+		}
+		if e.depth == 1 && e.keyDone && string(e.key) == "body" {
+			e.found = true
+			e.diverting = true
+		}
+		e.key, e.keyDone = nil, false
+	case '{', '[':
+		e.depth++
+		e.expectKey = e.depth == 1 && c == '{'
+		e.key, e.keyDone = nil, false
+	case '}', ']':
+		e.depth--
+	case ',':
+		e.expectKey = e.depth == 1
+		e.key, e.keyDone = nil, false
+	case ':', ' ', '\t', '\r', '\n':
+	default:
+		// a non-string value
+		e.key, e.keyDone = nil, false
+	}
+}
+
+// divert unescapes a byte of the body, reporting if it is the closing
+// quote.
+func (e *bodyExtractor) divert(c byte) bool {
+	switch {
+	case e.hex != nil:
+		e.hex = append(e.hex, c)
+		if len(e.hex) < 4 {
+			return false
+		}
+		v, err := strconv.ParseUint(string(e.hex), 16, 16)
+		e.hex = nil
+		if err != nil {
+			// the event is invalid, which the JSON parser reports
+			return false
+		}
+		r := rune(v)
+		switch {
+		case utf16.IsSurrogate(r) && e.surrogate == 0:
+			e.surrogate = r
+			return false
+		case e.surrogate != 0:
+			r = utf16.DecodeRune(e.surrogate, r)
+			e.surrogate = 0
+		}
+		e.out = utf8.AppendRune(e.out, r)
+	case e.escaped:
+		e.escaped = false
+		switch c {
+		case 'u':
+			e.hex = make([]byte, 0, 4)
+			return false
+		case 'b':
+			c = '\b'
+		case 'f':
+			c = '\f'
+		case 'n':
+			c = '\n'
+		case 'r':
+			c = '\r'
+		case 't':
+			c = '\t'
+		}
+		e.out = append(e.out, c)
+	case c == '\\':
+		e.escaped = true
+	case c == '"':
+		return true
+	default:
+		e.out = append(e.out, c)
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package mlambda
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var ssmService = awsapi.Service{
+	SigningName:  "ssm",
+	JSONVersion:  "1.1",
+	TargetPrefix: "AmazonSSM",
+}
+
+// getParameter returns the value of a parameter, decrypting
+// SecureString parameters.
+func getParameter(ctx context.Context, client *awsapi.Client, name string) (string, error) {
+	var out struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	err := client.DoJSON(ctx, ssmService, "GetParameter", map[string]any{
+		"Name":           name,
+		"WithDecryption": true,
+	}, &out)
+	return out.Parameter.Value, err
+}
+
+// getParametersByPath returns the parameters beneath path (recursively),
+// keyed by their names relative to it, decrypting SecureString
+// parameters.
+func getParametersByPath(ctx context.Context, client *awsapi.Client, path string) (map[string]string, error) {
+	path = "/" + strings.Trim(path, "/")
+	params := map[string]string{}
+	var next string
+	for {
+		in := map[string]any{
+			"Path":           path,
+			"Recursive":      true,
+			"WithDecryption": true,
+		}
+		if next != "" {
+			in["NextToken"] = next
+		}
+		var out struct {
+			Parameters []struct {
+				Name  string `json:"Name"`
+				Value string `json:"Value"`
+			} `json:"Parameters"`
+			NextToken string `json:"NextToken"`
+		}
+		if err := client.DoJSON(ctx, ssmService, "GetParametersByPath", in, &out); err != nil {
+			return nil, err
+		}
+		for _, p := range out.Parameters {
+			name := strings.TrimPrefix(strings.TrimPrefix(p.Name, path), "/")
+			params[name] = p.Value
+		}
+		if out.NextToken == "" {
+			return params, nil
+		}
+		next = out.NextToken
+	}
+}
+
+// ParameterConfig loads configuration from the SSM parameters beneath a
+// path, such as "/my-service/prod". Parameters are cached for TTL
+// (default five minutes), so they can be read on every invocation and
+// changes are picked up by warm functions without calling SSM each
+// time. If refreshing fails, the previous parameters continue to be
+// used.
+//
+// The function needs ssm:GetParametersByPath on the path, and
+// kms:Decrypt for SecureString parameters.
+type ParameterConfig struct {
+	Client *awsapi.Client
+	Path   string
+	TTL    time.Duration
+
+	mu      sync.Mutex
+	params  map[string]string
+	fetched time.Time
+}
+
+// Parameters returns the parameters, keyed by their names relative to
+// the path (such as "db/password").
+func (c *ParameterConfig) Parameters(ctx context.Context) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	if c.params != nil && time.Since(c.fetched) < ttl {
+		return c.params, nil
+	}
+	params, err := getParametersByPath(ctx, c.Client, c.Path)
+	if err != nil {
+		err = fmt.Errorf("getting parameters by path %s: %s", c.Path, err)
+		if c.params == nil {
+			return nil, err
+		}
+		// keep using the old parameters until the next refresh
+		slog.WarnContext(ctx, "refreshing parameters", "error", err)
+		c.fetched = time.Now()
+		return c.params, nil
+	}
+	c.params, c.fetched = params, time.Now()
+	return params, nil
+}
+
+// Load sets the fields of the struct pointed to by into from the
+// parameters. Fields are matched by their "param" tag, naming the
+// parameter relative to the path, such as:
+//
+//	type Config struct {
+//		DBPassword string        `param:"db/password,required"`
+//		Timeout    time.Duration `param:"timeout"`
+//		Hosts      []string      `param:"hosts"`
+//	}
+//
+// Fields are set as by DecodeMessageAttributes, except that
+// time.Duration fields parse a duration such as "30s" and []string
+// fields split a comma-separated StringList. Call Load at init to fail
+// fast on missing configuration, and again in handlers (into a new
+// value) to see refreshed parameters.
+func (c *ParameterConfig) Load(ctx context.Context, into any) error {
+	params, err := c.Parameters(ctx)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(into)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("loading parameters into %T: not a pointer to a struct", into)
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		tag, ok := field.Tag.Lookup("param")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		s, ok := params[name]
+		if !ok {
+			if opts == "required" {
+				return fmt.Errorf("missing parameter %q", name)
+			}
+			continue
+		}
+		if err := setParameter(v.Field(i), s); err != nil {
+			return fmt.Errorf("parameter %q: %s", name, err)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+func setParameter(f reflect.Value, s string) error {
+	t := f.Type()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		s = fmt.Sprint(int64(d))
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+		if f.Kind() == reflect.Pointer {
+			f.Set(reflect.New(t))
+			f = f.Elem()
+		}
+		f.Set(reflect.ValueOf(items).Convert(t))
+		return nil
+	}
+	return setAttribute(f, MessageAttribute{DataType: "String", StringValue: s})
+}
@@ -0,0 +1,62 @@
+package mlambda
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var stepFunctionsService = awsapi.Service{
+	SigningName:  "states",
+	JSONVersion:  "1.0",
+	TargetPrefix: "AWSStepFunctions",
+}
+
+const defaultTaskHeartbeatInterval = 30 * time.Second
+
+// StartTaskHeartbeat sends heartbeats for a Step Functions callback
+// task (with SendTaskHeartbeat) every interval (30s if zero), so the
+// state machine doesn't time the task out while the function is still
+// working on it. The interval should be well under the task's
+// HeartbeatSeconds.
+//
+// Heartbeats stop when ctx is done or stop is called. The returned
+// context is derived from ctx, and is canceled if Step Functions
+// reports that the task has timed out or no longer exists, so work on
+// it can be abandoned; context.Cause describes why. Other failures are
+// logged, and heartbeats continue.
+//
+// The function needs states:SendTaskHeartbeat.
+func StartTaskHeartbeat(ctx context.Context, client *awsapi.Client, taskToken string, interval time.Duration) (taskCtx context.Context, stop func()) {
+	if interval <= 0 {
+		interval = defaultTaskHeartbeatInterval
+	}
+	taskCtx, cancel := context.WithCancelCause(ctx)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-taskCtx.Done():
+				return
+			case <-t.C:
+			}
+
+			in := map[string]any{"taskToken": taskToken}
+			err := client.DoJSON(taskCtx, stepFunctionsService, "SendTaskHeartbeat", in, nil)
+			switch {
+			case err == nil:
+			case awsapi.IsCode(err, "TaskTimedOut"), awsapi.IsCode(err, "TaskDoesNotExist"), awsapi.IsCode(err, "InvalidToken"):
+				cancel(fmt.Errorf("task heartbeat: %s", err))
+				return
+			case taskCtx.Err() == nil:
+				slog.WarnContext(taskCtx, "sending task heartbeat", "error", err)
+			}
+		}
+	}()
+	return taskCtx, func() { cancel(context.Canceled) }
+}
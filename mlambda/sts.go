@@ -0,0 +1,219 @@
+package mlambda
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var stsService = awsapi.Service{
+	SigningName: "sts",
+}
+
+// credentialsExpiryWindow is how long before they expire assumed-role
+// credentials are refreshed, so requests signed with them don't fail
+// in flight.
+const credentialsExpiryWindow = 5 * time.Minute
+
+// AssumeRoleProvider is an awsapi.CredentialsProvider for the
+// credentials of an assumed role, such as a role in a customer's
+// account. Credentials are cached until shortly before they expire.
+//
+// The function's execution-role (the credentials of Client) must be
+// allowed to assume the role.
+type AssumeRoleProvider struct {
+	Client *awsapi.Client
+
+	RoleARN string
+
+	// ExternalID is passed to STS, as the role's trust-policy may
+	// require to prevent confused-deputy attacks.
+	ExternalID string
+
+	// SessionName identifies the session in the role's CloudTrail
+	// logs. The default is the function's name.
+	SessionName string
+
+	// Duration is how long credentials last. The default is one hour.
+	Duration time.Duration
+
+	mu    sync.Mutex
+	creds awsapi.Credentials
+}
+
+// Retrieve implements awsapi.CredentialsProvider.
+func (p *AssumeRoleProvider) Retrieve(ctx context.Context) (awsapi.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.creds.AccessKeyID != "" && !p.creds.Expired(time.Now().Add(credentialsExpiryWindow)) {
+		return p.creds, nil
+	}
+	creds, err := p.assumeRole(ctx)
+	if err != nil {
+		return awsapi.Credentials{}, fmt.Errorf("assuming role %s: %s", p.RoleARN, err)
+	}
+	p.creds = creds
+	return creds, nil
+}
+
+var _ awsapi.CredentialsProvider = (*AssumeRoleProvider)(nil)
+
+func (p *AssumeRoleProvider) assumeRole(ctx context.Context) (awsapi.Credentials, error) {
+	duration := cmp.Or(p.Duration, time.Hour)
+	form := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {p.RoleARN},
+		"RoleSessionName": {roleSessionName(cmp.Or(p.SessionName, FunctionInfoFromEnv().Name, "mlambda"))},
+		"DurationSeconds": {strconv.Itoa(int(duration / time.Second))},
+	}
+	if p.ExternalID != "" {
+		form.Set("ExternalId", p.ExternalID)
+	}
+	body := []byte(form.Encode())
+
+	r, err := http.NewRequest("POST", p.Client.Endpoint(stsService)+"/", nil)
+	if err != nil {
+		return awsapi.Credentials{}, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.Client.Do(ctx, stsService, r, body)
+	if err != nil {
+		return awsapi.Credentials{}, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return awsapi.Credentials{}, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error struct {
+				Code    string
+				Message string
+			}
+		}
+		_ = xml.Unmarshal(b, &e)
+		return awsapi.Credentials{}, &awsapi.APIError{StatusCode: resp.StatusCode, Code: cmp.Or(e.Error.Code, resp.Status), Message: e.Error.Message}
+	}
+
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleResult>Credentials"`
+	}
+	if err := xml.Unmarshal(b, &out); err != nil {
+		return awsapi.Credentials{}, fmt.Errorf("decoding response: %s", err)
+	}
+	return awsapi.Credentials{
+		AccessKeyID:     out.Credentials.AccessKeyID,
+		SecretAccessKey: out.Credentials.SecretAccessKey,
+		SessionToken:    out.Credentials.SessionToken,
+		Expires:         out.Credentials.Expiration,
+	}, nil
+}
+
+// Role is a role to assume.
+type Role struct {
+	ARN        string
+	ExternalID string
+}
+
+// AssumeRoleOptions configures AssumeRole.
+type AssumeRoleOptions struct {
+	Client *awsapi.Client
+
+	// Role returns the role to assume for a request, such as the role
+	// in the account of the request's tenant, or for its route. If it
+	// returns the zero Role, no credentials are added to the request.
+	Role func(r *http.Request) (Role, error)
+
+	// SessionName and Duration are as for AssumeRoleProvider.
+	SessionName string
+	Duration    time.Duration
+}
+
+// AssumeRole returns middleware which makes credentials for the role
+// of each request available to h from CredentialsFromContext. The role
+// is only assumed when the credentials are first used, and credentials
+// are cached across invocations.
+//
+// If opts.Role fails, the request receives a 500.
+func AssumeRole(h http.Handler, opts AssumeRoleOptions) http.Handler {
+	if opts.Client == nil || opts.Role == nil {
+		panic("mlambda: AssumeRoleOptions requires Client and Role")
+	}
+	var mu sync.Mutex
+	providers := map[Role]*AssumeRoleProvider{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, err := opts.Role(r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "choosing role to assume", "error", err)
+			WriteProblem(w, r, NewProblem(http.StatusInternalServerError, ""))
+			return
+		}
+		if role == (Role{}) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		mu.Lock()
+		p, ok := providers[role]
+		if !ok {
+			p = &AssumeRoleProvider{
+				Client:      opts.Client,
+				RoleARN:     role.ARN,
+				ExternalID:  role.ExternalID,
+				SessionName: opts.SessionName,
+				Duration:    opts.Duration,
+			}
+			providers[role] = p
+		}
+		mu.Unlock()
+
+		h.ServeHTTP(w, r.WithContext(ContextWithCredentials(r.Context(), p)))
+	})
+}
+
+type credentialsKey struct{}
+
+// ContextWithCredentials returns a context carrying credentials.
+func ContextWithCredentials(ctx context.Context, creds awsapi.CredentialsProvider) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, creds)
+}
+
+// CredentialsFromContext returns the credentials added to a request by
+// AssumeRole. Use them to call AWS on the role's behalf, with
+// awsapi.Client.WithCredentials.
+func CredentialsFromContext(ctx context.Context) (awsapi.CredentialsProvider, bool) {
+	creds, ok := ctx.Value(credentialsKey{}).(awsapi.CredentialsProvider)
+	return creds, ok
+}
+
+// roleSessionName returns a valid session-name derived from s.
+func roleSessionName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x80 && (r == '_' || r == '+' || r == '=' || r == ',' || r == '.' || r == '@' || r == '-' ||
+			'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return r
+		}
+		return '-'
+	}, s)
+	return s[:min(64, len(s))]
+}
@@ -0,0 +1,95 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultFlushTimeout = 500 * time.Millisecond
+
+// FlushFunc delivers buffered telemetry (metrics, spans, logs). It should
+// return promptly once ctx is done.
+type FlushFunc func(ctx context.Context) error
+
+// RegisterFlush adds f to the functions run at the end of every
+// invocation. They are run once the response has been sent and the
+// invocation's metrics recorded, but before the next invocation is
+// requested, as once it is requested the lambda service may freeze the
+// process indefinitely.
+//
+// RegisterFlush is safe to call concurrently, including from handlers.
+func (s *Server) RegisterFlush(f FlushFunc) {
+	s.flushMu.Lock()
+	s.flushFuncs = append(s.flushFuncs, f)
+	s.flushMu.Unlock()
+}
+
+// RegisterShutdown adds f to the functions run when the lambda service
+// shuts down the execution environment, such as to release resources
+// held across invocations. They are run with the functions registered
+// with RegisterFlush, and only if FlushOnShutdown is set.
+//
+// RegisterShutdown is safe to call concurrently, including from
+// handlers.
+func (s *Server) RegisterShutdown(f FlushFunc) {
+	s.flushMu.Lock()
+	s.shutdownFuncs = append(s.shutdownFuncs, f)
+	s.flushMu.Unlock()
+}
+
+// runFlushes runs the registered flush-functions concurrently, waiting
+// at most the flush-timeout for them to complete.
+func (s *Server) runFlushes(ctx context.Context) {
+	timeout := s.FlushTimeout
+	if timeout <= 0 {
+		timeout = defaultFlushTimeout
+	}
+	s.flush(ctx, timeout, false)
+}
+
+// flush runs the registered flush-functions (and, at shutdown, the
+// shutdown-functions) concurrently, waiting at most timeout for them
+// to complete.
+func (s *Server) flush(ctx context.Context, timeout time.Duration, shutdown bool) {
+	s.flushMu.Lock()
+	fs := s.flushFuncs
+	if shutdown {
+		fs = append(fs[:len(fs):len(fs)], s.shutdownFuncs...)
+	}
+	s.flushMu.Unlock()
+
+	if len(fs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, f := range fs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(ctx); err != nil {
+				s.logger().LogAttrs(ctx, slog.LevelWarn, "telemetry flush failed",
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger().LogAttrs(ctx, slog.LevelWarn, "telemetry flush timed out",
+			slog.Duration("timeout", timeout),
+		)
+	}
+}
@@ -0,0 +1,61 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// stageTimings records how long each stage of an invocation took, so
+// users can tell whether latency lives in their code or in the runtime.
+//
+// Adapters (such as HttpHandler) add decode and serialize timings via
+// the invocation context; the server records the rest.
+type stageTimings struct {
+	pollWait  time.Duration
+	handler   time.Duration
+	upload    time.Duration
+	decode    atomic.Int64
+	serialize atomic.Int64
+}
+
+type stageTimingsKey struct{}
+
+func contextWithStageTimings(ctx context.Context, t *stageTimings) context.Context {
+	return context.WithValue(ctx, stageTimingsKey{}, t)
+}
+
+// observeDecode adds to the time spent decoding the event.
+func observeDecode(ctx context.Context, d time.Duration) {
+	if t, ok := ctx.Value(stageTimingsKey{}).(*stageTimings); ok {
+		t.decode.Add(int64(d))
+	}
+}
+
+// observeSerialize adds to the time spent serializing the response.
+func observeSerialize(ctx context.Context, d time.Duration) {
+	if t, ok := ctx.Value(stageTimingsKey{}).(*stageTimings); ok {
+		t.serialize.Add(int64(d))
+	}
+}
+
+// LogValue implements slog.LogValuer.
+func (t *stageTimings) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Duration("pollWait", t.pollWait),
+		slog.Duration("decode", time.Duration(t.decode.Load())),
+		slog.Duration("handler", t.handler),
+		slog.Duration("serialize", time.Duration(t.serialize.Load())),
+		slog.Duration("upload", t.upload),
+	)
+}
+
+// recordTimings publishes the stage-timings to the metrics-hooks.
+func (s *Server) recordTimings(ctx context.Context, t *stageTimings) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	s.record(ctx, "PollWaitDuration", ms(t.pollWait), UnitMilliseconds)
+	s.record(ctx, "DecodeDuration", ms(time.Duration(t.decode.Load())), UnitMilliseconds)
+	s.record(ctx, "SerializeDuration", ms(time.Duration(t.serialize.Load())), UnitMilliseconds)
+	s.record(ctx, "UploadDuration", ms(t.upload), UnitMilliseconds)
+}
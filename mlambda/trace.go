@@ -0,0 +1,139 @@
+package mlambda
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceContext identifies the distributed trace (and the parent span
+// within it) which an incoming request is part of.
+//
+// https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	// TraceID is the 32 hex-digit trace-id.
+	TraceID string
+
+	// ParentID is the 16 hex-digit id of the calling span. It may be
+	// empty if the caller did not supply one.
+	ParentID string
+
+	// Sampled reports if the caller recorded its part of the trace.
+	Sampled bool
+
+	// TraceState is the vendor-specific tracestate header, if any.
+	TraceState string
+}
+
+// Traceparent formats the trace-context as a W3C traceparent header-value.
+func (t TraceContext) Traceparent() string {
+	flags := "00"
+	if t.Sampled {
+		flags = "01"
+	}
+	parent := t.ParentID
+	if parent == "" {
+		parent = "0000000000000000"
+	}
+	return "00-" + t.TraceID + "-" + parent + "-" + flags
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a copy of ctx holding t.
+func ContextWithTraceContext(ctx context.Context, t TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, t)
+}
+
+// TraceContextFromContext returns the trace-context of the incoming request,
+// if it was part of a distributed trace.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	t, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return t, ok
+}
+
+// traceContextFromHeaders extracts the trace-context from the W3C
+// traceparent/tracestate headers or, failing that, the X-Amzn-Trace-Id
+// header.
+func traceContextFromHeaders(h http.Header) (TraceContext, bool) {
+	if t, ok := parseTraceparent(h.Get("traceparent")); ok {
+		t.TraceState = h.Get("tracestate")
+		return t, true
+	}
+	return parseAmznTraceId(h.Get("X-Amzn-Trace-Id"))
+}
+
+// parseTraceparent parses a W3C traceparent header of the form:
+//
+//	00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(v string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, false
+	}
+	version, traceId, parentId, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return TraceContext{}, false
+	}
+	if !isHexId(traceId, 32) || !isHexId(parentId, 16) || !isHex(flags, 2) {
+		return TraceContext{}, false
+	}
+
+	f, _ := hex.DecodeString(flags)
+	return TraceContext{
+		TraceID:  traceId,
+		ParentID: parentId,
+		Sampled:  f[0]&0x01 != 0,
+	}, true
+}
+
+// parseAmznTraceId parses an X-Ray trace-header of the form:
+//
+//	Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+//
+// X-Ray trace-ids map directly onto W3C trace-ids once the version and
+// dashes are removed.
+func parseAmznTraceId(v string) (TraceContext, bool) {
+	var t TraceContext
+	for _, field := range strings.Split(v, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch k {
+		case "Root":
+			parts := strings.Split(v, "-")
+			if len(parts) != 3 || parts[0] != "1" {
+				return TraceContext{}, false
+			}
+			t.TraceID = parts[1] + parts[2]
+		case "Parent":
+			t.ParentID = v
+		case "Sampled":
+			t.Sampled = v == "1"
+		}
+	}
+	if !isHexId(t.TraceID, 32) {
+		return TraceContext{}, false
+	}
+	if t.ParentID != "" && !isHexId(t.ParentID, 16) {
+		t.ParentID = ""
+	}
+	return t, true
+}
+
+// isHexId reports if s is an n-digit lowercase hex-string which is
+// not all zeros.
+func isHexId(s string, n int) bool {
+	return isHex(s, n) && strings.Trim(s, "0") != ""
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultWarmupTimeout = 5 * time.Second
+
+// Warmup is a function run while the server is initializing, such as
+// dialing a database or fetching signing-keys, so the work doesn't add
+// to the latency of the first invocation.
+type Warmup struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// runWarmups runs the server's warmup functions concurrently and waits for
+// them to complete, or for the warmup-timeout to pass. Failures are logged
+// but are not fatal, as warmups are only an optimization.
+func (s *Server) runWarmups(ctx context.Context) {
+	if len(s.Warmups) == 0 {
+		return
+	}
+
+	timeout := s.WarmupTimeout
+	if timeout <= 0 {
+		timeout = defaultWarmupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for _, warmup := range s.Warmups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			warmupStart := time.Now()
+			err := warmup.Func(ctx)
+			d := time.Since(warmupStart)
+
+			s.record(ctx, "WarmupDuration", float64(d)/float64(time.Millisecond), UnitMilliseconds,
+				Dimension{Name: "Warmup", Value: warmup.Name},
+			)
+
+			attrs := []slog.Attr{
+				slog.String("warmup", warmup.Name),
+				slog.Duration("duration", d),
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+				s.logger().LogAttrs(ctx, slog.LevelWarn, "warmup failed", attrs...)
+				return
+			}
+			s.logger().LogAttrs(ctx, slog.LevelDebug, "warmup complete", attrs...)
+		}()
+	}
+
+	// we don't wait beyond the timeout for warmups which don't respect
+	// their context.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	s.logger().LogAttrs(ctx, slog.LevelInfo, "warmups complete",
+		slog.Int("count", len(s.Warmups)),
+		slog.Duration("duration", time.Since(start)),
+	)
+}
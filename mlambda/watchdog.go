@@ -0,0 +1,89 @@
+package mlambda
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+const (
+	defaultDeadlineWarning = time.Second
+
+	// maxGoroutineDump bounds the size of logged goroutine-dumps.
+	maxGoroutineDump = 64 * 1024
+)
+
+// stage is the phase of the invocation-lifecycle the server is in.
+type stage int32
+
+const (
+	stagePolling stage = iota
+	stageHandler
+	stageUpload
+)
+
+func (s stage) String() string {
+	switch s {
+	case stagePolling:
+		return "polling"
+	case stageHandler:
+		return "handler"
+	case stageUpload:
+		return "upload"
+	}
+	return "unknown"
+}
+
+func (s *Server) setStage(st stage) {
+	s.stage.Store(int32(st))
+}
+
+func (s *Server) currentStage() stage {
+	return stage(s.stage.Load())
+}
+
+// startWatchdog arranges for diagnostics to be logged shortly before the
+// invocation deadline. The returned function stops the watchdog.
+func (s *Server) startWatchdog(ctx context.Context, requestId string, start time.Time, deadline time.Time) (stop func()) {
+	margin := s.DeadlineWarning
+	if margin == 0 {
+		margin = defaultDeadlineWarning
+	}
+	if margin < 0 || deadline.IsZero() {
+		return func() {}
+	}
+
+	t := time.AfterFunc(time.Until(deadline.Add(-margin)), func() {
+		s.logger().LogAttrs(ctx, slog.LevelWarn, "invocation is approaching its deadline",
+			slog.String("requestId", requestId),
+			slog.String("stage", s.currentStage().String()),
+			slog.Duration("elapsed", time.Since(start)),
+			slog.Duration("remaining", time.Until(deadline)),
+			slog.String("goroutines", goroutineDump(maxGoroutineDump)),
+		)
+
+		if s.profiling {
+			profile, err := captureProfile("heap")
+			if err == nil {
+				_, _ = s.writeProfile(ctx, "heap", profile)
+			}
+			// ship a running CPU profile before the sandbox
+			// is stopped
+			s.stopCPUProfile(ctx)
+		}
+	})
+	return func() { t.Stop() }
+}
+
+// goroutineDump returns the stacks of all goroutines, truncated to
+// at most max bytes.
+func goroutineDump(max int) string {
+	buf := make([]byte, max)
+	n := runtime.Stack(buf, true)
+	dump := string(buf[:n])
+	if n == max {
+		dump += "\n... (truncated)"
+	}
+	return dump
+}
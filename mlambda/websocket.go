@@ -0,0 +1,118 @@
+package mlambda
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/awsapi"
+)
+
+var apiGatewayManagementService = awsapi.Service{
+	SigningName: "execute-api",
+}
+
+// ErrConnectionGone is returned when a WebSocket client has
+// disconnected.
+var ErrConnectionGone = errors.New("connection gone")
+
+// WebSocketConnections manages the connections of an API Gateway
+// WebSocket API through its @connections API. The function needs
+// execute-api:ManageConnections on the API.
+type WebSocketConnections struct {
+	Client *awsapi.Client
+
+	// Endpoint is the API's callback URL, such as
+	// "https://{api-id}.execute-api.{region}.amazonaws.com/{stage}"
+	// (or the custom domain and base-path mapping).
+	Endpoint string
+}
+
+// WebSocketConnection describes a connection.
+type WebSocketConnection struct {
+	ConnectedAt  time.Time `json:"connectedAt"`
+	LastActiveAt time.Time `json:"lastActiveAt"`
+	Identity     struct {
+		SourceIP  string `json:"sourceIp"`
+		UserAgent string `json:"userAgent"`
+	} `json:"identity"`
+}
+
+// PostToConnection sends data to a client.
+func (c *WebSocketConnections) PostToConnection(ctx context.Context, id string, data []byte) error {
+	_, err := c.do(ctx, http.MethodPost, id, data)
+	return err
+}
+
+// GetConnection describes a client's connection.
+func (c *WebSocketConnections) GetConnection(ctx context.Context, id string) (*WebSocketConnection, error) {
+	b, err := c.do(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	var conn WebSocketConnection
+	if err := jsonv2.Unmarshal(b, &conn, jsonv2.RejectUnknownMembers(false)); err != nil {
+		return nil, err
+	}
+	return &conn, nil
+}
+
+// DeleteConnection disconnects a client.
+func (c *WebSocketConnections) DeleteConnection(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, id, nil)
+	return err
+}
+
+// Broadcast sends data to every connection in store with the given key,
+// removing those which have gone from the store. It returns the first
+// other error, after trying every connection.
+func (c *WebSocketConnections) Broadcast(ctx context.Context, store ConnectionStore, key string, data []byte) error {
+	conns, err := store.List(ctx, key)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, conn := range conns {
+		err := c.PostToConnection(ctx, conn.ID, data)
+		switch {
+		case errors.Is(err, ErrConnectionGone):
+			if err := store.Remove(ctx, conn.ID); err != nil {
+				slog.WarnContext(ctx, "removing gone connection", "error", err, "connectionId", conn.ID)
+			}
+		case err != nil && firstErr == nil:
+			firstErr = fmt.Errorf("posting to %s: %s", conn.ID, err)
+		}
+	}
+	return firstErr
+}
+
+func (c *WebSocketConnections) do(ctx context.Context, method string, id string, body []byte) ([]byte, error) {
+	r, err := http.NewRequest(method, c.Endpoint+"/@connections/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(ctx, apiGatewayManagementService, r, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		return nil, ErrConnectionGone
+	case resp.StatusCode/100 != 2:
+		return nil, &awsapi.APIError{StatusCode: resp.StatusCode, Code: resp.Header.Get("X-Amzn-ErrorType"), Message: string(bytes.TrimSpace(b))}
+	}
+	return b, nil
+}
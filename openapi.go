@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// operation describes a route of the API, for registration and for the
+// OpenAPI document.
+type operation struct {
+	method  string
+	path    string
+	id      string
+	summary string
+
+	// requestBody is the media-type of the request-body, if any, and
+	// request the name of its schema. The default schema is "Thing".
+	requestBody string
+	request     string
+
+	// query lists the query-parameters.
+	query []string
+
+	// status is the success status-code, and response the name of
+	// the schema of the response-body, if any.
+	status   int
+	response string
+
+	// errors lists the error status-codes.
+	errors []int
+
+	// scope is the JWT scope required to call the operation.
+	scope string
+
+	handler http.HandlerFunc
+}
+
+// openAPI builds an OpenAPI 3 document describing ops.
+func openAPI(ops []operation) map[string]any {
+	paths := map[string]any{}
+	for _, op := range ops {
+		item, ok := paths[op.path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[op.path] = item
+		}
+		item[strings.ToLower(op.method)] = op.openAPI()
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Thing API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Thing":     thingSchema(),
+				"ThingPage": thingPageSchema(),
+				"Problem":   problemSchema(),
+
+				"BatchRequest":  batchRequestSchema(),
+				"BatchResponse": batchResponseSchema(),
+
+				"AttachmentUpload": attachmentUploadSchema(),
+			},
+		},
+	}
+}
+
+func (op *operation) openAPI() map[string]any {
+	o := map[string]any{
+		"operationId": op.id,
+		"summary":     op.summary,
+	}
+	errors := op.errors
+	if op.scope != "" {
+		o["description"] = "Requires the " + op.scope + " scope."
+		errors = append([]int{http.StatusForbidden}, errors...)
+	}
+
+	var params []any
+	for _, segment := range strings.Split(op.path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]any{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+	for _, q := range op.query {
+		params = append(params, map[string]any{
+			"name":   q,
+			"in":     "query",
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+
+	if op.requestBody != "" {
+		schema := op.request
+		if schema == "" {
+			schema = "Thing"
+		}
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				op.requestBody: map[string]any{"schema": ref(schema)},
+			},
+		}
+	}
+
+	responses := map[string]any{}
+	success := map[string]any{"description": http.StatusText(op.status)}
+	if op.response != "" {
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": ref(op.response)}}
+	}
+	responses[fmt.Sprint(op.status)] = success
+	for _, status := range errors {
+		responses[fmt.Sprint(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content":     map[string]any{"application/problem+json": map[string]any{"schema": ref("Problem")}},
+		}
+	}
+	o["responses"] = responses
+
+	return o
+}
+
+func ref(schema string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + schema}
+}
+
+func thingSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"name"},
+		"properties": map[string]any{
+			"id":          map[string]any{"type": "string", "readOnly": true},
+			"name":        map[string]any{"type": "string", "minLength": 1, "maxLength": maxNameLength},
+			"description": map[string]any{"type": "string", "maxLength": maxDescriptionLength},
+			"status":      map[string]any{"type": "string", "enum": []string{StatusActive, StatusArchived}, "default": StatusActive},
+			"created":     map[string]any{"type": "string", "format": "date-time", "readOnly": true},
+			"updated":     map[string]any{"type": "string", "format": "date-time", "readOnly": true},
+			"version":     map[string]any{"type": "integer", "format": "int64"},
+		},
+		"additionalProperties": false,
+	}
+}
+
+func thingPageSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"items"},
+		"properties": map[string]any{
+			"items":     map[string]any{"type": "array", "items": ref("Thing")},
+			"nextToken": map[string]any{"type": "string"},
+		},
+	}
+}
+
+func batchRequestSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"operations"},
+		"properties": map[string]any{
+			"operations": map[string]any{
+				"type":     "array",
+				"minItems": 1,
+				"maxItems": maxBatchSize,
+				"items": map[string]any{
+					"type":     "object",
+					"required": []string{"op"},
+					"properties": map[string]any{
+						"op":    map[string]any{"type": "string", "enum": []string{batchCreate, batchDelete}},
+						"id":    map[string]any{"type": "string"},
+						"thing": ref("Thing"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func batchResponseSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"results"},
+		"properties": map[string]any{
+			"results": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":     "object",
+					"required": []string{"status"},
+					"properties": map[string]any{
+						"status": map[string]any{"type": "integer"},
+						"thing":  ref("Thing"),
+						"error":  ref("Problem"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func attachmentUploadSchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"uploadUrl", "expires"},
+		"properties": map[string]any{
+			"uploadUrl": map[string]any{"type": "string", "format": "uri"},
+			"expires":   map[string]any{"type": "string", "format": "date-time"},
+		},
+	}
+}
+
+func problemSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"type":     map[string]any{"type": "string", "format": "uri-reference"},
+			"title":    map[string]any{"type": "string"},
+			"status":   map[string]any{"type": "integer"},
+			"detail":   map[string]any{"type": "string"},
+			"instance": map[string]any{"type": "string"},
+		},
+	}
+}
+
+// swaggerUI is a page rendering the OpenAPI document with Swagger UI.
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+<title>Thing API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func serveSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	// the page loads scripts and styles from a CDN
+	w.Header().Del("Content-Security-Policy")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, swaggerUI)
+}
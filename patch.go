@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// mergePatchContentType is the media-type of RFC 7396 merge-patches.
+const mergePatchContentType = "application/merge-patch+json"
+
+// readOnlyMembers are the members of a thing maintained by the store,
+// which a patch may not change.
+var readOnlyMembers = []string{"id", "version", "created", "updated"}
+
+// patchThing applies an RFC 7396 merge-patch to a thing.
+//
+// The patch is applied to the stored version of the thing, and the
+// update fails with a 409 if the thing is modified concurrently (or a
+// 412 if the request was conditional on If-Match). A patch which
+// changes a read-only member is rejected, and one which changes nothing
+// leaves the thing, and its version, as it was.
+func (a *api) patchThing(w http.ResponseWriter, r *http.Request) {
+	if mt, ok := requestMediaType(r); !ok || mt.MIME() != mergePatchContentType {
+		w.Header().Set("Accept-Patch", mergePatchContentType)
+		writeProblem(w, r, problemUnsupportedMedia, "content-type must be "+mergePatchContentType)
+		return
+	}
+
+	var patch any
+	if err := json.UnmarshalRead(r.Body, &patch); err != nil {
+		pt, detail := requestError(err)
+		writeProblem(w, r, pt, detail)
+		return
+	}
+
+	id := r.PathValue("id")
+	existing, err := a.store.Get(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if im := r.Header.Get("If-Match"); im != "" && !etagMatches(im, thingETag(existing), false) {
+		writePreconditionFailed(w, r)
+		return
+	}
+
+	t, changed, err := applyMergePatch(existing, patch)
+	if err != nil {
+		writeProblem(w, r, problemInvalidRequest, err.Error())
+		return
+	}
+	if !changed {
+		w.Header().Set("ETag", thingETag(existing))
+		writeJSON(w, 200, existing)
+		return
+	}
+	if p := validationProblem(t.validate()); p != nil {
+		mlambda.WriteProblem(w, r, p)
+		return
+	}
+
+	if err := a.store.Update(r.Context(), t); err != nil {
+		writeConditionalError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", thingETag(t))
+	writeJSON(w, 200, t)
+}
+
+// applyMergePatch returns the result of applying patch to t, and if
+// that differs from t.
+func applyMergePatch(t *Thing, patch any) (*Thing, bool, error) {
+	original, err := json.Marshal(t)
+	if err != nil {
+		return nil, false, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(original, &doc); err != nil {
+		return nil, false, err
+	}
+	if p, ok := patch.(map[string]any); ok {
+		for _, k := range readOnlyMembers {
+			if v, ok := p[k]; ok && !reflect.DeepEqual(v, doc[k]) {
+				return nil, false, fmt.Errorf("%q is read-only", k)
+			}
+		}
+	}
+
+	b, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return nil, false, err
+	}
+	var patched Thing
+	if err := json.Unmarshal(b, &patched, decodeOptions); err != nil {
+		return nil, false, fmt.Errorf("patched thing is invalid: %s", err)
+	}
+
+	b, err = json.Marshal(&patched)
+	if err != nil {
+		return nil, false, err
+	}
+	return &patched, !bytes.Equal(b, original), nil
+}
+
+// mergePatch implements the RFC 7396 merge-patch algorithm over
+// decoded JSON values.
+func mergePatch(target any, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// problemType is a kind of error reported by the API. Each has a
+// stable type-URI (relative to the API) which clients may match on.
+type problemType struct {
+	slug   string
+	status int
+	title  string
+}
+
+var (
+	problemInvalidRequest   = problemType{"invalid-request", http.StatusBadRequest, "Invalid request"}
+	problemValidation       = problemType{"validation-failed", http.StatusBadRequest, "Validation failed"}
+	problemForbidden        = problemType{"forbidden", http.StatusForbidden, "Forbidden"}
+	problemNotFound         = problemType{"not-found", http.StatusNotFound, "Not found"}
+	problemMethodNotAllowed = problemType{"method-not-allowed", http.StatusMethodNotAllowed, "Method not allowed"}
+	problemNotAcceptable    = problemType{"not-acceptable", http.StatusNotAcceptable, "Not acceptable"}
+	problemConflict         = problemType{"conflict", http.StatusConflict, "Conflict"}
+	problemPrecondition     = problemType{"precondition-failed", http.StatusPreconditionFailed, "Precondition failed"}
+	problemTooLarge         = problemType{"payload-too-large", http.StatusRequestEntityTooLarge, "Payload too large"}
+	problemUnsupportedMedia = problemType{"unsupported-media-type", http.StatusUnsupportedMediaType, "Unsupported media type"}
+	problemRateLimited      = problemType{"rate-limited", http.StatusTooManyRequests, "Too many requests"}
+)
+
+// newProblem returns a problem document of type pt.
+func (pt problemType) newProblem(detail string) *mlambda.Problem {
+	return &mlambda.Problem{
+		Type:   "/problems/" + pt.slug,
+		Title:  pt.title,
+		Status: pt.status,
+		Detail: detail,
+	}
+}
+
+// writeProblem responds with a problem document of type pt. The
+// document's instance identifies the lambda request.
+func writeProblem(w http.ResponseWriter, r *http.Request, pt problemType, detail string) {
+	mlambda.WriteProblem(w, r, pt.newProblem(detail))
+}
+
+// notFound responds to requests for unknown routes.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problemNotFound, "no route for "+r.Method+" "+r.URL.Path)
+}
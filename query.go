@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a parsed search-query. The grammar is a list of terms
+// separated by spaces:
+//
+//	name:widget           field equals value
+//	created:>2024-01-01   comparisons (>, >=, <, <=)
+//	version:2..5          inclusive ranges (either end may be omitted)
+//	sort:-created         sort by a field, descending with "-"
+//	gadget                name or description contains the word
+//
+// Values containing spaces may be double-quoted, as in name:"big widget".
+// The fields are name, status, created, updated, and version. Times are
+// RFC 3339 timestamps or dates.
+type Query struct {
+	Filters []Filter
+
+	// Text lists words which must appear in the name or description,
+	// case-insensitively.
+	Text []string
+
+	// Sort is the field to order by, and Descending its direction.
+	// The default is to sort by id.
+	Sort       string
+	Descending bool
+}
+
+// Filter compares a field to a value. The value is a string, a
+// time.Time, or an int64, depending on the field.
+type Filter struct {
+	Field string
+	Op    string
+	Value any
+}
+
+// Filter operators.
+const (
+	opEq = "="
+	opGt = ">"
+	opGe = ">="
+	opLt = "<"
+	opLe = "<="
+)
+
+// queryFields maps searchable fields to their kind.
+var queryFields = map[string]string{
+	"id":      "string",
+	"name":    "string",
+	"status":  "string",
+	"created": "time",
+	"updated": "time",
+	"version": "int",
+}
+
+// ParseQuery parses a search-query.
+func ParseQuery(s string) (*Query, error) {
+	terms, err := splitTerms(s)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{}
+	for _, term := range terms {
+		field, value, ok := strings.Cut(term, ":")
+		if !ok {
+			q.Text = append(q.Text, strings.ToLower(term))
+			continue
+		}
+
+		if field == "sort" {
+			q.Descending = strings.HasPrefix(value, "-")
+			q.Sort = strings.TrimPrefix(value, "-")
+			if _, ok := queryFields[q.Sort]; !ok {
+				return nil, fmt.Errorf("cannot sort by %q", q.Sort)
+			}
+			continue
+		}
+
+		kind, ok := queryFields[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		filters, err := parseFilter(field, kind, value)
+		if err != nil {
+			return nil, err
+		}
+		q.Filters = append(q.Filters, filters...)
+	}
+	return q, nil
+}
+
+// splitTerms splits a query on spaces, respecting double-quotes.
+func splitTerms(s string) ([]string, error) {
+	var terms []string
+	var b strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if b.Len() > 0 {
+				terms = append(terms, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if b.Len() > 0 {
+		terms = append(terms, b.String())
+	}
+	return terms, nil
+}
+
+// parseFilter parses the value of a field:value term.
+func parseFilter(field string, kind string, value string) ([]Filter, error) {
+	if lo, hi, ok := strings.Cut(value, ".."); ok {
+		var filters []Filter
+		if lo != "" {
+			v, err := parseValue(field, kind, lo)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, Filter{Field: field, Op: opGe, Value: v})
+		}
+		if hi != "" {
+			v, err := parseValue(field, kind, hi)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, Filter{Field: field, Op: opLe, Value: v})
+		}
+		if len(filters) == 0 {
+			return nil, fmt.Errorf("empty range for %q", field)
+		}
+		return filters, nil
+	}
+
+	op := opEq
+	for _, candidate := range []string{opGe, opLe, opGt, opLt} {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			value = value[len(candidate):]
+			break
+		}
+	}
+	v, err := parseValue(field, kind, value)
+	if err != nil {
+		return nil, err
+	}
+	return []Filter{{Field: field, Op: op, Value: v}}, nil
+}
+
+func parseValue(field string, kind string, value string) (any, error) {
+	switch kind {
+	case "time":
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a timestamp or date", field)
+		}
+		return t, nil
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer", field)
+		}
+		return n, nil
+	}
+	return value, nil
+}
+
+// matches reports whether t satisfies the query.
+func (q *Query) matches(t *Thing) bool {
+	for _, f := range q.Filters {
+		c := compareField(t, f.Field, f.Value)
+		var ok bool
+		switch f.Op {
+		case opEq:
+			ok = c == 0
+		case opGt:
+			ok = c > 0
+		case opGe:
+			ok = c >= 0
+		case opLt:
+			ok = c < 0
+		case opLe:
+			ok = c <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, word := range q.Text {
+		if !strings.Contains(strings.ToLower(t.Name), word) && !strings.Contains(strings.ToLower(t.Description), word) {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders things by the query's sort-field, then by id.
+func (q *Query) less(a *Thing, b *Thing) bool {
+	if q.Sort != "" && q.Sort != "id" {
+		if c := compareField(a, q.Sort, fieldValue(b, q.Sort)); c != 0 {
+			return (c < 0) != q.Descending
+		}
+	}
+	if q.Descending && (q.Sort == "" || q.Sort == "id") {
+		return a.ID > b.ID
+	}
+	return a.ID < b.ID
+}
+
+// fieldValue returns the value of a searchable field of t.
+func fieldValue(t *Thing, field string) any {
+	switch field {
+	case "id":
+		return t.ID
+	case "name":
+		return t.Name
+	case "status":
+		return t.Status
+	case "created":
+		return t.Created
+	case "updated":
+		return t.Updated
+	case "version":
+		return t.Version
+	}
+	return nil
+}
+
+// compareField compares a field of t to v, which must be of the
+// field's kind.
+func compareField(t *Thing, field string, v any) int {
+	switch a := fieldValue(t, field).(type) {
+	case string:
+		return strings.Compare(a, v.(string))
+	case time.Time:
+		return a.Compare(v.(time.Time))
+	case int64:
+		b := v.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Thing is the resource managed by the demo API.
+type Thing struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+
+	// Version is incremented by every update.
+	Version int64 `json:"version"`
+}
+
+// ErrNotFound is returned by a Store when a thing does not exist.
+var ErrNotFound = errors.New("thing not found")
+
+// ErrConflict is returned by a Store when an update is based on an
+// out-of-date version of a thing.
+var ErrConflict = errors.New("thing has been modified")
+
+// ErrInvalidCursor is returned by a Store when a list-cursor is not
+// one it issued.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions controls a Store listing.
+type ListOptions struct {
+	// Limit is the maximum number of things to return.
+	Limit int
+
+	// Cursor continues a previous listing. It is opaque to callers.
+	Cursor string
+
+	// Name, if set, only matches things with this name.
+	Name string
+
+	// CreatedAfter, if set, only matches things created after it.
+	CreatedAfter time.Time
+}
+
+// matches reports whether t passes the filters in o.
+func (o *ListOptions) matches(t *Thing) bool {
+	if o.Name != "" && t.Name != o.Name {
+		return false
+	}
+	if !o.CreatedAfter.IsZero() && !t.Created.After(o.CreatedAfter) {
+		return false
+	}
+	return true
+}
+
+// Store persists things. Each tenant (see tenantFromContext) has its
+// own things.
+type Store interface {
+	// Create stores a new thing, assigning its id and timestamps.
+	Create(ctx context.Context, t *Thing) error
+
+	Get(ctx context.Context, id string) (*Thing, error)
+
+	// List returns a page of things and a cursor for the next page.
+	// The cursor is empty on the last page. The order is up to the
+	// store.
+	List(ctx context.Context, opts ListOptions) ([]*Thing, string, error)
+
+	// Search returns a page of the things matching q, in the order it
+	// specifies, and a cursor for the next page. Only the Limit and
+	// Cursor options are used.
+	Search(ctx context.Context, q *Query, opts ListOptions) ([]*Thing, string, error)
+
+	// Update replaces an existing thing, updating its timestamp and
+	// version. If the thing's version is set it must match the stored
+	// version, otherwise ErrConflict is returned.
+	Update(ctx context.Context, t *Thing) error
+
+	// Delete removes a thing. If version is non-zero it must match
+	// the stored version, otherwise ErrConflict is returned.
+	Delete(ctx context.Context, id string, version int64) error
+}
+
+// memStore is a Store held in memory. When deployed, each lambda
+// execution-environment has its own store.
+type memStore struct {
+	mu sync.Mutex
+
+	// things is keyed by scoped id.
+	things map[string]Thing
+}
+
+func newMemStore() *memStore {
+	return &memStore{things: map[string]Thing{}}
+}
+
+// Create implements Store.
+func (m *memStore) Create(ctx context.Context, t *Thing) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.ID = newID()
+	t.Created = time.Now().UTC()
+	t.Updated = t.Created
+	t.Version = 1
+	m.things[scopedID(ctx, t.ID)] = *t
+	return nil
+}
+
+// Get implements Store.
+func (m *memStore) Get(ctx context.Context, id string) (*Thing, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.things[scopedID(ctx, id)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+// List implements Store. The cursor is the last id returned.
+func (m *memStore) List(ctx context.Context, opts ListOptions) ([]*Thing, string, error) {
+	var after string
+	if opts.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		after = string(b)
+	}
+
+	m.mu.Lock()
+	var things []*Thing
+	for key, t := range m.things {
+		if key == scopedID(ctx, t.ID) && t.ID > after && opts.matches(&t) {
+			things = append(things, &t)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(things, func(i, j int) bool { return things[i].ID < things[j].ID })
+
+	var cursor string
+	if opts.Limit > 0 && len(things) > opts.Limit {
+		things = things[:opts.Limit]
+		cursor = base64.RawURLEncoding.EncodeToString([]byte(things[len(things)-1].ID))
+	}
+	return things, cursor, nil
+}
+
+// Search implements Store.
+func (m *memStore) Search(ctx context.Context, q *Query, opts ListOptions) ([]*Thing, string, error) {
+	m.mu.Lock()
+	var things []*Thing
+	for key, t := range m.things {
+		if key == scopedID(ctx, t.ID) && q.matches(&t) {
+			things = append(things, &t)
+		}
+	}
+	m.mu.Unlock()
+
+	return searchPage(things, q, opts)
+}
+
+// searchPage sorts the results of a search and returns the page
+// selected by opts. The cursor is the offset of the page.
+func searchPage(things []*Thing, q *Query, opts ListOptions) ([]*Thing, string, error) {
+	var offset int
+	if opts.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		offset, err = strconv.Atoi(string(b))
+		if err != nil || offset < 0 {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+
+	sort.Slice(things, func(i, j int) bool { return q.less(things[i], things[j]) })
+
+	if offset >= len(things) {
+		return nil, "", nil
+	}
+	things = things[offset:]
+
+	var cursor string
+	if opts.Limit > 0 && len(things) > opts.Limit {
+		things = things[:opts.Limit]
+		cursor = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset + opts.Limit)))
+	}
+	return things, cursor, nil
+}
+
+// Update implements Store.
+func (m *memStore) Update(ctx context.Context, t *Thing) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := scopedID(ctx, t.ID)
+	existing, ok := m.things[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if t.Version != 0 && t.Version != existing.Version {
+		return ErrConflict
+	}
+	t.Created = existing.Created
+	t.Updated = time.Now().UTC()
+	t.Version = existing.Version + 1
+	m.things[key] = *t
+	return nil
+}
+
+// Delete implements Store.
+func (m *memStore) Delete(ctx context.Context, id string, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := scopedID(ctx, id)
+	existing, ok := m.things[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if version != 0 && version != existing.Version {
+		return ErrConflict
+	}
+	delete(m.things, key)
+	return nil
+}
+
+// Ping implements pinger.
+func (m *memStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+var _ Store = (*memStore)(nil)
+var _ pinger = (*memStore)(nil)
+
+// newID generates a random thing-id.
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
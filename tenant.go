@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// defaultTenantClaim is the JWT claim naming the caller's tenant.
+const defaultTenantClaim = "tenant"
+
+type tenantKey struct{}
+
+// contextWithTenant returns a context for requests made on behalf of
+// the tenant.
+func contextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFromContext returns the tenant of a request. The empty string
+// is the default tenant, used when there is no authorizer (such as
+// when running locally).
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// scopedID qualifies a thing-id with the tenant of ctx. Stores key
+// things by their scoped id, so each tenant sees only its own things,
+// and a request for another tenant's thing is indistinguishable from a
+// request for one which does not exist.
+func scopedID(ctx context.Context, id string) string {
+	tenant := tenantFromContext(ctx)
+	if tenant == "" {
+		return id
+	}
+	return url.PathEscape(tenant) + "/" + id
+}
+
+// requireTenant wraps h so that it is called on behalf of the tenant
+// named by the request's authorizer: the claim of a JWT authorizer, or
+// the context-value of a lambda authorizer. Requests without a tenant
+// receive a 403.
+func requireTenant(claim string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := requestTenant(r, claim)
+		if tenant == "" {
+			writeProblem(w, r, problemForbidden, "the caller has no "+claim)
+			return
+		}
+		h(w, r.WithContext(contextWithTenant(r.Context(), tenant)))
+	}
+}
+
+func requestTenant(r *http.Request, claim string) string {
+	gc, ok := mlambda.GatewayContextFromContext(r.Context())
+	if !ok {
+		return ""
+	}
+	switch a := gc.Authorizer; {
+	case a.JWT != nil:
+		return a.JWT.Claims[claim]
+	case a.Lambda != nil:
+		tenant, _ := a.Lambda[claim].(string)
+		return tenant
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-json-experiment/json"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// Thing statuses.
+const (
+	StatusActive   = "active"
+	StatusArchived = "archived"
+)
+
+const (
+	maxNameLength        = 100
+	maxDescriptionLength = 1000
+)
+
+// fieldError describes an invalid field of a request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validate checks the client-supplied fields of t.
+func (t *Thing) validate() []fieldError {
+	var errs []fieldError
+
+	switch n := utf8.RuneCountInString(t.Name); {
+	case strings.TrimSpace(t.Name) == "":
+		errs = append(errs, fieldError{Field: "name", Message: "is required"})
+	case n > maxNameLength:
+		errs = append(errs, fieldError{Field: "name", Message: fmt.Sprintf("must be at most %d characters", maxNameLength)})
+	}
+
+	if utf8.RuneCountInString(t.Description) > maxDescriptionLength {
+		errs = append(errs, fieldError{Field: "description", Message: fmt.Sprintf("must be at most %d characters", maxDescriptionLength)})
+	}
+
+	switch t.Status {
+	case StatusActive, StatusArchived:
+	default:
+		errs = append(errs, fieldError{Field: "status", Message: fmt.Sprintf("must be %q or %q", StatusActive, StatusArchived)})
+	}
+
+	return errs
+}
+
+// decodeOptions are used to decode things strictly: unknown or
+// duplicate members are rejected.
+var decodeOptions = json.RejectUnknownMembers(true)
+
+// decodeThing decodes and validates a thing from the request-body.
+// The server-managed fields are accepted, so clients may send back a
+// thing they received. The id, created, and updated fields are ignored;
+// a version makes an update conditional on the stored version.
+func decodeThing(r *http.Request) (*Thing, *mlambda.Problem) {
+	var t Thing
+	if err := json.UnmarshalRead(r.Body, &t, decodeOptions); err != nil {
+		pt, detail := requestError(err)
+		return nil, pt.newProblem(detail)
+	}
+	if t.Status == "" {
+		t.Status = StatusActive
+	}
+	if p := validationProblem(t.validate()); p != nil {
+		return nil, p
+	}
+	return &t, nil
+}
+
+// validationProblem describes invalid fields, or returns nil if there
+// are none.
+func validationProblem(errs []fieldError) *mlambda.Problem {
+	if len(errs) == 0 {
+		return nil
+	}
+	p := problemValidation.newProblem("the request has invalid fields")
+	p.Extensions = map[string]any{"errors": errs}
+	return p
+}
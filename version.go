@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/aslatter/aws-go-lambda-demo/mlambda"
+)
+
+// versionInfo identifies the deployed code.
+type versionInfo struct {
+	Module    string `json:"module,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+
+	// FunctionVersion is the lambda function-version, and
+	// FunctionQualifier the alias or version it was invoked with.
+	FunctionVersion   string `json:"functionVersion,omitempty"`
+	FunctionQualifier string `json:"functionQualifier,omitempty"`
+}
+
+// buildInfo is read once, as it doesn't change.
+var buildInfo = readBuildInfo()
+
+func readBuildInfo() versionInfo {
+	v := versionInfo{GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.Module = info.Main.Path
+	v.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.time":
+			v.BuildTime = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	return v
+}
+
+// version reports which code is deployed.
+func (a *api) version(w http.ResponseWriter, r *http.Request) {
+	v := buildInfo
+	v.FunctionVersion = mlambda.FunctionInfoFromEnv().Version
+	if lc, ok := mlambda.FromContext(r.Context()); ok {
+		v.FunctionQualifier = functionQualifier(lc.InvokedFunctionArn)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, 200, &v)
+}
+
+// functionQualifier returns the alias or version from a qualified
+// function-ARN, such as "arn:aws:lambda:us-east-1:123456789012:function:demo:live".
+func functionQualifier(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 8 {
+		return ""
+	}
+	return parts[7]
+}